@@ -0,0 +1,9 @@
+package sightpb
+
+import "embed"
+
+// Schemas holds the .proto definitions so they can be served to
+// implementations in other languages
+//
+//go:embed *.proto
+var Schemas embed.FS
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"sight-libp2p-node/pkg/sightnode"
+	"sight-libp2p-node/pkg/sightnode/sightnodetest"
+)
+
+// benchType is the message type of benchmark messages
+const benchType = "bench"
+
+// benchPayload identifies a benchmark message on arrival. Pad brings the
+// payload to the requested size.
+type benchPayload struct {
+	Run    string `json:"bench"`
+	Seq    int64  `json:"seq"`
+	SentAt int64  `json:"sentAt"`
+	Pad    string `json:"pad,omitempty"`
+}
+
+// bench sends messages at a fixed rate for a while and reports throughput,
+// latency and loss. By default it starts a gateway and a hoster in this
+// process and sends from one to the other; with -api it sends through a
+// running node instead, measuring delivery only when the recipient forwards
+// to the receiver started with -listen. The -max-* flags make it exit with
+// status 1, so a release pipeline can fail on a regression.
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	rate := fs.Int("rate", 100, "messages sent per second")
+	size := fs.Int("size", 256, "payload size in bytes")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send for")
+	concurrency := fs.Int("concurrency", 64, "sends in flight at most; ticks finding them all busy are skipped and reported")
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for deliveries after the last send")
+	transport := fs.String("transport", "localhost", "transport of the local pair: localhost or memory")
+	api := fs.String("api", "", "HTTP API of a running node to send through, instead of a local pair")
+	to := fs.String("to", "", "recipient DID, required with -api")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "bearer token sent with every request to -api")
+	listen := fs.String("listen", "", "with -api, address to receive the recipient's forwards on; point its TUNNEL_API here")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	maxP99 := fs.Duration("max-p99", 0, "fail when the p99 delivery latency exceeds this (0 disables)")
+	maxLoss := fs.Float64("max-loss", -1, "fail when more than this percentage of messages is lost (negative disables)")
+	fs.Parse(args)
+
+	if *rate <= 0 || *concurrency <= 0 || *duration <= 0 {
+		log.Fatal("bench: -rate, -concurrency and -duration must be positive")
+	}
+	if *api != "" && *to == "" {
+		log.Fatal("bench: -api needs -to")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	recv := &benchReceiver{runID: uuid.NewString(), seen: make(map[int64]bool)}
+	measured := true
+	sendURL, recipient := strings.TrimRight(*api, "/")+"/v1/libp2p/send", *to
+	if *api == "" {
+		server := httptest.NewServer(recv)
+		defer server.Close()
+		cluster, err := startBenchPair(ctx, *transport, server.URL)
+		if err != nil {
+			log.Fatal("bench: ", err)
+		}
+		defer cluster.Close()
+		sendURL = cluster.Gateway().API.URL + "/v1/libp2p/send"
+		recipient = cluster.Hosters()[0].DID()
+	} else if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal("bench: ", err)
+		}
+		server := &http.Server{Handler: recv}
+		go server.Serve(l)
+		defer server.Close()
+	} else {
+		measured = false
+	}
+
+	s := &benchSender{
+		url:   sendURL,
+		to:    recipient,
+		token: *token,
+		runID: recv.runID,
+		size:  *size,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		},
+	}
+	log.Printf("Bench: sending %d msg/s of %d bytes to %s for %s", *rate, *size, recipient, *duration)
+	s.run(ctx, *rate, *concurrency, *duration)
+	if measured {
+		recv.waitFor(ctx, s.ok.Load(), *wait)
+	}
+
+	report := newBenchReport(s, recv, measured)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.print(os.Stdout)
+	}
+
+	var failures []string
+	if *maxP99 > 0 && report.Delivery != nil && report.Delivery.Latency.P99 > msec(*maxP99) {
+		failures = append(failures, fmt.Sprintf("p99 latency %.1fms exceeds %s", report.Delivery.Latency.P99, *maxP99))
+	}
+	if *maxLoss >= 0 && report.Delivery != nil && report.Delivery.LossPercent > *maxLoss {
+		failures = append(failures, fmt.Sprintf("loss %.2f%% exceeds %.2f%%", report.Delivery.LossPercent, *maxLoss))
+	}
+	if len(failures) > 0 {
+		fmt.Fprintln(os.Stderr, "bench: "+strings.Join(failures, "; "))
+		os.Exit(1)
+	}
+}
+
+// startBenchPair starts a gateway and a hoster forwarding to tunnelURL and
+// waits until messages between them are routed
+func startBenchPair(ctx context.Context, transport, tunnelURL string) (*sightnodetest.Cluster, error) {
+	opts := sightnodetest.Options{
+		Hosters: 1,
+		Configure: func(i int, cfg *sightnode.Config) {
+			if i > 0 {
+				cfg.TunnelAPI = tunnelURL
+			}
+		},
+	}
+	switch transport {
+	case "localhost":
+		opts.Transport = sightnodetest.Localhost
+	case "memory":
+		opts.Transport = sightnodetest.InMemory
+	default:
+		return nil, fmt.Errorf("unknown transport %q: want localhost or memory", transport)
+	}
+	cluster, err := sightnodetest.Start(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	readyCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := cluster.WaitReady(readyCtx); err != nil {
+		cluster.Close()
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// benchSender posts the benchmark messages to a node's send endpoint
+type benchSender struct {
+	url, to, token, runID string
+	size                  int
+	client                *http.Client
+
+	sent, ok, failed, skipped atomic.Int64
+	started, finished         time.Time
+
+	mu sync.Mutex
+	// latencies are the response times of the send API
+	latencies []time.Duration
+}
+
+// run sends one message per tick until d has passed. Sending is open-loop:
+// a slow node does not slow the schedule down, it shows up as skipped ticks.
+func (s *benchSender) run(ctx context.Context, rate, concurrency int, d time.Duration) {
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	deadline := time.After(d)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	s.started = time.Now()
+loop:
+	for seq := int64(1); ; seq++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			s.skipped.Add(1)
+			continue
+		}
+		wg.Add(1)
+		go func(seq int64) {
+			defer func() { <-sem; wg.Done() }()
+			s.send(ctx, seq)
+		}(seq)
+	}
+	wg.Wait()
+	s.finished = time.Now()
+}
+
+func (s *benchSender) send(ctx context.Context, seq int64) {
+	s.sent.Add(1)
+	body, err := s.message(seq)
+	if err != nil {
+		log.Fatal("bench: ", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatal("bench: ", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	if err != nil {
+		if s.failed.Add(1) == 1 {
+			log.Printf("Bench: send failed: %v", err)
+		}
+		return
+	}
+	s.ok.Add(1)
+	s.mu.Lock()
+	s.latencies = append(s.latencies, time.Since(start))
+	s.mu.Unlock()
+}
+
+// message encodes the send request of message seq, padded to the payload size
+func (s *benchSender) message(seq int64) ([]byte, error) {
+	p := benchPayload{Run: s.runID, Seq: seq, SentAt: time.Now().UnixNano()}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	// The pad field adds its own name and quotes
+	if pad := s.size - len(raw) - len(`,"pad":""`); pad > 0 {
+		p.Pad = strings.Repeat("x", pad)
+		if raw, err = json.Marshal(p); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"envelope": 1,
+		"to":       s.to,
+		"type":     benchType,
+		"payload":  json.RawMessage(raw),
+	})
+}
+
+// benchReceiver stands in for the recipient's tunnel API and records when
+// each message of the run arrives
+type benchReceiver struct {
+	runID string
+
+	mu         sync.Mutex
+	seen       map[int64]bool
+	duplicates int
+	latencies  []time.Duration
+	last       time.Time
+	// arrived is signalled on every new message, waking waitFor
+	arrived chan struct{}
+}
+
+func (b *benchReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Readiness probes only check reachability
+	if r.Method == http.MethodHead {
+		return
+	}
+	now := time.Now()
+	var p benchPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Run != b.runID {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen[p.Seq] {
+		b.duplicates++
+		return
+	}
+	b.seen[p.Seq] = true
+	b.latencies = append(b.latencies, now.Sub(time.Unix(0, p.SentAt)))
+	b.last = now
+	if b.arrived != nil {
+		select {
+		case b.arrived <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitFor returns once want messages arrived, or nothing arrived for wait
+func (b *benchReceiver) waitFor(ctx context.Context, want int64, wait time.Duration) {
+	b.mu.Lock()
+	b.arrived = make(chan struct{}, 1)
+	b.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		got := int64(len(b.seen))
+		b.mu.Unlock()
+		if got >= want {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-b.arrived:
+			timer.Reset(wait)
+		}
+	}
+}
+
+// benchLatency are latency percentiles in milliseconds
+type benchLatency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func newBenchLatency(samples []time.Duration) benchLatency {
+	if len(samples) == 0 {
+		return benchLatency{}
+	}
+	slices.Sort(samples)
+	at := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(samples)))) - 1
+		return msec(samples[max(i, 0)])
+	}
+	return benchLatency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: msec(samples[len(samples)-1])}
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// benchDelivery is what the receiver measured
+type benchDelivery struct {
+	Received    int64   `json:"received"`
+	Lost        int64   `json:"lost"`
+	LossPercent float64 `json:"lossPercent"`
+	Duplicates  int     `json:"duplicates"`
+	// Rate is the received messages per second, from the first send to
+	// the last arrival
+	Rate    float64      `json:"rate"`
+	Latency benchLatency `json:"latencyMs"`
+}
+
+// benchReport is the outcome of a run
+type benchReport struct {
+	Sent       int64        `json:"sent"`
+	Accepted   int64        `json:"accepted"`
+	Failed     int64        `json:"failed"`
+	Skipped    int64        `json:"skipped"`
+	SendRate   float64      `json:"sendRate"`
+	APILatency benchLatency `json:"apiLatencyMs"`
+	// Delivery is nil when arrivals were not measured
+	Delivery *benchDelivery `json:"delivery,omitempty"`
+}
+
+func newBenchReport(s *benchSender, b *benchReceiver, measured bool) benchReport {
+	r := benchReport{
+		Sent:       s.sent.Load(),
+		Accepted:   s.ok.Load(),
+		Failed:     s.failed.Load(),
+		Skipped:    s.skipped.Load(),
+		APILatency: newBenchLatency(s.latencies),
+	}
+	if elapsed := s.finished.Sub(s.started).Seconds(); elapsed > 0 {
+		r.SendRate = float64(r.Accepted) / elapsed
+	}
+	if !measured {
+		return r
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := &benchDelivery{
+		Received:   int64(len(b.seen)),
+		Duplicates: b.duplicates,
+		Latency:    newBenchLatency(b.latencies),
+	}
+	d.Lost = max(r.Accepted-d.Received, 0)
+	if r.Accepted > 0 {
+		d.LossPercent = 100 * float64(d.Lost) / float64(r.Accepted)
+	}
+	if elapsed := b.last.Sub(s.started).Seconds(); d.Received > 0 && elapsed > 0 {
+		d.Rate = float64(d.Received) / elapsed
+	}
+	r.Delivery = d
+	return r
+}
+
+func (r benchReport) print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	latency := func(l benchLatency) string {
+		return fmt.Sprintf("p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms", l.P50, l.P90, l.P99, l.Max)
+	}
+	fmt.Fprintf(w, "Sent\t%d (%d accepted, %d failed, %d skipped)\n", r.Sent, r.Accepted, r.Failed, r.Skipped)
+	fmt.Fprintf(w, "Send rate\t%.1f msg/s\n", r.SendRate)
+	fmt.Fprintf(w, "API latency\t%s\n", latency(r.APILatency))
+	if d := r.Delivery; d != nil {
+		fmt.Fprintf(w, "Received\t%d (%d lost, %.2f%%, %d duplicates)\n", d.Received, d.Lost, d.LossPercent, d.Duplicates)
+		fmt.Fprintf(w, "Delivery rate\t%.1f msg/s\n", d.Rate)
+		fmt.Fprintf(w, "Latency\t%s\n", latency(d.Latency))
+	} else {
+		fmt.Fprintln(w, "Delivery\tnot measured: pass -listen and point the recipient's TUNNEL_API at it")
+	}
+	w.Flush()
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"sight-libp2p-node/pkg/sightnode"
+)
+
+// Terminal control sequences used by the dashboard
+const (
+	altScreenOn  = "\x1b[?1049h\x1b[?25l"
+	altScreenOff = "\x1b[?25h\x1b[?1049l"
+	cursorHome   = "\x1b[H"
+	clearLine    = "\x1b[K"
+	clearBelow   = "\x1b[J"
+)
+
+// dashboardErrors is the number of recent errors listed
+const dashboardErrors = 8
+
+// dashboard polls a node's HTTP API and redraws a live view of its peers,
+// topics, message rates, queues and recent errors, for operators of headless
+// hosters reaching the node over SSH
+func dashboard(args []string) {
+	port := os.Getenv("LIBP2P_PORT")
+	if port == "" {
+		port = "4010"
+	}
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	api := fs.String("api", "http://localhost:"+port, "HTTP API of the node to watch")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "bearer token sent with every request")
+	once := fs.Bool("once", false, "print a single snapshot and exit, e.g. when stdout is not a terminal")
+	fs.Parse(args)
+
+	d := &dashboardView{
+		api:      strings.TrimRight(*api, "/"),
+		token:    *token,
+		interval: *interval,
+		client:   &http.Client{Timeout: *interval},
+	}
+	interactive := !*once && term.IsTerminal(int(os.Stdout.Fd()))
+	if !interactive {
+		d.poll(context.Background())
+		os.Stdout.WriteString(strings.Join(d.render(0, 0), "\n") + "\n")
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Raw mode lets q quit without Enter; Ctrl-C then arrives as a byte
+	if state, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+		defer term.Restore(int(os.Stdin.Fd()), state)
+		go readQuitKey(stop)
+	}
+	os.Stdout.WriteString(altScreenOn)
+	defer os.Stdout.WriteString(altScreenOff)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		d.poll(ctx)
+		width, height, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			width, height = 0, 0
+		}
+		lines := d.render(width, height)
+		os.Stdout.WriteString(cursorHome + strings.Join(lines, clearLine+"\r\n") + clearLine + clearBelow)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readQuitKey calls quit when q, Esc or Ctrl-C is pressed
+func readQuitKey(quit func()) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			if b == 'q' || b == 'Q' || b == 3 || b == 27 {
+				quit()
+				return
+			}
+		}
+	}
+}
+
+// dashboardView holds the last snapshot polled from the node
+type dashboardView struct {
+	api      string
+	token    string
+	interval time.Duration
+	client   *http.Client
+
+	polledAt      time.Time
+	stats         sightnode.NodeStats
+	peers         []sightnode.PeerInfo
+	subscriptions []sightnode.SubscriptionHealth
+	readiness     sightnode.ReadinessReport
+	deadLetters   []sightnode.DeadLetter
+	// rates are messages per second since the previous poll, by counter
+	rates map[string]float64
+	// err is the last polling error, shown until a poll succeeds
+	err error
+}
+
+func (d *dashboardView) poll(ctx context.Context) {
+	var (
+		stats sightnode.NodeStats
+		peers struct {
+			Peers []sightnode.PeerInfo `json:"peers"`
+		}
+		subs struct {
+			Subscriptions []sightnode.SubscriptionHealth `json:"subscriptions"`
+		}
+		readiness   sightnode.ReadinessReport
+		deadLetters []sightnode.DeadLetter
+	)
+	for path, v := range map[string]interface{}{
+		"/v1/libp2p/stats":         &stats,
+		"/v1/libp2p/peers":         &peers,
+		"/v1/libp2p/subscriptions": &subs,
+		"/readyz":                  &readiness,
+		"/v1/libp2p/dlq":           &deadLetters,
+	} {
+		if err := d.get(ctx, path, v); err != nil {
+			d.err = err
+			return
+		}
+	}
+
+	now := time.Now()
+	if !d.polledAt.IsZero() {
+		elapsed := now.Sub(d.polledAt).Seconds()
+		rate := func(cur, prev uint64) float64 {
+			if cur < prev || elapsed <= 0 {
+				// The node restarted and its counters were reset
+				return 0
+			}
+			return float64(cur-prev) / elapsed
+		}
+		prev := d.stats.Messages
+		d.rates = map[string]float64{
+			"received":  rate(stats.Messages.Received, prev.Received),
+			"published": rate(stats.Messages.Published, prev.Published),
+			"failed":    rate(stats.Messages.Failed, prev.Failed),
+			"forwarded": rate(stats.Messages.Forwarded, prev.Forwarded),
+		}
+	}
+	d.polledAt = now
+	d.stats, d.peers, d.subscriptions = stats, peers.Peers, subs.Subscriptions
+	d.readiness, d.deadLetters = readiness, deadLetters
+	d.err = nil
+}
+
+// get decodes the JSON response of a GET request. /readyz answers 503 with
+// a report when the node is not ready, so any JSON body is accepted.
+func (d *dashboardView) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.api+path, nil)
+	if err != nil {
+		return err
+	}
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// render lays the snapshot out in lines of at most width columns, listing
+// only as many peers as fit in height rows (0 means unlimited)
+func (d *dashboardView) render(width, height int) []string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "sight dashboard  %s  every %s  (q to quit)  %s\n",
+		d.api, d.interval, time.Now().Format("15:04:05"))
+	if d.err != nil {
+		fmt.Fprintf(&b, "ERROR polling the node: %v\n", d.err)
+	}
+	if d.polledAt.IsZero() {
+		return truncateLines(b.String(), width)
+	}
+	s := d.stats
+
+	ready := "ready"
+	if !d.readiness.Ready {
+		var failing []string
+		for name, check := range d.readiness.Checks {
+			if !check.OK {
+				failing = append(failing, name)
+			}
+		}
+		sort.Strings(failing)
+		ready = "NOT READY (" + strings.Join(failing, ", ") + ")"
+	}
+	fmt.Fprintf(&b, "\nnode      %s  peer %s  up %s  %s\n", s.DID, s.PeerID, s.Uptime, ready)
+	fmt.Fprintf(&b, "messages  received %d (%.1f/s)  published %d (%.1f/s)  failed %d (%.1f/s)  forwarded %d (%.1f/s)\n",
+		s.Messages.Received, d.rates["received"], s.Messages.Published, d.rates["published"],
+		s.Messages.Failed, d.rates["failed"], s.Messages.Forwarded, d.rates["forwarded"])
+	fmt.Fprintf(&b, "queues    outbox %d (high %d, normal %d, low %d)  forwarder %d  dead letters %d\n",
+		s.Queues.OutboxPending, s.Queues.Outbox["high"], s.Queues.Outbox["normal"], s.Queues.Outbox["low"],
+		s.Queues.Forwarder, s.Queues.DeadLetters)
+
+	restarts := make(map[string]int)
+	for _, sub := range d.subscriptions {
+		restarts[sub.Topic] = sub.Restarts
+	}
+	b.WriteString("\n")
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tMESH PEERS\tHEALTHY\tRESTARTS")
+	for _, t := range s.Topics {
+		fmt.Fprintf(w, "%s\t%d\t%t\t%d\n", t.Topic, t.Peers, t.Healthy, restarts[t.Topic])
+	}
+	w.Flush()
+
+	errors := d.recentErrors()
+	peers := d.peers
+	if height > 0 {
+		// Header, blank lines and table headings take the remaining rows
+		used := strings.Count(b.String(), "\n") + 4 + len(errors)
+		if room := height - used; room < len(peers) {
+			peers = peers[:max(room, 0)]
+		}
+	}
+	fmt.Fprintf(&b, "\nPEERS (%d connected)\n", len(d.peers))
+	w = tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tDID\tDIRECTION\tSINCE\tAGENT")
+	for _, p := range peers {
+		since := ""
+		if !p.FirstSeen.IsZero() {
+			since = time.Since(p.FirstSeen).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.PeerID, p.DID, p.Direction, since, p.AgentVersion)
+	}
+	w.Flush()
+
+	fmt.Fprintf(&b, "\nRECENT ERRORS\n")
+	for _, e := range errors {
+		b.WriteString(e + "\n")
+	}
+	return truncateLines(b.String(), width)
+}
+
+// recentErrors lists the failing readiness checks, subscription errors and
+// the latest dead letters
+func (d *dashboardView) recentErrors() []string {
+	var errors []string
+	for name, check := range d.readiness.Checks {
+		if !check.OK {
+			errors = append(errors, fmt.Sprintf("readiness     %s: %s", name, check.Detail))
+		}
+	}
+	sort.Strings(errors)
+	for _, sub := range d.subscriptions {
+		if sub.LastError != "" {
+			errors = append(errors, fmt.Sprintf("subscription  %s %s: %s", sub.LastRestart, sub.Topic, sub.LastError))
+		}
+	}
+	for i := len(d.deadLetters) - 1; i >= 0 && len(errors) < dashboardErrors; i-- {
+		dl := d.deadLetters[i]
+		kind := "dead letter"
+		if dl.Parked {
+			kind = "parked"
+		}
+		errors = append(errors, fmt.Sprintf("%-12s  %s %s: %s", kind, dl.FailedAt, dl.MessageID, dl.Error))
+	}
+	if len(errors) > dashboardErrors {
+		errors = errors[:dashboardErrors]
+	}
+	return errors
+}
+
+// truncateLines splits text into lines cut to width runes (0 means no limit)
+func truncateLines(text string, width int) []string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if width <= 0 {
+		return lines
+	}
+	for i, line := range lines {
+		if r := []rune(line); len(r) > width {
+			lines[i] = string(r[:width])
+		}
+	}
+	return lines
+}
@@ -2,68 +2,179 @@ package main
 
 import (
 	"context"
-	"github.com/gorilla/mux"
+	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
+	"time"
+
+	"sight-libp2p-node/pkg/sightnode"
 )
 
 func main() {
-	// Load or generate keypair
-	keypair := LoadOrGenerateKeypair()
+	// "simulate" runs a local mini-mesh instead of a node
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+	// "dashboard" watches a running node through its HTTP API
+	if len(os.Args) > 1 && os.Args[1] == "dashboard" {
+		dashboard(os.Args[2:])
+		return
+	}
+	// "bench" measures throughput, latency and loss of a message stream
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+
+	identity := flag.String("identity", os.Getenv("IDENTITY"), "named identity profile to run as")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "env file overriding the environment, re-read on reload")
+	flag.Parse()
+	if err := sightnode.SetIdentity(*identity); err != nil {
+		log.Fatal(err)
+	}
+	if *identity != "" {
+		log.Printf("Using identity profile %q", *identity)
+	}
+
+	// Get environment variables (with defaults), overridden by the config
+	// file, which SIGHUP and /config/reload read again
+	cfg := sightnode.LoadConfig()
+	var nodeOpts []sightnode.Option
+	if *configFile != "" {
+		loadConfig := func() (sightnode.Config, error) {
+			return sightnode.LoadConfigFile(*configFile)
+		}
+		var err error
+		if cfg, err = loadConfig(); err != nil {
+			log.Fatal("Failed to read config file: ", err)
+		}
+		nodeOpts = append(nodeOpts, sightnode.WithConfigSource(loadConfig))
+	}
+	sightnode.SetLogLevel(cfg.LogLevel)
 
-	// Get environment variables (with defaults)
-	isGateway := os.Getenv("IS_GATEWAY") == "1"
-	nodePort := getEnvInt("NODE_PORT", 15050)
-	libp2pPort := getEnvInt("LIBP2P_PORT", 4010)
-	bootstrap := strings.Split(os.Getenv("BOOTSTRAP_ADDRS"), ",")
-	tunnelAPI := "http://localhost:" + os.Getenv("API_PORT") + "/libp2p/message"
+	// Load or generate keypair
+	keypair, err := sightnode.LoadOrGenerateKeypair(cfg)
+	if err != nil {
+		log.Fatal("Failed to load keypair: ", err)
+	}
 
-	// Create the Libp2p service
-	service := NewLibp2pNodeService(keypair, nodePort, tunnelAPI, isGateway, bootstrap)
-	service.InitNode()
+	shutdownTracing, err := sightnode.InitTracing(context.Background(), cfg)
+	if err != nil {
+		log.Fatal("Failed to set up tracing: ", err)
+	}
 
-	// Create the controller
-	controller := NewLibp2pNodeController(service)
+	// Root context of the node's work, cancelled once shutdown has drained
+	// the queues so in-flight publishes, dials and forwards stop
+	rootCtx, cancelRoot := context.WithCancel(context.Background())
+	defer cancelRoot()
 
-	// Set up router
-	router := mux.NewRouter()
-	router.HandleFunc("/libp2p/send", controller.SendHandler).Methods("POST")
+	// Create and start the node
+	node, err := sightnode.New(keypair, cfg, nodeOpts...)
+	if err != nil {
+		log.Fatal("Failed to create node: ", err)
+	}
+	if err := startNode(rootCtx, node, cfg); err != nil {
+		log.Fatal("Failed to start node: ", err)
+	}
 
 	// Start the HTTP server
 	srv := &http.Server{
-		Handler: router,
-		Addr:    ":" + strconv.Itoa(libp2pPort),
+		Handler: node.Handler(),
+		Addr:    ":" + strconv.Itoa(cfg.Libp2pPort),
 	}
 
+	tlsConf, err := sightnode.APITLSConfig(cfg)
+	if err != nil {
+		log.Fatal("Invalid API TLS config: ", err)
+	}
+	srv.TLSConfig = tlsConf
+
 	// Run server in a goroutine
 	go func() {
-		log.Printf("HTTP server started on :%d", libp2pPort)
-		if err := srv.ListenAndServe(); err != nil {
+		var err error
+		if srv.TLSConfig != nil {
+			log.Printf("HTTPS server started on :%d", cfg.Libp2pPort)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("HTTP server started on :%d", cfg.Libp2pPort)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
 
-	// Graceful shutdown
+	// Optional gRPC control-plane API
+	grpcSrv, err := sightnode.NewGRPCServer(node.Service(), cfg)
+	if err != nil {
+		log.Fatal("Failed to create gRPC server: ", err)
+	}
+	if cfg.GRPCPort != 0 {
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.GRPCPort))
+		if err != nil {
+			log.Fatal("Failed to listen for gRPC: ", err)
+		}
+		go func() {
+			log.Printf("gRPC server started on :%d", cfg.GRPCPort)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := node.Reload(); err != nil {
+				log.Printf("Configuration reload failed: %v", err)
+			}
+		}
+	}()
+
+	// Graceful shutdown: refuse new sends and flush the queues before closing
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 	log.Println("Shutting down...")
-	service.Stop()
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	if err := node.Drain(drainCtx); err != nil {
+		log.Printf("Drain timed out after %s", cfg.DrainTimeout)
+	}
+	cancel()
+	cancelRoot()
+	grpcSrv.Stop()
+	node.Stop()
 	srv.Shutdown(context.Background())
+	shutdownTracing(context.Background())
 }
 
-func getEnvInt(key string, defaultVal int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultVal
-	}
-	intVal, err := strconv.Atoi(value)
-	if err != nil {
-		return defaultVal
+// startNode starts the node, retrying transient failures such as a busy port
+// or a failed topic join with exponential backoff. Configuration errors and
+// the last failure once the attempts are used up are returned.
+func startNode(ctx context.Context, node *sightnode.Node, cfg sightnode.Config) error {
+	wait := cfg.StartupBackoffMin
+	for attempt := 1; ; attempt++ {
+		err := node.Start(ctx)
+		if err == nil {
+			return nil
+		}
+		if sightnode.IsFatalStartupError(err) || attempt >= cfg.StartupAttempts {
+			return err
+		}
+		log.Printf("Starting node failed (attempt %d/%d), retrying in %s: %v", attempt, cfg.StartupAttempts, wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, cfg.StartupBackoffMax)
 	}
-	return intVal
 }
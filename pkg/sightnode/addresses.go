@@ -0,0 +1,59 @@
+package sightnode
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddressReport lists the node addresses as full /p2p/ multiaddrs, ready to
+// be used as bootstrap strings
+type AddressReport struct {
+	PeerID     string   `json:"peerId"`
+	Listen     []string `json:"listen"`
+	Interfaces []string `json:"interfaces"`
+	Observed   []string `json:"observed"`
+	Advertised []string `json:"advertised"`
+}
+
+// Addresses returns the configured listen addresses, their expansion to the
+// local interfaces, the addresses peers observed us at and the ones we announce
+func (s *Libp2pNodeService) Addresses() AddressReport {
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+
+	id := node.ID()
+	report := AddressReport{
+		PeerID:     id.String(),
+		Listen:     p2pAddrs(id, node.Network().ListenAddresses()),
+		Advertised: p2pAddrs(id, node.Addrs()),
+		Observed:   []string{},
+	}
+	ifaceAddrs, err := node.Network().InterfaceListenAddresses()
+	if err == nil {
+		report.Interfaces = p2pAddrs(id, ifaceAddrs)
+	} else {
+		report.Interfaces = []string{}
+	}
+	if h, ok := node.(interface{ IDService() identify.IDService }); ok {
+		report.Observed = p2pAddrs(id, h.IDService().OwnObservedAddrs())
+	}
+	return report
+}
+
+// p2pAddrs appends /p2p/<id> to each address
+func p2pAddrs(id peer.ID, addrs []ma.Multiaddr) []string {
+	out := make([]string, 0, len(addrs))
+	if len(addrs) == 0 {
+		return out
+	}
+	full, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: id, Addrs: addrs})
+	if err != nil {
+		return out
+	}
+	for _, addr := range full {
+		out = append(out, addr.String())
+	}
+	return out
+}
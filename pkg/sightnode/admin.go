@@ -0,0 +1,58 @@
+package sightnode
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// requireAdmin only lets requests carrying "Authorization: Bearer <ADMIN_TOKEN>"
+// through. Without a configured token the wrapped endpoints are disabled.
+func requireAdmin(cfg Config, next http.Handler) http.Handler {
+	return adminGate(cfg, "Bearer", next)
+}
+
+// requireAdminBrowser is requireAdmin for pages opened in a browser, which
+// cannot send a bearer token: it challenges with Basic auth so the browser
+// prompts for the token, entered as the password with any user name
+func requireAdminBrowser(cfg Config, next http.Handler) http.Handler {
+	return adminGate(cfg, `Basic realm="sight node", charset="UTF-8"`, next)
+}
+
+// adminGate accepts the admin token as a bearer token or a Basic auth
+// password and answers other requests with the challenge
+func adminGate(cfg Config, challenge string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminToken == "" {
+			http.Error(w, "Admin endpoints are disabled (ADMIN_TOKEN not set)", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerDebugRoutes exposes net/http/pprof and expvar under /debug for
+// diagnosing memory growth and goroutine leaks
+func registerDebugRoutes(router *mux.Router, cfg Config) {
+	debug := router.PathPrefix("/debug").Subrouter()
+	debug.Use(func(next http.Handler) http.Handler { return requireAdmin(cfg, next) })
+	debug.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/pprof/trace", pprof.Trace)
+	debug.PathPrefix("/pprof/").HandlerFunc(pprof.Index)
+	debug.Handle("/vars", expvar.Handler())
+}
@@ -0,0 +1,402 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// admissionProtocol is the registration handshake a hoster runs with a
+// gateway before the gateway routes its messages
+const admissionProtocol protocol.ID = "/sight/admission/1.0.0"
+
+// ADMISSION_MODE values
+const (
+	admissionOpen    = "open"
+	admissionAuto    = "auto"
+	admissionManual  = "manual"
+	admissionWebhook = "webhook"
+)
+
+// Admission states
+const (
+	admissionPending  = "pending"
+	admissionApproved = "approved"
+	admissionRejected = "rejected"
+)
+
+var errUnknownAdmission = errors.New("no admission request for this DID")
+
+// Admission is a gateway's decision about one hoster DID
+type Admission struct {
+	DID         string `json:"did"`
+	PeerID      string `json:"peerId"`
+	Status      string `json:"status"`
+	RequestedAt string `json:"requestedAt"`
+	DecidedAt   string `json:"decidedAt,omitempty"`
+}
+
+type admissionChallenge struct {
+	Challenge []byte `json:"challenge"`
+}
+
+type admissionRequest struct {
+	DID       string `json:"did"`
+	Signature []byte `json:"signature"`
+}
+
+type admissionResponse struct {
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// admissionSigningBytes binds the signed challenge to the gateway it was
+// issued by, so it cannot be replayed to another gateway
+func admissionSigningBytes(gateway peer.ID, challenge []byte) []byte {
+	return append([]byte("sight-admission:"+gateway.String()+":"), challenge...)
+}
+
+// admissionList holds the admission decisions of a gateway, persisted across
+// restarts
+type admissionList struct {
+	mu      sync.RWMutex
+	entries map[string]*Admission
+	path    string
+}
+
+func newAdmissionList(cfg Config) *admissionList {
+	l := &admissionList{
+		entries: make(map[string]*Admission),
+		path:    cfg.DataDir + "/admissions.json",
+	}
+	l.load()
+	return l
+}
+
+// Get returns the admission of a DID
+func (l *admissionList) Get(did string) (Admission, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	a, ok := l.entries[did]
+	if !ok {
+		return Admission{}, false
+	}
+	return *a, true
+}
+
+// Put adds or replaces an admission
+func (l *admissionList) Put(a Admission) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[a.DID] = &a
+	l.save()
+}
+
+// List returns the admissions sorted by request time
+func (l *admissionList) List() []Admission {
+	l.mu.RLock()
+	list := make([]Admission, 0, len(l.entries))
+	for _, a := range l.entries {
+		list = append(list, *a)
+	}
+	l.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].RequestedAt < list[j].RequestedAt })
+	return list
+}
+
+func (l *admissionList) load() {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return
+	}
+	var list []Admission
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Error reading admissions: %v", err)
+		return
+	}
+	for i := range list {
+		l.entries[list[i].DID] = &list[i]
+	}
+}
+
+// save persists the list; callers hold l.mu
+func (l *admissionList) save() {
+	list := make([]Admission, 0, len(l.entries))
+	for _, a := range l.entries {
+		list = append(list, *a)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Error marshalling admissions: %v", err)
+		return
+	}
+	if err := os.WriteFile(l.path, data, 0600); err != nil {
+		log.Printf("Error writing admissions: %v", err)
+	}
+}
+
+// admissionRequired reports whether this gateway routes only admitted hosters
+func (s *Libp2pNodeService) admissionRequired() bool {
+	return s.isGateway && s.cfg.AdmissionMode != admissionOpen
+}
+
+// admitted reports whether the gateway may route messages of a peer
+func (s *Libp2pNodeService) admitted(id peer.ID) bool {
+	if !s.admissionRequired() {
+		return true
+	}
+	did, err := s.peerDID(id)
+	if err != nil {
+		return false
+	}
+	a, ok := s.admissions.Get(did)
+	return ok && a.Status == admissionApproved && a.PeerID == id.String()
+}
+
+// handleAdmissionStream challenges a hoster to prove it holds its DID key and
+// decides on its admission according to ADMISSION_MODE
+func (s *Libp2pNodeService) handleAdmissionStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(30 * time.Second))
+	from := st.Conn().RemotePeer()
+	self := st.Conn().LocalPeer()
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		st.Reset()
+		return
+	}
+	if err := json.NewEncoder(st).Encode(admissionChallenge{Challenge: challenge}); err != nil {
+		st.Reset()
+		return
+	}
+	var req admissionRequest
+	if err := json.NewDecoder(st).Decode(&req); err != nil {
+		st.Reset()
+		return
+	}
+	var resp admissionResponse
+	if err := verifyAdmission(self, from, challenge, req); err != nil {
+		log.Printf("Rejecting admission of %s: %v", from, err)
+		resp.Error = err.Error()
+	} else {
+		resp.Status = s.decideAdmission(req.DID, from)
+	}
+	json.NewEncoder(st).Encode(resp)
+}
+
+// verifyAdmission checks that the DID belongs to the connected peer and that
+// it signed the challenge
+func verifyAdmission(gateway, from peer.ID, challenge []byte, req admissionRequest) error {
+	id, err := DIDToPeerID(req.DID)
+	if err != nil {
+		return err
+	}
+	if id != from {
+		return fmt.Errorf("DID %s does not belong to %s", req.DID, from)
+	}
+	pub, err := DIDPublicKey(req.DID)
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(admissionSigningBytes(gateway, challenge), req.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid challenge signature")
+	}
+	return nil
+}
+
+// decideAdmission returns the admission status of a verified hoster, keeping
+// earlier decisions and asking the policy for new DIDs
+func (s *Libp2pNodeService) decideAdmission(did string, id peer.ID) string {
+	a, known := s.admissions.Get(did)
+	if !known {
+		a = Admission{DID: did, Status: admissionPending, RequestedAt: time.Now().Format(time.RFC3339)}
+		switch s.cfg.AdmissionMode {
+		case admissionAuto:
+			a.Status = admissionApproved
+		case admissionWebhook:
+			a.Status = s.askAdmissionWebhook(did, id)
+		}
+		if a.Status != admissionPending {
+			a.DecidedAt = a.RequestedAt
+		}
+		log.Printf("Admission of %s: %s", did, a.Status)
+	}
+	a.PeerID = id.String()
+	s.admissions.Put(a)
+	if a.Status == admissionApproved {
+		s.routeAdmitted(id)
+	}
+	return a.Status
+}
+
+// askAdmissionWebhook asks ADMISSION_WEBHOOK_URL about a new hoster. A 2xx
+// response approves it unless its JSON body says {"status":"pending"} or
+// {"status":"rejected"}; other responses reject it. When the endpoint cannot
+// be reached the request stays pending for an operator to decide.
+func (s *Libp2pNodeService) askAdmissionWebhook(did string, id peer.ID) string {
+	body, err := json.Marshal(map[string]string{"did": did, "peerId": id.String()})
+	if err != nil {
+		return admissionPending
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.cfg.AdmissionWebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Admission webhook unavailable, %s stays pending: %v", did, err)
+		return admissionPending
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return admissionRejected
+	}
+	var decision admissionResponse
+	if json.NewDecoder(resp.Body).Decode(&decision) == nil {
+		switch decision.Status {
+		case admissionPending, admissionRejected:
+			return decision.Status
+		}
+	}
+	return admissionApproved
+}
+
+// routeAdmitted adds an admitted hoster to the registry
+func (s *Libp2pNodeService) routeAdmitted(id peer.ID) {
+	s.mu.RLock()
+	node, registry := s.node, s.registry
+	s.mu.RUnlock()
+	if registry != nil {
+		registry.record(id, node.Peerstore().Addrs(id))
+	}
+}
+
+// DecideAdmission approves or rejects a hoster DID. A rejected hoster is
+// removed from the registry and disconnected.
+func (s *Libp2pNodeService) DecideAdmission(did string, approve bool) (Admission, error) {
+	a, ok := s.admissions.Get(did)
+	if !ok {
+		return Admission{}, errUnknownAdmission
+	}
+	a.Status = admissionRejected
+	if approve {
+		a.Status = admissionApproved
+	}
+	a.DecidedAt = time.Now().Format(time.RFC3339)
+	s.admissions.Put(a)
+	log.Printf("Admission of %s: %s", did, a.Status)
+
+	id, err := peer.Decode(a.PeerID)
+	if err != nil {
+		return a, nil
+	}
+	if approve {
+		s.routeAdmitted(id)
+		return a, nil
+	}
+	s.mu.RLock()
+	node, registry := s.node, s.registry
+	s.mu.RUnlock()
+	if registry != nil {
+		registry.Remove(did)
+	}
+	node.Network().ClosePeer(id)
+	return a, nil
+}
+
+// Admissions returns the admission decisions of this gateway
+func (s *Libp2pNodeService) Admissions() []Admission {
+	return s.admissions.List()
+}
+
+// requestAdmission runs the handshake with a gateway and returns its decision
+func requestAdmission(ctx context.Context, h hostlibp2p.Host, gateway peer.ID, kp Keypair, did string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	st, err := h.NewStream(ctx, gateway, admissionProtocol)
+	if err != nil {
+		return "", err
+	}
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(30 * time.Second))
+	var ch admissionChallenge
+	dec := json.NewDecoder(st)
+	if err := dec.Decode(&ch); err != nil {
+		return "", err
+	}
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return "", err
+	}
+	sig, err := priv.Sign(admissionSigningBytes(gateway, ch.Challenge))
+	if err != nil {
+		return "", err
+	}
+	if err := json.NewEncoder(st).Encode(admissionRequest{DID: did, Signature: sig}); err != nil {
+		return "", err
+	}
+	var resp admissionResponse
+	if err := dec.Decode(&resp); err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Status, nil
+}
+
+// watchAdmission registers with every gateway that requires admission as
+// soon as identify shows it speaks the protocol
+func (s *Libp2pNodeService) watchAdmission(ctx context.Context, h hostlibp2p.Host) error {
+	if _, err := ParseSightDID(s.did); err != nil {
+		return nil
+	}
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	kp, did := s.keypair, s.did
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				if !supportsProtocol(evt.Protocols, admissionProtocol) {
+					continue
+				}
+				go func(gateway peer.ID) {
+					status, err := requestAdmission(ctx, h, gateway, kp, did)
+					if err != nil {
+						log.Printf("Admission to gateway %s failed: %v", gateway, err)
+						return
+					}
+					log.Printf("Admission to gateway %s: %s", gateway, status)
+				}(evt.Peer)
+			}
+		}
+	}()
+	return nil
+}
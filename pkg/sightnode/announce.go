@@ -0,0 +1,80 @@
+package sightnode
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// addrsFactory builds the libp2p.AddrsFactory option from ANNOUNCE_ADDRS and
+// NO_ANNOUNCE_ADDRS. Announce addresses replace the detected ones entirely;
+// no-announce entries (multiaddrs or CIDR ranges) are then filtered out.
+// It returns nil when neither is configured.
+func addrsFactory(cfg Config) (libp2p.Option, error) {
+	if len(cfg.AnnounceAddrs) == 0 && len(cfg.NoAnnounceAddrs) == 0 {
+		return nil, nil
+	}
+	var announce []ma.Multiaddr
+	for _, s := range cfg.AnnounceAddrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announce address %q: %w", s, err)
+		}
+		announce = append(announce, addr)
+	}
+	var noAnnounce []ma.Multiaddr
+	var noAnnounceNets []*net.IPNet
+	for _, s := range cfg.NoAnnounceAddrs {
+		if !strings.HasPrefix(s, "/") {
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid no-announce range %q: %w", s, err)
+			}
+			noAnnounceNets = append(noAnnounceNets, ipnet)
+			continue
+		}
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid no-announce address %q: %w", s, err)
+		}
+		noAnnounce = append(noAnnounce, addr)
+	}
+
+	return libp2p.AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if len(announce) > 0 {
+			addrs = announce
+		}
+		out := make([]ma.Multiaddr, 0, len(addrs))
+		for _, addr := range addrs {
+			if !addrExcluded(addr, noAnnounce, noAnnounceNets) {
+				out = append(out, addr)
+			}
+		}
+		return out
+	}), nil
+}
+
+func addrExcluded(addr ma.Multiaddr, exact []ma.Multiaddr, nets []*net.IPNet) bool {
+	for _, ex := range exact {
+		if addr.Equal(ex) {
+			return true
+		}
+	}
+	if len(nets) == 0 {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false
+	}
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package sightnode
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// APITLSConfig builds the HTTPS config for the HTTP API, requiring client
+// certificates signed by API_TLS_CLIENT_CA_FILE when it is set (mTLS).
+// It returns nil when TLS is not configured.
+func APITLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.APITLSCertFile == "" && cfg.APITLSKeyFile == "" {
+		if cfg.APITLSClientCAFile != "" {
+			return nil, errors.New("API_TLS_CLIENT_CA_FILE requires API_TLS_CERT_FILE and API_TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.APITLSCertFile, cfg.APITLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.APITLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.APITLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in API_TLS_CLIENT_CA_FILE")
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConf, nil
+}
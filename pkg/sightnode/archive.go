@@ -0,0 +1,220 @@
+package sightnode
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Archive directions
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// archiveQueueSize bounds the envelopes waiting to be written; beyond it
+// archiving is skipped rather than slowing down delivery
+const archiveQueueSize = 1024
+
+// ArchivedMessage is one sent or received envelope
+type ArchivedMessage struct {
+	ID        string          `json:"id"`
+	Direction string          `json:"direction"`
+	FromDID   string          `json:"fromDid,omitempty"`
+	ToDID     string          `json:"toDid,omitempty"`
+	Topic     string          `json:"topic,omitempty"`
+	Type      string          `json:"type,omitempty"`
+	Envelope  json.RawMessage `json:"envelope"`
+	Time      string          `json:"time"`
+}
+
+// ArchiveQuery filters GET /v1/libp2p/messages; zero values match everything
+type ArchiveQuery struct {
+	DID       string
+	Topic     string
+	Direction string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// messageArchive stores envelopes in SQLite, written by a single goroutine
+type messageArchive struct {
+	db    *sql.DB
+	queue chan archiveEntry
+}
+
+type archiveEntry struct {
+	msg  ArchivedMessage
+	time time.Time
+}
+
+func openMessageArchive(cfg Config) (*messageArchive, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.ArchivePath), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", cfg.ArchivePath+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+CREATE TABLE IF NOT EXISTS messages (
+	id         TEXT NOT NULL,
+	direction  TEXT NOT NULL,
+	from_did   TEXT NOT NULL DEFAULT '',
+	to_did     TEXT NOT NULL DEFAULT '',
+	topic      TEXT NOT NULL DEFAULT '',
+	type       TEXT NOT NULL DEFAULT '',
+	envelope   TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_created_at ON messages (created_at);
+CREATE INDEX IF NOT EXISTS messages_from_did ON messages (from_did, created_at);
+CREATE INDEX IF NOT EXISTS messages_to_did ON messages (to_did, created_at);`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating archive schema: %w", err)
+	}
+	a := &messageArchive{db: db, queue: make(chan archiveEntry, archiveQueueSize)}
+	go a.writer()
+	return a, nil
+}
+
+// Record queues an envelope for archiving without blocking
+func (a *messageArchive) Record(direction, topic, fromDID string, envelope map[string]interface{}) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return
+	}
+	msg := ArchivedMessage{Direction: direction, Topic: topic, FromDID: fromDID, Envelope: data}
+	msg.ID, _ = envelope["id"].(string)
+	msg.ToDID, _ = envelope["to"].(string)
+	msg.Type = envelopeType(envelope)
+	select {
+	case a.queue <- archiveEntry{msg: msg, time: time.Now()}:
+	default:
+		debugf("Archive queue full, not archiving message %s", msg.ID)
+	}
+}
+
+func (a *messageArchive) writer() {
+	for e := range a.queue {
+		_, err := a.db.Exec(`INSERT INTO messages (id, direction, from_did, to_did, topic, type, envelope, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.msg.ID, e.msg.Direction, e.msg.FromDID, e.msg.ToDID, e.msg.Topic, e.msg.Type, string(e.msg.Envelope), e.time.UnixMilli())
+		if err != nil {
+			log.Printf("Error archiving message %s: %v", e.msg.ID, err)
+		}
+	}
+}
+
+// evictOlderThan deletes messages archived before cutoff
+func (a *messageArchive) evictOlderThan(cutoff time.Time) (int64, error) {
+	res, err := a.db.Exec(`DELETE FROM messages WHERE created_at < ?`, cutoff.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// evictOverBytes deletes the oldest messages beyond max envelope bytes, per
+// remote DID (the sender of incoming and the recipient of outgoing messages)
+// or across the whole archive
+func (a *messageArchive) evictOverBytes(max int64, perDID bool) (int64, error) {
+	partition := ""
+	if perDID {
+		partition = "PARTITION BY CASE direction WHEN 'in' THEN from_did ELSE to_did END"
+	}
+	res, err := a.db.Exec(`DELETE FROM messages WHERE rowid IN (
+	SELECT rowid FROM (
+		SELECT rowid, SUM(length(envelope)) OVER (`+partition+` ORDER BY created_at DESC, rowid DESC) AS total
+		FROM messages
+	) WHERE total > ?
+)`, max)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// deleteDID deletes the messages sent to or by did
+func (a *messageArchive) deleteDID(did string) (int64, error) {
+	res, err := a.db.Exec(`DELETE FROM messages WHERE from_did = ? OR to_did = ?`, did, did)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Query returns archived messages matching q, newest first
+func (a *messageArchive) Query(q ArchiveQuery) ([]ArchivedMessage, error) {
+	var where []string
+	var args []interface{}
+	if q.DID != "" {
+		where = append(where, "(from_did = ? OR to_did = ?)")
+		args = append(args, q.DID, q.DID)
+	}
+	if q.Topic != "" {
+		where = append(where, "topic = ?")
+		args = append(args, q.Topic)
+	}
+	if q.Direction != "" {
+		where = append(where, "direction = ?")
+		args = append(args, q.Direction)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at <= ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	query := `SELECT id, direction, from_did, to_did, topic, type, envelope, created_at FROM messages`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := []ArchivedMessage{}
+	for rows.Next() {
+		var m ArchivedMessage
+		var envelope string
+		var created int64
+		if err := rows.Scan(&m.ID, &m.Direction, &m.FromDID, &m.ToDID, &m.Topic, &m.Type, &envelope, &created); err != nil {
+			return nil, err
+		}
+		m.Envelope = json.RawMessage(envelope)
+		m.Time = time.UnixMilli(created).Format(time.RFC3339Nano)
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// ArchivedMessages queries the message archive
+func (s *Libp2pNodeService) ArchivedMessages(q ArchiveQuery) ([]ArchivedMessage, error) {
+	if s.archive == nil {
+		return nil, fmt.Errorf("message archive is disabled")
+	}
+	return s.archive.Query(q)
+}
+
+// archiveMessage records an envelope when the archive is enabled
+func (s *Libp2pNodeService) archiveMessage(direction, topic, fromDID string, envelope map[string]interface{}) {
+	if s.archive != nil {
+		s.archive.Record(direction, topic, fromDID, envelope)
+	}
+}
@@ -0,0 +1,205 @@
+package sightnode
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audit outcomes
+const (
+	auditPublished = "published"
+	auditFailed    = "failed"
+	auditReceived  = "received"
+	auditExpired   = "expired"
+	auditRevoked   = "revoked"
+)
+
+var errAuditDisabled = errors.New("audit log is disabled")
+
+// AuditEntry records the metadata of one message, never its payload. Each
+// entry carries the hash of the previous one, so editing or removing an
+// entry breaks the chain from that point on.
+type AuditEntry struct {
+	Seq       uint64 `json:"seq"`
+	Time      string `json:"time"`
+	MessageID string `json:"messageId,omitempty"`
+	Direction string `json:"direction"`
+	FromDID   string `json:"fromDid,omitempty"`
+	ToDID     string `json:"toDid,omitempty"`
+	Size      int    `json:"size"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	PrevHash  string `json:"prevHash"`
+	Hash      string `json:"hash"`
+}
+
+// hash returns the chain hash of the entry: SHA-256 over its JSON encoding
+// without the hash itself
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditVerification is the result of checking the audit chain
+type AuditVerification struct {
+	Valid   bool   `json:"valid"`
+	Entries uint64 `json:"entries"`
+	Head    string `json:"head,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// auditLog is an append-only JSON Lines file of hash-chained entries
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	seq  uint64
+	head string
+}
+
+// openAuditLog opens the log for appending, continuing the chain of the
+// entries already in it
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	l := &auditLog{path: path}
+	v := l.verify()
+	if !v.Valid {
+		return nil, fmt.Errorf("audit log %s is corrupt: %s", path, v.Error)
+	}
+	l.seq, l.head = v.Entries, v.Head
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// Append adds an entry to the chain
+func (l *auditLog) Append(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = l.seq + 1
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.PrevHash = l.head
+	hash, err := e.hash()
+	if err != nil {
+		log.Printf("Error hashing audit entry: %v", err)
+		return
+	}
+	e.Hash = hash
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling audit entry: %v", err)
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	l.seq, l.head = e.Seq, e.Hash
+}
+
+// Export writes the whole log to w
+func (l *auditLog) Export(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Verify walks the chain and reports the first broken entry
+func (l *auditLog) Verify() AuditVerification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.verify()
+}
+
+func (l *auditLog) verify() AuditVerification {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return AuditVerification{Valid: true}
+	}
+	if err != nil {
+		return AuditVerification{Error: err.Error()}
+	}
+	defer f.Close()
+
+	var v AuditVerification
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			v.Error = fmt.Sprintf("entry %d: %v", v.Entries+1, err)
+			return v
+		}
+		if e.Seq != v.Entries+1 || e.PrevHash != v.Head {
+			v.Error = fmt.Sprintf("entry %d does not follow entry %d", e.Seq, v.Entries)
+			return v
+		}
+		if hash, err := e.hash(); err != nil || hash != e.Hash {
+			v.Error = fmt.Sprintf("entry %d has been modified", e.Seq)
+			return v
+		}
+		v.Entries, v.Head = e.Seq, e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Valid = true
+	return v
+}
+
+// auditEnvelope records the outcome of a message when AUDIT_LOG is enabled
+func (s *Libp2pNodeService) auditEnvelope(direction, fromDID string, envelope map[string]interface{}, size int, outcome string, err error) {
+	if s.auditLog == nil {
+		return
+	}
+	e := AuditEntry{Direction: direction, FromDID: fromDID, Size: size, Outcome: outcome}
+	e.MessageID, _ = envelope["id"].(string)
+	e.ToDID, _ = envelope["to"].(string)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.auditLog.Append(e)
+}
+
+// ExportAuditLog writes the audit log as JSON Lines
+func (s *Libp2pNodeService) ExportAuditLog(w io.Writer) error {
+	if s.auditLog == nil {
+		return errAuditDisabled
+	}
+	return s.auditLog.Export(w)
+}
+
+// VerifyAuditLog checks the hash chain of the audit log
+func (s *Libp2pNodeService) VerifyAuditLog() (AuditVerification, error) {
+	if s.auditLog == nil {
+		return AuditVerification{}, errAuditDisabled
+	}
+	return s.auditLog.Verify(), nil
+}
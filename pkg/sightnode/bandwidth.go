@@ -0,0 +1,99 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bandwidthIdleTTL is how long an idle peer or protocol keeps its counters
+const bandwidthIdleTTL = time.Hour
+
+// BandwidthReport is returned by GET /v1/libp2p/bandwidth
+type BandwidthReport struct {
+	Totals    metrics.Stats            `json:"totals"`
+	Peers     map[string]metrics.Stats `json:"peers"`
+	Protocols map[string]metrics.Stats `json:"protocols"`
+}
+
+// newBandwidthCounter creates the counter passed to the host as its
+// bandwidth reporter and registers it with Prometheus. With several nodes in
+// one process, e.g. on a sightnodetest.Network, only the first node's
+// counter is exported; a failed registration leaves it unexported too.
+func newBandwidthCounter(cfg Config) *metrics.BandwidthCounter {
+	bwc := metrics.NewBandwidthCounter()
+	err := prometheus.Register(&bandwidthCollector{bwc: bwc, perPeer: cfg.BandwidthPeerMetrics})
+	if err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		log.Printf("Error registering bandwidth metrics: %v", err)
+	}
+	return bwc
+}
+
+// trimBandwidth drops the counters of idle peers and protocols until ctx is
+// done
+func trimBandwidth(ctx context.Context, bwc *metrics.BandwidthCounter) {
+	ticker := time.NewTicker(bandwidthIdleTTL / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bwc.TrimIdle(time.Now().Add(-bandwidthIdleTTL))
+		}
+	}
+}
+
+// Bandwidth returns the byte counters and rates per peer and per protocol
+func (s *Libp2pNodeService) Bandwidth() BandwidthReport {
+	report := BandwidthReport{
+		Totals:    s.bandwidth.GetBandwidthTotals(),
+		Peers:     make(map[string]metrics.Stats),
+		Protocols: make(map[string]metrics.Stats),
+	}
+	for id, stats := range s.bandwidth.GetBandwidthByPeer() {
+		report.Peers[id.String()] = stats
+	}
+	for proto, stats := range s.bandwidth.GetBandwidthByProtocol() {
+		report.Protocols[string(proto)] = stats
+	}
+	return report
+}
+
+var (
+	bandwidthProtocolDesc = prometheus.NewDesc("sight_bandwidth_protocol_bytes_total",
+		"Bytes transferred per libp2p protocol", []string{"protocol", "direction"}, nil)
+	bandwidthPeerDesc = prometheus.NewDesc("sight_bandwidth_peer_bytes_total",
+		"Bytes transferred per peer", []string{"peer", "direction"}, nil)
+)
+
+// bandwidthCollector exposes the bandwidth counter as Prometheus counters
+type bandwidthCollector struct {
+	bwc     *metrics.BandwidthCounter
+	perPeer bool
+}
+
+func (c *bandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bandwidthProtocolDesc
+	if c.perPeer {
+		ch <- bandwidthPeerDesc
+	}
+}
+
+func (c *bandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	for proto, stats := range c.bwc.GetBandwidthByProtocol() {
+		ch <- prometheus.MustNewConstMetric(bandwidthProtocolDesc, prometheus.CounterValue, float64(stats.TotalIn), string(proto), "in")
+		ch <- prometheus.MustNewConstMetric(bandwidthProtocolDesc, prometheus.CounterValue, float64(stats.TotalOut), string(proto), "out")
+	}
+	if !c.perPeer {
+		return
+	}
+	for id, stats := range c.bwc.GetBandwidthByPeer() {
+		ch <- prometheus.MustNewConstMetric(bandwidthPeerDesc, prometheus.CounterValue, float64(stats.TotalIn), id.String(), "in")
+		ch <- prometheus.MustNewConstMetric(bandwidthPeerDesc, prometheus.CounterValue, float64(stats.TotalOut), id.String(), "out")
+	}
+}
@@ -0,0 +1,259 @@
+package sightnode
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// bootstrapTag protects bootstrap/gateway peers in the connection manager
+const bootstrapTag = "bootstrap"
+
+type bootstrapPeer struct {
+	info        peer.AddrInfo
+	failures    int
+	nextAttempt time.Time
+}
+
+// bootstrapManager keeps the node connected to its bootstrap peers, re-dialing
+// with exponential backoff and jitter whenever a connection is lost. Peers come
+// from static /p2p/ multiaddrs and from /dnsaddr seeds re-resolved periodically.
+type bootstrapManager struct {
+	mu       sync.Mutex
+	host     hostlibp2p.Host
+	cfg      Config
+	gater    *PeerGater
+	static   []peer.AddrInfo
+	seeds    []ma.Multiaddr
+	resolved []peer.AddrInfo
+	peers    map[peer.ID]*bootstrapPeer
+	kick     chan struct{}
+	reseed   chan struct{}
+}
+
+func newBootstrapManager(h hostlibp2p.Host, cfg Config, gater *PeerGater, addrs []string) *bootstrapManager {
+	m := &bootstrapManager{
+		host:   h,
+		cfg:    cfg,
+		gater:  gater,
+		peers:  make(map[peer.ID]*bootstrapPeer),
+		kick:   make(chan struct{}, 1),
+		reseed: make(chan struct{}, 1),
+	}
+	m.static, m.seeds = parseBootstrapAddrs(append(addrs, dnsSeedAddrs(cfg.BootstrapDNSSeeds)...))
+	m.rebuild()
+	return m
+}
+
+// parseBootstrapAddrs parses multiaddrs, merging several addresses of one peer.
+// /dnsaddr entries without a /p2p/ component are returned as DNS seeds.
+func parseBootstrapAddrs(addrs []string) ([]peer.AddrInfo, []ma.Multiaddr) {
+	var maddrs, seeds []ma.Multiaddr
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			log.Printf("Invalid bootstrap addr: %s (%v)", addr, err)
+			continue
+		}
+		if isDNSSeed(maddr) {
+			seeds = append(seeds, maddr)
+			continue
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(maddrs...)
+	if err != nil {
+		log.Printf("Invalid bootstrap addrs: %v", err)
+	}
+	return infos, seeds
+}
+
+// SetAddrs replaces the bootstrap peer set, keeping the backoff state of
+// peers that remain and releasing the protection of removed ones
+func (m *bootstrapManager) SetAddrs(addrs []string) {
+	static, seeds := parseBootstrapAddrs(append(addrs, dnsSeedAddrs(m.cfg.BootstrapDNSSeeds)...))
+	m.mu.Lock()
+	m.static = static
+	reseed := !sameMultiaddrs(m.seeds, seeds)
+	if reseed {
+		m.seeds = seeds
+		m.resolved = nil
+	}
+	m.mu.Unlock()
+	m.rebuild()
+	if reseed {
+		select {
+		case m.reseed <- struct{}{}:
+		default:
+		}
+	}
+	m.trigger()
+}
+
+// rebuild merges the static and DNS-resolved peers into the dial set
+func (m *bootstrapManager) rebuild() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	merged := make(map[peer.ID]peer.AddrInfo)
+	for _, info := range append(append([]peer.AddrInfo{}, m.static...), m.resolved...) {
+		existing := merged[info.ID]
+		existing.ID = info.ID
+		existing.Addrs = append(existing.Addrs, info.Addrs...)
+		merged[info.ID] = existing
+	}
+	old := m.peers
+	m.peers = make(map[peer.ID]*bootstrapPeer)
+	for id, info := range merged {
+		if bp, ok := old[id]; ok {
+			bp.info = info
+			m.peers[id] = bp
+			delete(old, id)
+			continue
+		}
+		m.peers[id] = &bootstrapPeer{info: info}
+		// Bootstrap peers are the gateways, never prune them
+		m.host.ConnManager().Protect(id, bootstrapTag)
+		// and keep them reachable even with a restrictive allowlist
+		m.gater.Allow(id)
+	}
+	for id := range old {
+		m.host.ConnManager().Unprotect(id, bootstrapTag)
+	}
+}
+
+// allowPeers re-adds every bootstrap peer to the allowlist after the gater
+// was reloaded from config
+func (m *bootstrapManager) allowPeers() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.peers {
+		m.gater.Allow(id)
+	}
+}
+
+// Start dials all bootstrap peers once, then keeps reconnecting in the background
+func (m *bootstrapManager) Start(ctx context.Context) {
+	m.host.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			if m.isBootstrapPeer(c.RemotePeer()) {
+				m.trigger()
+			}
+		},
+	})
+	m.resolveSeeds(ctx)
+	m.connectAll(ctx)
+	go m.loop(ctx)
+}
+
+func (m *bootstrapManager) isBootstrapPeer(id peer.ID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.peers[id]
+	return ok
+}
+
+// Rebootstrap clears the backoff of every bootstrap peer, re-resolves the DNS
+// seeds and redials at once
+func (m *bootstrapManager) Rebootstrap() {
+	m.mu.Lock()
+	for _, bp := range m.peers {
+		bp.nextAttempt = time.Time{}
+	}
+	m.mu.Unlock()
+	select {
+	case m.reseed <- struct{}{}:
+	default:
+	}
+	m.trigger()
+}
+
+// trigger requests an immediate connectivity check
+func (m *bootstrapManager) trigger() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (m *bootstrapManager) loop(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.BootstrapCheckInterval)
+	defer ticker.Stop()
+	dnsTicker := time.NewTicker(m.cfg.BootstrapDNSInterval)
+	defer dnsTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.kick:
+		case <-dnsTicker.C:
+			m.resolveSeeds(ctx)
+		case <-m.reseed:
+			m.resolveSeeds(ctx)
+		}
+		m.connectAll(ctx)
+	}
+}
+
+// connectAll dials every disconnected bootstrap peer whose backoff has elapsed
+func (m *bootstrapManager) connectAll(ctx context.Context) {
+	now := time.Now()
+	var due []*bootstrapPeer
+	m.mu.Lock()
+	for _, bp := range m.peers {
+		if m.host.Network().Connectedness(bp.info.ID) == network.Connected {
+			bp.failures = 0
+			continue
+		}
+		if now.Before(bp.nextAttempt) {
+			continue
+		}
+		due = append(due, bp)
+	}
+	m.mu.Unlock()
+
+	for _, bp := range due {
+		dialCtx, cancel := context.WithTimeout(ctx, m.cfg.DialTimeout)
+		err := m.host.Connect(dialCtx, bp.info)
+		cancel()
+		m.mu.Lock()
+		if err != nil {
+			bp.failures++
+			wait := m.backoff(bp.failures)
+			bp.nextAttempt = time.Now().Add(wait)
+			log.Printf("Failed to connect to %s: %v (retry in %s)", bp.info.ID, err, wait.Round(time.Second))
+		} else {
+			bp.failures = 0
+			log.Printf("Connected to bootstrap peer: %s", bp.info.ID)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// backoff returns the delay before redialling a bootstrap peer
+func (m *bootstrapManager) backoff(failures int) time.Duration {
+	return expBackoff(failures, m.cfg.BootstrapBackoffMin, m.cfg.BootstrapBackoffMax)
+}
+
+// expBackoff doubles min for every failure after the first, capped at max,
+// with up to 50% jitter so peers retrying together spread out
+func expBackoff(failures int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half))
+	}
+	return d
+}
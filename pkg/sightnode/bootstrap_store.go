@@ -0,0 +1,98 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// bootstrapFile persists the bootstrap list edited through the API. Once it
+// exists it takes precedence over BOOTSTRAP_ADDRS.
+func bootstrapFile(cfg Config) string {
+	return cfg.DataDir + "/bootstrap-peers.json"
+}
+
+// loadBootstrapAddrs returns the persisted bootstrap list, or the configured one
+func loadBootstrapAddrs(cfg Config) []string {
+	data, err := os.ReadFile(bootstrapFile(cfg))
+	if err != nil {
+		return cfg.Bootstrap
+	}
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		log.Printf("Error reading persisted bootstrap list, using BOOTSTRAP_ADDRS: %v", err)
+		return cfg.Bootstrap
+	}
+	return addrs
+}
+
+func saveBootstrapAddrs(cfg Config, addrs []string) error {
+	data, err := json.Marshal(addrs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(bootstrapFile(cfg), data, 0600)
+}
+
+// BootstrapAddrs returns the current bootstrap list
+func (s *Libp2pNodeService) BootstrapAddrs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string{}, s.bootstrapAddrs...)
+}
+
+// AddBootstrap adds a bootstrap multiaddr (with /p2p/ suffix, or a bare
+// /dnsaddr seed) and dials it
+func (s *Libp2pNodeService) AddBootstrap(addr string) error {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return err
+	}
+	if !isDNSSeed(maddr) {
+		if _, err := peer.AddrInfoFromP2pAddr(maddr); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.bootstrapAddrs {
+		if existing == addr {
+			return nil
+		}
+	}
+	addrs := append(append([]string{}, s.bootstrapAddrs...), addr)
+	if err := saveBootstrapAddrs(s.cfg, addrs); err != nil {
+		return err
+	}
+	s.bootstrapAddrs = addrs
+	s.bootstrap.SetAddrs(addrs)
+	return nil
+}
+
+// RemoveBootstrap removes a bootstrap multiaddr, or every address of a peer
+// when given a bare PeerID
+func (s *Libp2pNodeService) RemoveBootstrap(value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []string
+	for _, existing := range s.bootstrapAddrs {
+		if existing == value || strings.HasSuffix(existing, "/p2p/"+value) {
+			continue
+		}
+		addrs = append(addrs, existing)
+	}
+	if len(addrs) == len(s.bootstrapAddrs) {
+		return errors.New("bootstrap peer not found")
+	}
+	if err := saveBootstrapAddrs(s.cfg, addrs); err != nil {
+		return err
+	}
+	s.bootstrapAddrs = addrs
+	s.bootstrap.SetAddrs(addrs)
+	return nil
+}
@@ -0,0 +1,94 @@
+package sightnode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Circuit breaker states, also the values of the state gauge
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sight_tunnel_circuit_state",
+	Help: "Circuit breaker state per webhook URL (0 closed, 1 open, 2 half-open)",
+}, []string{"url"})
+
+// circuitBreaker stops deliveries to a webhook after consecutive failures.
+// Once the open timeout has passed a single probe is let through: its success
+// closes the breaker, its failure opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	url       string
+	threshold int
+	timeout   time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+}
+
+func newCircuitBreaker(url string, threshold int, timeout time.Duration) *circuitBreaker {
+	breakerState.WithLabelValues(url).Set(breakerClosed)
+	return &circuitBreaker{url: url, threshold: threshold, timeout: timeout}
+}
+
+// Allow reports whether a delivery may be attempted now
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.timeout {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// A probe is already in flight
+		return false
+	}
+	return true
+}
+
+// Success records a delivery and reports whether it closed the breaker
+func (b *circuitBreaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state == breakerClosed {
+		return false
+	}
+	b.setState(breakerClosed)
+	return true
+}
+
+// Failure records a failed delivery and reports whether the breaker is now open
+func (b *circuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.setState(breakerOpen)
+		b.openedAt = time.Now()
+	}
+	return b.state == breakerOpen
+}
+
+// Open reports whether the breaker currently rejects deliveries
+func (b *circuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// setState updates the state and its gauge; callers hold b.mu
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	breakerState.WithLabelValues(b.url).Set(float64(state))
+}
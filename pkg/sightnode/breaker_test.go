@@ -0,0 +1,49 @@
+package sightnode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	// Steps: f a failed delivery, s a successful one, w waits out the open
+	// timeout; open is whether the breaker rejects deliveries afterwards
+	for _, tc := range []struct {
+		name  string
+		steps string
+		open  bool
+	}{
+		{"below the threshold", "ff", false},
+		{"threshold reached", "fff", true},
+		{"successes reset the count", "ffsff", false},
+		{"probe succeeds", "fffws", false},
+		{"probe fails", "fffwf", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newCircuitBreaker("http://tunnel.test/"+tc.name, 3, timeout)
+			for _, step := range tc.steps {
+				switch step {
+				case 'f':
+					b.Failure()
+				case 's':
+					b.Success()
+				case 'w':
+					time.Sleep(timeout)
+					if !b.Allow() {
+						t.Fatal("no probe was let through after the open timeout")
+					}
+					if b.Allow() {
+						t.Fatal("a second delivery was let through while probing")
+					}
+				}
+			}
+			if b.Open() != tc.open {
+				t.Errorf("breaker open = %v, want %v", b.Open(), tc.open)
+			}
+			if b.Allow() == tc.open {
+				t.Errorf("Allow() = %v with the breaker open = %v", !tc.open, tc.open)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package sightnode
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Kinds of injected faults
+const (
+	chaosDelay = "delay"
+	chaosDrop  = "drop"
+	chaosKill  = "kill"
+)
+
+var chaosInjections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sight_chaos_injections_total",
+	Help: "Faults injected by chaos mode, per kind",
+}, []string{"kind"})
+
+// chaosInjector degrades the network on purpose so retries, deduplication
+// and reconnection can be exercised. It is nil unless CHAOS is set.
+type chaosInjector struct {
+	cfg Config
+}
+
+func newChaosInjector(cfg Config) *chaosInjector {
+	if !cfg.Chaos {
+		return nil
+	}
+	log.Printf("WARNING: chaos mode enabled: latency up to %s, %d%% of pubsub messages dropped, connection killed every %s",
+		cfg.ChaosLatency, cfg.ChaosDropPercent, cfg.ChaosKillInterval)
+	return &chaosInjector{cfg: cfg}
+}
+
+// admit delays a received pubsub message and reports whether to handle it
+// or drop it. Our own messages are left alone.
+func (c *chaosInjector) admit(msg *pubsub.Message) bool {
+	if c == nil || msg.Local {
+		return true
+	}
+	if c.cfg.ChaosLatency > 0 {
+		chaosInjections.WithLabelValues(chaosDelay).Inc()
+		time.Sleep(time.Duration(rand.Int63n(int64(c.cfg.ChaosLatency))))
+	}
+	if rand.Intn(100) < c.cfg.ChaosDropPercent {
+		chaosInjections.WithLabelValues(chaosDrop).Inc()
+		debugf("Chaos: dropped message from %s on %s", msg.GetFrom(), msg.GetTopic())
+		return false
+	}
+	return true
+}
+
+// run closes a random connection every ChaosKillInterval until ctx is done
+func (c *chaosInjector) run(ctx context.Context, h hostlibp2p.Host) {
+	if c == nil || c.cfg.ChaosKillInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.ChaosKillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		conns := h.Network().Conns()
+		if len(conns) == 0 {
+			continue
+		}
+		conn := conns[rand.Intn(len(conns))]
+		chaosInjections.WithLabelValues(chaosKill).Inc()
+		log.Printf("Chaos: killing connection to %s", conn.RemotePeer())
+		conn.Close()
+	}
+}
@@ -0,0 +1,488 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// clusterProtocol carries one request per stream between the gateways of a
+// cluster: a gateway registers the presence of its hosters with the owners of
+// their DIDs, and looks up the DIDs it does not know there.
+const clusterProtocol protocol.ID = "/sight/cluster/1.0.0"
+
+// clusterTag protects the cluster members in the connection manager
+const clusterTag = "cluster"
+
+// Cluster request operations
+const (
+	clusterRegister = "register"
+	clusterLookup   = "lookup"
+)
+
+var (
+	clusterPresence = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_cluster_presence",
+		Help: "DIDs whose presence this gateway owns or replicates on the cluster ring",
+	})
+	clusterRelays = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_cluster_relays",
+		Help: "Inbox topics relayed for DIDs homed on other gateways of the cluster",
+	})
+	clusterRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_cluster_requests_total",
+		Help: "Requests sent to the owners of DIDs on the cluster ring, per operation and result",
+	}, []string{"op", "result"})
+)
+
+type clusterRequest struct {
+	Op string `json:"op"`
+	// Ring is the fingerprint of the sender's ring, to detect members
+	// configured differently
+	Ring    string          `json:"ring"`
+	Entries []RegistryEntry `json:"entries,omitempty"`
+	DID     string          `json:"did,omitempty"`
+}
+
+type clusterResponse struct {
+	Entry *RegistryEntry `json:"entry,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// hashRing is a consistent-hash ring over the cluster members, each placed
+// at several virtual points so DIDs spread evenly and a membership change
+// only moves the DIDs next to the points added or removed
+type hashRing struct {
+	points  []uint64
+	owners  map[uint64]peer.ID
+	members []peer.ID
+}
+
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func newHashRing(members []peer.ID, vnodes int) *hashRing {
+	r := &hashRing{owners: make(map[uint64]peer.ID), members: append([]peer.ID{}, members...)}
+	sort.Slice(r.members, func(i, j int) bool { return r.members[i] < r.members[j] })
+	for _, id := range r.members {
+		for i := 0; i < vnodes; i++ {
+			point := ringHash(id.String() + "#" + strconv.Itoa(i))
+			r.owners[point] = id
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the member owning a DID: the first point at or after its hash
+func (r *hashRing) Owner(did string) peer.ID {
+	return r.Owners(did, 1)[0]
+}
+
+// Owners returns the owner of a DID followed by the next distinct members
+// clockwise, which hold replicas of its presence, n members at most
+func (r *hashRing) Owners(did string, n int) []peer.ID {
+	n = min(n, len(r.members))
+	h := ringHash(did)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	owners := make([]peer.ID, 0, n)
+	for j := 0; len(owners) < n; j++ {
+		id := r.owners[r.points[(i+j)%len(r.points)]]
+		if !slices.Contains(owners, id) {
+			owners = append(owners, id)
+		}
+	}
+	return owners
+}
+
+// Owns reports whether a member holds the presence of a DID, as owner or
+// replica
+func (r *hashRing) Owns(did string, id peer.ID, replicas int) bool {
+	return slices.Contains(r.Owners(did, replicas), id)
+}
+
+// Fingerprint identifies the member set, equal on gateways that agree
+func (r *hashRing) Fingerprint() string {
+	h := sha256.New()
+	for _, id := range r.members {
+		h.Write([]byte(id))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+type presence struct {
+	entry   RegistryEntry
+	expires time.Time
+}
+
+type clusterRelay struct {
+	cancel  pubsub.RelayCancelFunc
+	expires time.Time
+}
+
+// cluster shards the DID registry of gateways configured with CLUSTER_PEERS.
+// Every gateway keeps the hosters connected to it in its own registry and,
+// at every CLUSTER_SYNC_INTERVAL, registers them with the owner of their DID
+// on the ring and the CLUSTER_REPLICAS-1 members after it, so presence
+// survives the loss of its owner. Members only hold the presence of the DIDs
+// they own or replicate, which expires unless refreshed, so the state of a
+// gateway stays bounded by its share of the ring rather than growing with
+// the cluster.
+//
+// A gateway that resolves a DID homed on another member relays its inbox
+// topic for a while: gossipsub then carries messages from the sender's
+// gateway to the recipient's, with the sender's signature intact.
+type cluster struct {
+	host     hostlibp2p.Host
+	cfg      Config
+	gater    *PeerGater
+	registry *didRegistry
+	join     func(string) (Topic, error)
+	peers    []peer.AddrInfo
+	ring     *hashRing
+
+	mu       sync.RWMutex
+	presence map[string]presence
+	// relays are the inbox topics relayed for DIDs homed on other members
+	relays map[string]clusterRelay
+}
+
+// checkCluster validates the clustering settings
+func checkCluster(cfg Config) error {
+	if len(cfg.ClusterPeers) == 0 {
+		return nil
+	}
+	if cfg.ClusterSyncInterval <= 0 {
+		return fmt.Errorf("CLUSTER_SYNC_INTERVAL must be positive, got %s", cfg.ClusterSyncInterval)
+	}
+	if cfg.ClusterVnodes < 1 {
+		return fmt.Errorf("CLUSTER_VNODES must be at least 1, got %d", cfg.ClusterVnodes)
+	}
+	if cfg.ClusterReplicas < 1 {
+		return fmt.Errorf("CLUSTER_REPLICAS must be at least 1, got %d", cfg.ClusterReplicas)
+	}
+	for _, addr := range cfg.ClusterPeers {
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			return fmt.Errorf("CLUSTER_PEERS entry %q must be a multiaddr ending with /p2p/<peer id>: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func newCluster(h hostlibp2p.Host, cfg Config, gater *PeerGater, registry *didRegistry, join func(string) (Topic, error)) *cluster {
+	c := &cluster{
+		host:     h,
+		cfg:      cfg,
+		gater:    gater,
+		registry: registry,
+		join:     join,
+		presence: make(map[string]presence),
+		relays:   make(map[string]clusterRelay),
+	}
+	infos, _ := parseBootstrapAddrs(cfg.ClusterPeers)
+	members := []peer.ID{h.ID()}
+	for _, info := range infos {
+		// The same CLUSTER_PEERS may be given to every member, self included
+		if info.ID == h.ID() {
+			continue
+		}
+		c.peers = append(c.peers, info)
+		members = append(members, info.ID)
+		h.ConnManager().Protect(info.ID, clusterTag)
+	}
+	c.ring = newHashRing(members, cfg.ClusterVnodes)
+	c.allowPeers()
+	log.Printf("Cluster ring %s with %d gateways", c.ring.Fingerprint(), len(members))
+	return c
+}
+
+// allowPeers keeps the cluster members reachable even with a restrictive
+// allowlist, also after the gater was reloaded from config
+func (c *cluster) allowPeers() {
+	for _, info := range c.peers {
+		c.gater.Allow(info.ID)
+	}
+}
+
+func (c *cluster) isPeer(id peer.ID) bool {
+	_, ok := c.peerInfo(id)
+	return ok
+}
+
+func (c *cluster) peerInfo(id peer.ID) (peer.AddrInfo, bool) {
+	for _, info := range c.peers {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return peer.AddrInfo{}, false
+}
+
+// Start registers the local hosters now and then at every
+// CLUSTER_SYNC_INTERVAL
+func (c *cluster) Start(ctx context.Context) {
+	c.host.SetStreamHandler(clusterProtocol, c.handleStream)
+	go func() {
+		ticker := time.NewTicker(c.cfg.ClusterSyncInterval)
+		defer ticker.Stop()
+		for {
+			c.sync(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sync registers the hosters connected here with the owners of their DIDs
+// and drops the expired presence and relays
+func (c *cluster) sync(ctx context.Context) {
+	byOwner := make(map[peer.ID][]RegistryEntry)
+	for _, entry := range c.registry.List() {
+		id, err := peer.Decode(entry.PeerID)
+		if err != nil || !entry.Connected || c.isPeer(id) {
+			continue
+		}
+		entry.Gateway = c.host.ID().String()
+		entry.Connected = false
+		for _, owner := range c.ring.Owners(entry.DID, c.cfg.ClusterReplicas) {
+			byOwner[owner] = append(byOwner[owner], entry)
+		}
+	}
+	c.store(byOwner[c.host.ID()])
+
+	var wg sync.WaitGroup
+	for _, info := range c.peers {
+		entries := byOwner[info.ID]
+		if len(entries) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.request(ctx, info, clusterRequest{Op: clusterRegister, Entries: entries})
+			if err != nil {
+				log.Printf("Registering %d hosters with cluster gateway %s failed: %v", len(entries), info.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	now := time.Now()
+	for did, p := range c.presence {
+		if now.After(p.expires) {
+			delete(c.presence, did)
+		}
+	}
+	for did, r := range c.relays {
+		if now.After(r.expires) {
+			r.cancel()
+			delete(c.relays, did)
+		}
+	}
+	clusterPresence.Set(float64(len(c.presence)))
+	clusterRelays.Set(float64(len(c.relays)))
+	c.mu.Unlock()
+}
+
+// store records the presence of DIDs owned here until three sync intervals
+// pass without a refresh
+func (c *cluster) store(entries []RegistryEntry) {
+	expires := time.Now().Add(3 * c.cfg.ClusterSyncInterval)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		c.presence[entry.DID] = presence{entry: entry, expires: expires}
+	}
+	clusterPresence.Set(float64(len(c.presence)))
+}
+
+// request sends one request to a cluster member, connecting first if needed
+func (c *cluster) request(ctx context.Context, info peer.AddrInfo, req clusterRequest) (clusterResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.DialTimeout)
+	defer cancel()
+	req.Ring = c.ring.Fingerprint()
+	resp, err := c.roundTrip(ctx, info, req)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	clusterRequests.WithLabelValues(req.Op, result).Inc()
+	return resp, err
+}
+
+func (c *cluster) roundTrip(ctx context.Context, info peer.AddrInfo, req clusterRequest) (clusterResponse, error) {
+	if err := c.host.Connect(ctx, info); err != nil {
+		return clusterResponse{}, err
+	}
+	st, err := c.host.NewStream(ctx, info.ID, clusterProtocol)
+	if err != nil {
+		return clusterResponse{}, err
+	}
+	defer st.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(st).Encode(req); err != nil {
+		st.Reset()
+		return clusterResponse{}, err
+	}
+	st.CloseWrite()
+	var resp clusterResponse
+	if err := json.NewDecoder(st).Decode(&resp); err != nil {
+		return clusterResponse{}, err
+	}
+	return resp, nil
+}
+
+// handleStream serves the registrations and lookups of the other members
+func (c *cluster) handleStream(st network.Stream) {
+	defer st.Close()
+	from := st.Conn().RemotePeer()
+	if !c.isPeer(from) {
+		debugf("Refusing cluster request from %s, not in CLUSTER_PEERS", from)
+		st.Reset()
+		return
+	}
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	var req clusterRequest
+	if err := json.NewDecoder(st).Decode(&req); err != nil {
+		st.Reset()
+		return
+	}
+	if req.Ring != c.ring.Fingerprint() {
+		log.Printf("Cluster gateway %s uses ring %s, this gateway %s: check CLUSTER_PEERS", from, req.Ring, c.ring.Fingerprint())
+	}
+	var resp clusterResponse
+	switch req.Op {
+	case clusterRegister:
+		owned := req.Entries[:0]
+		for _, entry := range req.Entries {
+			// The gateway a hoster is homed on vouches for it, no other
+			if entry.Gateway == from.String() && c.ring.Owns(entry.DID, c.host.ID(), c.cfg.ClusterReplicas) {
+				owned = append(owned, entry)
+			}
+		}
+		c.store(owned)
+	case clusterLookup:
+		if entry, ok := c.Get(req.DID); ok {
+			resp.Entry = &entry
+		} else {
+			resp.Error = "DID not registered"
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown operation %q", req.Op)
+	}
+	json.NewEncoder(st).Encode(resp)
+}
+
+// Get returns the presence of a DID owned or replicated here
+func (c *cluster) Get(did string) (RegistryEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.presence[did]
+	if !ok || time.Now().After(p.expires) {
+		return RegistryEntry{}, false
+	}
+	return p.entry, true
+}
+
+// Lookup returns the presence of a DID, held here or asked from its owner,
+// or from the replicas when the owner is unreachable. The inbox of a DID
+// found homed on another member is relayed from then on.
+func (c *cluster) Lookup(ctx context.Context, did string) (RegistryEntry, error) {
+	err := errors.New("DID not registered")
+	for _, owner := range c.ring.Owners(did, c.cfg.ClusterReplicas) {
+		var entry RegistryEntry
+		if owner == c.host.ID() {
+			var ok bool
+			if entry, ok = c.Get(did); !ok {
+				continue
+			}
+		} else {
+			info, _ := c.peerInfo(owner)
+			resp, reqErr := c.request(ctx, info, clusterRequest{Op: clusterLookup, DID: did})
+			if reqErr != nil {
+				err = fmt.Errorf("asking owner %s: %w", owner, reqErr)
+				continue
+			}
+			if resp.Entry == nil {
+				continue
+			}
+			entry = *resp.Entry
+		}
+		c.relay(ctx, entry)
+		return entry, nil
+	}
+	return RegistryEntry{}, err
+}
+
+// relay relays the inbox topic of a DID homed on another member until three
+// sync intervals pass without a lookup, and makes sure that member is
+// connected so the two gateways mesh on the topic
+func (c *cluster) relay(ctx context.Context, entry RegistryEntry) {
+	home, err := peer.Decode(entry.Gateway)
+	if err != nil || home == c.host.ID() {
+		return
+	}
+	if info, ok := c.peerInfo(home); ok && c.host.Network().Connectedness(home) != network.Connected {
+		if err := c.host.Connect(ctx, info); err != nil {
+			debugf("Connecting to cluster gateway %s failed: %v", home, err)
+		}
+	}
+	expires := time.Now().Add(3 * c.cfg.ClusterSyncInterval)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.relays[entry.DID]; ok {
+		r.expires = expires
+		c.relays[entry.DID] = r
+		return
+	}
+	topic, err := c.join(inboxTopic(entry.DID))
+	var cancel pubsub.RelayCancelFunc
+	if err == nil {
+		cancel, err = topic.Relay()
+	}
+	if err != nil {
+		log.Printf("Error relaying inbox of %s: %v", entry.DID, err)
+		return
+	}
+	c.relays[entry.DID] = clusterRelay{cancel: cancel, expires: expires}
+	clusterRelays.Set(float64(len(c.relays)))
+}
+
+// List returns the presence owned or replicated here sorted by DID
+func (c *cluster) List() []RegistryEntry {
+	c.mu.RLock()
+	list := make([]RegistryEntry, 0, len(c.presence))
+	for _, p := range c.presence {
+		list = append(list, p.entry)
+	}
+	c.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].DID < list[j].DID })
+	return list
+}
@@ -0,0 +1,302 @@
+package sightnode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Payload content encodings (envelope "contentEncoding" field)
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// maxDecompressedSize bounds inflated payloads to guard against compression bombs
+const maxDecompressedSize = 64 << 20
+
+// supportedEncodings are the encodings this node can decode, advertised to peers
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+var (
+	// gzip writers are costly to set up, so they and their output buffers
+	// are reused across messages
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	buffers     = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// maxPooledBuffer keeps the buffers of unusually large payloads out of the pool
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buffers.Put(buf)
+	}
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// encodingProtocol is the protocol ID advertising support for an encoding.
+// Peers learn it through identify, so no extra round trip is needed.
+func encodingProtocol(enc string) protocol.ID {
+	return protocol.ID("/sight/encoding/" + enc + "/1.0.0")
+}
+
+// advertiseEncodings registers the capability protocols on the host
+func advertiseEncodings(h hostlibp2p.Host) {
+	for _, enc := range supportedEncodings {
+		h.SetStreamHandler(encodingProtocol(enc), func(st network.Stream) { st.Reset() })
+	}
+}
+
+// peerEncodings returns the encodings a peer advertised through identify
+func peerEncodings(ps peerstore.Peerstore, id peer.ID) []string {
+	var encodings []string
+	for _, enc := range supportedEncodings {
+		if ok, _ := ps.SupportsProtocols(id, encodingProtocol(enc)); len(ok) > 0 {
+			encodings = append(encodings, enc)
+		}
+	}
+	return encodings
+}
+
+// negotiateEncoding picks the first configured encoding the recipient of a
+// message advertises, or "" to send the payload uncompressed
+func (s *Libp2pNodeService) negotiateEncoding(to string) string {
+	if len(s.cfg.Compression) == 0 {
+		return ""
+	}
+	advertised := s.recipientEncodings(to)
+	for _, enc := range s.cfg.Compression {
+		if slices.Contains(advertised, enc) {
+			return enc
+		}
+	}
+	return ""
+}
+
+// recipientEncodings returns the encodings the node of a DID advertises:
+// through identify when it is a peer, else from the registry of a gateway,
+// e.g. for hosters publishing to each other through it. Registry lookups run
+// in the background, so the messages sent meanwhile are not compressed.
+func (s *Libp2pNodeService) recipientEncodings(did string) []string {
+	id, err := DIDToPeerID(did)
+	if err != nil {
+		return nil
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	if protos, _ := node.Peerstore().GetProtocols(id); len(protos) > 0 {
+		return peerEncodings(node.Peerstore(), id)
+	}
+	return s.encodings.Get(did, func() ([]string, error) {
+		entry, err := s.ResolvePeer(context.Background(), did)
+		return entry.Encodings, err
+	})
+}
+
+// encodingCacheSize is the number of recipients above which expired
+// entries are dropped from the encoding cache
+const encodingCacheSize = 4096
+
+// Lifetimes of the encodings learnt from a gateway, and of a failed lookup
+const (
+	encodingTTL      = 10 * time.Minute
+	encodingRetryTTL = time.Minute
+)
+
+// encodingCache keeps the encodings of recipients that are not peers
+type encodingCache struct {
+	mu      sync.Mutex
+	entries map[string]*encodingEntry
+}
+
+type encodingEntry struct {
+	encodings []string
+	// expires is zero while the lookup runs
+	expires time.Time
+}
+
+func newEncodingCache() *encodingCache {
+	return &encodingCache{entries: make(map[string]*encodingEntry)}
+}
+
+// Get returns the cached encodings of did. On a miss it starts lookup in the
+// background, once per DID, and returns none.
+func (c *encodingCache) Get(did string, lookup func() ([]string, error)) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if e, ok := c.entries[did]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e.encodings
+	}
+	if len(c.entries) >= encodingCacheSize {
+		for k, e := range c.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[did] = &encodingEntry{}
+	go func() {
+		encodings, err := lookup()
+		ttl := encodingTTL
+		if err != nil {
+			debugf("Looking up the encodings of %s failed: %v", did, err)
+			ttl = encodingRetryTTL
+		}
+		c.mu.Lock()
+		c.entries[did] = &encodingEntry{encodings: encodings, expires: time.Now().Add(ttl)}
+		c.mu.Unlock()
+	}()
+	return nil
+}
+
+// compressEnvelope replaces the payload with its compressed JSON when the
+// recipient supports it and the payload is large enough to benefit
+func (s *Libp2pNodeService) compressEnvelope(msg map[string]interface{}) error {
+	to, _ := msg["to"].(string)
+	enc := s.negotiateEncoding(to)
+	if enc == "" {
+		return nil
+	}
+	raw, err := payloadJSON(msg)
+	if err != nil {
+		return err
+	}
+	if len(raw) < s.cfg.CompressMinSize {
+		return nil
+	}
+	data, err := compressBytes(enc, raw)
+	if err != nil {
+		return err
+	}
+	msg["payload"] = data
+	msg["contentEncoding"] = enc
+	return nil
+}
+
+// decompressEnvelope restores a compressed payload in place
+func decompressEnvelope(envelope map[string]interface{}) error {
+	enc, ok := envelope["contentEncoding"].(string)
+	if !ok {
+		return nil
+	}
+	// Compressed payloads are raw bytes in protobuf envelopes and base64 in JSON ones
+	var data []byte
+	switch p := envelope["payload"].(type) {
+	case []byte:
+		data = p
+	case json.RawMessage:
+		var encoded string
+		if err := json.Unmarshal(p, &encoded); err != nil {
+			return fmt.Errorf("compressed payload is not binary")
+		}
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("compressed payload is not binary")
+	}
+	raw, err := decompressBytes(enc, data)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(raw) {
+		return fmt.Errorf("decompressed payload is not valid JSON")
+	}
+	envelope["payload"] = json.RawMessage(raw)
+	delete(envelope, "contentEncoding")
+	return nil
+}
+
+func compressBytes(enc string, data []byte) ([]byte, error) {
+	switch enc {
+	case encodingZstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	case encodingGzip:
+		buf := getBuffer()
+		defer putBuffer(buf)
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return bytes.Clone(buf.Bytes()), nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", enc)
+	}
+}
+
+func decompressBytes(enc string, data []byte) ([]byte, error) {
+	switch enc {
+	case encodingZstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(data, nil)
+	case encodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		raw, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", enc)
+	}
+}
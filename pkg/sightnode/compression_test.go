@@ -0,0 +1,172 @@
+package sightnode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	raw := []byte(`{"readings":"` + string(bytes.Repeat([]byte("abc"), 1000)) + `"}`)
+	for _, enc := range supportedEncodings {
+		t.Run(enc, func(t *testing.T) {
+			data, err := compressBytes(enc, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) >= len(raw) {
+				t.Errorf("compressed %d bytes into %d", len(raw), len(data))
+			}
+			got, err := decompressBytes(enc, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Fatal("payload changed in the round trip")
+			}
+		})
+	}
+}
+
+func TestDecompressEnvelope(t *testing.T) {
+	raw := []byte(`{"type":"telemetry","value":42}`)
+	data, err := compressBytes(encodingGzip, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JSON envelopes carry compressed payloads base64 encoded
+	encoded, err := json.Marshal(map[string]interface{}{"to": "x", "contentEncoding": encodingGzip, "payload": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := decodeEnvelope(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decompressEnvelope(envelope); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := envelope["contentEncoding"]; ok {
+		t.Error("contentEncoding left on a decompressed envelope")
+	}
+	got, _ := payloadJSON(envelope)
+	if !bytes.Equal(got, raw) {
+		t.Errorf("payload: got %s, want %s", got, raw)
+	}
+}
+
+func TestEncodingCacheLooksUpInBackground(t *testing.T) {
+	c := newEncodingCache()
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	lookup := func() ([]string, error) {
+		lookups.Add(1)
+		<-release
+		return []string{encodingZstd}, nil
+	}
+	// Messages sent while the lookup runs go uncompressed, without a second lookup
+	for i := 0; i < 3; i++ {
+		if got := c.Get("did:sight:x", lookup); got != nil {
+			t.Fatalf("encodings %v before the lookup returned", got)
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for got := c.Get("did:sight:x", lookup); !slices.Equal(got, []string{encodingZstd}); got = c.Get("did:sight:x", lookup) {
+		if time.Now().After(deadline) {
+			t.Fatalf("encodings %v, want the looked up zstd", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("%d lookups, want 1", n)
+	}
+}
+
+func TestDecompressBytesRejectsBombs(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < maxDecompressedSize/len(zeros)+1; i++ {
+		w.Write(zeros)
+	}
+	w.Close()
+	if _, err := decompressBytes(encodingGzip, buf.Bytes()); err == nil {
+		t.Fatal("payload inflating past the limit was accepted")
+	}
+}
+
+// compressGzipUnpooled is compressBytes for gzip without the writer and
+// buffer pools, the baseline BenchmarkCompress compares against
+func compressGzipUnpooled(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BenchmarkCompress measures the payload compression of outgoing messages:
+// gzip with the pooled writers against a new writer per message, and zstd
+func BenchmarkCompress(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10} {
+		raw, err := payloadJSON(map[string]interface{}{"payload": map[string]string{
+			"readings": string(bytes.Repeat([]byte(`{"sensor":"s-1","value":0.33},`), size/30)),
+		}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchmarks := []struct {
+			name     string
+			compress func([]byte) ([]byte, error)
+		}{
+			{"gzip-pooled", func(data []byte) ([]byte, error) { return compressBytes(encodingGzip, data) }},
+			{"gzip-unpooled", compressGzipUnpooled},
+			{"zstd", func(data []byte) ([]byte, error) { return compressBytes(encodingZstd, data) }},
+		}
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("size=%d/%s", len(raw), bm.name), func(b *testing.B) {
+				b.SetBytes(int64(len(raw)))
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if _, err := bm.compress(raw); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkDecompressEnvelope measures restoring a compressed payload on
+// the receive path
+func BenchmarkDecompressEnvelope(b *testing.B) {
+	raw := testEnvelope(b, 100)
+	for _, enc := range supportedEncodings {
+		data, err := compressBytes(enc, raw)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(enc, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				envelope := map[string]interface{}{"contentEncoding": enc, "payload": data}
+				if err := decompressEnvelope(envelope); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,783 @@
+package sightnode
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// Config holds the node settings read from the environment
+type Config struct {
+	IsGateway  bool
+	NodePort   int
+	Libp2pPort int
+	TunnelAPI  string
+	Bootstrap  []string
+
+	// Bootstrap-server mode: relay, rendezvous and record replica without
+	// inbox or tunnel forwarding
+	IsBootstrap bool
+
+	// Listen multiaddrs (IPv4/IPv6, TCP, QUIC, WS); when set they replace the
+	// listeners derived from NODE_PORT, NODE_WS_PORT and NODE_WSS_PORT
+	ListenAddrs []string
+
+	// Advertised addresses: ANNOUNCE_ADDRS replaces the detected ones,
+	// NO_ANNOUNCE_ADDRS (multiaddrs or CIDR ranges) are never advertised
+	AnnounceAddrs   []string
+	NoAnnounceAddrs []string
+
+	// Optional WebSocket listeners (0 disables them)
+	WSPort      int
+	WSSPort     int
+	WSSCertFile string
+	WSSKeyFile  string
+
+	// Connection security (noise, tls) and stream muxer (yamux, mplex) stack,
+	// in order of preference
+	SecurityTransports []string
+	Muxers             []string
+
+	// Connection manager watermarks and grace period for new connections
+	ConnLowWater    int
+	ConnHighWater   int
+	ConnGracePeriod time.Duration
+
+	// Resource manager limits (0 keeps the auto-scaled default)
+	RcmgrLimitsFile      string
+	RcmgrMaxConns        int
+	RcmgrMaxStreams      int
+	RcmgrMaxFD           int
+	RcmgrMaxMemoryMB     int
+	RcmgrPeerMaxConns    int
+	RcmgrPeerMaxStreams  int
+	RcmgrPeerMaxMemoryMB int
+	RcmgrConnsPerIP      int
+
+	// Largest pubsub message accepted by the topic validator, in bytes
+	MaxMessageSize int
+
+	// How long message IDs are remembered for deduplication
+	DedupTTL time.Duration
+
+	// Default lifetime stamped as expiresAt on outgoing messages (0 = never expire)
+	MessageTTL time.Duration
+
+	// Connection gating: PeerIDs/DIDs (and CIDRs for the blocklist)
+	PeerAllowlist []string
+	PeerBlocklist []string
+	// Inbound connections must come from one of InboundAllowCIDRs when set,
+	// e.g. a gateway whose hosters are on known networks, and from none of
+	// InboundDenyCIDRs
+	InboundAllowCIDRs []string
+	InboundDenyCIDRs  []string
+
+	// Peers the connection manager never prunes, and extra tags ("peer=tag:weight")
+	// that raise or lower a peer's value when pruning
+	ProtectedPeers []string
+	PeerTags       []string
+
+	// PeerIDs/DIDs of the gateway operators allowed to sign revocations
+	// (defaults to the bootstrap peers)
+	RevocationAuthorities []string
+
+	// Topics requiring a membership token to publish, "topic" or
+	// "topic=owner" (owner defaults to the bootstrap peers), and the lifetime
+	// of tokens owners issue
+	ProtectedTopics []string
+	TopicTokenTTL   time.Duration
+
+	// How a gateway admits new hosters: open (no handshake), auto, manual
+	// (approved through the admin API) or webhook (asks AdmissionWebhookURL)
+	AdmissionMode       string
+	AdmissionWebhookURL string
+
+	// Messages per second accepted from one sender, over pubsub and direct
+	// streams together (0 disables the limit), and the burst allowed above
+	// it (default twice the rate)
+	PubsubRateLimit int
+	PubsubRateBurst int
+
+	// HTTPS for the HTTP API, with client certificate verification when a CA is set
+	APITLSCertFile     string
+	APITLSKeyFile      string
+	APITLSClientCAFile string
+
+	// Startup retries: attempts before giving up on transient failures and the
+	// exponential backoff bounds between them
+	StartupAttempts   int
+	StartupBackoffMin time.Duration
+	StartupBackoffMax time.Duration
+
+	// Bootstrap reconnection: check interval and exponential backoff bounds
+	BootstrapCheckInterval time.Duration
+	BootstrapBackoffMin    time.Duration
+	BootstrapBackoffMax    time.Duration
+
+	// DNS seeds: domains whose _dnsaddr TXT records list bootstrap peers,
+	// re-resolved at this interval
+	BootstrapDNSSeeds    []string
+	BootstrapDNSInterval time.Duration
+
+	// Hosters: gateways in order of preference, health-checked at this
+	// interval. After GatewayFailures failed checks in a row traffic fails
+	// over to the next healthy one, and back once the preferred one recovers.
+	Gateways              []string
+	GatewayHealthInterval time.Duration
+	GatewayFailures       int
+
+	// Gateways: other gateways to federate with, exchanging registries at
+	// this interval so hosters homed on either reach each other
+	FederationPeers        []string
+	FederationSyncInterval time.Duration
+
+	// Gateways: the other members of a cluster sharding DIDs over a
+	// consistent-hash ring with this many points per member. Presence is
+	// registered at this interval with the owner of each DID and the members
+	// following it, this many in all.
+	ClusterPeers        []string
+	ClusterSyncInterval time.Duration
+	ClusterVnodes       int
+	ClusterReplicas     int
+
+	// Keep peer addresses on disk and redial this many known peers at startup
+	PeerstorePersist   bool
+	PeerstoreReconnect int
+
+	// Readiness criteria for /readyz
+	ReadyMinPeers    int
+	ReadyCheckTunnel bool
+
+	// Connectivity watchdog: minimum connected peers and peers on each of
+	// WatchdogTopics, checked every WatchdogInterval (0 disables it); below them
+	// for WatchdogGrace the node reports not ready and re-bootstraps
+	WatchdogInterval      time.Duration
+	WatchdogGrace         time.Duration
+	WatchdogMinPeers      int
+	WatchdogMinTopicPeers int
+	WatchdogTopics        []string
+
+	// How incoming messages reach upstream: webhook (tunnel API), stream or both
+	DeliveryMode string
+
+	// Stream messages kept for clients resuming /events with Last-Event-ID
+	StreamReplaySize int
+
+	// JSON file of webhook targets with routing rules (replaces TUNNEL_API)
+	WebhooksFile string
+
+	// Routes of incoming messages by type, each type=tunnel, type=drop,
+	// type=webhook:URL or type=topic:NAME; routes edited through the API
+	// are persisted and replace these
+	TypeRoutes []string
+
+	// Shared secret signing the requests to the tunnel API, webhooks and
+	// RPC API with an HMAC header (empty disables signing)
+	TunnelHMACSecret string
+
+	// Event-streaming sink for incoming messages, alongside the webhooks or,
+	// with SinkOnly, instead of them: nats or kafka-rest (a Kafka REST proxy,
+	// not the brokers themselves), its server URLs, and the subject or topic
+	// template where {did}, {type} and {topic} stand for the sender DID,
+	// payload type and topic
+	SinkType    string
+	SinkURLs    []string
+	SinkSubject string
+	SinkOnly    bool
+
+	// Webhook forwarding: buffer size, workers, retries with backoff and DLQ size
+	TunnelQueueSize int
+	TunnelWorkers   int
+	// What happens to deliveries when the buffer is full: deadletter, drop,
+	// or spool to disk, up to TunnelSpoolMax entries
+	TunnelOverflow   string
+	TunnelSpoolMax   int
+	TunnelRetryMax   int
+	TunnelBackoffMin time.Duration
+	TunnelBackoffMax time.Duration
+	DLQMaxEntries    int
+
+	// Tunnel HTTP client: dial and TLS handshake timeout, TCP keep-alive
+	// period (negative disables connection reuse), idle connection pool
+	// limits, per-host connection cap (0 is unlimited) and an explicit proxy
+	// URL overriding HTTP_PROXY/HTTPS_PROXY. TunnelTimeout bounds each request.
+	TunnelConnectTimeout      time.Duration
+	TunnelKeepAlive           time.Duration
+	TunnelMaxIdleConns        int
+	TunnelMaxIdleConnsPerHost int
+	TunnelMaxConnsPerHost     int
+	TunnelIdleConnTimeout     time.Duration
+	TunnelProxy               string
+
+	// Payload compression preference (gzip, zstd; empty disables) and the
+	// smallest JSON payload worth compressing. Recipients that are not peers
+	// are looked up in a gateway registry; until the answer arrives their
+	// messages go uncompressed.
+	Compression     []string
+	CompressMinSize int
+
+	// Envelope wire format of direct deliveries to peers that support it:
+	// json or proto. Pubsub messages are always JSON.
+	WireFormat string
+
+	// Request/response RPC: upstream URL serving incoming calls and the default timeout
+	RPCAPI     string
+	RPCTimeout time.Duration
+
+	// File transfer: files can only be sent from FileSendDir, and are only
+	// received into FileReceiveDir from FileReceivePeers (PeerIDs or DIDs),
+	// within the per-file and total size limits and the per-transfer timeout.
+	// Both directions are off until their directory is set.
+	FileSendDir        string
+	FileReceiveDir     string
+	FileReceivePeers   []string
+	FileMaxSize        int64
+	FileReceiveQuota   int64
+	FileReceiveTimeout time.Duration
+
+	// Also use the shared sight-message topic, for fleets with nodes that
+	// predate per-DID inbox topics. On until the migration is done.
+	LegacyTopic bool
+
+	// Export per-peer bandwidth counters on /metrics (one series per peer)
+	BandwidthPeerMetrics bool
+
+	// Network event notifications: webhook URL (empty disables) and/or the stream
+	EventsWebhook string
+	EventsStream  bool
+
+	// OpenTelemetry tracing, exported over OTLP/HTTP when an endpoint is set
+	OTLPEndpoint    string
+	OTelServiceName string
+
+	// Pre-shared swarm key for a private network (default: swarm.key in the data dir)
+	SwarmKeyFile string
+
+	// Outbound queue: capacity per priority lane, publishing workers and the
+	// high,normal,low dispatch weights
+	OutboxSize    int
+	OutboxWorkers int
+	OutboxWeights []int
+
+	// Batch send: parallel publishes per request and the largest batch accepted
+	BatchConcurrency int
+	BatchMaxSize     int
+
+	// Send signed delivery receipts back to senders
+	DeliveryReceipts bool
+
+	// At-least-once and ordered messages: how long to wait for the receipt
+	// before publishing again, and how many publishes before giving up
+	QoSAckTimeout  time.Duration
+	QoSMaxAttempts int
+
+	// How long a skipped sequence number may take to arrive before it counts as lost
+	SeqGapTimeout time.Duration
+
+	// How long an Idempotency-Key maps to the message it first sent
+	IdempotencyTTL time.Duration
+
+	// How long the delivery state of sent messages can be queried
+	MessageStatusTTL time.Duration
+
+	// How long shutdown waits for queued messages and forwards to flush
+	DrainTimeout time.Duration
+
+	// Per-operation timeouts: publishing one outbound message, dialling one
+	// peer and posting one webhook forward
+	PublishTimeout time.Duration
+	DialTimeout    time.Duration
+	TunnelTimeout  time.Duration
+
+	// Signed application records replicated between nodes: value size, the
+	// lifetime of our own records, the longest lifetime accepted from others,
+	// and how many records are kept in total and per DID
+	RecordMaxSize    int
+	RecordTTL        time.Duration
+	RecordMaxTTL     time.Duration
+	RecordMaxRecords int
+	RecordMaxPerDID  int
+
+	// Rendezvous discovery: namespace hosters register under (empty disables
+	// it), registration lifetime and per-namespace limit on gateways
+	RendezvousNamespace        string
+	RendezvousTTL              time.Duration
+	RendezvousMaxRegistrations int
+
+	// Tunnel circuit breaker: consecutive failures before it opens and how long
+	// it stays open before a probe
+	BreakerThreshold   int
+	BreakerOpenTimeout time.Duration
+
+	// SQLite archive of sent and received envelopes
+	ArchiveEnabled bool
+	ArchivePath    string
+
+	// Retention of the archive and dead-letter queue, enforced every
+	// RetentionInterval: maximum age, bytes per DID and bytes per store (0
+	// disables a limit)
+	RetentionMaxAge         time.Duration
+	RetentionMaxBytesPerDID int64
+	RetentionMaxBytes       int64
+	RetentionInterval       time.Duration
+
+	// Hash-chained audit log of message metadata (never payloads)
+	AuditLog     bool
+	AuditLogPath string
+
+	// Bearer token for admin endpoints (/debug); empty disables them
+	AdminToken string
+
+	// Where the node keeps its state: shared data, and keys, queues and
+	// history of the active identity. Both default to the directories of the
+	// identity profile; give each node its own to run several in one process.
+	DataDir     string
+	IdentityDir string
+
+	// Keypair in IdentityDir: generated as KeyType (ed25519, secp256k1 or
+	// rsa) or recovered from KeyMnemonic, and kept encrypted under
+	// KeystorePassphrase, which is prompted for when KeystoreEncrypt is set
+	// and it is empty. With SignerSocket the key stays with that external
+	// signer instead.
+	KeyType            string
+	KeyMnemonic        string
+	KeystorePassphrase string
+	KeystoreEncrypt    bool
+	SignerSocket       string
+
+	// Chaos mode, for resilience tests only: CHAOS=1 enables it, then
+	// received pubsub messages are delayed by up to ChaosLatency and
+	// ChaosDropPercent of them dropped, and a random connection is killed
+	// every ChaosKillInterval (0 disables each)
+	Chaos             bool
+	ChaosLatency      time.Duration
+	ChaosDropPercent  int
+	ChaosKillInterval time.Duration
+
+	// Gossipsub mesh tuning: target/low/high mesh degree, heartbeat, fanout
+	// lifetime and message cache windows (defaults are the libp2p ones)
+	GossipSubD             int
+	GossipSubDlo           int
+	GossipSubDhi           int
+	GossipSubHeartbeat     time.Duration
+	GossipSubFanoutTTL     time.Duration
+	GossipSubHistoryLength int
+	GossipSubHistoryGossip int
+
+	// gRPC control-plane port (0 disables it)
+	GRPCPort int
+
+	// MQTT bridge: broker URL (empty disables it), client credentials and
+	// the topic mappings, each libp2p=mqtt (both ways), libp2p>mqtt or
+	// libp2p<mqtt. Identical payloads seen on a mapping within
+	// MQTTLoopWindow are not bridged again, which breaks echo loops.
+	MQTTBroker     string
+	MQTTClientID   string
+	MQTTUsername   string
+	MQTTPassword   string
+	MQTTTopics     []string
+	MQTTQoS        int
+	MQTTLoopWindow time.Duration
+
+	LogLevel string
+}
+
+// configEnv looks up the variables the configuration is read from: the
+// environment, or while LoadConfigFile runs an env file over it
+var (
+	configEnvMu sync.Mutex
+	configEnv   = os.Getenv
+)
+
+func getenv(key string) string {
+	return configEnv(key)
+}
+
+// LoadConfig reads the node configuration from environment variables (with defaults)
+func LoadConfig() Config {
+	configEnvMu.Lock()
+	defer configEnvMu.Unlock()
+	return loadConfig()
+}
+
+// LoadConfigFile reads the node configuration like LoadConfig, with the
+// variables of an env file (KEY=VALUE lines, # comments) taking precedence
+// over the environment. Unlike the environment of a running process the
+// file can change, so nodes reload their configuration from it.
+func LoadConfigFile(path string) (Config, error) {
+	vars, err := readEnvFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	configEnvMu.Lock()
+	defer configEnvMu.Unlock()
+	configEnv = func(key string) string {
+		if value, ok := vars[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	}
+	defer func() { configEnv = os.Getenv }()
+	return loadConfig(), nil
+}
+
+// readEnvFile parses an env file. Values may be quoted; an "export " prefix
+// is ignored so the file can also be sourced by a shell.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// loadConfig reads the configuration through configEnv; callers hold
+// configEnvMu
+func loadConfig() Config {
+	cfg := Config{
+		IsGateway:   getenv("IS_GATEWAY") == "1",
+		IsBootstrap: getenv("IS_BOOTSTRAP") == "1",
+		NodePort:    getEnvInt("NODE_PORT", 15050),
+		Libp2pPort:  getEnvInt("LIBP2P_PORT", 4010),
+		TunnelAPI:   getEnvString("TUNNEL_API", "http://localhost:"+getenv("API_PORT")+"/libp2p/message"),
+		Bootstrap:   getEnvList("BOOTSTRAP_ADDRS"),
+		WSPort:      getEnvInt("NODE_WS_PORT", 0),
+		WSSPort:     getEnvInt("NODE_WSS_PORT", 0),
+		WSSCertFile: getenv("NODE_WSS_CERT_FILE"),
+		WSSKeyFile:  getenv("NODE_WSS_KEY_FILE"),
+
+		ListenAddrs: getEnvList("LISTEN_ADDRS"),
+
+		AnnounceAddrs:   getEnvList("ANNOUNCE_ADDRS"),
+		NoAnnounceAddrs: getEnvList("NO_ANNOUNCE_ADDRS"),
+
+		SecurityTransports: getEnvListDefault("SECURITY_TRANSPORTS", []string{"tls", "noise"}),
+		Muxers:             getEnvListDefault("MUXERS", []string{"yamux"}),
+
+		ConnLowWater:    getEnvInt("CONN_LOW_WATER", 160),
+		ConnHighWater:   getEnvInt("CONN_HIGH_WATER", 192),
+		ConnGracePeriod: getEnvDuration("CONN_GRACE_PERIOD", time.Minute),
+
+		RcmgrLimitsFile:      getenv("RCMGR_LIMITS_FILE"),
+		RcmgrMaxConns:        getEnvInt("RCMGR_MAX_CONNS", 0),
+		RcmgrMaxStreams:      getEnvInt("RCMGR_MAX_STREAMS", 0),
+		RcmgrMaxFD:           getEnvInt("RCMGR_MAX_FD", 0),
+		RcmgrMaxMemoryMB:     getEnvInt("RCMGR_MAX_MEMORY_MB", 0),
+		RcmgrPeerMaxConns:    getEnvInt("RCMGR_PEER_MAX_CONNS", 0),
+		RcmgrPeerMaxStreams:  getEnvInt("RCMGR_PEER_MAX_STREAMS", 0),
+		RcmgrPeerMaxMemoryMB: getEnvInt("RCMGR_PEER_MAX_MEMORY_MB", 0),
+		RcmgrConnsPerIP:      getEnvInt("RCMGR_CONNS_PER_IP", 0),
+
+		MaxMessageSize: getEnvInt("MAX_MESSAGE_SIZE", 1<<20),
+
+		DedupTTL: getEnvDuration("DEDUP_TTL", 10*time.Minute),
+
+		MessageTTL: getEnvDuration("MESSAGE_TTL", 0),
+
+		PeerAllowlist: getEnvList("PEER_ALLOWLIST"),
+		PeerBlocklist: getEnvList("PEER_BLOCKLIST"),
+
+		InboundAllowCIDRs: getEnvList("INBOUND_ALLOW_CIDRS"),
+		InboundDenyCIDRs:  getEnvList("INBOUND_DENY_CIDRS"),
+
+		ProtectedPeers: getEnvList("PROTECTED_PEERS"),
+		PeerTags:       getEnvList("PEER_TAGS"),
+
+		RevocationAuthorities: getEnvList("REVOCATION_AUTHORITIES"),
+
+		ProtectedTopics: getEnvList("PROTECTED_TOPICS"),
+		TopicTokenTTL:   getEnvDuration("TOPIC_TOKEN_TTL", 24*time.Hour),
+
+		AdmissionMode:       getEnvString("ADMISSION_MODE", admissionOpen),
+		AdmissionWebhookURL: getenv("ADMISSION_WEBHOOK_URL"),
+
+		PubsubRateLimit: getEnvInt("PUBSUB_RATE_LIMIT", 0),
+		PubsubRateBurst: getEnvInt("PUBSUB_RATE_BURST", 0),
+
+		APITLSCertFile:     getenv("API_TLS_CERT_FILE"),
+		APITLSKeyFile:      getenv("API_TLS_KEY_FILE"),
+		APITLSClientCAFile: getenv("API_TLS_CLIENT_CA_FILE"),
+
+		StartupAttempts:   getEnvInt("STARTUP_ATTEMPTS", 5),
+		StartupBackoffMin: getEnvDuration("STARTUP_BACKOFF_MIN", time.Second),
+		StartupBackoffMax: getEnvDuration("STARTUP_BACKOFF_MAX", 30*time.Second),
+
+		BootstrapCheckInterval: getEnvDuration("BOOTSTRAP_CHECK_INTERVAL", 30*time.Second),
+		BootstrapBackoffMin:    getEnvDuration("BOOTSTRAP_BACKOFF_MIN", time.Second),
+		BootstrapBackoffMax:    getEnvDuration("BOOTSTRAP_BACKOFF_MAX", 5*time.Minute),
+
+		BootstrapDNSSeeds:    getEnvList("BOOTSTRAP_DNS_SEEDS"),
+		BootstrapDNSInterval: getEnvDuration("BOOTSTRAP_DNS_INTERVAL", 10*time.Minute),
+
+		Gateways:              getEnvList("GATEWAY_ADDRS"),
+		GatewayHealthInterval: getEnvDuration("GATEWAY_HEALTH_INTERVAL", 10*time.Second),
+		GatewayFailures:       getEnvInt("GATEWAY_FAILURES", 2),
+
+		FederationPeers:        getEnvList("FEDERATION_PEERS"),
+		FederationSyncInterval: getEnvDuration("FEDERATION_SYNC_INTERVAL", 30*time.Second),
+
+		ClusterPeers:        getEnvList("CLUSTER_PEERS"),
+		ClusterSyncInterval: getEnvDuration("CLUSTER_SYNC_INTERVAL", 15*time.Second),
+		ClusterVnodes:       getEnvInt("CLUSTER_VNODES", 100),
+		ClusterReplicas:     getEnvInt("CLUSTER_REPLICAS", 2),
+
+		PeerstorePersist:   getEnvBool("PEERSTORE_PERSIST", true),
+		PeerstoreReconnect: getEnvInt("PEERSTORE_RECONNECT", 10),
+
+		ReadyMinPeers:    getEnvInt("READY_MIN_PEERS", 1),
+		ReadyCheckTunnel: getEnvBool("READY_CHECK_TUNNEL", true),
+
+		WatchdogInterval:      getEnvDuration("WATCHDOG_INTERVAL", 15*time.Second),
+		WatchdogGrace:         getEnvDuration("WATCHDOG_GRACE", 2*time.Minute),
+		WatchdogMinPeers:      getEnvInt("WATCHDOG_MIN_PEERS", 1),
+		WatchdogMinTopicPeers: getEnvInt("WATCHDOG_MIN_TOPIC_PEERS", 1),
+		WatchdogTopics:        getEnvListDefault("WATCHDOG_TOPICS", []string{recordsTopic}),
+
+		DeliveryMode: getEnvString("DELIVERY_MODE", deliveryWebhook),
+
+		StreamReplaySize: getEnvInt("STREAM_REPLAY_SIZE", 1000),
+
+		WebhooksFile: getenv("WEBHOOKS_FILE"),
+		TypeRoutes:   getEnvList("TYPE_ROUTES"),
+
+		TunnelHMACSecret: getenv("TUNNEL_HMAC_SECRET"),
+
+		SinkType:    getenv("SINK_TYPE"),
+		SinkURLs:    getEnvList("SINK_URLS"),
+		SinkSubject: getEnvString("SINK_SUBJECT", "sight.{type}"),
+		SinkOnly:    getEnvBool("SINK_ONLY", false),
+
+		TunnelQueueSize:  getEnvInt("TUNNEL_QUEUE_SIZE", 1000),
+		TunnelWorkers:    getEnvInt("TUNNEL_WORKERS", 4),
+		TunnelOverflow:   getEnvString("TUNNEL_OVERFLOW", overflowDeadLetter),
+		TunnelSpoolMax:   getEnvInt("TUNNEL_SPOOL_MAX", 100000),
+		TunnelRetryMax:   getEnvInt("TUNNEL_RETRY_MAX", 5),
+		TunnelBackoffMin: getEnvDuration("TUNNEL_BACKOFF_MIN", 500*time.Millisecond),
+		TunnelBackoffMax: getEnvDuration("TUNNEL_BACKOFF_MAX", 30*time.Second),
+		DLQMaxEntries:    getEnvInt("DLQ_MAX_ENTRIES", 10000),
+
+		TunnelConnectTimeout:      getEnvDuration("TUNNEL_CONNECT_TIMEOUT", 5*time.Second),
+		TunnelKeepAlive:           getEnvDuration("TUNNEL_KEEPALIVE", 30*time.Second),
+		TunnelMaxIdleConns:        getEnvInt("TUNNEL_MAX_IDLE_CONNS", 100),
+		TunnelMaxIdleConnsPerHost: getEnvInt("TUNNEL_MAX_IDLE_CONNS_PER_HOST", 16),
+		TunnelMaxConnsPerHost:     getEnvInt("TUNNEL_MAX_CONNS_PER_HOST", 0),
+		TunnelIdleConnTimeout:     getEnvDuration("TUNNEL_IDLE_CONN_TIMEOUT", 90*time.Second),
+		TunnelProxy:               getenv("TUNNEL_PROXY"),
+
+		Compression:     getEnvList("COMPRESSION"),
+		CompressMinSize: getEnvInt("COMPRESS_MIN_SIZE", 1024),
+
+		WireFormat: getEnvString("WIRE_FORMAT", wireJSON),
+
+		RPCTimeout: getEnvDuration("RPC_TIMEOUT", 30*time.Second),
+
+		FileSendDir:        getenv("FILE_SEND_DIR"),
+		FileReceiveDir:     getenv("FILE_RECEIVE_DIR"),
+		FileReceivePeers:   getEnvList("FILE_RECEIVE_PEERS"),
+		FileMaxSize:        int64(getEnvInt("FILE_MAX_SIZE", 100<<20)),
+		FileReceiveQuota:   int64(getEnvInt("FILE_RECEIVE_QUOTA", 1<<30)),
+		FileReceiveTimeout: getEnvDuration("FILE_RECEIVE_TIMEOUT", 10*time.Minute),
+
+		LegacyTopic: getEnvBool("LEGACY_TOPIC", true),
+
+		BandwidthPeerMetrics: getEnvBool("BANDWIDTH_PEER_METRICS", true),
+
+		EventsWebhook: getenv("EVENTS_WEBHOOK"),
+		EventsStream:  getEnvBool("EVENTS_STREAM", false),
+
+		OTLPEndpoint:    getEnvString("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+		OTelServiceName: getEnvString("OTEL_SERVICE_NAME", "sight-libp2p-node"),
+
+		SwarmKeyFile: getenv("SWARM_KEY_FILE"),
+
+		OutboxSize:    getEnvInt("OUTBOX_SIZE", 1000),
+		OutboxWorkers: getEnvInt("OUTBOX_WORKERS", 4),
+		OutboxWeights: getEnvIntList("OUTBOX_WEIGHTS", []int{8, 4, 1}),
+
+		BatchConcurrency: getEnvInt("BATCH_CONCURRENCY", 8),
+		BatchMaxSize:     getEnvInt("BATCH_MAX_SIZE", 1000),
+
+		DeliveryReceipts: getEnvBool("DELIVERY_RECEIPTS", true),
+		QoSAckTimeout:    getEnvDuration("QOS_ACK_TIMEOUT", 10*time.Second),
+		QoSMaxAttempts:   getEnvInt("QOS_MAX_ATTEMPTS", 5),
+		SeqGapTimeout:    getEnvDuration("SEQ_GAP_TIMEOUT", 30*time.Second),
+		IdempotencyTTL:   getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		MessageStatusTTL: getEnvDuration("MESSAGE_STATUS_TTL", time.Hour),
+
+		DrainTimeout: getEnvDuration("DRAIN_TIMEOUT", 30*time.Second),
+
+		PublishTimeout: getEnvDuration("PUBLISH_TIMEOUT", 10*time.Second),
+		DialTimeout:    getEnvDuration("DIAL_TIMEOUT", 15*time.Second),
+		TunnelTimeout:  getEnvDuration("TUNNEL_TIMEOUT", 30*time.Second),
+
+		RecordMaxSize:    getEnvInt("RECORD_MAX_SIZE", 16*1024),
+		RecordTTL:        getEnvDuration("RECORD_TTL", 24*time.Hour),
+		RecordMaxTTL:     getEnvDuration("RECORD_MAX_TTL", 48*time.Hour),
+		RecordMaxRecords: getEnvInt("RECORD_MAX_RECORDS", 10000),
+		RecordMaxPerDID:  getEnvInt("RECORD_MAX_PER_DID", 64),
+
+		RendezvousNamespace:        getenv("RENDEZVOUS_NAMESPACE"),
+		RendezvousTTL:              getEnvDuration("RENDEZVOUS_TTL", 2*time.Hour),
+		RendezvousMaxRegistrations: getEnvInt("RENDEZVOUS_MAX_REGISTRATIONS", 1000),
+
+		BreakerThreshold:   getEnvInt("BREAKER_THRESHOLD", 5),
+		BreakerOpenTimeout: getEnvDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+
+		ArchiveEnabled: getEnvBool("ARCHIVE_ENABLED", true),
+		ArchivePath:    getEnvString("ARCHIVE_PATH", getIdentityDir()+"/archive.db"),
+
+		// ARCHIVE_RETENTION is the former name of RETENTION_MAX_AGE
+		RetentionMaxAge:         getEnvDuration("RETENTION_MAX_AGE", getEnvDuration("ARCHIVE_RETENTION", 7*24*time.Hour)),
+		RetentionMaxBytesPerDID: int64(getEnvInt("RETENTION_MAX_BYTES_PER_DID", 0)),
+		RetentionMaxBytes:       int64(getEnvInt("RETENTION_MAX_BYTES", 0)),
+		RetentionInterval:       getEnvDuration("RETENTION_INTERVAL", 10*time.Minute),
+
+		AuditLog:     getEnvBool("AUDIT_LOG", false),
+		AuditLogPath: getEnvString("AUDIT_LOG_PATH", getIdentityDir()+"/audit.log"),
+
+		AdminToken: getenv("ADMIN_TOKEN"),
+
+		DataDir:     getDataDir(),
+		IdentityDir: getIdentityDir(),
+
+		KeyType:            getenv("KEY_TYPE"),
+		KeyMnemonic:        getenv("KEY_MNEMONIC"),
+		KeystorePassphrase: getenv("KEYSTORE_PASSPHRASE"),
+		KeystoreEncrypt:    getenv("KEYSTORE_ENCRYPT") == "1",
+		SignerSocket:       getenv("SIGNER_SOCKET"),
+
+		Chaos:             getEnvBool("CHAOS", false),
+		ChaosLatency:      getEnvDuration("CHAOS_LATENCY", 0),
+		ChaosDropPercent:  getEnvInt("CHAOS_DROP_PERCENT", 0),
+		ChaosKillInterval: getEnvDuration("CHAOS_KILL_INTERVAL", 0),
+
+		GossipSubD:             getEnvInt("GOSSIPSUB_D", pubsub.GossipSubD),
+		GossipSubDlo:           getEnvInt("GOSSIPSUB_DLO", pubsub.GossipSubDlo),
+		GossipSubDhi:           getEnvInt("GOSSIPSUB_DHI", pubsub.GossipSubDhi),
+		GossipSubHeartbeat:     getEnvDuration("GOSSIPSUB_HEARTBEAT", pubsub.GossipSubHeartbeatInterval),
+		GossipSubFanoutTTL:     getEnvDuration("GOSSIPSUB_FANOUT_TTL", pubsub.GossipSubFanoutTTL),
+		GossipSubHistoryLength: getEnvInt("GOSSIPSUB_HISTORY_LENGTH", pubsub.GossipSubHistoryLength),
+		GossipSubHistoryGossip: getEnvInt("GOSSIPSUB_HISTORY_GOSSIP", pubsub.GossipSubHistoryGossip),
+
+		GRPCPort: getEnvInt("GRPC_PORT", 0),
+
+		MQTTBroker:     getenv("MQTT_BROKER"),
+		MQTTClientID:   getenv("MQTT_CLIENT_ID"),
+		MQTTUsername:   getenv("MQTT_USERNAME"),
+		MQTTPassword:   getenv("MQTT_PASSWORD"),
+		MQTTTopics:     getEnvList("MQTT_TOPICS"),
+		MQTTQoS:        getEnvInt("MQTT_QOS", 0),
+		MQTTLoopWindow: getEnvDuration("MQTT_LOOP_WINDOW", 30*time.Second),
+
+		LogLevel: getenv("LOG_LEVEL"),
+	}
+	cfg.RPCAPI = getEnvString("RPC_API", cfg.TunnelAPI)
+	return cfg
+}
+
+func getEnvString(key string, defaultVal string) string {
+	if value := getenv(key); value != "" {
+		return value
+	}
+	return defaultVal
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	value := getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	intVal, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultVal
+	}
+	return intVal
+}
+
+// getEnvBool accepts 1/0 and true/false
+func getEnvBool(key string, defaultVal bool) bool {
+	value, err := strconv.ParseBool(getenv(key))
+	if err != nil {
+		return defaultVal
+	}
+	return value
+}
+
+// getEnvDuration parses a Go duration string such as "30s" or "5m"
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	value := getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultVal
+	}
+	return d
+}
+
+// getEnvList splits a comma separated variable, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvIntList parses a comma separated list of integers, falling back to
+// defaultVal when the variable is empty or invalid
+func getEnvIntList(key string, defaultVal []int) []int {
+	var list []int
+	for _, item := range getEnvList(key) {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return defaultVal
+		}
+		list = append(list, n)
+	}
+	if len(list) == 0 {
+		return defaultVal
+	}
+	return list
+}
+
+// getEnvListDefault is getEnvList falling back to defaultVal when the variable is empty
+func getEnvListDefault(key string, defaultVal []string) []string {
+	if list := getEnvList(key); len(list) > 0 {
+		return list
+	}
+	return defaultVal
+}
@@ -0,0 +1,51 @@
+package sightnode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileOverridesEnvironment(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("PUBSUB_RATE_LIMIT", "5")
+	path := filepath.Join(t.TempDir(), "sight.env")
+	err := os.WriteFile(path, []byte(`# reloadable settings
+LOG_LEVEL=debug
+export LEGACY_TOPIC=false
+PEER_ALLOWLIST="a, b"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel %q, want the file's debug", cfg.LogLevel)
+	}
+	if cfg.LegacyTopic {
+		t.Error("LegacyTopic still enabled")
+	}
+	if len(cfg.PeerAllowlist) != 2 || cfg.PeerAllowlist[1] != "b" {
+		t.Errorf("PeerAllowlist %q, want the file's quoted list", cfg.PeerAllowlist)
+	}
+	if cfg.PubsubRateLimit != 5 {
+		t.Errorf("PubsubRateLimit %d, want 5 from the environment", cfg.PubsubRateLimit)
+	}
+	if got := LoadConfig().LogLevel; got != "info" {
+		t.Errorf("LoadConfig read LogLevel %q after LoadConfigFile, want info", got)
+	}
+}
+
+func TestLoadConfigFileRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sight.env")
+	if err := os.WriteFile(path, []byte("LOG_LEVEL debug\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(path); err == nil {
+		t.Fatal("line without = was accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnectResult reports the outcome of a forced connection attempt
+type ConnectResult struct {
+	Peer       string   `json:"peer"`
+	Connected  bool     `json:"connected"`
+	Addrs      []string `json:"addrs"`
+	RemoteAddr string   `json:"remoteAddr,omitempty"`
+	Transport  string   `json:"transport,omitempty"`
+	Duration   string   `json:"duration"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// transportNames maps multiaddr protocols to the transport they select,
+// checked from the outermost protocol inwards
+var transportNames = []struct{ protocol, name string }{
+	{"p2p-circuit", "relay"},
+	{"webrtc-direct", "webrtc-direct"},
+	{"webtransport", "webtransport"},
+	{"quic-v1", "quic"},
+	{"wss", "wss"},
+	{"tls", "wss"},
+	{"ws", "ws"},
+	{"tcp", "tcp"},
+}
+
+// transportOf names the transport a multiaddr dials
+func transportOf(addr ma.Multiaddr) string {
+	protos := map[string]bool{}
+	for _, p := range addr.Protocols() {
+		protos[p.Name] = true
+	}
+	for _, t := range transportNames {
+		if protos[t.protocol] {
+			if t.protocol == "tls" && !protos["ws"] {
+				continue
+			}
+			return t.name
+		}
+	}
+	return "unknown"
+}
+
+// Connect dials a peer given a full multiaddr (/.../p2p/<id>), a PeerID or a
+// DID. PeerIDs and DIDs are dialled at the addresses in the peerstore, which
+// holds everything learned from identify, bootstrap and earlier connections;
+// unknown peers are resolved through the connected gateways. Dial errors are reported in the result rather than returned.
+func (s *Libp2pNodeService) Connect(ctx context.Context, target string) (ConnectResult, error) {
+	var info peer.AddrInfo
+	if strings.HasPrefix(target, "/") {
+		addr, err := ma.NewMultiaddr(target)
+		if err != nil {
+			return ConnectResult{}, err
+		}
+		ai, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			return ConnectResult{}, errors.New("multiaddr must end with /p2p/<peer id>")
+		}
+		info = *ai
+	} else {
+		id, err := parsePeerOrDID(target)
+		if err != nil {
+			return ConnectResult{}, err
+		}
+		info.ID = id
+	}
+
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	if info.ID == node.ID() {
+		return ConnectResult{}, errors.New("cannot connect to self")
+	}
+	if len(info.Addrs) == 0 {
+		info.Addrs = node.Peerstore().Addrs(info.ID)
+	}
+	if len(info.Addrs) == 0 {
+		if did, err := peerDID(node.Peerstore(), info.ID); err == nil {
+			if _, err := s.ResolvePeer(ctx, did); err != nil {
+				debugf("Could not resolve %s: %v", did, err)
+			}
+			info.Addrs = node.Peerstore().Addrs(info.ID)
+		}
+	}
+
+	result := ConnectResult{Peer: info.ID.String(), Addrs: []string{}}
+	for _, addr := range info.Addrs {
+		result.Addrs = append(result.Addrs, addr.String())
+	}
+
+	start := time.Now()
+	err := node.Connect(ctx, info)
+	result.Duration = time.Since(start).String()
+	if err != nil {
+		if len(info.Addrs) == 0 {
+			err = errors.New("no known addresses for peer")
+		}
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Connected = true
+	if conns := node.Network().ConnsToPeer(info.ID); len(conns) > 0 {
+		remote := conns[0].RemoteMultiaddr()
+		result.RemoteAddr = remote.String()
+		result.Transport = transportOf(remote)
+	}
+	return result, nil
+}
@@ -0,0 +1,1103 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/trace"
+
+	"sight-libp2p-node/api/sightpb"
+)
+
+type Libp2pNodeController struct {
+	service *Libp2pNodeService
+}
+
+func NewLibp2pNodeController(service *Libp2pNodeService) *Libp2pNodeController {
+	return &Libp2pNodeController{service}
+}
+
+func (c *Libp2pNodeController) SendHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	msg, err := envelopeFromTunnel(body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	ctx, span := tracer.Start(httpTraceContext(r), "send", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	// The request ID travels in the envelope to the recipient's tunnel API
+	requestID := r.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	w.Header().Set(requestIDHeader, requestID)
+	msg["requestId"] = requestID
+
+	var id string
+	var duplicate bool
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		id, duplicate, err = c.service.SendIdempotent(ctx, key, msg)
+	} else {
+		id, err = c.service.SendAsync(ctx, msg)
+	}
+	if err == nil {
+		debugf("Queued message %s", messageRef(id, requestID))
+	}
+	if err != nil {
+		status := 400
+		if errors.Is(err, errOutboxFull) || errors.Is(err, errDraining) {
+			status = 503
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if duplicate {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// MessagesHandler queries the message archive. Filters: did (sender or
+// recipient), topic, direction (in/out), since and until (RFC3339) and limit.
+func (c *Libp2pNodeController) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := ArchiveQuery{
+		DID:       params.Get("did"),
+		Topic:     params.Get("topic"),
+		Direction: params.Get("direction"),
+		Limit:     100,
+	}
+	if q.Direction != "" && q.Direction != directionIn && q.Direction != directionOut {
+		http.Error(w, "direction must be in or out", 400)
+		return
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+name+": "+err.Error(), 400)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", 400)
+			return
+		}
+		q.Limit = limit
+	}
+	messages, err := c.service.ArchivedMessages(q)
+	if err != nil {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// AuditExportHandler downloads the audit log as JSON Lines, one hash-chained
+// entry per line
+func (c *Libp2pNodeController) AuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	if c.service.auditLog == nil {
+		http.Error(w, errAuditDisabled.Error(), 503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.log"`)
+	c.service.ExportAuditLog(w)
+}
+
+// AuditVerifyHandler checks the hash chain of the audit log
+func (c *Libp2pNodeController) AuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := c.service.VerifyAuditLog()
+	if err != nil {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// DeleteDataHandler purges the data stored about a DID
+func (c *Libp2pNodeController) DeleteDataHandler(w http.ResponseWriter, r *http.Request) {
+	did := mux.Vars(r)["did"]
+	if _, err := ParseSightDID(did); err != nil {
+		http.Error(w, "Invalid DID: "+err.Error(), 400)
+		return
+	}
+	deletion, err := c.service.DeleteDIDData(did)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deletion)
+}
+
+// MessageStatusHandler reports the delivery state of a message sent by this node
+func (c *Libp2pNodeController) MessageStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, ok := c.service.MessageStatus(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Message not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// envelopeFromTunnel wraps a message posted by the upstream service into an
+// envelope. A message with "envelope": 1 is a structured envelope; any other
+// is itself the payload, with the recipient in "to". The payload stays the
+// posted JSON, never decoded, so it reaches the recipient unchanged.
+func envelopeFromTunnel(body json.RawMessage) (map[string]interface{}, error) {
+	var req sendRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, errors.New("invalid JSON")
+	}
+	if req.To == "" {
+		return nil, errors.New("to is required")
+	}
+	payload := body
+	switch req.Envelope {
+	case 0:
+		// A flat message is its own payload
+	case 1:
+		if len(req.Payload) == 0 {
+			return nil, errors.New("payload is required in an envelope")
+		}
+		payload = req.Payload
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", req.Envelope)
+	}
+	libp2pMsg := map[string]interface{}{
+		"to":      req.To,
+		"payload": payload,
+	}
+	if req.ExpiresAt != "" {
+		libp2pMsg["expiresAt"] = req.ExpiresAt
+	}
+	if req.Priority != "" {
+		libp2pMsg["priority"] = req.Priority
+	}
+	if req.QoS != "" {
+		libp2pMsg["qos"] = req.QoS
+	}
+	// In flat messages the type also stays in the payload, for receivers
+	// that predate the field
+	if req.Type != "" {
+		libp2pMsg["type"] = req.Type
+	}
+	return libp2pMsg, nil
+}
+
+// BatchSendResult is the outcome of one message of a batch
+type BatchSendResult struct {
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// checkBatch validates the batch send settings
+func checkBatch(cfg Config) error {
+	if cfg.BatchConcurrency < 1 {
+		return fmt.Errorf("BATCH_CONCURRENCY must be at least 1, got %d", cfg.BatchConcurrency)
+	}
+	if cfg.BatchMaxSize < 1 {
+		return fmt.Errorf("BATCH_MAX_SIZE must be at least 1, got %d", cfg.BatchMaxSize)
+	}
+	return nil
+}
+
+// SendBatchHandler publishes an array of messages concurrently, with at most
+// BATCH_CONCURRENCY in flight, and returns a result per message in order
+func (c *Libp2pNodeController) SendBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var batch []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	if len(batch) > c.service.cfg.BatchMaxSize {
+		http.Error(w, fmt.Sprintf("batch exceeds %d messages", c.service.cfg.BatchMaxSize), 400)
+		return
+	}
+	ctx, span := tracer.Start(httpTraceContext(r), "send-batch", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	results := make([]BatchSendResult, len(batch))
+	sem := make(chan struct{}, c.service.cfg.BatchConcurrency)
+	var wg sync.WaitGroup
+	for i, tunnelMsg := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, tunnelMsg json.RawMessage) {
+			defer func() { <-sem; wg.Done() }()
+			msg, err := envelopeFromTunnel(tunnelMsg)
+			var id string
+			if err == nil {
+				id, err = c.service.HandleOutgoingMessage(ctx, msg)
+			}
+			results[i] = BatchSendResult{ID: id, Status: "ok"}
+			if err != nil {
+				results[i].Status = "error"
+				results[i].Error = err.Error()
+			}
+		}(i, tunnelMsg)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// RotateKeyHandler rotates the node keypair and returns the signed rotation record
+func (c *Libp2pNodeController) RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	record, err := c.service.RotateKey()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// ExportKeyRequest is the request body of ExportKeyHandler
+type ExportKeyRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// ExportKeyHandler returns the node keypair encrypted with the given passphrase
+func (c *Libp2pNodeController) ExportKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExportKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	ks, err := c.service.ExportKey(req.Passphrase)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ks)
+}
+
+// MnemonicHandler returns the 24-word backup phrase of the node key
+func (c *Libp2pNodeController) MnemonicHandler(w http.ResponseWriter, r *http.Request) {
+	mnemonic, err := c.service.ExportMnemonic()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"mnemonic": mnemonic})
+}
+
+// ImportKeyRequest is the request body of ImportKeyHandler
+type ImportKeyRequest struct {
+	Keystore   *EncryptedKeystore `json:"keystore"`
+	Passphrase string             `json:"passphrase"`
+	Mnemonic   string             `json:"mnemonic"`
+}
+
+// ImportKeyHandler replaces the node identity with an exported keystore or a
+// backup phrase
+func (c *Libp2pNodeController) ImportKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req ImportKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	var did string
+	var err error
+	switch {
+	case req.Mnemonic != "":
+		did, err = c.service.ImportMnemonic(req.Mnemonic)
+	case req.Keystore != nil:
+		did, err = c.service.ImportKey(req.Keystore, req.Passphrase)
+	default:
+		http.Error(w, "keystore or mnemonic is required", 400)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "did": did})
+}
+
+// ResolveDIDHandler decodes a DID into its public key and PeerID
+func (c *Libp2pNodeController) ResolveDIDHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := ResolveDID(mux.Vars(r)["did"])
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// DIDDocumentHandler resolves the DID document a DID published on the network
+func (c *Libp2pNodeController) DIDDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	did := mux.Vars(r)["did"]
+	if _, err := ParseSightDID(did); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	doc, err := c.service.ResolveDIDDocument(r.Context(), did)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/did+json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// ResolvePeerHandler returns the current PeerID and addresses of a DID
+func (c *Libp2pNodeController) ResolvePeerHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := c.service.ResolvePeer(r.Context(), mux.Vars(r)["did"])
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// RevokeRequest is the request body of RevokeHandler
+type RevokeRequest struct {
+	DID    string `json:"did"`
+	Reason string `json:"reason"`
+}
+
+// RevokeHandler signs and distributes the revocation of a DID
+func (c *Libp2pNodeController) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	revocation, err := c.service.Revoke(r.Context(), req.DID, req.Reason)
+	if errors.Is(err, errNotAuthority) {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revocation)
+}
+
+// RevocationsHandler lists the revoked DIDs
+func (c *Libp2pNodeController) RevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revocations": c.service.Revocations()})
+}
+
+// SubscriptionsHandler reports the health of the pubsub subscriptions
+func (c *Libp2pNodeController) SubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscriptions": c.service.Subscriptions()})
+}
+
+// AdmissionsHandler lists the admission decisions of this gateway
+func (c *Libp2pNodeController) AdmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"admissions": c.service.Admissions()})
+}
+
+// DecideAdmissionHandler approves or rejects a hoster, depending on the route
+func (c *Libp2pNodeController) DecideAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	admission, err := c.service.DecideAdmission(vars["did"], vars["decision"] == "approve")
+	if errors.Is(err, errUnknownAdmission) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admission)
+}
+
+// IssueTopicTokenRequest is the request body of IssueTopicTokenHandler
+type IssueTopicTokenRequest struct {
+	Topic  string `json:"topic"`
+	Holder string `json:"holder"`
+	TTL    string `json:"ttl"`
+}
+
+// IssueTopicTokenHandler signs a membership token for a protected topic
+func (c *Libp2pNodeController) IssueTopicTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req IssueTopicTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	ttl := c.service.cfg.TopicTokenTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			http.Error(w, "Invalid ttl", 400)
+			return
+		}
+	}
+	token, err := c.service.IssueTopicToken(req.Topic, req.Holder, ttl)
+	if errors.Is(err, errNotTopicOwner) {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// AddTopicTokenHandler installs a membership token issued to this node
+func (c *Libp2pNodeController) AddTopicTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var token TopicToken
+	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	if err := c.service.AddTopicToken(token); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// TopicTokensHandler lists the membership tokens this node holds
+func (c *Libp2pNodeController) TopicTokensHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": c.service.TopicTokens()})
+}
+
+// CreateGroupRequest is the request body of CreateGroupHandler
+type CreateGroupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// CreateGroupHandler creates a group and invites its members
+func (c *Libp2pNodeController) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	group, err := c.service.CreateGroup(r.Context(), req.Name, req.Members)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// GroupsHandler lists the groups this node created or was invited to
+func (c *Libp2pNodeController) GroupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"groups": c.service.Groups()})
+}
+
+// JoinGroupHandler accepts a group invite
+func (c *Libp2pNodeController) JoinGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, err := c.service.JoinGroup(mux.Vars(r)["id"])
+	if errors.Is(err, errUnknownGroup) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// SendGroupHandler publishes a message to every member of a group
+func (c *Libp2pNodeController) SendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	// The payload is passed through undecoded, like in SendHandler
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	msg := map[string]interface{}{"payload": json.RawMessage(body)}
+	if requestID := r.Header.Get(requestIDHeader); requestID != "" {
+		msg["requestId"] = requestID
+	}
+	id, err := c.service.SendGroup(r.Context(), mux.Vars(r)["id"], msg)
+	if err != nil {
+		status := 400
+		if errors.Is(err, errUnknownGroup) {
+			status = 404
+		} else if errors.Is(err, errDraining) {
+			status = 503
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// PeerDIDHandler looks up the DID of a PeerID
+func (c *Libp2pNodeController) PeerDIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := peer.Decode(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid peer ID", 400)
+		return
+	}
+	did, err := c.service.peerDID(id)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	info, err := ResolveDID(did)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// DisconnectPeerHandler closes all connections to a peer; ?block=true also
+// adds it to the blocklist
+func (c *Libp2pNodeController) DisconnectPeerHandler(w http.ResponseWriter, r *http.Request) {
+	block, _ := strconv.ParseBool(r.URL.Query().Get("block"))
+	id, conns, err := c.service.DisconnectPeer(mux.Vars(r)["id"], block)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "ok",
+		"peer":        id.String(),
+		"connections": conns,
+		"blocked":     block,
+	})
+}
+
+// PeersHandler lists connected peers with their protections and tags
+func (c *Libp2pNodeController) PeersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"peers": c.service.Peers()})
+}
+
+// PeerDetailsHandler describes a connected peer: protocols, connections,
+// latency and gossipsub score
+func (c *Libp2pNodeController) PeerDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	details, err := c.service.PeerDetails(mux.Vars(r)["id"])
+	if errors.Is(err, errPeerNotConnected) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// BlockRequest is the request body of BlockHandler
+type BlockRequest struct {
+	Peer string `json:"peer"`
+	DID  string `json:"did"`
+	CIDR string `json:"cidr"`
+}
+
+// BlockHandler adds (POST) or removes (DELETE) a PeerID, DID or CIDR from the blocklist
+func (c *Libp2pNodeController) BlockHandler(w http.ResponseWriter, r *http.Request) {
+	var req BlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	entry := req.Peer
+	if req.DID != "" {
+		entry = req.DID
+	}
+	if req.CIDR != "" {
+		entry = req.CIDR
+	}
+	if entry == "" {
+		http.Error(w, "peer, did or cidr is required", 400)
+		return
+	}
+	var err error
+	if r.Method == http.MethodDelete {
+		err = c.service.gater.Unblock(entry)
+	} else {
+		err = c.service.BlockPeer(entry)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// BlocklistHandler returns the blocked peers and CIDR ranges
+func (c *Libp2pNodeController) BlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.gater.List())
+}
+
+// BootstrapRequest is the request body of BootstrapHandler
+type BootstrapRequest struct {
+	Addr string `json:"addr"`
+}
+
+// BootstrapHandler adds (POST) or removes (DELETE) a bootstrap peer and returns the resulting list
+func (c *Libp2pNodeController) BootstrapHandler(w http.ResponseWriter, r *http.Request) {
+	var req BootstrapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+		http.Error(w, "addr is required", 400)
+		return
+	}
+	var err error
+	if r.Method == http.MethodDelete {
+		err = c.service.RemoveBootstrap(req.Addr)
+	} else {
+		err = c.service.AddBootstrap(req.Addr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	c.BootstrapListHandler(w, r)
+}
+
+// BootstrapListHandler returns the current bootstrap list
+func (c *Libp2pNodeController) BootstrapListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"bootstrap": c.service.BootstrapAddrs()})
+}
+
+// TypeRouteRequest is the request body of SetTypeRouteHandler
+type TypeRouteRequest struct {
+	Action string `json:"action"`
+	URL    string `json:"url,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+}
+
+// TypeRoutesHandler returns the routing table of incoming message types
+func (c *Libp2pNodeController) TypeRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]TypeRoute{"routes": c.service.typeRoutes.List()})
+}
+
+// SetTypeRouteHandler adds or replaces the route of a message type
+func (c *Libp2pNodeController) SetTypeRouteHandler(w http.ResponseWriter, r *http.Request) {
+	var req TypeRouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	route := TypeRoute{Type: mux.Vars(r)["type"], Action: req.Action, URL: req.URL, Topic: req.Topic}
+	if err := c.service.typeRoutes.Set(route); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(route)
+}
+
+// DeleteTypeRouteHandler removes the route of a message type, whose messages
+// then go to the tunnel again
+func (c *Libp2pNodeController) DeleteTypeRouteHandler(w http.ResponseWriter, r *http.Request) {
+	removed, err := c.service.typeRoutes.Remove(mux.Vars(r)["type"])
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !removed {
+		http.Error(w, "no route for this type", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HealthzHandler reports that the process is alive
+func (c *Libp2pNodeController) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler reports whether the node can serve traffic (503 when not ready)
+func (c *Libp2pNodeController) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := c.service.Readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// DLQHandler lists the deliveries that failed after all retries
+func (c *Libp2pNodeController) DLQHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.forwarder.DeadLetters())
+}
+
+// DLQReplayHandler queues a dead letter for delivery again
+func (c *Libp2pNodeController) DLQReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if err := c.service.forwarder.Replay(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// SchemaHandler serves the protobuf schemas of the API and wire envelope
+func (c *Libp2pNodeController) SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := sightpb.Schemas.ReadFile(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, "Schema not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// RPCRequest is the request body of RPCHandler
+type RPCRequest struct {
+	To      string          `json:"to"`
+	Payload json.RawMessage `json:"payload"`
+	Timeout string          `json:"timeout"`
+}
+
+// RPCHandler sends a request to the node owning a DID and returns its response
+func (c *Libp2pNodeController) RPCHandler(w http.ResponseWriter, r *http.Request) {
+	var req RPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" {
+		http.Error(w, "to is required", 400)
+		return
+	}
+	timeout, err := c.service.rpcTimeout(req.Timeout)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	resp, err := c.service.CallRPC(ctx, req.To, req.Payload)
+	if err != nil {
+		status := 502
+		if isTimeout(err) {
+			status = 504
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SendFileRequest is the request body of SendFileHandler
+type SendFileRequest struct {
+	To string `json:"to"`
+	// Path is relative to FILE_SEND_DIR, or absolute inside it
+	Path string `json:"path"`
+}
+
+// SendFileHandler starts sending a local file to a DID; poll GET /v1/libp2p/files/{id} for progress
+func (c *Libp2pNodeController) SendFileHandler(w http.ResponseWriter, r *http.Request) {
+	var req SendFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" || req.Path == "" {
+		http.Error(w, "to and path are required", 400)
+		return
+	}
+	transfer, err := c.service.SendFile(req.To, req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// FileTransferHandler returns the progress of a file transfer
+func (c *Libp2pNodeController) FileTransferHandler(w http.ResponseWriter, r *http.Request) {
+	transfer, ok := c.service.files.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Transfer not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// FileTransfersHandler lists the file transfers sent and received
+func (c *Libp2pNodeController) FileTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.files.List())
+}
+
+// RegistryHandler returns the gateway's DID routing table, with the routes
+// learned from federated gateways and the cluster presence it owns
+func (c *Libp2pNodeController) RegistryHandler(w http.ResponseWriter, r *http.Request) {
+	c.service.mu.RLock()
+	registry, fed, cl := c.service.registry, c.service.federation, c.service.cluster
+	c.service.mu.RUnlock()
+	if registry == nil {
+		http.Error(w, "Registry is only kept by gateways", 404)
+		return
+	}
+	entries := registry.List()
+	if fed != nil {
+		entries = append(entries, fed.List()...)
+	}
+	if cl != nil {
+		// The presence owned here includes hosters already listed locally
+		local := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			local[entry.DID] = true
+		}
+		for _, entry := range cl.List() {
+			if !local[entry.DID] {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ConnectRequest is the request body of ConnectHandler
+type ConnectRequest struct {
+	Addr string `json:"addr"`
+	Peer string `json:"peer"`
+	DID  string `json:"did"`
+}
+
+// ConnectHandler forces a connection attempt to a multiaddr, PeerID or DID
+func (c *Libp2pNodeController) ConnectHandler(w http.ResponseWriter, r *http.Request) {
+	var req ConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	target := req.Addr
+	if target == "" {
+		target = req.Peer
+	}
+	if target == "" {
+		target = req.DID
+	}
+	if target == "" {
+		http.Error(w, "addr, peer or did is required", 400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	result, err := c.service.Connect(ctx, target)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Connected {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// AddressesHandler lists the node listen, interface, observed and announced addresses
+func (c *Libp2pNodeController) AddressesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.Addresses())
+}
+
+// PutRecordHandler publishes the request body as a signed record. The key is
+// <did>/<name> and the DID must be this node's own.
+func (c *Libp2pNodeController) PutRecordHandler(w http.ResponseWriter, r *http.Request) {
+	did, name, err := splitRecordKey(mux.Vars(r)["key"])
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	c.service.mu.RLock()
+	own := c.service.did
+	c.service.mu.RUnlock()
+	if did != own {
+		http.Error(w, "Records can only be published under this node's DID", 403)
+		return
+	}
+	var value json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	record, err := c.service.PutRecord(r.Context(), name, value)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// GetRecordHandler resolves a record from the local store or connected peers
+func (c *Libp2pNodeController) GetRecordHandler(w http.ResponseWriter, r *http.Request) {
+	record, err := c.service.GetRecord(r.Context(), mux.Vars(r)["key"])
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// ProvideRequest is the request body of ProvideHandler
+type ProvideRequest struct {
+	CID string `json:"cid"`
+}
+
+// ProvideHandler announces that this node holds a CID
+func (c *Libp2pNodeController) ProvideHandler(w http.ResponseWriter, r *http.Request) {
+	var req ProvideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	id, err := cid.Decode(req.CID)
+	if err != nil {
+		http.Error(w, "Invalid CID: "+err.Error(), 400)
+		return
+	}
+	record, err := c.service.Provide(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "cid": id.String(), "expiresAt": record.ExpiresAt})
+}
+
+// FindProvidersHandler lists the DIDs that announced a CID
+func (c *Libp2pNodeController) FindProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := cid.Decode(mux.Vars(r)["cid"])
+	if err != nil {
+		http.Error(w, "Invalid CID: "+err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.FindProviders(r.Context(), id))
+}
+
+// PingRequest is the request body of PingHandler
+type PingRequest struct {
+	Peer  string `json:"peer"`
+	DID   string `json:"did"`
+	Count int    `json:"count"`
+}
+
+// PingHandler measures the round trip time to a PeerID or DID
+func (c *Libp2pNodeController) PingHandler(w http.ResponseWriter, r *http.Request) {
+	var req PingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	target := req.Peer
+	if req.DID != "" {
+		target = req.DID
+	}
+	if target == "" {
+		http.Error(w, "peer or did is required", 400)
+		return
+	}
+	if req.Count <= 0 || req.Count > 20 {
+		req.Count = 3
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	result, err := c.service.Ping(ctx, target, req.Count)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// BandwidthHandler returns the bandwidth used per peer and per protocol
+func (c *Libp2pNodeController) BandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.Bandwidth())
+}
+
+// StatsHandler returns the node's message counters, queue depths and topic
+// mesh sizes, as polled by the dashboard subcommand
+func (c *Libp2pNodeController) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.Stats())
+}
+
+// OpenAPIHandler serves the OpenAPI document of the versioned API
+func (c *Libp2pNodeController) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OpenAPI())
+}
+
+// UIHandler serves the single-page status UI
+func (c *Libp2pNodeController) UIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(webUIPage)
+}
+
+// UIStatusHandler returns the snapshot the status UI polls
+func (c *Libp2pNodeController) UIStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.UIStatus())
+}
+
+// ConfigReloadHandler re-reads the configuration, like SIGHUP
+func (c *Libp2pNodeController) ConfigReloadHandler(w http.ResponseWriter, r *http.Request) {
+	applied, err := c.service.ReloadConfig()
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "applied": applied})
+}
@@ -0,0 +1,53 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEnvelopeFromTunnel(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		payload string
+		err     bool
+	}{
+		{"flat", `{"to":"did:sight:a","type":"chat","text":"hi"}`, `{"to":"did:sight:a","type":"chat","text":"hi"}`, false},
+		// Upstreams that predate envelopes may use a payload field of their own
+		{"flat with payload field", `{"to":"did:sight:a","type":"x","payload":{"v":1}}`, `{"to":"did:sight:a","type":"x","payload":{"v":1}}`, false},
+		{"envelope", `{"envelope":1,"to":"did:sight:a","type":"x","payload":{"v":1}}`, `{"v":1}`, false},
+		{"envelope without payload", `{"envelope":1,"to":"did:sight:a"}`, "", true},
+		{"unknown envelope version", `{"envelope":2,"to":"did:sight:a","payload":{}}`, "", true},
+		{"no recipient", `{"type":"chat"}`, "", true},
+		{"invalid JSON", `{"to":`, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := envelopeFromTunnel(json.RawMessage(tc.body))
+			if tc.err {
+				if err == nil {
+					t.Fatalf("accepted, envelope %v", msg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(msg["payload"].(json.RawMessage)); got != tc.payload {
+				t.Errorf("payload %s, want %s", got, tc.payload)
+			}
+			if msg["to"] != "did:sight:a" {
+				t.Errorf("to %v, want did:sight:a", msg["to"])
+			}
+		})
+	}
+}
+
+func TestCheckBatchRejectsZeroConcurrency(t *testing.T) {
+	// A zero BATCH_CONCURRENCY would block every batch send forever
+	if err := checkBatch(Config{BatchConcurrency: 0, BatchMaxSize: 10}); err == nil {
+		t.Error("BATCH_CONCURRENCY=0 was accepted")
+	}
+	if err := checkBatch(Config{BatchConcurrency: 1, BatchMaxSize: 10}); err != nil {
+		t.Error(err)
+	}
+}
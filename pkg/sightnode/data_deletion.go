@@ -0,0 +1,62 @@
+package sightnode
+
+import (
+	"errors"
+	"log"
+)
+
+// errDataDeleted is returned to senders waiting on a purged message
+var errDataDeleted = errors.New("message removed by a data deletion request")
+
+// DataDeletion reports what DeleteDIDData removed
+type DataDeletion struct {
+	DID         string `json:"did"`
+	Queued      int    `json:"queued"`
+	Statuses    int    `json:"statuses"`
+	Archived    int64  `json:"archived"`
+	DeadLetters int    `json:"deadLetters"`
+	Records     int    `json:"records"`
+	Registry    bool   `json:"registry"`
+}
+
+// DeleteDIDData purges what this node stores about a DID: queued messages to
+// it, their delivery states, archived messages to or from it, dead letters
+// from it, its records and its registry entry. Records are only removed
+// locally; peers still holding them replicate them again until they expire.
+// The audit log is append-only and keeps its metadata entries.
+func (s *Libp2pNodeService) DeleteDIDData(did string) (DataDeletion, error) {
+	if _, err := ParseSightDID(did); err != nil {
+		return DataDeletion{}, err
+	}
+	d := DataDeletion{DID: did}
+
+	purged := s.outbox.Purge(func(job outboundJob) bool { return job.to == did })
+	for _, job := range purged {
+		if job.done != nil {
+			job.done <- errDataDeleted
+		}
+	}
+	d.Queued = len(purged)
+	d.Statuses = s.statuses.RemoveTo(did)
+
+	if s.archive != nil {
+		n, err := s.archive.deleteDID(did)
+		if err != nil {
+			return d, err
+		}
+		d.Archived = n
+	}
+	d.DeadLetters = s.forwarder.removeDeadLetters(did)
+	d.Records = s.records.RemoveOwned(did)
+
+	s.mu.RLock()
+	registry := s.registry
+	s.mu.RUnlock()
+	if registry != nil {
+		_, d.Registry = registry.Lookup(did)
+		registry.Remove(did)
+	}
+
+	log.Printf("Deleted data of %s: %d queued, %d archived, %d dead letters, %d records", did, d.Queued, d.Archived, d.DeadLetters, d.Records)
+	return d, nil
+}
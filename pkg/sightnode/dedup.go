@@ -0,0 +1,53 @@
+package sightnode
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupCache remembers message IDs for a TTL so duplicates delivered by
+// gossipsub are only forwarded to the tunnel once
+type dedupCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newDedupCache(ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		ttl:       ttl,
+		seen:      make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// Contains reports whether the ID was seen within the TTL, without recording it
+func (d *dedupCache) Contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.seen[id]
+	return ok && time.Since(t) <= d.ttl
+}
+
+// Seen records the ID and reports whether it was already seen within the TTL
+func (d *dedupCache) Seen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastPrune) > d.ttl {
+		for k, t := range d.seen {
+			if now.Sub(t) > d.ttl {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	if t, ok := d.seen[id]; ok && now.Sub(t) <= d.ttl {
+		return true
+	}
+	d.seen[id] = now
+	return false
+}
@@ -0,0 +1,217 @@
+package sightnode
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/mr-tron/base58"
+)
+
+// sightDIDPrefix is the method prefix of hoster DIDs
+const sightDIDPrefix = "did:sight:hoster:"
+
+// didKeyType describes a key type usable in sight DIDs: its name in
+// KEY_TYPE, the multicodec prefix of its public key and how to encode and
+// decode the key after the prefix
+type didKeyType struct {
+	keyType    pb.KeyType
+	name       string
+	multicodec []byte
+	marshal    func(crypto.PubKey) ([]byte, error)
+	unmarshal  func([]byte) (crypto.PubKey, error)
+}
+
+// didKeyTypes lists the supported key types, Ed25519 being the default.
+// Multicodecs: ed25519-pub 0xed, secp256k1-pub 0xe7, rsa-pub 0x1205 (varints).
+var didKeyTypes = []didKeyType{
+	{crypto.Ed25519, "ed25519", []byte{0xed, 0x01}, crypto.PubKey.Raw, crypto.UnmarshalEd25519PublicKey},
+	{crypto.Secp256k1, "secp256k1", []byte{0xe7, 0x01}, crypto.PubKey.Raw, crypto.UnmarshalSecp256k1PublicKey},
+	{crypto.RSA, "rsa", []byte{0x85, 0x24}, marshalRSADIDKey, unmarshalRSADIDKey},
+}
+
+// marshalRSADIDKey encodes an RSA key as rsa-pub defines it, PKCS#1 DER;
+// libp2p's Raw form is PKIX
+func marshalRSADIDKey(key crypto.PubKey) ([]byte, error) {
+	std, err := crypto.PubKeyToStdKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := std.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key: %T", std)
+	}
+	return x509.MarshalPKCS1PublicKey(pub), nil
+}
+
+// unmarshalRSADIDKey decodes the PKCS#1 DER of an rsa-pub key
+func unmarshalRSADIDKey(data []byte) (crypto.PubKey, error) {
+	pub, err := x509.ParsePKCS1PublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	// Goes through libp2p so its minimum key size applies
+	return crypto.UnmarshalRsaPublicKey(pkix)
+}
+
+// ed25519Multicodec is the multicodec prefix (ed25519-pub) of Ed25519 DIDs
+var ed25519Multicodec = didKeyTypes[0].multicodec
+
+// keyTypeByName returns the key type for a KEY_TYPE value; empty means Ed25519
+func keyTypeByName(name string) (didKeyType, error) {
+	if name == "" {
+		return didKeyTypes[0], nil
+	}
+	for _, t := range didKeyTypes {
+		if strings.EqualFold(t.name, name) {
+			return t, nil
+		}
+	}
+	return didKeyType{}, fmt.Errorf("unsupported key type %q", name)
+}
+
+func keyTypeOf(key crypto.PubKey) (didKeyType, error) {
+	for _, t := range didKeyTypes {
+		if t.keyType == key.Type() {
+			return t, nil
+		}
+	}
+	return didKeyType{}, fmt.Errorf("unsupported key type %s", key.Type())
+}
+
+// DIDInfo is the resolved form of a sight DID
+type DIDInfo struct {
+	DID       string `json:"did"`
+	KeyType   string `json:"keyType"`
+	PublicKey []byte `json:"publicKey"`
+	PeerID    string `json:"peerId"`
+}
+
+// DIDPublicKey decodes a did:sight:hoster DID back into its public key
+func DIDPublicKey(did string) (crypto.PubKey, error) {
+	if !strings.HasPrefix(did, sightDIDPrefix) {
+		return nil, fmt.Errorf("not a %s DID: %q", strings.TrimSuffix(sightDIDPrefix, ":"), did)
+	}
+	raw, err := base58.Decode(strings.TrimPrefix(did, sightDIDPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DID encoding: %w", err)
+	}
+	for _, t := range didKeyTypes {
+		if !bytes.HasPrefix(raw, t.multicodec) {
+			continue
+		}
+		key, err := t.unmarshal(raw[len(t.multicodec):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid DID public key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, errors.New("unsupported DID key type")
+}
+
+// ParseSightDID decodes a did:sight:hoster DID back into its raw public key
+func ParseSightDID(did string) ([]byte, error) {
+	key, err := DIDPublicKey(did)
+	if err != nil {
+		return nil, err
+	}
+	return key.Raw()
+}
+
+// PubKeyToDID builds the DID of a public key of any supported type
+func PubKeyToDID(key crypto.PubKey) (string, error) {
+	t, err := keyTypeOf(key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := t.marshal(key)
+	if err != nil {
+		return "", err
+	}
+	multicodec := append(append([]byte{}, t.multicodec...), raw...)
+	return sightDIDPrefix + base58.Encode(multicodec), nil
+}
+
+// DIDToPeerID derives the libp2p PeerID of the node owning the DID
+func DIDToPeerID(did string) (peer.ID, error) {
+	key, err := DIDPublicKey(did)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(key)
+}
+
+// PeerIDToDID recovers the DID from a PeerID that embeds its public key
+// (Ed25519 and secp256k1). RSA PeerIDs are hashes, so their DID cannot be
+// recovered from the PeerID alone.
+func PeerIDToDID(id peer.ID) (string, error) {
+	key, err := id.ExtractPublicKey()
+	if err != nil {
+		return "", err
+	}
+	return PubKeyToDID(key)
+}
+
+// peerDID returns the DID of a peer, taking its public key from the
+// peerstore when the PeerID does not embed it (RSA). The peerstore holds the
+// key of every peer a secure connection was made with, and of the authors of
+// signed pubsub messages.
+func peerDID(ps peerstore.Peerstore, id peer.ID) (string, error) {
+	key, err := id.ExtractPublicKey()
+	if err != nil && ps != nil {
+		if key = ps.PubKey(id); key != nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", fmt.Errorf("public key of %s is unknown", id)
+	}
+	return PubKeyToDID(key)
+}
+
+// peerDID returns the DID of a peer, see peerDID
+func (s *Libp2pNodeService) peerDID(id peer.ID) (string, error) {
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	var ps peerstore.Peerstore
+	if node != nil {
+		ps = node.Peerstore()
+	}
+	return peerDID(ps, id)
+}
+
+// ResolveDID returns the public key and PeerID for a DID
+func ResolveDID(did string) (*DIDInfo, error) {
+	key, err := DIDPublicKey(did)
+	if err != nil {
+		return nil, err
+	}
+	t, err := keyTypeOf(key)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DIDInfo{DID: did, KeyType: t.name, PublicKey: pub, PeerID: id.String()}, nil
+}
@@ -0,0 +1,184 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+)
+
+// didDocumentRecord is the record name a DID publishes its document under
+const didDocumentRecord = "did-document"
+
+// didDocumentDebounce coalesces address changes and new mesh peers into one
+// republish
+const didDocumentDebounce = 2 * time.Second
+
+// DIDDocument is a minimal DID document: the DID key and how to reach the
+// node holding it
+type DIDDocument struct {
+	Context            []string             `json:"@context"`
+	ID                 string               `json:"id"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod"`
+	Authentication     []string             `json:"authentication"`
+	Service            []DIDService         `json:"service"`
+	Updated            string               `json:"updated"`
+}
+
+// VerificationMethod is the public key of a DID
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// DIDService is a service endpoint of a DID document
+type DIDService struct {
+	ID              string      `json:"id"`
+	Type            string      `json:"type"`
+	ServiceEndpoint interface{} `json:"serviceEndpoint"`
+}
+
+// LibP2PEndpoint is the endpoint of the LibP2PNode service
+type LibP2PEndpoint struct {
+	PeerID     string   `json:"peerId"`
+	Multiaddrs []string `json:"multiaddrs"`
+}
+
+// buildDIDDocument describes did as reachable at the node's advertised addresses
+func buildDIDDocument(did string, addrs AddressReport) DIDDocument {
+	keyID := did + "#key-1"
+	// Ed25519 keeps its dedicated suite; other key types use the generic Multikey
+	keyType := "Multikey"
+	if key, err := DIDPublicKey(did); err == nil && key.Type() == crypto.Ed25519 {
+		keyType = "Ed25519VerificationKey2020"
+	}
+	return DIDDocument{
+		Context: []string{"https://www.w3.org/ns/did/v1"},
+		ID:      did,
+		VerificationMethod: []VerificationMethod{{
+			ID:         keyID,
+			Type:       keyType,
+			Controller: did,
+			// The DID suffix is already the base58btc multicodec key
+			PublicKeyMultibase: "z" + strings.TrimPrefix(did, sightDIDPrefix),
+		}},
+		Authentication: []string{keyID},
+		Service: []DIDService{
+			{
+				ID:              did + "#libp2p",
+				Type:            "LibP2PNode",
+				ServiceEndpoint: LibP2PEndpoint{PeerID: addrs.PeerID, Multiaddrs: addrs.Advertised},
+			},
+			{
+				ID:              did + "#inbox",
+				Type:            "SightInbox",
+				ServiceEndpoint: inboxTopic(did),
+			},
+		},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// PublishDIDDocument signs the current DID document as a record and replicates it
+func (s *Libp2pNodeService) PublishDIDDocument(ctx context.Context) (DIDDocument, error) {
+	s.mu.RLock()
+	did := s.did
+	s.mu.RUnlock()
+	if _, err := ParseSightDID(did); err != nil {
+		return DIDDocument{}, err
+	}
+	doc := buildDIDDocument(did, s.Addresses())
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return DIDDocument{}, err
+	}
+	if _, err := s.PutRecord(ctx, didDocumentRecord, value); err != nil {
+		return DIDDocument{}, err
+	}
+	return doc, nil
+}
+
+// ResolveDIDDocument looks up the DID document record of did on the network
+func (s *Libp2pNodeService) ResolveDIDDocument(ctx context.Context, did string) (DIDDocument, error) {
+	if _, err := ParseSightDID(did); err != nil {
+		return DIDDocument{}, err
+	}
+	r, err := s.GetRecord(ctx, did+"/"+didDocumentRecord)
+	if err != nil {
+		return DIDDocument{}, err
+	}
+	var doc DIDDocument
+	if err := json.Unmarshal(r.Value, &doc); err != nil {
+		return DIDDocument{}, fmt.Errorf("invalid DID document: %w", err)
+	}
+	// The record signature proves the DID wrote it, not that it describes itself
+	if doc.ID != did {
+		return DIDDocument{}, fmt.Errorf("DID document of %s is for %s", did, doc.ID)
+	}
+	return doc, nil
+}
+
+// publishDIDDocuments keeps the DID document current: it republishes when the
+// node's addresses change and when a peer joins the records mesh, so newly
+// connected nodes replicate it
+func (s *Libp2pNodeService) publishDIDDocuments(ctx context.Context, h hostlibp2p.Host) error {
+	if _, err := ParseSightDID(s.did); err != nil {
+		// Gateways have no DID key to publish under
+		return nil
+	}
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		return err
+	}
+	joins, err := s.recordsTopic.EventHandler()
+	if err != nil {
+		sub.Close()
+		return err
+	}
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		defer joins.Cancel()
+		for {
+			if _, err := joins.NextPeerEvent(ctx); err != nil {
+				return
+			}
+			notify()
+		}
+	}()
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.Out():
+				notify()
+			case <-changed:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(didDocumentDebounce):
+				}
+				if _, err := s.PublishDIDDocument(ctx); err != nil {
+					log.Printf("Error publishing DID document: %v", err)
+				}
+			}
+		}
+	}()
+	notify()
+	return nil
+}
@@ -0,0 +1,42 @@
+package sightnode
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/mr-tron/base58"
+)
+
+func TestRSADIDsEncodePKCS1(t *testing.T) {
+	_, pub, err := crypto.GenerateRSAKeyPair(2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did, err := PubKeyToDID(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := base58.Decode(strings.TrimPrefix(did, sightDIDPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte{0x85, 0x24}) {
+		t.Fatalf("DID %s does not start with the rsa-pub multicodec", did)
+	}
+	if _, err := x509.ParsePKCS1PublicKey(raw[2:]); err != nil {
+		t.Errorf("DID key is not PKCS#1: %v", err)
+	}
+
+	decoded, err := DIDPublicKey(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equals(pub) {
+		t.Error("DID decodes to another key")
+	}
+}
@@ -0,0 +1,120 @@
+package sightnode
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+const (
+	// maxDNSAddrDepth bounds nested /dnsaddr lookups (dnsaddr=/dnsaddr/...)
+	maxDNSAddrDepth = 4
+	// dnsSeedTimeout bounds one round of seed resolution
+	dnsSeedTimeout = 15 * time.Second
+)
+
+// dnsSeedAddrs turns BOOTSTRAP_DNS_SEEDS domains into /dnsaddr multiaddrs
+func dnsSeedAddrs(domains []string) []string {
+	var addrs []string
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "/") {
+			addrs = append(addrs, domain)
+			continue
+		}
+		addrs = append(addrs, "/dnsaddr/"+domain)
+	}
+	return addrs
+}
+
+// isDNSSeed reports whether a bootstrap entry is a /dnsaddr name without a
+// PeerID, whose peers are only known after resolving its _dnsaddr TXT records.
+// /dns4, /dns6 and /dnsaddr entries carrying /p2p/ are dialled directly; the
+// host resolves them on every dial.
+func isDNSSeed(maddr ma.Multiaddr) bool {
+	if len(maddr) == 0 || maddr[0].Protocol().Code != ma.P_DNSADDR {
+		return false
+	}
+	_, err := maddr.ValueForProtocol(ma.P_P2P)
+	return err != nil
+}
+
+func sameMultiaddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveDNSAddr expands a /dnsaddr multiaddr into dialable /p2p/ addresses,
+// following nested /dnsaddr records up to depth levels
+func resolveDNSAddr(ctx context.Context, maddr ma.Multiaddr, depth int) ([]ma.Multiaddr, error) {
+	resolved, err := madns.Resolve(ctx, maddr)
+	if err != nil {
+		return nil, err
+	}
+	var out []ma.Multiaddr
+	for _, addr := range resolved {
+		if len(addr) > 0 && addr[0].Protocol().Code == ma.P_DNSADDR && depth > 0 {
+			nested, err := resolveDNSAddr(ctx, addr, depth-1)
+			if err != nil {
+				log.Printf("Error resolving %s: %v", addr, err)
+				continue
+			}
+			out = append(out, nested...)
+			continue
+		}
+		if _, err := addr.ValueForProtocol(ma.P_P2P); err == nil {
+			out = append(out, addr)
+		}
+	}
+	return out, nil
+}
+
+// resolveSeeds re-resolves the DNS seeds. A failed lookup keeps the previously
+// resolved peers so a DNS outage does not drop working gateways.
+func (m *bootstrapManager) resolveSeeds(ctx context.Context) {
+	m.mu.Lock()
+	seeds := m.seeds
+	m.mu.Unlock()
+	if len(seeds) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsSeedTimeout)
+	defer cancel()
+	var addrs []ma.Multiaddr
+	failed := false
+	for _, seed := range seeds {
+		resolved, err := resolveDNSAddr(ctx, seed, maxDNSAddrDepth)
+		if err != nil {
+			log.Printf("Error resolving bootstrap seed %s: %v", seed, err)
+			failed = true
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+	if len(addrs) == 0 && failed {
+		return
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		log.Printf("Invalid bootstrap seed addrs: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	m.resolved = infos
+	m.mu.Unlock()
+	m.rebuild()
+	debugf("Resolved %d bootstrap peers from %d DNS seeds", len(infos), len(seeds))
+}
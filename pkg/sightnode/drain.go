@@ -0,0 +1,112 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// drainPollInterval is how often Drain checks whether the queues are empty
+const drainPollInterval = 100 * time.Millisecond
+
+var errDraining = errors.New("node is shutting down")
+
+// pendingOutbound is an outbox job persisted across a restart
+type pendingOutbound struct {
+	ID       string `json:"id"`
+	To       string `json:"to"`
+	Priority string `json:"priority"`
+	Data     []byte `json:"data"`
+}
+
+func pendingOutboxFile(cfg Config) string {
+	return cfg.IdentityDir + "/outbox-pending.json"
+}
+
+// Drain stops accepting new sends and waits until the outbox and the tunnel
+// forwarder are empty or ctx is done. Outbound messages still queued then are
+// persisted and sent after the next start; undelivered webhook forwards go to
+// the dead-letter queue.
+func (s *Libp2pNodeService) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.outbox.Pending() > 0 || s.forwarder.Pending() > 0 {
+		select {
+		case <-ctx.Done():
+			s.persistOutbox()
+			if n := s.forwarder.DeadLetterQueued(errDraining); n > 0 {
+				log.Printf("Moved %d undelivered forwards to the dead-letter queue", n)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// persistOutbox saves the jobs still queued in the outbox
+func (s *Libp2pNodeService) persistOutbox() {
+	jobs := s.outbox.Take()
+	if len(jobs) == 0 {
+		return
+	}
+	pending := make([]pendingOutbound, 0, len(jobs))
+	for _, job := range jobs {
+		pending = append(pending, pendingOutbound{ID: job.id, To: job.to, Priority: job.priority, Data: job.data})
+		if job.done != nil {
+			job.done <- errDraining
+		}
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("Error marshalling pending outbox: %v", err)
+		return
+	}
+	_ = os.MkdirAll(s.cfg.IdentityDir, 0700)
+	if err := os.WriteFile(pendingOutboxFile(s.cfg), data, 0600); err != nil {
+		log.Printf("Error writing pending outbox: %v", err)
+		return
+	}
+	log.Printf("Persisted %d unsent messages", len(pending))
+}
+
+// restoreOutbox queues the messages persisted by the last drain
+func (s *Libp2pNodeService) restoreOutbox(ctx context.Context) {
+	data, err := os.ReadFile(pendingOutboxFile(s.cfg))
+	if err != nil {
+		return
+	}
+	var pending []pendingOutbound
+	if err := json.Unmarshal(data, &pending); err != nil {
+		log.Printf("Error reading pending outbox: %v", err)
+		return
+	}
+	restored := 0
+	for _, p := range pending {
+		envelope, err := decodeEnvelope(p.Data)
+		if err != nil {
+			continue
+		}
+		// Ordered messages lose their ordering across restarts but are still retried
+		qos, _ := parseQoS(envelope["qos"])
+		if qos == qosOrdered {
+			qos = qosAtLeastOnce
+		}
+		job := outboundJob{ctx: ctx, id: p.ID, to: p.To, qos: qos, envelope: envelope, data: p.Data}
+		if err := s.outbox.Enqueue(p.Priority, job); err != nil {
+			log.Printf("Dropping persisted message %s: %v", p.ID, err)
+			continue
+		}
+		s.statuses.Set(p.ID, p.To, stateQueued, nil)
+		restored++
+	}
+	if err := os.Remove(pendingOutboxFile(s.cfg)); err != nil {
+		log.Printf("Error removing pending outbox: %v", err)
+	}
+	log.Printf("Restored %d messages queued before shutdown", restored)
+}
@@ -0,0 +1,201 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/protobuf/proto"
+
+	"sight-libp2p-node/api/sightpb"
+)
+
+// Wire formats for outgoing envelopes (WIRE_FORMAT)
+const (
+	wireJSON  = "json"
+	wireProto = "proto"
+)
+
+// protoEnvelopeV1 prefixes protobuf envelopes; JSON envelopes start with '{'
+const protoEnvelopeV1 byte = 0x01
+
+// envelopeProtoProtocol advertises that a node can decode protobuf envelopes
+const envelopeProtoProtocol = protocol.ID("/sight/envelope/proto/1.0.0")
+
+// Envelope fields carried natively by the protobuf format
+var protoEnvelopeFields = map[string]bool{
+	"id": true, "to": true, "expiresAt": true, "contentEncoding": true, "payload": true,
+}
+
+// envelopeType returns the message type of an envelope: its type field, or
+// for senders that predate it the type field of the payload
+func envelopeType(envelope map[string]interface{}) string {
+	if typ, ok := envelope["type"].(string); ok && typ != "" {
+		return typ
+	}
+	switch payload := envelope["payload"].(type) {
+	case map[string]interface{}:
+		typ, _ := payload["type"].(string)
+		return typ
+	case json.RawMessage:
+		var typed struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal(payload, &typed)
+		return typed.Type
+	}
+	return ""
+}
+
+// payloadJSON returns the payload of an envelope as JSON: the received bytes
+// verbatim while it has not been decoded, so upstream gets what was sent
+func payloadJSON(envelope map[string]interface{}) ([]byte, error) {
+	if raw, ok := envelope["payload"].(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(envelope["payload"])
+}
+
+// decodePayload replaces a received payload with its decoded form, for code
+// that inspects or edits it
+func decodePayload(envelope map[string]interface{}) error {
+	raw, ok := envelope["payload"].(json.RawMessage)
+	if !ok {
+		return nil
+	}
+	var payload interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	envelope["payload"] = payload
+	return nil
+}
+
+// advertiseWireFormats registers the envelope capability protocol on the host
+func advertiseWireFormats(h hostlibp2p.Host) {
+	h.SetStreamHandler(envelopeProtoProtocol, func(st network.Stream) { st.Reset() })
+}
+
+// directEnvelope returns the envelope to send to id over a direct stream:
+// protobuf when configured and id advertises support, the JSON encoding data
+// otherwise. Messages over pubsub and gateways stay JSON, as every node
+// relaying them validates them and older ones only decode JSON.
+func (s *Libp2pNodeService) directEnvelope(h hostlibp2p.Host, id peer.ID, envelope map[string]interface{}, data []byte) []byte {
+	if s.cfg.WireFormat != wireProto {
+		return data
+	}
+	if ok, _ := h.Peerstore().SupportsProtocols(id, envelopeProtoProtocol); len(ok) == 0 {
+		return data
+	}
+	encoded, err := encodeProtoEnvelope(envelope)
+	if err != nil {
+		debugf("Error encoding protobuf envelope, sending JSON: %v", err)
+		return data
+	}
+	return encoded
+}
+
+func encodeProtoEnvelope(msg map[string]interface{}) ([]byte, error) {
+	env := &sightpb.Envelope{Version: 1}
+	env.Id, _ = msg["id"].(string)
+	env.To, _ = msg["to"].(string)
+	env.ExpiresAt, _ = msg["expiresAt"].(string)
+	env.ContentEncoding, _ = msg["contentEncoding"].(string)
+
+	if compressed, ok := msg["payload"].([]byte); ok && env.ContentEncoding != "" {
+		env.Payload = compressed
+	} else {
+		payload, err := json.Marshal(msg["payload"])
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = payload
+	}
+
+	extra := map[string]interface{}{}
+	for k, v := range msg {
+		if !protoEnvelopeFields[k] {
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		data, err := json.Marshal(extra)
+		if err != nil {
+			return nil, err
+		}
+		env.Extra = data
+	}
+
+	data, err := proto.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{protoEnvelopeV1}, data...), nil
+}
+
+// decodeEnvelope parses a JSON or protobuf envelope into its map form. The
+// payload is kept as a json.RawMessage rather than decoded: most messages are
+// only forwarded, and building the value tree of a large payload only to
+// encode it again dominates the cost of the receive path.
+func decodeEnvelope(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty message")
+	}
+	if data[0] != protoEnvelopeV1 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		envelope := make(map[string]interface{}, len(fields))
+		for key, raw := range fields {
+			if key == "payload" {
+				envelope[key] = raw
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			envelope[key] = value
+		}
+		return envelope, nil
+	}
+
+	var env sightpb.Envelope
+	if err := proto.Unmarshal(data[1:], &env); err != nil {
+		return nil, err
+	}
+	if env.Version != 1 {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	envelope := map[string]interface{}{}
+	if len(env.Extra) > 0 {
+		if err := json.Unmarshal(env.Extra, &envelope); err != nil {
+			return nil, err
+		}
+	}
+	setIfNotEmpty := func(key, value string) {
+		if value != "" {
+			envelope[key] = value
+		}
+	}
+	setIfNotEmpty("id", env.Id)
+	setIfNotEmpty("to", env.To)
+	setIfNotEmpty("expiresAt", env.ExpiresAt)
+	setIfNotEmpty("contentEncoding", env.ContentEncoding)
+	if len(env.Payload) > 0 {
+		if env.ContentEncoding != "" {
+			envelope["payload"] = env.Payload
+		} else {
+			if !json.Valid(env.Payload) {
+				return nil, errors.New("payload is not valid JSON")
+			}
+			envelope["payload"] = json.RawMessage(env.Payload)
+		}
+	}
+	return envelope, nil
+}
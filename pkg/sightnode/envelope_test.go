@@ -0,0 +1,172 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// testEnvelope returns a JSON envelope whose payload holds n readings, the
+// shape of the telemetry gateways forward at high rates
+func testEnvelope(tb testing.TB, n int) []byte {
+	tb.Helper()
+	readings := make([]map[string]interface{}, n)
+	for i := range readings {
+		readings[i] = map[string]interface{}{"sensor": fmt.Sprintf("s-%d", i), "value": float64(i) / 3, "ok": true}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"id":        "0b7f9c1e-4d1a-4a57-9f57-5a3c8a0f2b11",
+		"to":        "did:sight:hoster:6MkpTHR8VNsBxYAAWHut2Geadd9jSwuBV8xRoAnwWsdvktH",
+		"expiresAt": "2030-01-01T00:00:00Z",
+		"type":      "telemetry",
+		"payload":   map[string]interface{}{"type": "telemetry", "readings": readings},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+func testPeerID(tb testing.TB) peer.ID {
+	tb.Helper()
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return id
+}
+
+func TestDecodeEnvelopeKeepsPayloadVerbatim(t *testing.T) {
+	data := testEnvelope(t, 3)
+	envelope, err := decodeEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := payloadJSON(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, fields["payload"]) {
+		t.Fatalf("payload was re-encoded:\n got %s\nwant %s", raw, fields["payload"])
+	}
+	if typ := envelopeType(envelope); typ != "telemetry" {
+		t.Fatalf("envelope type %q, want telemetry", typ)
+	}
+}
+
+func TestProtoEnvelopeRoundTrip(t *testing.T) {
+	envelope, err := decodeEnvelope(testEnvelope(t, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeProtoEnvelope(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "to", "expiresAt", "type"} {
+		if decoded[key] != envelope[key] {
+			t.Errorf("%s: got %v, want %v", key, decoded[key], envelope[key])
+		}
+	}
+	want, _ := payloadJSON(envelope)
+	got, _ := payloadJSON(decoded)
+	if !bytes.Equal(got, want) {
+		t.Errorf("payload: got %s, want %s", got, want)
+	}
+}
+
+// BenchmarkValidateMessage measures the pubsub validator every gateway runs
+// on each message before forwarding it through the mesh
+func BenchmarkValidateMessage(b *testing.B) {
+	cfg := Config{MaxMessageSize: 1 << 20, DataDir: b.TempDir()}
+	s := &Libp2pNodeService{cfg: cfg, revocations: newRevocationList(cfg)}
+	from := testPeerID(b)
+	for _, n := range []int{1, 100} {
+		data := testEnvelope(b, n)
+		msg := &pubsub.Message{Message: &pb.Message{From: []byte(from), Data: data, Signature: []byte{1}}}
+		b.Run(fmt.Sprintf("readings=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if s.validateMessage(context.Background(), from, msg) != pubsub.ValidationAccept {
+					b.Fatal("message rejected")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkForwardEnvelope compares forwarding a received envelope with its
+// payload kept raw, as decodeEnvelope does, against decoding the payload and
+// encoding it again
+func BenchmarkForwardEnvelope(b *testing.B) {
+	for _, n := range []int{1, 100} {
+		data := testEnvelope(b, n)
+		for _, mode := range []string{"raw", "decoded"} {
+			b.Run(fmt.Sprintf("readings=%d/%s", n, mode), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					envelope, err := decodeEnvelope(data)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if mode == "decoded" {
+						if err := decodePayload(envelope); err != nil {
+							b.Fatal(err)
+						}
+					}
+					if _, err := json.Marshal(envelope); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkDecodeEnvelope compares decoding the JSON and protobuf wire formats
+func BenchmarkDecodeEnvelope(b *testing.B) {
+	envelope, err := decodeEnvelope(testEnvelope(b, 100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	protoData, err := encodeProtoEnvelope(envelope)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, format := range []struct {
+		name string
+		data []byte
+	}{{wireJSON, testEnvelope(b, 100)}, {wireProto, protoData}} {
+		b.Run(format.name, func(b *testing.B) {
+			b.SetBytes(int64(len(format.data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeEnvelope(format.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
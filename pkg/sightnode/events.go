@@ -0,0 +1,104 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+)
+
+// eventsTopic is the stream topic network events are published on
+const eventsTopic = "events"
+
+// Network event types
+const (
+	eventPeerConnected       = "peer.connected"
+	eventPeerDisconnected    = "peer.disconnected"
+	eventReachabilityChanged = "reachability.changed"
+	eventMessageGap          = "message.gap"
+)
+
+// NetworkEvent describes a topology change of the host or lost messages
+type NetworkEvent struct {
+	Type         string `json:"type"`
+	Peer         string `json:"peer,omitempty"`
+	DID          string `json:"did,omitempty"`
+	Reachability string `json:"reachability,omitempty"`
+	FirstSeq     uint64 `json:"firstSeq,omitempty"`
+	LastSeq      uint64 `json:"lastSeq,omitempty"`
+	Missing      int    `json:"missing,omitempty"`
+	Peers        int    `json:"peers,omitempty"`
+	Detail       string `json:"detail,omitempty"`
+	Time         string `json:"time"`
+}
+
+// watchNetworkEvents forwards connectedness and reachability changes to the
+// events webhook, the stream when enabled and local subscribers until ctx is done
+func (s *Libp2pNodeService) watchNetworkEvents(ctx context.Context, h hostlibp2p.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerConnectednessChanged),
+		new(event.EvtLocalReachabilityChanged),
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				if evt, ok := networkEventFrom(h.Peerstore(), e); ok {
+					s.emitNetworkEvent(h.ID(), evt)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func networkEventFrom(ps peerstore.Peerstore, e interface{}) (NetworkEvent, bool) {
+	evt := NetworkEvent{Time: time.Now().Format(time.RFC3339)}
+	switch e := e.(type) {
+	case event.EvtPeerConnectednessChanged:
+		switch e.Connectedness {
+		case network.Connected:
+			evt.Type = eventPeerConnected
+		case network.NotConnected:
+			evt.Type = eventPeerDisconnected
+		default:
+			return evt, false
+		}
+		evt.Peer = e.Peer.String()
+		evt.DID, _ = peerDID(ps, e.Peer)
+	case event.EvtLocalReachabilityChanged:
+		evt.Type = eventReachabilityChanged
+		evt.Reachability = e.Reachability.String()
+	default:
+		return evt, false
+	}
+	return evt, true
+}
+
+func (s *Libp2pNodeService) emitNetworkEvent(self peer.ID, evt NetworkEvent) {
+	debugf("Network event: %s %s", evt.Type, evt.Peer)
+	s.streams.Publish(StreamMessage{Topic: eventsTopic, Type: evt.Type, From: self.String(), Payload: evt}, !s.cfg.EventsStream)
+	if s.cfg.EventsWebhook != "" {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			log.Printf("Error marshalling network event: %v", err)
+			return
+		}
+		s.forwarder.Enqueue(forwardJob{URL: s.cfg.EventsWebhook, Body: data})
+	}
+}
@@ -0,0 +1,19 @@
+package sightnode
+
+import (
+	"time"
+)
+
+// messageExpired reports whether an envelope's optional expiresAt (RFC3339)
+// is in the past. Envelopes without a valid expiresAt never expire.
+func messageExpired(msg map[string]interface{}) bool {
+	value, ok := msg["expiresAt"].(string)
+	if !ok {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false
+	}
+	return time.Now().After(expiresAt)
+}
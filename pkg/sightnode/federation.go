@@ -0,0 +1,299 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// federationProtocol lets a gateway fetch the hosters homed on another
+// gateway of FEDERATION_PEERS. The requester sends nothing; the answer is a
+// JSON array of RegistryEntry.
+const federationProtocol protocol.ID = "/sight/federation/1.0.0"
+
+// federationTag protects the federated gateways in the connection manager
+const federationTag = "federation"
+
+var (
+	federationRoutes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_federation_routes",
+		Help: "DIDs homed on federated gateways",
+	})
+	federationSyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_federation_syncs_total",
+		Help: "Registry exchanges with federated gateways, per result",
+	}, []string{"result"})
+)
+
+// federation joins gateways serving disjoint sets of hosters into one
+// network. Gateways stay connected to each other and exchange their
+// registries, so DIDs homed elsewhere resolve, and relay the inbox topics of
+// every DID in the federation: gossipsub then carries a message from its
+// sender's gateway to the recipient's, with the sender's signature intact.
+type federation struct {
+	host     hostlibp2p.Host
+	cfg      Config
+	gater    *PeerGater
+	registry *didRegistry
+	join     func(string) (Topic, error)
+	peers    []peer.AddrInfo
+
+	mu sync.RWMutex
+	// remote are the entries of each federated gateway at its last sync
+	remote map[peer.ID][]RegistryEntry
+	// relays are the inbox topics relayed, by DID
+	relays map[string]pubsub.RelayCancelFunc
+}
+
+// checkFederation validates the federation settings
+func checkFederation(cfg Config) error {
+	if len(cfg.FederationPeers) == 0 {
+		return nil
+	}
+	if cfg.FederationSyncInterval <= 0 {
+		return fmt.Errorf("FEDERATION_SYNC_INTERVAL must be positive, got %s", cfg.FederationSyncInterval)
+	}
+	for _, addr := range cfg.FederationPeers {
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			return fmt.Errorf("FEDERATION_PEERS entry %q must be a multiaddr ending with /p2p/<peer id>: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func newFederation(h hostlibp2p.Host, cfg Config, gater *PeerGater, registry *didRegistry, join func(string) (Topic, error)) *federation {
+	f := &federation{
+		host:     h,
+		cfg:      cfg,
+		gater:    gater,
+		registry: registry,
+		join:     join,
+		remote:   make(map[peer.ID][]RegistryEntry),
+		relays:   make(map[string]pubsub.RelayCancelFunc),
+	}
+	f.peers, _ = parseBootstrapAddrs(cfg.FederationPeers)
+	f.allowPeers()
+	for _, info := range f.peers {
+		h.ConnManager().Protect(info.ID, federationTag)
+	}
+	return f
+}
+
+// allowPeers keeps the federated gateways reachable even with a restrictive
+// allowlist, also after the gater was reloaded from config
+func (f *federation) allowPeers() {
+	for _, info := range f.peers {
+		f.gater.Allow(info.ID)
+	}
+}
+
+func (f *federation) isPeer(id peer.ID) bool {
+	for _, info := range f.peers {
+		if info.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Start syncs with the federated gateways now and then at every
+// FEDERATION_SYNC_INTERVAL
+func (f *federation) Start(ctx context.Context) {
+	f.host.SetStreamHandler(federationProtocol, f.handleStream)
+	go func() {
+		ticker := time.NewTicker(f.cfg.FederationSyncInterval)
+		defer ticker.Stop()
+		for {
+			f.syncAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// homed returns the hosters connected to this gateway, the ones it exports
+// to the federation
+func (f *federation) homed() []RegistryEntry {
+	entries := []RegistryEntry{}
+	for _, entry := range f.registry.List() {
+		id, err := peer.Decode(entry.PeerID)
+		if err != nil || !entry.Connected || f.isPeer(id) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// handleStream answers a federated gateway with the hosters homed here
+func (f *federation) handleStream(st network.Stream) {
+	defer st.Close()
+	if !f.isPeer(st.Conn().RemotePeer()) {
+		debugf("Refusing federation request from %s, not in FEDERATION_PEERS", st.Conn().RemotePeer())
+		st.Reset()
+		return
+	}
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	json.NewEncoder(st).Encode(f.homed())
+}
+
+func (f *federation) syncAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, info := range f.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entries, err := f.fetch(ctx, info)
+			f.mu.Lock()
+			if err != nil {
+				// Routes through an unreachable gateway are withdrawn
+				delete(f.remote, info.ID)
+			} else {
+				f.remote[info.ID] = entries
+			}
+			f.mu.Unlock()
+			if err != nil {
+				federationSyncs.WithLabelValues("error").Inc()
+				log.Printf("Federation sync with gateway %s failed: %v", info.ID, err)
+				return
+			}
+			federationSyncs.WithLabelValues("ok").Inc()
+		}()
+	}
+	wg.Wait()
+	f.updateRelays()
+}
+
+// fetch connects to a federated gateway if needed and reads its hosters
+func (f *federation) fetch(ctx context.Context, info peer.AddrInfo) ([]RegistryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.DialTimeout)
+	defer cancel()
+	if err := f.host.Connect(ctx, info); err != nil {
+		return nil, err
+	}
+	st, err := f.host.NewStream(ctx, info.ID, federationProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+	st.CloseWrite()
+	var entries []RegistryEntry
+	if err := json.NewDecoder(st).Decode(&entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Gateway = info.ID.String()
+		entries[i].Connected = false
+	}
+	return entries, nil
+}
+
+// updateRelays relays the inbox topics of the hosters homed on the federated
+// gateways, and stops relaying those of hosters gone. The registry relays the
+// ones homed here.
+func (f *federation) updateRelays() {
+	wanted := make(map[string]bool)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := 0
+	for _, entries := range f.remote {
+		routes += len(entries)
+		for _, entry := range entries {
+			wanted[entry.DID] = true
+		}
+	}
+	federationRoutes.Set(float64(routes))
+	for did, cancel := range f.relays {
+		if !wanted[did] {
+			cancel()
+			delete(f.relays, did)
+		}
+	}
+	for did := range wanted {
+		if _, ok := f.relays[did]; ok {
+			continue
+		}
+		topic, err := f.join(inboxTopic(did))
+		var cancel pubsub.RelayCancelFunc
+		if err == nil {
+			cancel, err = topic.Relay()
+		}
+		if err != nil {
+			log.Printf("Error relaying inbox of %s: %v", did, err)
+			continue
+		}
+		f.relays[did] = cancel
+	}
+}
+
+// Get returns the entry of a DID homed on a federated gateway
+func (f *federation) Get(did string) (RegistryEntry, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, entries := range f.remote {
+		for _, entry := range entries {
+			if entry.DID == did {
+				return entry, true
+			}
+		}
+	}
+	return RegistryEntry{}, false
+}
+
+// List returns the entries of the federated gateways sorted by DID
+func (f *federation) List() []RegistryEntry {
+	f.mu.RLock()
+	list := []RegistryEntry{}
+	for _, entries := range f.remote {
+		list = append(list, entries...)
+	}
+	f.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].DID < list[j].DID })
+	return list
+}
+
+// lookupRoute returns the registry entry of a DID homed on this gateway or,
+// failing that, on a federated one or as known to its owner in the cluster
+func (s *Libp2pNodeService) lookupRoute(ctx context.Context, did string) (RegistryEntry, bool) {
+	s.mu.RLock()
+	registry, fed, cl := s.registry, s.federation, s.cluster
+	s.mu.RUnlock()
+	if registry == nil {
+		return RegistryEntry{}, false
+	}
+	if entry, ok := registry.Get(did); ok {
+		return entry, true
+	}
+	if fed != nil {
+		if entry, ok := fed.Get(did); ok {
+			return entry, true
+		}
+	}
+	if cl != nil {
+		entry, err := cl.Lookup(ctx, did)
+		if err != nil {
+			debugf("Cluster lookup of %s failed: %v", did, err)
+			return RegistryEntry{}, false
+		}
+		return entry, true
+	}
+	return RegistryEntry{}, false
+}
@@ -0,0 +1,442 @@
+package sightnode
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// fileProtocol transfers one file per stream: a JSON header, the receiver's
+// acceptance, the raw content in chunks, then the receiver's verdict
+const fileProtocol = protocol.ID("/sight/file/1.0.0")
+
+const fileChunkSize = 64 << 10
+
+// maxFileTransfers bounds the transfers kept for polling; the oldest finished
+// ones are forgotten first
+const maxFileTransfers = 1000
+
+// File transfer states
+const (
+	transferPending      = "pending"
+	transferTransferring = "transferring"
+	transferDone         = "done"
+	transferFailed       = "failed"
+)
+
+type fileHeader struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type fileReply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// FileTransfer is the progress of a file being sent or received
+type FileTransfer struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+	Peer      string `json:"peer"`
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size"`
+	Bytes     int64  `json:"bytes"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	StartedAt string `json:"startedAt"`
+}
+
+// fileTransfers tracks the latest transfers since the node came up
+type fileTransfers struct {
+	mu        sync.Mutex
+	transfers map[string]*FileTransfer
+	// order holds the transfer IDs oldest first
+	order []string
+	// reserved counts the bytes of the files being received
+	reserved int64
+}
+
+func newFileTransfers() *fileTransfers {
+	return &fileTransfers{transfers: make(map[string]*FileTransfer)}
+}
+
+func (t *fileTransfers) add(ft *FileTransfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transfers[ft.ID] = ft
+	t.order = append(t.order, ft.ID)
+	for i := 0; len(t.transfers) > maxFileTransfers && i < len(t.order); {
+		old := t.transfers[t.order[i]]
+		if old.Status == transferDone || old.Status == transferFailed {
+			delete(t.transfers, old.ID)
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// reserve claims size bytes of FILE_RECEIVE_QUOTA, counting the files
+// already in dir and the transfers in flight. The returned func releases
+// the claim once the file is stored or discarded.
+func (t *fileTransfers) reserve(dir string, size, quota int64) (func(), error) {
+	used, err := dirUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if used+t.reserved+size > quota {
+		return nil, fmt.Errorf("receive quota of %d bytes exceeded", quota)
+	}
+	t.reserved += size
+	return func() {
+		t.mu.Lock()
+		t.reserved -= size
+		t.mu.Unlock()
+	}, nil
+}
+
+// dirUsage sums the sizes of the regular files in dir
+func dirUsage(dir string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+func (t *fileTransfers) update(id string, fn func(ft *FileTransfer)) {
+	t.mu.Lock()
+	if ft, ok := t.transfers[id]; ok {
+		fn(ft)
+	}
+	t.mu.Unlock()
+}
+
+func (t *fileTransfers) finish(id string, err error) {
+	t.update(id, func(ft *FileTransfer) {
+		if err != nil {
+			ft.Status = transferFailed
+			ft.Error = err.Error()
+			return
+		}
+		ft.Status = transferDone
+	})
+}
+
+// Get returns a copy of a transfer
+func (t *fileTransfers) Get(id string) (FileTransfer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ft, ok := t.transfers[id]
+	if !ok {
+		return FileTransfer{}, false
+	}
+	return *ft, true
+}
+
+// List returns copies of all transfers
+func (t *fileTransfers) List() []FileTransfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]FileTransfer, 0, len(t.transfers))
+	for _, ft := range t.transfers {
+		list = append(list, *ft)
+	}
+	return list
+}
+
+// progressWriter records the bytes copied so far on the transfer
+type progressWriter struct {
+	transfers *fileTransfers
+	id        string
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	p.transfers.update(p.id, func(ft *FileTransfer) { ft.Bytes += int64(len(b)) })
+	return len(b), nil
+}
+
+// sendablePath resolves a path, relative to FILE_SEND_DIR or absolute,
+// following symlinks, and checks that it stays inside FILE_SEND_DIR
+func (s *Libp2pNodeService) sendablePath(path string) (string, error) {
+	if s.cfg.FileSendDir == "" {
+		return "", errors.New("file sending is disabled (FILE_SEND_DIR is not set)")
+	}
+	dir, err := filepath.Abs(s.cfg.FileSendDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !inDir(dir, resolved) {
+		return "", fmt.Errorf("%s is outside FILE_SEND_DIR", path)
+	}
+	return resolved, nil
+}
+
+// inDir reports whether path is dir or lies below it
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// SendFile starts sending a file of FILE_SEND_DIR to the node owning a DID
+// and returns the transfer to poll for progress
+func (s *Libp2pNodeService) SendFile(to, path string) (FileTransfer, error) {
+	id, err := DIDToPeerID(s.resolveRotatedDID(to))
+	if err != nil {
+		return FileTransfer{}, err
+	}
+	path, err = s.sendablePath(path)
+	if err != nil {
+		return FileTransfer{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return FileTransfer{}, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return FileTransfer{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	ft := &FileTransfer{
+		ID:        uuid.NewString(),
+		Direction: "send",
+		Peer:      id.String(),
+		Name:      filepath.Base(path),
+		Path:      path,
+		Size:      info.Size(),
+		Status:    transferPending,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+	s.files.add(ft)
+	go func() {
+		defer f.Close()
+		err := s.sendFile(ft.ID, to, f, fileHeader{ID: ft.ID, Name: ft.Name, Size: ft.Size})
+		if err != nil {
+			log.Printf("File transfer %s to %s failed: %v", ft.ID, to, err)
+		}
+		s.files.finish(ft.ID, err)
+	}()
+	return *ft, nil
+}
+
+func (s *Libp2pNodeService) sendFile(transferID, to string, f *os.File, header fileHeader) error {
+	// Hash first so the receiver can verify the content
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	header.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	id, err := DIDToPeerID(s.resolveRotatedDID(to))
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RPCTimeout)
+	st, err := node.NewStream(ctx, id, fileProtocol)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	dec := json.NewDecoder(st)
+	if err := json.NewEncoder(st).Encode(header); err != nil {
+		st.Reset()
+		return err
+	}
+	var reply fileReply
+	if err := dec.Decode(&reply); err != nil {
+		st.Reset()
+		return err
+	}
+	if !reply.OK {
+		return fmt.Errorf("rejected by peer: %s", reply.Error)
+	}
+
+	s.files.update(transferID, func(ft *FileTransfer) { ft.Status = transferTransferring })
+	buf := make([]byte, fileChunkSize)
+	dst := io.MultiWriter(st, progressWriter{s.files, transferID})
+	if _, err := io.CopyBuffer(dst, f, buf); err != nil {
+		st.Reset()
+		return err
+	}
+	if err := st.CloseWrite(); err != nil {
+		st.Reset()
+		return err
+	}
+	if err := dec.Decode(&reply); err != nil {
+		return err
+	}
+	if !reply.OK {
+		return fmt.Errorf("peer failed to store file: %s", reply.Error)
+	}
+	return nil
+}
+
+// fileSenderAllowed reports whether FILE_RECEIVE_PEERS lists a peer
+func (s *Libp2pNodeService) fileSenderAllowed(id peer.ID) bool {
+	for _, entry := range s.cfg.FileReceivePeers {
+		if allowed, err := parsePeerOrDID(entry); err == nil && allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFileStream receives a file into FILE_RECEIVE_DIR
+func (s *Libp2pNodeService) handleFileStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(s.cfg.FileReceiveTimeout))
+	remote := st.Conn().RemotePeer()
+	enc := json.NewEncoder(st)
+	dec := json.NewDecoder(st)
+
+	var header fileHeader
+	if err := dec.Decode(&header); err != nil {
+		st.Reset()
+		return
+	}
+	name := filepath.Base(header.Name)
+	switch {
+	case s.cfg.FileReceiveDir == "":
+		enc.Encode(fileReply{Error: "file transfer is disabled"})
+		return
+	case !s.fileSenderAllowed(remote):
+		enc.Encode(fileReply{Error: "sender is not allowed to send files"})
+		return
+	case name == "." || name == ".." || name == string(filepath.Separator):
+		enc.Encode(fileReply{Error: "invalid file name"})
+		return
+	case header.Size < 0 || header.Size > s.cfg.FileMaxSize:
+		enc.Encode(fileReply{Error: fmt.Sprintf("file exceeds %d bytes", s.cfg.FileMaxSize)})
+		return
+	}
+	release, err := s.files.reserve(s.cfg.FileReceiveDir, header.Size, s.cfg.FileReceiveQuota)
+	if err != nil {
+		enc.Encode(fileReply{Error: err.Error()})
+		return
+	}
+	defer release()
+
+	// The transfer ID ends up in file names, so it is never the peer's
+	ft := &FileTransfer{
+		ID:        uuid.NewString(),
+		Direction: "receive",
+		Peer:      remote.String(),
+		Name:      name,
+		Size:      header.Size,
+		Status:    transferTransferring,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+	s.files.add(ft)
+
+	// The encoder ends the header with a newline, which is not content
+	content := bufio.NewReader(io.MultiReader(dec.Buffered(), st))
+	if b, err := content.ReadByte(); err == nil && b != '\n' {
+		content.UnreadByte()
+	}
+	path, err := s.receiveFile(ft.ID, content, header, enc)
+	if err != nil {
+		log.Printf("File transfer %s from %s failed: %v", ft.ID, remote, err)
+		enc.Encode(fileReply{Error: err.Error()})
+	} else {
+		s.files.update(ft.ID, func(ft *FileTransfer) { ft.Path = path })
+		enc.Encode(fileReply{OK: true})
+	}
+	s.files.finish(ft.ID, err)
+}
+
+func (s *Libp2pNodeService) receiveFile(transferID string, r io.Reader, header fileHeader, enc *json.Encoder) (string, error) {
+	if err := os.MkdirAll(s.cfg.FileReceiveDir, 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.cfg.FileReceiveDir, ".incoming-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := enc.Encode(fileReply{OK: true}); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	dst := io.MultiWriter(tmp, hash, progressWriter{s.files, transferID})
+	n, err := io.CopyBuffer(dst, io.LimitReader(r, header.Size+1), make([]byte, fileChunkSize))
+	if err != nil {
+		return "", err
+	}
+	if n != header.Size {
+		return "", fmt.Errorf("received %d of %d bytes", n, header.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != header.SHA256 {
+		return "", errors.New("checksum mismatch")
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.cfg.FileReceiveDir, filepath.Base(header.Name))
+	if _, err := os.Stat(path); err == nil {
+		path = filepath.Join(s.cfg.FileReceiveDir, transferID+"-"+filepath.Base(header.Name))
+	}
+	if !inDir(filepath.Clean(s.cfg.FileReceiveDir), path) {
+		return "", fmt.Errorf("%s is outside FILE_RECEIVE_DIR", path)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,105 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestReceivedFilesStayInReceiveDir(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	sender, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "a", "b", "files")
+	s := &Libp2pNodeService{
+		cfg: Config{
+			FileReceiveDir:     dir,
+			FileReceivePeers:   []string{sender.ID().String()},
+			FileMaxSize:        1 << 20,
+			FileReceiveQuota:   1 << 20,
+			FileReceiveTimeout: 10 * time.Second,
+		},
+		files: newFileTransfers(),
+	}
+	receiver.SetStreamHandler(fileProtocol, s.handleFileStream)
+
+	send := func(id, name, content string) fileReply {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		st, err := sender.NewStream(ctx, receiver.ID(), fileProtocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		sum := sha256.Sum256([]byte(content))
+		enc, dec := json.NewEncoder(st), json.NewDecoder(st)
+		if err := enc.Encode(fileHeader{ID: id, Name: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}); err != nil {
+			t.Fatal(err)
+		}
+		var reply fileReply
+		if err := dec.Decode(&reply); err != nil || !reply.OK {
+			return reply
+		}
+		st.Write([]byte(content))
+		st.CloseWrite()
+		reply = fileReply{}
+		if err := dec.Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	for _, tc := range []struct {
+		name   string
+		id     string
+		header string
+	}{
+		{"new file", "3f1c0a52-0000-4000-8000-000000000000", "report.txt"},
+		// The name exists now, so the transfer ID prefixes it
+		{"existing file", "../../../escape", "report.txt"},
+		{"traversal in name", "../../x", "../../../report.txt"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if reply := send(tc.id, tc.header, "data of "+tc.name); !reply.OK {
+				t.Fatalf("transfer failed: %s", reply.Error)
+			}
+		})
+	}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			t.Errorf("received file written outside FILE_RECEIVE_DIR: %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("%d files received, want 3", len(entries))
+	}
+}
@@ -0,0 +1,450 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// forwardJob is one delivery of a payload to one webhook URL
+type forwardJob struct {
+	MessageID string
+	RequestID string
+	// FromDID is the sender of the forwarded message, when known
+	FromDID string
+	URL     string
+	Body    []byte
+	Trace   propagation.MapCarrier
+	// OnDelivered runs after a successful post, e.g. to send a receipt
+	OnDelivered func()
+}
+
+// DeadLetter is a delivery that failed after all retries
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	MessageID string          `json:"messageId,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	FromDID   string          `json:"fromDid,omitempty"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Attempts  int             `json:"attempts"`
+	FailedAt  string          `json:"failedAt"`
+	// Parked deliveries were held back by an open circuit breaker and are
+	// replayed automatically once the webhook recovers
+	Parked bool `json:"parked,omitempty"`
+}
+
+// tunnelForwarder posts incoming payloads to the webhooks from a bounded
+// buffer, retrying with exponential backoff and parking failures in a
+// persistent dead-letter queue. When the buffer is full, deliveries are
+// dead-lettered, dropped or spooled to disk per TUNNEL_OVERFLOW.
+type tunnelForwarder struct {
+	cfg    Config
+	client TunnelClient
+	queue  chan forwardJob
+	// sink receives the deliveries targeted at SINK_TYPE, nil without one
+	sink messageSink
+	// spool holds the overflow with TUNNEL_OVERFLOW=spool, nil otherwise
+	spool *tunnelSpool
+	// pending counts queued and in-flight deliveries, not spooled ones
+	pending atomic.Int64
+	// delivered counts successful posts
+	delivered atomic.Uint64
+	// dropped counts deliveries shed because the buffer was full
+	dropped atomic.Uint64
+
+	mu       sync.Mutex
+	dlq      []DeadLetter
+	path     string
+	breakers map[string]*circuitBreaker
+	// ctx is the node's root context; deliveries in flight when it is
+	// cancelled are dead-lettered
+	ctx context.Context
+}
+
+func newTunnelForwarder(cfg Config, client TunnelClient, sink messageSink, spool *tunnelSpool) *tunnelForwarder {
+	f := &tunnelForwarder{
+		cfg:      cfg,
+		client:   client,
+		sink:     sink,
+		spool:    spool,
+		queue:    make(chan forwardJob, cfg.TunnelQueueSize),
+		path:     cfg.IdentityDir + "/dead-letters.json",
+		breakers: make(map[string]*circuitBreaker),
+		ctx:      context.Background(),
+	}
+	f.load()
+	for i := 0; i < cfg.TunnelWorkers; i++ {
+		go f.worker()
+	}
+	if spool != nil {
+		go f.unspool()
+	}
+	return f
+}
+
+// bind ties deliveries to the node's root context
+func (f *tunnelForwarder) bind(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ctx = ctx
+}
+
+func (f *tunnelForwarder) context() context.Context {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ctx
+}
+
+// breaker returns the circuit breaker of a webhook URL
+func (f *tunnelForwarder) breaker(url string) *circuitBreaker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.breakers[url]
+	if !ok {
+		b = newCircuitBreaker(url, f.cfg.BreakerThreshold, f.cfg.BreakerOpenTimeout)
+		f.breakers[url] = b
+	}
+	return b
+}
+
+// Enqueue buffers a delivery without blocking; when the buffer is full it
+// overflows per TUNNEL_OVERFLOW
+func (f *tunnelForwarder) Enqueue(job forwardJob) {
+	// Behind a non-empty spool, new deliveries queue up on disk too so they
+	// stay in order
+	if f.spool != nil && f.spool.Len() > 0 {
+		f.overflow(job)
+		return
+	}
+	f.pending.Add(1)
+	select {
+	case f.queue <- job:
+		tunnelQueueDepth.WithLabelValues("memory").Set(float64(len(f.queue)))
+	default:
+		f.pending.Add(-1)
+		f.overflow(job)
+	}
+}
+
+func (f *tunnelForwarder) worker() {
+	for job := range f.queue {
+		tunnelQueueDepth.WithLabelValues("memory").Set(float64(len(f.queue)))
+		f.deliver(job)
+		f.pending.Add(-1)
+	}
+}
+
+// Pending returns the number of queued, spooled and in-flight deliveries
+func (f *tunnelForwarder) Pending() int {
+	return int(f.pending.Load()) + f.Spooled()
+}
+
+// Spooled returns the number of deliveries spooled to disk
+func (f *tunnelForwarder) Spooled() int {
+	if f.spool == nil {
+		return 0
+	}
+	return f.spool.Len()
+}
+
+// DeadLetterQueued moves every delivery still waiting in the buffer to the
+// DLQ. Spooled deliveries stay on disk for the next start.
+func (f *tunnelForwarder) DeadLetterQueued(cause error) int {
+	n := 0
+	for {
+		select {
+		case job := <-f.queue:
+			f.pending.Add(-1)
+			f.deadLetter(job, 0, cause)
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// deliver posts the job, retrying network errors and 5xx responses. While
+// the webhook circuit is open the job is parked instead.
+func (f *tunnelForwarder) deliver(job forwardJob) {
+	ctx := f.context()
+	b := f.breaker(job.URL)
+	var err error
+	attempts := 0
+	for attempts <= f.cfg.TunnelRetryMax {
+		if !b.Allow() {
+			f.park(job, attempts, err)
+			return
+		}
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				f.deadLetter(job, attempts, ctx.Err())
+				return
+			case <-time.After(expBackoff(attempts, f.cfg.TunnelBackoffMin, f.cfg.TunnelBackoffMax)):
+			}
+		}
+		attempts++
+		if err = f.post(ctx, job); err == nil {
+			f.delivered.Add(1)
+			if b.Success() {
+				log.Printf("Circuit to %s closed, replaying parked messages", job.URL)
+				go f.replayParked(job.URL)
+			}
+			if job.OnDelivered != nil {
+				job.OnDelivered()
+			}
+			return
+		}
+		debugf("Forward of %s to %s failed (attempt %d): %v", messageRef(job.MessageID, job.RequestID), job.URL, attempts, err)
+		if ctx.Err() != nil {
+			// Shutting down: the webhook is not at fault
+			f.deadLetter(job, attempts, err)
+			return
+		}
+		if b.Failure() {
+			log.Printf("Circuit to %s open after repeated failures", job.URL)
+			f.park(job, attempts, err)
+			return
+		}
+	}
+	log.Printf("Forward error (%s), dead-lettering message %s: %v", job.URL, messageRef(job.MessageID, job.RequestID), err)
+	f.deadLetter(job, attempts, err)
+}
+
+// post makes one delivery attempt, to a webhook or the sink, bounded by
+// TunnelTimeout
+func (f *tunnelForwarder) post(ctx context.Context, job forwardJob) error {
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.TunnelTimeout)
+	defer cancel()
+	ctx = otel.GetTextMapPropagator().Extract(ctx, job.Trace)
+	ctx, span := tracer.Start(ctx, "tunnel-forward", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("sight.message.id", job.MessageID), attribute.String("http.url", job.URL)))
+	defer span.End()
+
+	if subject, ok := f.sinkSubject(job.URL); ok {
+		if err := f.sink.Publish(ctx, subject, job); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if job.RequestID != "" {
+		req.Header.Set(requestIDHeader, job.RequestID)
+	}
+	signTunnelRequest(f.cfg, req, job.Body)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	return nil
+}
+
+// park holds a job in the dead-letter queue until the circuit closes
+func (f *tunnelForwarder) park(job forwardJob, attempts int, cause error) {
+	if cause == nil {
+		cause = errors.New("circuit open")
+	}
+	f.addDeadLetter(job, attempts, cause, true)
+}
+
+func (f *tunnelForwarder) deadLetter(job forwardJob, attempts int, cause error) {
+	f.addDeadLetter(job, attempts, cause, false)
+}
+
+func (f *tunnelForwarder) addDeadLetter(job forwardJob, attempts int, cause error, parked bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dlq = append(f.dlq, DeadLetter{
+		ID:        uuid.NewString(),
+		MessageID: job.MessageID,
+		RequestID: job.RequestID,
+		FromDID:   job.FromDID,
+		URL:       job.URL,
+		Payload:   job.Body,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().Format(time.RFC3339),
+		Parked:    parked,
+	})
+	if over := len(f.dlq) - f.cfg.DLQMaxEntries; over > 0 {
+		f.dlq = append([]DeadLetter{}, f.dlq[over:]...)
+	}
+	f.save()
+}
+
+// DeadLetters returns the current dead-letter queue, oldest first
+func (f *tunnelForwarder) DeadLetters() []DeadLetter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DeadLetter{}, f.dlq...)
+}
+
+// Replay removes a dead letter and queues it for delivery again
+func (f *tunnelForwarder) Replay(id string) error {
+	f.mu.Lock()
+	var entry *DeadLetter
+	for i, dl := range f.dlq {
+		if dl.ID == id {
+			entry = &dl
+			f.dlq = append(f.dlq[:i:i], f.dlq[i+1:]...)
+			break
+		}
+	}
+	if entry == nil {
+		f.mu.Unlock()
+		return errors.New("dead letter not found")
+	}
+	f.save()
+	f.mu.Unlock()
+
+	f.Enqueue(forwardJob{MessageID: entry.MessageID, RequestID: entry.RequestID, FromDID: entry.FromDID, URL: entry.URL, Body: entry.Payload})
+	return nil
+}
+
+// evictDeadLetters drops dead letters that failed before cutoff, then the
+// oldest ones until the payloads fit in maxBytes (0 means no byte limit).
+// It returns how many were dropped for each reason.
+func (f *tunnelForwarder) evictDeadLetters(cutoff time.Time, maxBytes int64) (aged, over int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.dlq[:0:0]
+	var total int64
+	for _, dl := range f.dlq {
+		if failedAt, err := time.Parse(time.RFC3339, dl.FailedAt); err == nil && failedAt.Before(cutoff) {
+			aged++
+			continue
+		}
+		kept = append(kept, dl)
+		total += int64(len(dl.Payload))
+	}
+	for maxBytes > 0 && total > maxBytes && len(kept) > 0 {
+		total -= int64(len(kept[0].Payload))
+		kept = kept[1:]
+		over++
+	}
+	if aged+over > 0 {
+		f.dlq = kept
+		f.save()
+	}
+	return aged, over
+}
+
+// removeDeadLetters drops the dead letters of messages sent by did
+func (f *tunnelForwarder) removeDeadLetters(did string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.dlq[:0:0]
+	for _, dl := range f.dlq {
+		if dl.FromDID != did {
+			kept = append(kept, dl)
+		}
+	}
+	removed := len(f.dlq) - len(kept)
+	if removed > 0 {
+		f.dlq = kept
+		f.save()
+	}
+	return removed
+}
+
+// parkedFor returns the IDs of the deliveries parked for url, oldest first
+func (f *tunnelForwarder) parkedFor(url string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, dl := range f.dlq {
+		if dl.Parked && dl.URL == url {
+			ids = append(ids, dl.ID)
+		}
+	}
+	return ids
+}
+
+// replayParked requeues every delivery parked for url
+func (f *tunnelForwarder) replayParked(url string) {
+	for _, id := range f.parkedFor(url) {
+		if err := f.Replay(id); err != nil {
+			debugf("Replay of parked delivery %s failed: %v", id, err)
+		}
+	}
+}
+
+// probeLoop sends the oldest parked delivery of every open circuit once its
+// open timeout has passed, so a recovered webhook is noticed without new
+// traffic, until ctx is done
+func (f *tunnelForwarder) probeLoop(ctx context.Context) {
+	ticker := time.NewTicker(f.cfg.BreakerOpenTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		var open []string
+		for url, b := range f.breakers {
+			if b.Open() {
+				open = append(open, url)
+			}
+		}
+		f.mu.Unlock()
+		for _, url := range open {
+			if ids := f.parkedFor(url); len(ids) > 0 {
+				f.Replay(ids[0])
+			}
+		}
+	}
+}
+
+func (f *tunnelForwarder) load() {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &f.dlq); err != nil {
+		log.Printf("Error reading dead-letter queue: %v", err)
+	}
+}
+
+// save persists the DLQ; callers hold f.mu
+func (f *tunnelForwarder) save() {
+	data, err := json.Marshal(f.dlq)
+	if err != nil {
+		log.Printf("Error marshalling dead-letter queue: %v", err)
+		return
+	}
+	_ = os.MkdirAll(f.cfg.IdentityDir, 0700)
+	if err := os.WriteFile(f.path, data, 0600); err != nil {
+		log.Printf("Error writing dead-letter queue: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons a connection attempt is refused
+const (
+	refusedBlockedCIDR = "blocked_cidr"
+	refusedDeniedRange = "inbound_denied"
+	refusedNotAllowed  = "inbound_not_allowed"
+	refusedPeer        = "peer"
+)
+
+var connectionsRefused = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sight_connections_refused_total",
+	Help: "Inbound connection attempts refused by the connection gater, per reason",
+}, []string{"reason"})
+
+// Blocklist is the persisted set of refused peers and networks
+type Blocklist struct {
+	Peers []string `json:"peers"`
+	CIDRs []string `json:"cidrs"`
+}
+
+// PeerGater is a connection gater refusing blocked PeerIDs/DIDs and CIDR ranges.
+// When an allowlist is configured only those peers may connect. Inbound
+// connections are also checked against INBOUND_ALLOW_CIDRS and
+// INBOUND_DENY_CIDRS; relayed ones have no IP to check.
+type PeerGater struct {
+	mu      sync.RWMutex
+	path    string
+	blocked map[peer.ID]struct{}
+	nets    map[string]*net.IPNet
+	allowed map[peer.ID]struct{}
+
+	inboundAllow []*net.IPNet
+	inboundDeny  []*net.IPNet
+}
+
+// NewPeerGater loads the persisted blocklist and merges PEER_BLOCKLIST / PEER_ALLOWLIST
+func NewPeerGater(cfg Config) *PeerGater {
+	g := &PeerGater{path: cfg.DataDir + "/peer-blocklist.json"}
+	g.Reload(cfg)
+	return g
+}
+
+// Reload rebuilds the block and allow lists from the persisted blocklist and
+// the configured lists, replacing entries that came from a previous config
+func (g *PeerGater) Reload(cfg Config) {
+	fresh := &PeerGater{
+		blocked: make(map[peer.ID]struct{}),
+		nets:    make(map[string]*net.IPNet),
+		allowed: make(map[peer.ID]struct{}),
+	}
+	if data, err := os.ReadFile(g.path); err == nil {
+		var list Blocklist
+		if err := json.Unmarshal(data, &list); err != nil {
+			log.Printf("Error reading peer blocklist: %v", err)
+		}
+		for _, entry := range append(list.Peers, list.CIDRs...) {
+			if err := fresh.add(entry); err != nil {
+				log.Printf("Ignoring blocklist entry %q: %v", entry, err)
+			}
+		}
+	}
+	for _, entry := range cfg.PeerBlocklist {
+		if err := fresh.add(entry); err != nil {
+			log.Printf("Ignoring PEER_BLOCKLIST entry %q: %v", entry, err)
+		}
+	}
+	for _, entry := range cfg.PeerAllowlist {
+		id, err := parsePeerOrDID(entry)
+		if err != nil {
+			log.Printf("Ignoring PEER_ALLOWLIST entry %q: %v", entry, err)
+			continue
+		}
+		fresh.allowed[id] = struct{}{}
+	}
+	inboundAllow := parseCIDRList("INBOUND_ALLOW_CIDRS", cfg.InboundAllowCIDRs)
+	inboundDeny := parseCIDRList("INBOUND_DENY_CIDRS", cfg.InboundDenyCIDRs)
+
+	g.mu.Lock()
+	g.blocked, g.nets, g.allowed = fresh.blocked, fresh.nets, fresh.allowed
+	g.inboundAllow, g.inboundDeny = inboundAllow, inboundDeny
+	g.mu.Unlock()
+}
+
+// parseCIDRList parses the ranges of a config list, skipping invalid ones
+func parseCIDRList(name string, entries []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring %s entry %q: %v", name, entry, err)
+			continue
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePeerOrDID accepts either a PeerID or a did:sight DID
+func parsePeerOrDID(value string) (peer.ID, error) {
+	if strings.HasPrefix(value, "did:") {
+		return DIDToPeerID(value)
+	}
+	return peer.Decode(value)
+}
+
+// add blocks a PeerID, DID or CIDR range without persisting
+func (g *PeerGater) add(entry string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return err
+		}
+		g.nets[ipnet.String()] = ipnet
+		return nil
+	}
+	id, err := parsePeerOrDID(entry)
+	if err != nil {
+		return err
+	}
+	g.blocked[id] = struct{}{}
+	return nil
+}
+
+// Block adds a PeerID, DID or CIDR range to the blocklist and persists it
+func (g *PeerGater) Block(entry string) error {
+	if err := g.add(entry); err != nil {
+		return err
+	}
+	return g.save()
+}
+
+// Unblock removes a PeerID, DID or CIDR range from the blocklist
+func (g *PeerGater) Unblock(entry string) error {
+	g.mu.Lock()
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			g.mu.Unlock()
+			return err
+		}
+		delete(g.nets, ipnet.String())
+	} else {
+		id, err := parsePeerOrDID(entry)
+		if err != nil {
+			g.mu.Unlock()
+			return err
+		}
+		delete(g.blocked, id)
+	}
+	g.mu.Unlock()
+	return g.save()
+}
+
+// List returns the current blocklist
+func (g *PeerGater) List() Blocklist {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	list := Blocklist{Peers: []string{}, CIDRs: []string{}}
+	for id := range g.blocked {
+		list.Peers = append(list.Peers, id.String())
+	}
+	for cidr := range g.nets {
+		list.CIDRs = append(list.CIDRs, cidr)
+	}
+	return list
+}
+
+func (g *PeerGater) save() error {
+	data, err := json.Marshal(g.List())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.path, data, 0600)
+}
+
+func (g *PeerGater) peerAllowed(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if _, ok := g.blocked[p]; ok {
+		return false
+	}
+	if len(g.allowed) > 0 {
+		_, ok := g.allowed[p]
+		return ok
+	}
+	return true
+}
+
+func (g *PeerGater) addrAllowed(addr ma.Multiaddr) bool {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		// Non-IP addresses (e.g. relays) can't be matched against CIDRs
+		return true
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, ipnet := range g.nets {
+		if ipnet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// inboundRefusal returns why a connection from addr is refused, "" when it
+// is not
+func (g *PeerGater) inboundRefusal(addr ma.Multiaddr) string {
+	if !g.addrAllowed(addr) {
+		return refusedBlockedCIDR
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if containsIP(g.inboundDeny, ip) {
+		return refusedDeniedRange
+	}
+	if len(g.inboundAllow) > 0 && !containsIP(g.inboundAllow, ip) {
+		return refusedNotAllowed
+	}
+	return ""
+}
+
+// Allow adds peers to the allowlist, used to keep bootstrap peers reachable
+func (g *PeerGater) Allow(ids ...peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.allowed) == 0 {
+		return
+	}
+	for _, id := range ids {
+		g.allowed[id] = struct{}{}
+	}
+}
+
+func (g *PeerGater) InterceptPeerDial(p peer.ID) bool {
+	return g.peerAllowed(p)
+}
+
+func (g *PeerGater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	return g.peerAllowed(p) && g.addrAllowed(addr)
+}
+
+func (g *PeerGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	if reason := g.inboundRefusal(addrs.RemoteMultiaddr()); reason != "" {
+		connectionsRefused.WithLabelValues(reason).Inc()
+		debugf("Refused connection from %s: %s", addrs.RemoteMultiaddr(), reason)
+		return false
+	}
+	return true
+}
+
+func (g *PeerGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	allowed := g.peerAllowed(p)
+	if !allowed {
+		if dir == network.DirInbound {
+			connectionsRefused.WithLabelValues(refusedPeer).Inc()
+		}
+		debugf("Refused connection from blocked peer %s (%s)", p, addrs.RemoteMultiaddr())
+	}
+	return allowed
+}
+
+func (g *PeerGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// BlockPeer blocks an entry and drops existing connections to a blocked peer
+func (s *Libp2pNodeService) BlockPeer(entry string) error {
+	if err := s.gater.Block(entry); err != nil {
+		return fmt.Errorf("invalid block entry: %w", err)
+	}
+	if id, err := parsePeerOrDID(entry); err == nil {
+		s.mu.RLock()
+		node := s.node
+		s.mu.RUnlock()
+		if err := node.Network().ClosePeer(id); err != nil {
+			log.Printf("Error disconnecting blocked peer %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// DisconnectPeer closes all connections to a PeerID or DID and, when block is
+// set, adds it to the blocklist so it cannot reconnect. It returns the number
+// of connections that were open.
+func (s *Libp2pNodeService) DisconnectPeer(entry string, block bool) (peer.ID, int, error) {
+	id, err := parsePeerOrDID(entry)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid peer: %w", err)
+	}
+	if block {
+		if err := s.gater.Block(id.String()); err != nil {
+			return id, 0, err
+		}
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	conns := len(node.Network().ConnsToPeer(id))
+	if err := node.Network().ClosePeer(id); err != nil {
+		return id, conns, err
+	}
+	return id, conns, nil
+}
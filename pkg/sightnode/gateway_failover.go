@@ -0,0 +1,312 @@
+package sightnode
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// gatewayTag protects the configured gateways in the connection manager
+const gatewayTag = "gateway"
+
+var (
+	gatewayUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sight_gateway_up",
+		Help: "Whether a configured gateway passes its health checks",
+	}, []string{"peer"})
+	gatewayFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sight_gateway_failovers_total",
+		Help: "Changes of the gateway a hoster routes through",
+	})
+)
+
+// GatewayHealth is the health of one configured gateway, in order of
+// preference
+type GatewayHealth struct {
+	PeerID    string   `json:"peerId"`
+	Addrs     []string `json:"addrs"`
+	Healthy   bool     `json:"healthy"`
+	Active    bool     `json:"active"`
+	RTT       string   `json:"rtt,omitempty"`
+	LastCheck string   `json:"lastCheck,omitempty"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type gatewayState struct {
+	info      peer.AddrInfo
+	healthy   bool
+	failures  int
+	rtt       time.Duration
+	lastCheck time.Time
+	lastErr   error
+}
+
+// gatewayMonitor health-checks the gateways of GATEWAY_ADDRS by dialling and
+// pinging them, and picks the active one: the first healthy gateway in
+// order of preference. Staying connected to all of them keeps the hoster
+// registered everywhere, so a failover needs no re-registration.
+type gatewayMonitor struct {
+	host  hostlibp2p.Host
+	cfg   Config
+	gater *PeerGater
+
+	mu       sync.RWMutex
+	gateways []*gatewayState
+	active   int
+}
+
+// checkGatewayFailover validates the gateway failover settings
+func checkGatewayFailover(cfg Config) error {
+	if len(cfg.Gateways) == 0 {
+		return nil
+	}
+	if cfg.GatewayHealthInterval <= 0 {
+		return fmt.Errorf("GATEWAY_HEALTH_INTERVAL must be positive, got %s", cfg.GatewayHealthInterval)
+	}
+	if cfg.GatewayFailures < 1 {
+		return fmt.Errorf("GATEWAY_FAILURES must be at least 1, got %d", cfg.GatewayFailures)
+	}
+	for _, addr := range cfg.Gateways {
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			return fmt.Errorf("GATEWAY_ADDRS entry %q must be a multiaddr ending with /p2p/<peer id>: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func newGatewayMonitor(h hostlibp2p.Host, cfg Config, gater *PeerGater) *gatewayMonitor {
+	m := &gatewayMonitor{host: h, cfg: cfg, gater: gater, active: -1}
+	// Several addresses of one gateway are merged, keeping its first position
+	byID := make(map[peer.ID]*gatewayState)
+	for _, addr := range cfg.Gateways {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			continue
+		}
+		if gw, ok := byID[info.ID]; ok {
+			gw.info.Addrs = append(gw.info.Addrs, info.Addrs...)
+			continue
+		}
+		gw := &gatewayState{info: *info}
+		byID[info.ID] = gw
+		m.gateways = append(m.gateways, gw)
+	}
+	m.allowPeers()
+	for _, gw := range m.gateways {
+		h.ConnManager().Protect(gw.info.ID, gatewayTag)
+		h.Peerstore().AddAddrs(gw.info.ID, gw.info.Addrs, peerstore.PermanentAddrTTL)
+		gatewayUp.WithLabelValues(gw.info.ID.String()).Set(0)
+	}
+	return m
+}
+
+// allowPeers keeps the gateways reachable even with a restrictive allowlist,
+// also after the gater was reloaded from config
+func (m *gatewayMonitor) allowPeers() {
+	for _, gw := range m.gateways {
+		m.gater.Allow(gw.info.ID)
+	}
+}
+
+// Start checks the gateways now and then at every GATEWAY_HEALTH_INTERVAL
+func (m *gatewayMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.cfg.GatewayHealthInterval)
+		defer ticker.Stop()
+		for {
+			m.checkAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *gatewayMonitor) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, gw := range m.gateways {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := m.check(ctx, gw.info)
+			m.record(gw, rtt, err)
+		}()
+	}
+	wg.Wait()
+	m.elect()
+}
+
+// check dials a gateway if needed and measures one ping round trip
+func (m *gatewayMonitor) check(ctx context.Context, info peer.AddrInfo) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.DialTimeout)
+	defer cancel()
+	if m.host.Network().Connectedness(info.ID) != network.Connected {
+		if err := m.host.Connect(ctx, info); err != nil {
+			return 0, err
+		}
+	}
+	select {
+	case res := <-ping.Ping(ctx, m.host, info.ID):
+		return res.RTT, res.Error
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (m *gatewayMonitor) record(gw *gatewayState, rtt time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gw.lastCheck, gw.lastErr = time.Now(), err
+	if err != nil {
+		gw.failures++
+		debugf("Health check of gateway %s failed (%d in a row): %v", gw.info.ID, gw.failures, err)
+		if gw.failures >= m.cfg.GatewayFailures {
+			gw.healthy = false
+		}
+	} else {
+		gw.failures, gw.healthy, gw.rtt = 0, true, rtt
+	}
+	up := 0.0
+	if gw.healthy {
+		up = 1
+	}
+	gatewayUp.WithLabelValues(gw.info.ID.String()).Set(up)
+}
+
+// elect makes the first healthy gateway the active one
+func (m *gatewayMonitor) elect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := -1
+	for i, gw := range m.gateways {
+		if gw.healthy {
+			active = i
+			break
+		}
+	}
+	if active == m.active {
+		return
+	}
+	previous := m.active
+	m.active = active
+	switch {
+	case active < 0:
+		log.Printf("No configured gateway is reachable")
+	case previous < 0 && active == 0:
+		log.Printf("Routing through gateway %s", m.gateways[active].info.ID)
+	case active == 0:
+		log.Printf("Primary gateway %s is back, failing back to it", m.gateways[active].info.ID)
+	default:
+		log.Printf("Failing over to gateway %s", m.gateways[active].info.ID)
+	}
+	if previous >= 0 || active > 0 {
+		gatewayFailovers.Inc()
+	}
+}
+
+// Active returns the gateway traffic is routed through
+func (m *gatewayMonitor) Active() (peer.ID, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.active < 0 {
+		return "", false
+	}
+	return m.gateways[m.active].info.ID, true
+}
+
+// List returns the health of the gateways in order of preference
+func (m *gatewayMonitor) List() []GatewayHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]GatewayHealth, 0, len(m.gateways))
+	for i, gw := range m.gateways {
+		h := GatewayHealth{
+			PeerID:  gw.info.ID.String(),
+			Addrs:   []string{},
+			Healthy: gw.healthy,
+			Active:  i == m.active,
+		}
+		for _, addr := range gw.info.Addrs {
+			h.Addrs = append(h.Addrs, addr.String())
+		}
+		if gw.healthy {
+			h.RTT = gw.rtt.String()
+		}
+		if !gw.lastCheck.IsZero() {
+			h.LastCheck = gw.lastCheck.UTC().Format(time.RFC3339)
+		}
+		if gw.lastErr != nil {
+			h.LastError = gw.lastErr.Error()
+		}
+		list = append(list, h)
+	}
+	return list
+}
+
+// routeViaGateway delivers a unicast envelope directly to the recipient,
+// resolved through the active gateway when its addresses are unknown. It is
+// used with GATEWAY_ADDRS, and whenever no peer in reach subscribes to the
+// recipient's inbox, e.g. a hoster homed on another gateway of a cluster. A
+// false result means the caller should broadcast instead.
+func (s *Libp2pNodeService) routeViaGateway(ctx context.Context, to string, data []byte) bool {
+	s.mu.RLock()
+	node, ps, gateways := s.node, s.pubsub, s.gateways
+	s.mu.RUnlock()
+	if gateways == nil && len(ps.ListPeers(inboxTopic(to))) > 0 {
+		return false
+	}
+	id, err := DIDToPeerID(s.resolveRotatedDID(to))
+	if err != nil {
+		return false
+	}
+	homedElsewhere := false
+	if node.Network().Connectedness(id) != network.Connected && len(node.Peerstore().Addrs(id)) == 0 {
+		entry, err := s.ResolvePeer(ctx, to)
+		if err != nil {
+			debugf("Could not resolve %s through the gateways, broadcasting: %v", to, err)
+			return false
+		}
+		if id, err = peer.Decode(entry.PeerID); err != nil {
+			return false
+		}
+		homedElsewhere = entry.Gateway != ""
+	}
+	if err := sendDirect(ctx, node, id, data); err != nil {
+		debugf("Direct delivery to %s failed, broadcasting: %v", to, err)
+		if homedElsewhere {
+			awaitTopicPeer(ctx, ps, inboxTopic(to), 2*time.Second)
+		}
+		return false
+	}
+	return true
+}
+
+// awaitTopicPeer waits up to timeout for a peer in reach to subscribe to a
+// topic. A gateway starts relaying the inbox of a DID homed on another
+// gateway when resolving it, and the broadcast must not go out before its
+// subscription arrived.
+func awaitTopicPeer(ctx context.Context, ps PubSub, topic string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(ps.ListPeers(topic)) == 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
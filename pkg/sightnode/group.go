@@ -0,0 +1,627 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// groupTopicPrefix starts the topics group messages are published on
+const groupTopicPrefix = "sight/group/"
+
+// groupInviteType is the payload type of group invites
+const groupInviteType = "sight.group-invite"
+
+var errUnknownGroup = errors.New("unknown group")
+
+// groupTopic returns the topic of a group
+func groupTopic(id string) string {
+	return groupTopicPrefix + id
+}
+
+// Group is a set of DIDs sharing a symmetric key. Group messages are sealed
+// with the key and published on the group topic, so only members can read them.
+type Group struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Creator   string   `json:"creator"`
+	Members   []string `json:"members"`
+	Joined    bool     `json:"joined"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// IsMember reports whether did belongs to the group
+func (g Group) IsMember(did string) bool {
+	for _, m := range g.Members {
+		if m == did {
+			return true
+		}
+	}
+	return false
+}
+
+// storedGroup is a group with its key, as persisted
+type storedGroup struct {
+	Group
+	Key []byte `json:"key"`
+}
+
+// GroupInvite hands the group key to one member. The key is sealed to the
+// member's DID key and the invite is signed by the creator.
+type GroupInvite struct {
+	Type         string   `json:"type"`
+	GroupID      string   `json:"groupId"`
+	Name         string   `json:"name,omitempty"`
+	Creator      string   `json:"creator"`
+	Members      []string `json:"members"`
+	Member       string   `json:"member"`
+	EphemeralKey []byte   `json:"ephemeralKey"`
+	Nonce        []byte   `json:"nonce"`
+	SealedKey    []byte   `json:"sealedKey"`
+	IssuedAt     string   `json:"issuedAt"`
+	Signature    []byte   `json:"signature,omitempty"`
+}
+
+// signingBytes is the invite encoding covered by the signature
+func (inv GroupInvite) signingBytes() ([]byte, error) {
+	inv.Signature = nil
+	return json.Marshal(inv)
+}
+
+// Verify checks the invite signature against the creator DID key
+func (inv GroupInvite) Verify() error {
+	pub, err := DIDPublicKey(inv.Creator)
+	if err != nil {
+		return err
+	}
+	data, err := inv.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, inv.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid group invite signature")
+	}
+	return nil
+}
+
+// groupMessage is what goes over a group topic: an envelope sealed with the
+// group key
+type groupMessage struct {
+	GroupID    string `json:"groupId"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// isGroupInvite reports whether an envelope carries a group invite
+func isGroupInvite(envelope map[string]interface{}) bool {
+	invite, _ := envelope["groupInvite"].(bool)
+	return invite
+}
+
+// groupStore holds the groups this node created, joined or was invited to
+type groupStore struct {
+	mu     sync.RWMutex
+	groups map[string]*storedGroup
+	path   string
+}
+
+func newGroupStore(cfg Config) *groupStore {
+	st := &groupStore{
+		groups: make(map[string]*storedGroup),
+		path:   cfg.DataDir + "/groups.json",
+	}
+	st.load()
+	return st
+}
+
+// Get returns a group and its key
+func (st *groupStore) Get(id string) (storedGroup, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	g, ok := st.groups[id]
+	if !ok {
+		return storedGroup{}, false
+	}
+	return *g, true
+}
+
+// Put adds or replaces a group
+func (st *groupStore) Put(g storedGroup) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.groups[g.ID] = &g
+	st.save()
+}
+
+// List returns the groups, without their keys, sorted by creation time
+func (st *groupStore) List() []Group {
+	st.mu.RLock()
+	list := make([]Group, 0, len(st.groups))
+	for _, g := range st.groups {
+		list = append(list, g.Group)
+	}
+	st.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+	return list
+}
+
+func (st *groupStore) load() {
+	data, err := os.ReadFile(st.path)
+	if err != nil {
+		return
+	}
+	var list []storedGroup
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Error reading groups: %v", err)
+		return
+	}
+	for i := range list {
+		st.groups[list[i].ID] = &list[i]
+	}
+}
+
+// save persists the groups, keys included; callers hold st.mu
+func (st *groupStore) save() {
+	list := make([]storedGroup, 0, len(st.groups))
+	for _, g := range st.groups {
+		list = append(list, *g)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Error marshalling groups: %v", err)
+		return
+	}
+	if err := os.WriteFile(st.path, data, 0600); err != nil {
+		log.Printf("Error writing groups: %v", err)
+	}
+}
+
+// curve25519P is the field prime 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// ed25519ToX25519 maps an Ed25519 public key to the X25519 key of the same
+// secret, u = (1 + y) / (1 - y) mod p
+func ed25519ToX25519(pub []byte) ([]byte, error) {
+	if len(pub) != 32 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	// The key is little-endian y with the sign of x in the top bit
+	be := make([]byte, 32)
+	for i := range pub {
+		be[31-i] = pub[i]
+	}
+	be[0] &= 0x7f
+	y := new(big.Int).SetBytes(be)
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// x25519Scalar returns the X25519 private key of an Ed25519 keypair
+func (kp Keypair) x25519Scalar() ([]byte, error) {
+	if kp.Signer != "" {
+		return nil, errExternalKey
+	}
+	if kp.KeyType != "" || len(kp.Seed) < 32 {
+		return nil, errors.New("groups need an Ed25519 identity")
+	}
+	h := sha512.Sum512(kp.Seed[:32])
+	return h[:32], nil
+}
+
+// groupKeyWrap derives the key sealing a group key from the X25519 exchange
+func groupKeyWrap(shared, ephemeral, recipient []byte) []byte {
+	sum := sha256.Sum256(append(append(append([]byte{}, shared...), ephemeral...), recipient...))
+	return sum[:]
+}
+
+// sealGroupKey encrypts the group key to the X25519 form of a member's DID key
+func sealGroupKey(inv *GroupInvite, key []byte) error {
+	pub, err := DIDPublicKey(inv.Member)
+	if err != nil {
+		return err
+	}
+	if pub.Type() != crypto.Ed25519 {
+		return fmt.Errorf("group member %s has no Ed25519 key", inv.Member)
+	}
+	raw, err := pub.Raw()
+	if err != nil {
+		return err
+	}
+	recipient, err := ed25519ToX25519(raw)
+	if err != nil {
+		return err
+	}
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return err
+	}
+	if inv.EphemeralKey, err = curve25519.X25519(ephemeral, curve25519.Basepoint); err != nil {
+		return err
+	}
+	shared, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.NewX(groupKeyWrap(shared, inv.EphemeralKey, recipient))
+	if err != nil {
+		return err
+	}
+	inv.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(inv.Nonce); err != nil {
+		return err
+	}
+	inv.SealedKey = aead.Seal(nil, inv.Nonce, key, []byte(inv.GroupID))
+	return nil
+}
+
+// openGroupKey decrypts the group key of an invite addressed to kp
+func openGroupKey(inv GroupInvite, kp Keypair) ([]byte, error) {
+	scalar, err := kp.x25519Scalar()
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(scalar, inv.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(groupKeyWrap(shared, inv.EphemeralKey, recipient))
+	if err != nil {
+		return nil, err
+	}
+	if len(inv.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid group invite nonce")
+	}
+	return aead.Open(nil, inv.Nonce, inv.SealedKey, []byte(inv.GroupID))
+}
+
+// CreateGroup generates a group key, joins the group and sends each other
+// member an invite carrying the key sealed to their DID
+func (s *Libp2pNodeService) CreateGroup(ctx context.Context, name string, members []string) (Group, error) {
+	s.mu.RLock()
+	kp, self := s.keypair, s.did
+	s.mu.RUnlock()
+	if _, err := ParseSightDID(self); err != nil {
+		return Group{}, errors.New("only nodes with a DID can create groups")
+	}
+	if _, err := kp.x25519Scalar(); err != nil {
+		return Group{}, err
+	}
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return Group{}, err
+	}
+
+	all := []string{self}
+	seen := map[string]bool{self: true}
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		if _, err := ParseSightDID(m); err != nil {
+			return Group{}, fmt.Errorf("invalid member %q: %w", m, err)
+		}
+		seen[m] = true
+		all = append(all, m)
+	}
+	g := storedGroup{
+		Group: Group{
+			ID:        uuid.NewString(),
+			Name:      name,
+			Creator:   self,
+			Members:   all,
+			Joined:    true,
+			CreatedAt: time.Now().Format(time.RFC3339),
+		},
+		Key: make([]byte, chacha20poly1305.KeySize),
+	}
+	if _, err := rand.Read(g.Key); err != nil {
+		return Group{}, err
+	}
+
+	// Seal every invite before sending any, so a bad member fails the whole call
+	var invites []GroupInvite
+	for _, m := range all[1:] {
+		inv := GroupInvite{
+			Type:     groupInviteType,
+			GroupID:  g.ID,
+			Name:     name,
+			Creator:  self,
+			Members:  all,
+			Member:   m,
+			IssuedAt: g.CreatedAt,
+		}
+		if err := sealGroupKey(&inv, g.Key); err != nil {
+			return Group{}, err
+		}
+		data, err := inv.signingBytes()
+		if err != nil {
+			return Group{}, err
+		}
+		if inv.Signature, err = priv.Sign(data); err != nil {
+			return Group{}, err
+		}
+		invites = append(invites, inv)
+	}
+
+	s.groups.Put(g)
+	s.mu.Lock()
+	err = s.subscribeGroup(s.ctx, g.ID)
+	s.mu.Unlock()
+	if err != nil {
+		return Group{}, err
+	}
+	for _, inv := range invites {
+		if err := s.sendGroupInvite(ctx, inv); err != nil {
+			log.Printf("Error inviting %s to group %s: %v", inv.Member, g.ID, err)
+		}
+	}
+	return g.Group, nil
+}
+
+func (s *Libp2pNodeService) sendGroupInvite(ctx context.Context, inv GroupInvite) error {
+	// Round-trip through JSON so the payload looks the same as a decoded one
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+	_, _, err = s.enqueueOutgoing(context.WithoutCancel(ctx), map[string]interface{}{
+		"to":          inv.Member,
+		"payload":     payload,
+		"groupInvite": true,
+	})
+	return err
+}
+
+// handleGroupInvite stores the key of a valid invite addressed to us. The
+// group is only subscribed once joined.
+func (s *Libp2pNodeService) handleGroupInvite(envelope map[string]interface{}) {
+	data, err := json.Marshal(envelope["payload"])
+	if err != nil {
+		return
+	}
+	var inv GroupInvite
+	if err := json.Unmarshal(data, &inv); err != nil || inv.Type != groupInviteType {
+		debugf("Dropping malformed group invite %v", envelope["id"])
+		return
+	}
+	if !s.isOwnDID(inv.Member) {
+		return
+	}
+	if err := inv.Verify(); err != nil {
+		log.Printf("Dropping group invite %s: %v", inv.GroupID, err)
+		return
+	}
+	if _, ok := s.groups.Get(inv.GroupID); ok {
+		return
+	}
+	s.mu.RLock()
+	kp := s.keypair
+	s.mu.RUnlock()
+	key, err := openGroupKey(inv, kp)
+	if err != nil {
+		log.Printf("Dropping group invite %s: %v", inv.GroupID, err)
+		return
+	}
+	s.groups.Put(storedGroup{
+		Group: Group{
+			ID:        inv.GroupID,
+			Name:      inv.Name,
+			Creator:   inv.Creator,
+			Members:   inv.Members,
+			CreatedAt: inv.IssuedAt,
+		},
+		Key: key,
+	})
+	log.Printf("Invited to group %s by %s", inv.GroupID, inv.Creator)
+}
+
+// JoinGroup starts receiving the messages of a group we were invited to
+func (s *Libp2pNodeService) JoinGroup(id string) (Group, error) {
+	g, ok := s.groups.Get(id)
+	if !ok {
+		return Group{}, errUnknownGroup
+	}
+	if g.Joined {
+		return g.Group, nil
+	}
+	s.mu.Lock()
+	err := s.subscribeGroup(s.ctx, id)
+	s.mu.Unlock()
+	if err != nil {
+		return Group{}, err
+	}
+	g.Joined = true
+	s.groups.Put(g)
+	return g.Group, nil
+}
+
+// Groups returns the known groups
+func (s *Libp2pNodeService) Groups() []Group {
+	return s.groups.List()
+}
+
+// SendGroup seals an envelope with the group key and publishes it on the
+// group topic. It returns the message ID.
+func (s *Libp2pNodeService) SendGroup(ctx context.Context, id string, msg map[string]interface{}) (string, error) {
+	if s.draining.Load() {
+		return "", errDraining
+	}
+	g, ok := s.groups.Get(id)
+	if !ok {
+		return "", errUnknownGroup
+	}
+	if !g.Joined {
+		return "", fmt.Errorf("group %s has not been joined", id)
+	}
+	if _, ok := msg["id"]; !ok {
+		msg["id"] = uuid.NewString()
+	}
+	msgID, _ := msg["id"].(string)
+	msg["group"] = id
+	plain, err := json.Marshal(msg)
+	if err != nil {
+		return msgID, err
+	}
+	aead, err := chacha20poly1305.NewX(g.Key)
+	if err != nil {
+		return msgID, err
+	}
+	s.mu.RLock()
+	self := s.node.ID()
+	topic, err := s.joinTopic(groupTopic(id))
+	s.mu.RUnlock()
+	if err != nil {
+		return msgID, err
+	}
+	gm := groupMessage{GroupID: id, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(gm.Nonce); err != nil {
+		return msgID, err
+	}
+	gm.Ciphertext = aead.Seal(nil, gm.Nonce, plain, groupAD(id, self))
+	data, err := json.Marshal(gm)
+	if err != nil {
+		return msgID, err
+	}
+	return msgID, s.publishTopic(ctx, topic, data)
+}
+
+// groupAD binds a group message to its group and publisher, so a member
+// cannot republish another member's message as their own
+func groupAD(id string, from peer.ID) []byte {
+	return []byte(id + "/" + from.String())
+}
+
+// subscribeGroup receives the messages of a group; callers hold s.mu
+func (s *Libp2pNodeService) subscribeGroup(ctx context.Context, id string) error {
+	if err := s.registerTopicValidator(groupTopic(id), nil); err != nil {
+		return err
+	}
+	topic, err := s.joinTopic(groupTopic(id))
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+	self := s.node.ID()
+	go s.supervise(ctx, sub, func() (Subscription, error) {
+		return s.resubscribeJoined(groupTopic(id))
+	}, func(msg *pubsub.Message) {
+		s.handleGroupMessage(ctx, id, self, msg)
+	})
+	return nil
+}
+
+// subscribeGroups resubscribes the joined groups after a (re)start
+func (s *Libp2pNodeService) subscribeGroups(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.groups.List() {
+		if !g.Joined {
+			continue
+		}
+		if err := s.subscribeGroup(ctx, g.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Libp2pNodeService) handleGroupMessage(ctx context.Context, id string, self peer.ID, msg *pubsub.Message) {
+	from := msg.GetFrom()
+	if from == self {
+		return
+	}
+	envelope, err := s.openGroupMessage(id, from, messageData(msg))
+	if err != nil {
+		debugf("Dropping group message from %s: %v", from, err)
+		return
+	}
+	if msgID, ok := envelope["id"].(string); ok && s.dedup.Seen(msgID) {
+		return
+	}
+	s.deliverIncoming(ctx, msg.GetTopic(), from, envelope)
+}
+
+// openGroupMessage checks that the publisher is a member and decrypts the envelope
+func (s *Libp2pNodeService) openGroupMessage(id string, from peer.ID, data []byte) (map[string]interface{}, error) {
+	g, ok := s.groups.Get(id)
+	if !ok {
+		return nil, errUnknownGroup
+	}
+	did, err := s.peerDID(from)
+	if err != nil {
+		return nil, err
+	}
+	if !g.IsMember(did) {
+		return nil, fmt.Errorf("%s is not a member", did)
+	}
+	if s.revocations.Revoked(did) {
+		return nil, fmt.Errorf("%s is revoked", did)
+	}
+	var gm groupMessage
+	if err := json.Unmarshal(data, &gm); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(g.Key)
+	if err != nil {
+		return nil, err
+	}
+	if gm.GroupID != id || len(gm.Nonce) != aead.NonceSize() {
+		return nil, errors.New("malformed group message")
+	}
+	plain, err := aead.Open(nil, gm.Nonce, gm.Ciphertext, groupAD(id, from))
+	if err != nil {
+		return nil, err
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(plain, &envelope); err != nil {
+		return nil, err
+	}
+	envelope["group"] = id
+	return envelope, nil
+}
@@ -0,0 +1,168 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"sight-libp2p-node/api/sightpb"
+)
+
+// grpcServer implements the SightNode control-plane service on top of the node service
+type grpcServer struct {
+	sightpb.UnimplementedSightNodeServer
+	service *Libp2pNodeService
+}
+
+// NewGRPCServer creates the gRPC control-plane server, using the API TLS
+// settings (including client certificate verification) when configured
+func NewGRPCServer(service *Libp2pNodeService, cfg Config) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	tlsConf, err := APITLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+	srv := grpc.NewServer(opts...)
+	sightpb.RegisterSightNodeServer(srv, &grpcServer{service: service})
+	return srv, nil
+}
+
+// Send publishes a message, mirroring POST /v1/libp2p/send
+func (g *grpcServer) Send(ctx context.Context, req *sightpb.SendRequest) (*sightpb.SendResponse, error) {
+	if req.To == "" {
+		return nil, status.Error(codes.InvalidArgument, "to is required")
+	}
+	payload := map[string]interface{}{}
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "payload must be a JSON object")
+		}
+	}
+	if _, ok := payload["to"]; !ok {
+		payload["to"] = req.To
+	}
+	msg := map[string]interface{}{
+		"to":      req.To,
+		"payload": payload,
+	}
+	if req.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, req.ExpiresAt); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "expires_at must be RFC3339")
+		}
+		msg["expiresAt"] = req.ExpiresAt
+	}
+	if req.Priority != "" {
+		if _, err := parsePriority(req.Priority); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		msg["priority"] = req.Priority
+	}
+	id, err := g.service.HandleOutgoingMessage(ctx, msg)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &sightpb.SendResponse{Id: id, Status: "ok"}, nil
+}
+
+// Subscribe streams incoming messages like /v1/libp2p/stream; every request
+// received from the client replaces its filter. Like embedded subscribers,
+// gRPC clients receive messages whatever the DELIVERY_MODE: they are the
+// upstream service that opened GRPC_PORT to receive them.
+func (g *grpcServer) Subscribe(stream sightpb.SightNode_SubscribeServer) error {
+	client := &streamClient{send: make(chan StreamMessage, 256), local: true}
+	hub := g.service.streams
+	hub.add(client)
+	defer hub.remove(client)
+
+	// Reader: filter updates from the client, and close detection
+	done := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			client.mu.Lock()
+			client.filter = streamFilter{Topics: req.Topics, Types: req.Types}
+			client.mu.Unlock()
+		}
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-done:
+			return nil
+		case msg := <-client.send:
+			payload, err := json.Marshal(msg.Payload)
+			if err != nil {
+				continue
+			}
+			err = stream.Send(&sightpb.IncomingMessage{
+				Id:      msg.ID,
+				Topic:   msg.Topic,
+				Type:    msg.Type,
+				From:    msg.From,
+				FromDid: msg.FromDID,
+				Payload: payload,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Peers lists the connected peers with their DIDs and addresses
+func (g *grpcServer) Peers(ctx context.Context, req *sightpb.PeersRequest) (*sightpb.PeersResponse, error) {
+	g.service.mu.RLock()
+	node := g.service.node
+	g.service.mu.RUnlock()
+	if node == nil {
+		return nil, status.Error(codes.Unavailable, "node is not running")
+	}
+
+	resp := &sightpb.PeersResponse{}
+	for _, id := range node.Network().Peers() {
+		p := &sightpb.Peer{Id: id.String()}
+		if did, err := peerDID(node.Peerstore(), id); err == nil {
+			p.Did = did
+		}
+		for _, conn := range node.Network().ConnsToPeer(id) {
+			p.Addrs = append(p.Addrs, conn.RemoteMultiaddr().String())
+		}
+		resp.Peers = append(resp.Peers, p)
+	}
+	return resp, nil
+}
+
+// Status reports the node identity, listen addresses and readiness
+func (g *grpcServer) Status(ctx context.Context, req *sightpb.StatusRequest) (*sightpb.StatusResponse, error) {
+	g.service.mu.RLock()
+	node, did := g.service.node, g.service.did
+	g.service.mu.RUnlock()
+
+	resp := &sightpb.StatusResponse{
+		Did:     did,
+		Gateway: g.service.isGateway,
+		Ready:   g.service.Readiness().Ready,
+	}
+	if node != nil {
+		resp.PeerId = node.ID().String()
+		resp.ConnectedPeers = int32(len(node.Network().Peers()))
+		for _, addr := range node.Addrs() {
+			resp.ListenAddrs = append(resp.ListenAddrs, addr.String())
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,76 @@
+package sightnode
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ReadinessCheck is the outcome of a single readiness criterion
+type ReadinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ReadinessReport is returned by /readyz
+type ReadinessReport struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// Readiness evaluates the readiness criteria: host started, topic subscribed,
+// enough connected peers and (optionally) a reachable tunnel API
+func (s *Libp2pNodeService) Readiness() ReadinessReport {
+	s.mu.RLock()
+	node, subs := s.node, len(s.subscriptions)
+	s.mu.RUnlock()
+
+	report := ReadinessReport{Ready: true, Checks: map[string]ReadinessCheck{}}
+	add := func(name string, check ReadinessCheck) {
+		report.Checks[name] = check
+		report.Ready = report.Ready && check.OK
+	}
+
+	add("host", ReadinessCheck{OK: node != nil})
+	// Bootstrap nodes have no inbox to subscribe to
+	subscription := ReadinessCheck{OK: (subs > 0 || s.cfg.IsBootstrap) && s.subHealth.Healthy()}
+	if !s.subHealth.Healthy() {
+		subscription.Detail = "resubscribing after a pubsub error"
+	}
+	add("subscription", subscription)
+
+	peers := 0
+	if node != nil {
+		peers = len(node.Network().Peers())
+	}
+	add("peers", ReadinessCheck{
+		OK:     peers >= s.cfg.ReadyMinPeers,
+		Detail: fmt.Sprintf("%d connected, %d required", peers, s.cfg.ReadyMinPeers),
+	})
+
+	degraded, detail := s.watchdog.status()
+	add("connectivity", ReadinessCheck{OK: !degraded, Detail: detail})
+
+	if s.cfg.ReadyCheckTunnel {
+		add("tunnel", s.checkTunnel())
+	}
+	return report
+}
+
+// checkTunnel treats any HTTP response from the tunnel API as reachable
+func (s *Libp2pNodeService) checkTunnel() ReadinessCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.tunnelAPI, nil)
+	if err != nil {
+		return ReadinessCheck{OK: false, Detail: err.Error()}
+	}
+	signTunnelRequest(s.cfg, req, nil)
+	resp, err := s.forwarder.client.Do(req)
+	if err != nil {
+		return ReadinessCheck{OK: false, Detail: err.Error()}
+	}
+	resp.Body.Close()
+	return ReadinessCheck{OK: true, Detail: resp.Status}
+}
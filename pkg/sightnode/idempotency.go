@@ -0,0 +1,79 @@
+package sightnode
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idempotencyEntry is the message ID generated for an Idempotency-Key
+type idempotencyEntry struct {
+	id      string
+	created time.Time
+}
+
+// idempotencyCache maps Idempotency-Key headers to message IDs for a
+// retention window, so retried sends return the original ID instead of
+// publishing again
+type idempotencyCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	keys      map[string]idempotencyEntry
+	lastPrune time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:       ttl,
+		keys:      make(map[string]idempotencyEntry),
+		lastPrune: time.Now(),
+	}
+}
+
+// Reserve returns the message ID for key and whether it was already used.
+// A new key gets a fresh ID that later retries will see.
+func (c *idempotencyCache) Reserve(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > c.ttl {
+		for k, e := range c.keys {
+			if now.Sub(e.created) > c.ttl {
+				delete(c.keys, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if e, ok := c.keys[key]; ok && now.Sub(e.created) <= c.ttl {
+		return e.id, true
+	}
+	id := uuid.NewString()
+	c.keys[key] = idempotencyEntry{id: id, created: now}
+	return id, false
+}
+
+// Release forgets a key whose send failed, so a retry can publish
+func (c *idempotencyCache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, key)
+}
+
+// SendIdempotent queues a message like SendAsync unless key was already used
+// within the retention window, in which case the earlier message ID is returned
+// and duplicate reports true
+func (s *Libp2pNodeService) SendIdempotent(ctx context.Context, key string, msg map[string]interface{}) (id string, duplicate bool, err error) {
+	id, duplicate = s.idempotency.Reserve(key)
+	if duplicate {
+		return id, true, nil
+	}
+	msg["id"] = id
+	if _, err = s.SendAsync(ctx, msg); err != nil {
+		s.idempotency.Release(key)
+	}
+	return id, false, err
+}
@@ -0,0 +1,29 @@
+package sightnode
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// activeIdentity is the named identity profile selected at startup ("" is the default)
+var activeIdentity string
+
+var identityNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SetIdentity selects the identity profile whose keypair files are used
+func SetIdentity(name string) error {
+	if name != "" && !identityNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid identity name %q: use letters, digits, '-' and '_'", name)
+	}
+	activeIdentity = name
+	return nil
+}
+
+// getIdentityDir gets the directory holding the active identity's key files.
+// The default identity keeps the original location directly under the data dir.
+func getIdentityDir() string {
+	if activeIdentity == "" {
+		return getDataDir()
+	}
+	return getDataDir() + "/identities/" + activeIdentity
+}
@@ -0,0 +1,89 @@
+package sightnode
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// minExportPassphrase is the shortest passphrase accepted for key export
+const minExportPassphrase = 8
+
+// ExportKey returns the node keypair sealed with passphrase, in the same
+// format as the encrypted keystore, so it can be imported on another machine
+func (s *Libp2pNodeService) ExportKey(passphrase string) (*EncryptedKeystore, error) {
+	if len(passphrase) < minExportPassphrase {
+		return nil, errors.New("export passphrase must be at least 8 characters")
+	}
+	s.mu.RLock()
+	kp := s.keypair
+	s.mu.RUnlock()
+	if kp.Signer != "" {
+		return nil, errExternalKey
+	}
+	return EncryptKeypair(kp, passphrase)
+}
+
+// ExportMnemonic returns the 24-word backup phrase of the node keypair
+func (s *Libp2pNodeService) ExportMnemonic() (string, error) {
+	s.mu.RLock()
+	kp := s.keypair
+	s.mu.RUnlock()
+	return kp.Mnemonic()
+}
+
+// ImportKey replaces the node identity with an exported keypair, see ImportKeypair
+func (s *Libp2pNodeService) ImportKey(ks *EncryptedKeystore, passphrase string) (string, error) {
+	kp, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return "", err
+	}
+	return s.ImportKeypair(kp)
+}
+
+// ImportMnemonic replaces the node identity with the one recovered from a
+// 24-word backup phrase, see ImportKeypair
+func (s *Libp2pNodeService) ImportMnemonic(mnemonic string) (string, error) {
+	kp, err := KeypairFromMnemonic(mnemonic)
+	if err != nil {
+		return "", err
+	}
+	return s.ImportKeypair(kp)
+}
+
+// ImportKeypair replaces the node identity, persists it and restarts the host
+// under the imported identity. It returns the new DID.
+func (s *Libp2pNodeService) ImportKeypair(kp Keypair) (string, error) {
+	s.rotateMu.Lock()
+	defer s.rotateMu.Unlock()
+	s.mu.RLock()
+	external := s.keypair.Signer != ""
+	s.mu.RUnlock()
+	if external {
+		// Importing would silently move the identity out of the HSM
+		return "", errExternalKey
+	}
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return "", err
+	}
+	if kp.PublicKey, err = priv.GetPublic().Raw(); err != nil {
+		return "", err
+	}
+	did, err := kp.DID()
+	if err != nil {
+		return "", err
+	}
+	if err := saveKeypair(s.cfg, kp); err != nil {
+		return "", err
+	}
+
+	// The previous DIDs belonged to the replaced identity
+	if err := s.restartAs(kp, did, nil, false); err != nil {
+		return "", fmt.Errorf("identity import failed: %w", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	log.Printf("[KeyPair] Imported identity %s", s.did)
+	return s.did, nil
+}
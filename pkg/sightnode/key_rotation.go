@@ -0,0 +1,303 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// rotationTopic carries signed key rotation records
+const rotationTopic = "sight-key-rotation"
+
+// rotationAnnounceDelay is how long the old host stays up after announcing a rotation
+const rotationAnnounceDelay = 2 * time.Second
+
+// restartAttempts and restartRetryDelay bound the restarts under a new
+// identity before the previous one is restored
+const (
+	restartAttempts   = 3
+	restartRetryDelay = time.Second
+)
+
+// KeyRotationRecord announces that OldDID moved to NewDID. It is signed by the
+// old key so peers can trust the new key without a central authority.
+type KeyRotationRecord struct {
+	OldDID       string `json:"oldDid"`
+	NewDID       string `json:"newDid"`
+	OldPublicKey []byte `json:"oldPublicKey"`
+	NewPublicKey []byte `json:"newPublicKey"`
+	RotatedAt    string `json:"rotatedAt"`
+	Signature    []byte `json:"signature,omitempty"`
+}
+
+// signingBytes is the record encoding covered by the signature
+func (r KeyRotationRecord) signingBytes() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// Verify checks the record signature against the old public key
+func (r KeyRotationRecord) Verify() error {
+	pub, err := DIDPublicKey(r.OldDID)
+	if err != nil {
+		return err
+	}
+	newPub, err := DIDPublicKey(r.NewDID)
+	if err != nil {
+		return err
+	}
+	oldRaw, _ := pub.Raw()
+	newRaw, _ := newPub.Raw()
+	if !bytes.Equal(oldRaw, r.OldPublicKey) || !bytes.Equal(newRaw, r.NewPublicKey) {
+		return errors.New("rotation record DIDs do not match its keys")
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, r.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid rotation record signature")
+	}
+	return nil
+}
+
+// rotationsFile keeps this node's own rotation history
+func rotationsFile(cfg Config) string {
+	return cfg.IdentityDir + "/key-rotations.json"
+}
+
+// loadPreviousDIDs returns the DIDs this node used before its last rotations
+func loadPreviousDIDs(cfg Config) []string {
+	data, err := os.ReadFile(rotationsFile(cfg))
+	if err != nil {
+		return nil
+	}
+	var records []KeyRotationRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		log.Printf("Error reading key rotation history: %v", err)
+		return nil
+	}
+	var dids []string
+	for _, r := range records {
+		dids = append(dids, r.OldDID)
+	}
+	return dids
+}
+
+func appendRotationRecord(cfg Config, record KeyRotationRecord) error {
+	var records []KeyRotationRecord
+	if data, err := os.ReadFile(rotationsFile(cfg)); err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+	}
+	records = append(records, record)
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rotationsFile(cfg), data, 0600)
+}
+
+// isOwnDID reports whether a message addressed to did belongs to this node,
+// including DIDs from before a key rotation
+func (s *Libp2pNodeService) isOwnDID(did string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if did == s.did {
+		return true
+	}
+	for _, prev := range s.previousDIDs {
+		if did == prev {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRotatedDID follows rotations announced by peers to their current DID
+func (s *Libp2pNodeService) resolveRotatedDID(did string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := 0; i < len(s.rotatedDIDs); i++ {
+		next, ok := s.rotatedDIDs[did]
+		if !ok {
+			break
+		}
+		did = next
+	}
+	return did
+}
+
+func (s *Libp2pNodeService) joinRotationTopic(ctx context.Context) error {
+	if err := s.registerTopicValidator(rotationTopic, nil); err != nil {
+		return err
+	}
+	topic, err := s.pubsub.Join(rotationTopic)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.rotationTopic = topic
+	go s.supervise(ctx, sub, func() (Subscription, error) { return topic.Subscribe() }, s.handleRotationRecord)
+	return nil
+}
+
+func (s *Libp2pNodeService) handleRotationRecord(msg *pubsub.Message) {
+	var record KeyRotationRecord
+	if err := json.Unmarshal(messageData(msg), &record); err != nil {
+		log.Printf("Invalid key rotation record: %v", err)
+		return
+	}
+	if err := record.Verify(); err != nil {
+		log.Printf("Rejected key rotation %s -> %s: %v", record.OldDID, record.NewDID, err)
+		return
+	}
+	s.mu.Lock()
+	s.rotatedDIDs[record.OldDID] = record.NewDID
+	s.mu.Unlock()
+	log.Printf("Peer rotated key: %s -> %s", record.OldDID, record.NewDID)
+}
+
+// RotateKey generates a new keypair, announces a rotation record signed by the
+// old key, persists the new key and restarts the host with the new identity
+func (s *Libp2pNodeService) RotateKey() (*KeyRotationRecord, error) {
+	s.rotateMu.Lock()
+	defer s.rotateMu.Unlock()
+	s.mu.RLock()
+	oldKp := s.keypair
+	previousDIDs := append(append([]string{}, s.previousDIDs...), s.did)
+	s.mu.RUnlock()
+	if oldKp.Signer != "" {
+		// The replacement key has to be created inside the signer
+		return nil, errExternalKey
+	}
+
+	oldPriv, err := oldKp.PrivKey()
+	if err != nil {
+		return nil, err
+	}
+	// The new key keeps the type of the old one
+	newKp, err := GenerateKeypairOfType(oldKp.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	oldDID, err := oldKp.DID()
+	if err != nil {
+		return nil, err
+	}
+	newDID, err := newKp.DID()
+	if err != nil {
+		return nil, err
+	}
+	record := KeyRotationRecord{
+		OldDID:       oldDID,
+		NewDID:       newDID,
+		OldPublicKey: oldKp.PublicKey,
+		NewPublicKey: newKp.PublicKey,
+		RotatedAt:    time.Now().Format(time.RFC3339),
+	}
+	data, err := record.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	if record.Signature, err = oldPriv.Sign(data); err != nil {
+		return nil, err
+	}
+
+	// Persist before announcing so a crash can't leave peers pointing at a lost key
+	if err := saveKeypair(s.cfg, newKp); err != nil {
+		return nil, err
+	}
+	if err := appendRotationRecord(s.cfg, record); err != nil {
+		log.Printf("Error saving key rotation history: %v", err)
+	}
+
+	data, err = json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.publishTopic(context.Background(), s.rotationTopic, data); err != nil {
+		log.Printf("Error publishing key rotation: %v", err)
+	}
+	// Give gossipsub a moment to push the record out before the host goes away
+	time.Sleep(rotationAnnounceDelay)
+
+	if err := s.restartAs(newKp, newDID, previousDIDs, true); err != nil {
+		return nil, fmt.Errorf("key rotation failed: %w", err)
+	}
+	log.Printf("[KeyPair] Rotated to %s", record.NewDID)
+	return &record, nil
+}
+
+// restartAs stops the host and starts it again under kp, with did and
+// previousDIDs as the inbox DIDs of a hoster. The identity changes under
+// s.mu, but the restart runs without it, serialised by rotateMu, so API calls
+// and the host's own callbacks go on meanwhile. When the new identity does not
+// start, the previous one is restored, on disk too, and started again; an
+// announced did stays among its inboxes, as peers now address it.
+func (s *Libp2pNodeService) restartAs(kp Keypair, did string, previousDIDs []string, announced bool) error {
+	s.mu.Lock()
+	oldKp, oldDID, oldPrevious := s.keypair, s.did, s.previousDIDs
+	s.keypair = kp
+	if !s.isGateway {
+		s.did, s.previousDIDs = did, previousDIDs
+	}
+	parent, running := s.parentCtx, s.node != nil
+	s.mu.Unlock()
+
+	// A failed restart leaves no host to stop
+	if running {
+		s.Stop()
+	}
+	err := s.restart(parent)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Restart as %s failed, restoring the previous identity: %v", did, err)
+
+	s.mu.Lock()
+	s.keypair, s.did, s.previousDIDs = oldKp, oldDID, oldPrevious
+	if announced && !s.isGateway {
+		s.previousDIDs = append(append([]string{}, oldPrevious...), did)
+	}
+	s.mu.Unlock()
+	if serr := saveKeypair(s.cfg, oldKp); serr != nil {
+		log.Printf("Error restoring the previous keypair: %v", serr)
+	}
+	if rerr := s.restart(parent); rerr != nil {
+		return fmt.Errorf("the node failed to restart: %w; with the previous identity: %v", err, rerr)
+	}
+	return fmt.Errorf("the node failed to restart, the previous identity was restored: %w", err)
+}
+
+// restart starts the host, retrying failures that are not fatal, e.g. a
+// listen port the stopped host has not released yet
+func (s *Libp2pNodeService) restart(parent context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := s.InitNode(parent)
+		if err == nil || IsFatalStartupError(err) || attempt == restartAttempts {
+			return err
+		}
+		log.Printf("Restart attempt %d failed: %v", attempt, err)
+		select {
+		case <-parent.Done():
+			return err
+		case <-time.After(restartRetryDelay):
+		}
+	}
+}
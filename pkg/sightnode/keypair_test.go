@@ -0,0 +1,114 @@
+package sightnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrGenerateKeypairReturnsStartupErrors(t *testing.T) {
+	cfg := Config{IdentityDir: t.TempDir()}
+	if err := os.WriteFile(filepath.Join(cfg.IdentityDir, "device-keystore.json"), []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadOrGenerateKeypair(cfg)
+	if err == nil {
+		t.Fatal("corrupted keystore was loaded")
+	}
+	if !IsFatalStartupError(err) {
+		t.Errorf("error %v is not a fatal startup error", err)
+	}
+}
+
+func TestLoadOrGenerateKeypairUsesConfig(t *testing.T) {
+	// The environment must not leak into an embedder's configuration
+	t.Setenv("KEYSTORE_PASSPHRASE", "from the environment")
+	cfg := Config{IdentityDir: t.TempDir(), KeyType: "secp256k1", KeystorePassphrase: "secret"}
+
+	kp, err := LoadOrGenerateKeypair(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kp.KeyType != "secp256k1" {
+		t.Errorf("generated a %q key, want secp256k1", kp.KeyType)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.IdentityDir, "device-keystore.json")); err != nil {
+		t.Fatalf("no encrypted keystore in the identity dir: %v", err)
+	}
+
+	again, err := LoadOrGenerateKeypair(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Seed, kp.Seed) {
+		t.Error("reloaded a different key")
+	}
+	cfg.KeystorePassphrase = "wrong"
+	if _, err := LoadOrGenerateKeypair(cfg); err == nil {
+		t.Error("keystore unlocked with the wrong passphrase")
+	}
+}
+
+func TestLoadOrGenerateKeypairProtectsPlaintextKey(t *testing.T) {
+	cfg := Config{IdentityDir: t.TempDir()}
+	kp, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(cfg.IdentityDir, "device-keypair.json")
+	keystoreFile := filepath.Join(cfg.IdentityDir, "device-keystore.json")
+	writeWorldReadable := func() {
+		t.Helper()
+		if err := os.WriteFile(keyFile, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(keyFile, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Plaintext mode keeps the file, readable by its owner only
+	writeWorldReadable()
+	if _, err := LoadOrGenerateKeypair(cfg); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("plaintext keypair mode %v, want 0600", perm)
+	}
+
+	// A passphrase migrates it into the keystore and removes it
+	cfg.KeystorePassphrase = "secret"
+	loaded, err := LoadOrGenerateKeypair(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Seed, kp.Seed) {
+		t.Error("migration changed the key")
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("plaintext keypair still present after migration: %v", err)
+	}
+
+	// A plaintext copy left beside the keystore goes on the next start
+	writeWorldReadable()
+	if _, err := LoadOrGenerateKeypair(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("leftover plaintext keypair not removed: %v", err)
+	}
+	if _, err := os.Stat(keystoreFile); err != nil {
+		t.Fatal(err)
+	}
+}
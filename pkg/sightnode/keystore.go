@@ -0,0 +1,183 @@
+package sightnode
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// unlockedPassphrase is kept after unlocking so the keystore can be re-written
+// (e.g. on key rotation) without prompting again
+var unlockedPassphrase string
+
+// scrypt parameters for newly written keystores
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+)
+
+// EncryptedKeystore is the on-disk format of a passphrase protected keypair:
+// the JSON encoded Keypair sealed with XChaCha20-Poly1305 under a scrypt key
+type EncryptedKeystore struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsed   string `json:"lastUsed"`
+}
+
+// keystorePassphrase returns cfg.KeystorePassphrase, or prompts for it on the
+// terminal when required is set. An empty result means plaintext mode.
+func keystorePassphrase(cfg Config, required bool) (string, error) {
+	if pass := cfg.KeystorePassphrase; pass != "" {
+		return pass, nil
+	}
+	if !required {
+		return "", nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("keystore is encrypted: set KEYSTORE_PASSPHRASE or run interactively")
+	}
+	fmt.Fprint(os.Stderr, "Keystore passphrase: ")
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(pass) == 0 {
+		return "", errors.New("empty keystore passphrase")
+	}
+	return string(pass), nil
+}
+
+// EncryptKeypair seals a keypair with the given passphrase
+func EncryptKeypair(kp Keypair, passphrase string) (*EncryptedKeystore, error) {
+	plain, err := json.Marshal(kp)
+	if err != nil {
+		return nil, err
+	}
+	ks := &EncryptedKeystore{
+		Version:   1,
+		KDF:       "scrypt",
+		Salt:      make([]byte, 32),
+		N:         keystoreScryptN,
+		R:         keystoreScryptR,
+		P:         keystoreScryptP,
+		Nonce:     make([]byte, chacha20poly1305.NonceSizeX),
+		CreatedAt: kp.CreatedAt,
+		LastUsed:  kp.LastUsed,
+	}
+	if _, err := rand.Read(ks.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(ks.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, plain, nil)
+	return ks, nil
+}
+
+// Decrypt opens the keystore with the given passphrase
+func (ks *EncryptedKeystore) Decrypt(passphrase string) (Keypair, error) {
+	var kp Keypair
+	if ks.KDF != "scrypt" {
+		return kp, fmt.Errorf("unsupported keystore kdf %q", ks.KDF)
+	}
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return kp, err
+	}
+	plain, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, nil)
+	if err != nil {
+		return kp, errors.New("wrong passphrase or corrupted keystore")
+	}
+	err = json.Unmarshal(plain, &kp)
+	return kp, err
+}
+
+// aead derives the keystore key from the passphrase
+func (ks *EncryptedKeystore) aead(passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), ks.Salt, ks.N, ks.R, ks.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// writeKeystore stores the keystore readable by the owner only
+func writeKeystore(path string, ks *EncryptedKeystore) error {
+	data, err := json.Marshal(ks)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// loadEncryptedKeypair unlocks the encrypted keystore at path
+func loadEncryptedKeypair(cfg Config, path string) (Keypair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Keypair{}, fatalStartup("reading keystore", err)
+	}
+	var ks EncryptedKeystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return Keypair{}, fatalStartup("unmarshalling keystore", err)
+	}
+	passphrase, err := keystorePassphrase(cfg, true)
+	if err != nil {
+		return Keypair{}, fatalStartup("reading keystore passphrase", err)
+	}
+	kp, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return Keypair{}, fatalStartup("unlocking keystore", err)
+	}
+	unlockedPassphrase = passphrase
+	ks.LastUsed = time.Now().Format(time.RFC3339)
+	if err := writeKeystore(path, &ks); err != nil {
+		return Keypair{}, fatalStartup("writing updated keystore", err)
+	}
+	kp.LastUsed = ks.LastUsed
+	log.Printf("[KeyPair] Unlocked keystore %s", path)
+	return kp, nil
+}
+
+// saveEncryptedKeypair encrypts kp to path and, when migrating, removes the
+// old plaintext file
+func saveEncryptedKeypair(path string, kp Keypair, passphrase string, plaintextFile string) error {
+	unlockedPassphrase = passphrase
+	ks, err := EncryptKeypair(kp, passphrase)
+	if err != nil {
+		return fatalStartup("encrypting keypair", err)
+	}
+	if err := writeKeystore(path, ks); err != nil {
+		return fatalStartup("writing keystore", err)
+	}
+	if plaintextFile != "" {
+		// Once the keystore is written the plaintext file must go, or the
+		// next start removes it
+		if err := removePlaintextKeypair(plaintextFile); err != nil {
+			return fatalStartup("removing plaintext keypair after migration", err)
+		}
+		log.Printf("[KeyPair] Migrated %s to encrypted keystore %s", plaintextFile, path)
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	"github.com/mr-tron/base58" // Correct base58 package import
+)
+
+// Keypair struct to hold the keypair data. Seed is the raw private key of
+// KeyType (ed25519 when empty, secp256k1 or rsa). When Signer is set the
+// private key stays with the external signer on that socket and Seed is empty.
+type Keypair struct {
+	KeyType   string `json:"keyType,omitempty"`
+	Seed      []byte `json:"seed"`
+	CreatedAt string `json:"createdAt"`
+	LastUsed  string `json:"lastUsed"`
+	PublicKey []byte `json:"publicKey,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+
+	signer *externalSigner
+}
+
+// LoadOrGenerateKeypair function for loading or generating the keypair of
+// cfg.IdentityDir. When cfg.KeystorePassphrase is set (or an encrypted
+// keystore already exists) the keypair is kept encrypted in
+// device-keystore.json; an existing plaintext device-keypair.json is migrated
+// into it. With cfg.SignerSocket set, no key file is used and signing is
+// delegated to the external signer. Failures are StartupErrors, fatal unless
+// the signer may come up on a retry.
+func LoadOrGenerateKeypair(cfg Config) (Keypair, error) {
+	if socket := cfg.SignerSocket; socket != "" {
+		kp, err := ExternalKeypair(socket)
+		if err != nil {
+			return Keypair{}, startupFailure("connecting to external signer", err)
+		}
+		log.Printf("[KeyPair] Using external signer at %s", socket)
+		return kp, nil
+	}
+
+	keyDir := cfg.IdentityDir
+	keyFile := keyDir + "/device-keypair.json"
+	keystoreFile := keyDir + "/device-keystore.json"
+
+	// An encrypted keystore always takes precedence
+	if _, err := os.Stat(keystoreFile); err == nil {
+		kp, err := loadEncryptedKeypair(cfg, keystoreFile)
+		if err != nil {
+			return Keypair{}, err
+		}
+		// A plaintext copy left by an interrupted migration goes now
+		if err := removePlaintextKeypair(keyFile); err != nil {
+			return Keypair{}, fatalStartup("removing plaintext keypair", err)
+		}
+		return kp, nil
+	}
+	passphrase, err := keystorePassphrase(cfg, cfg.KeystoreEncrypt)
+	if err != nil {
+		return Keypair{}, fatalStartup("reading keystore passphrase", err)
+	}
+
+	// Check if the keypair file exists
+	if _, err := os.Stat(keyFile); err == nil {
+		// Older versions wrote the seed world-readable
+		if err := protectKeyFile(keyFile); err != nil {
+			return Keypair{}, fatalStartup("restricting keypair permissions", err)
+		}
+		// Read keypair from the file
+		kpStr, err := os.ReadFile(keyFile)
+		if err != nil {
+			return Keypair{}, fatalStartup("reading keypair", err)
+		}
+		var kp Keypair
+		err = json.Unmarshal(kpStr, &kp)
+		if err != nil {
+			return Keypair{}, fatalStartup("unmarshalling keypair", err)
+		}
+		kp.LastUsed = time.Now().Format(time.RFC3339)
+		if passphrase != "" {
+			return kp, saveEncryptedKeypair(keystoreFile, kp, passphrase, keyFile)
+		}
+		kpStr, err = json.Marshal(kp)
+		if err != nil {
+			return Keypair{}, fatalStartup("marshalling updated keypair", err)
+		}
+		err = os.WriteFile(keyFile, kpStr, 0600)
+		if err != nil {
+			return Keypair{}, fatalStartup("writing updated keypair", err)
+		}
+		log.Printf("[KeyPair] Loaded from %s", keyFile)
+		return kp, nil
+	} else {
+		// Generate a new keypair, or recover one from its backup phrase
+		kp, err := GenerateKeypairOfType(cfg.KeyType)
+		origin := "Generated new"
+		if mnemonic := cfg.KeyMnemonic; mnemonic != "" {
+			kp, err = KeypairFromMnemonic(mnemonic)
+			origin = "Recovered from mnemonic"
+		}
+		if err != nil {
+			return Keypair{}, fatalStartup("generating keypair", err)
+		}
+		_ = os.MkdirAll(keyDir, 0700)
+		if passphrase != "" {
+			if err := saveEncryptedKeypair(keystoreFile, kp, passphrase, ""); err != nil {
+				return Keypair{}, err
+			}
+			log.Printf("[KeyPair] %s and saved to %s", origin, keystoreFile)
+			return kp, nil
+		}
+		kpStr, err := json.Marshal(kp)
+		if err != nil {
+			return Keypair{}, fatalStartup("marshalling keypair", err)
+		}
+		err = os.WriteFile(keyFile, kpStr, 0600)
+		if err != nil {
+			return Keypair{}, fatalStartup("writing keypair to file", err)
+		}
+		log.Printf("[KeyPair] %s and saved to %s", origin, keyFile)
+		return kp, nil
+	}
+}
+
+// protectKeyFile makes a key file readable by its owner only
+func protectKeyFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 == 0 {
+		return nil
+	}
+	log.Printf("[KeyPair] %s was readable by other users (%v), restricting it to 0600", path, info.Mode().Perm())
+	return os.Chmod(path, 0600)
+}
+
+// removePlaintextKeypair deletes a plaintext keypair file, if any. Should
+// that fail it is at least made private.
+func removePlaintextKeypair(path string) error {
+	err := os.Remove(path)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	if cerr := protectKeyFile(path); cerr != nil {
+		log.Printf("[KeyPair] Error restricting %s: %v", path, cerr)
+	}
+	return err
+}
+
+// GenerateKeypair creates a fresh Ed25519 keypair
+func GenerateKeypair() (Keypair, error) {
+	return GenerateKeypairOfType("")
+}
+
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// GenerateKeypairOfType creates a fresh keypair of the named type:
+// ed25519 (the default when empty), secp256k1 or rsa
+func GenerateKeypairOfType(keyType string) (Keypair, error) {
+	t, err := keyTypeByName(keyType)
+	if err != nil {
+		return Keypair{}, err
+	}
+	priv, pub, err := crypto.GenerateKeyPair(int(t.keyType), rsaKeyBits)
+	if err != nil {
+		return Keypair{}, err
+	}
+	privBytes, err := priv.Raw()
+	if err != nil {
+		return Keypair{}, err
+	}
+	pubBytes, err := pub.Raw()
+	if err != nil {
+		return Keypair{}, err
+	}
+	now := time.Now().Format(time.RFC3339)
+	kp := Keypair{
+		Seed:      privBytes,
+		CreatedAt: now,
+		LastUsed:  now,
+		PublicKey: pubBytes,
+	}
+	// Ed25519 keypairs keep the original format without a key type
+	if t.keyType != crypto.Ed25519 {
+		kp.KeyType = t.name
+	}
+	return kp, nil
+}
+
+// SaveKeypair replaces the stored device keypair of cfg.IdentityDir, keeping
+// it encrypted when the node runs with an encrypted keystore
+func SaveKeypair(cfg Config, kp Keypair) error {
+	return saveKeypair(cfg, kp)
+}
+
+func saveKeypair(cfg Config, kp Keypair) error {
+	keyDir := cfg.IdentityDir
+	keyFile := keyDir + "/device-keypair.json"
+	keystoreFile := keyDir + "/device-keystore.json"
+
+	_, err := os.Stat(keystoreFile)
+	encrypted := err == nil
+	passphrase := unlockedPassphrase
+	if passphrase == "" {
+		passphrase, _ = keystorePassphrase(cfg, false)
+	}
+	if encrypted || passphrase != "" {
+		if passphrase == "" {
+			return errors.New("keystore passphrase is not available")
+		}
+		ks, err := EncryptKeypair(kp, passphrase)
+		if err != nil {
+			return err
+		}
+		return writeKeystore(keystoreFile, ks)
+	}
+	kpStr, err := json.Marshal(kp)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, kpStr, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return protectKeyFile(keyFile)
+}
+
+// PrivKey returns the libp2p private key for the keypair
+func (kp Keypair) PrivKey() (crypto.PrivKey, error) {
+	if kp.signer != nil {
+		return kp.signer, nil
+	}
+	if kp.Signer != "" {
+		return newExternalSigner(kp.Signer)
+	}
+	t, err := keyTypeByName(kp.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	switch t.keyType {
+	case crypto.Secp256k1:
+		return crypto.UnmarshalSecp256k1PrivateKey(kp.Seed)
+	case crypto.RSA:
+		return crypto.UnmarshalRsaPrivateKey(kp.Seed)
+	default:
+		return crypto.UnmarshalEd25519PrivateKey(kp.Seed)
+	}
+}
+
+// DID returns the hoster DID of the keypair
+func (kp Keypair) DID() (string, error) {
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return "", err
+	}
+	return PubKeyToDID(priv.GetPublic())
+}
+
+// getDataDir gets the directory where the configuration is stored
+func getDataDir() string {
+	if dir := os.Getenv("SIGHTAI_DATA_DIR"); dir != "" {
+		return dir + "/config"
+	}
+	return os.Getenv("HOME") + "/.sightai/config"
+}
+
+// CreateLibp2pNode creates a libp2p node with the given identity and returns
+// the host and pubsub service. Errors are *StartupError.
+func CreateLibp2pNode(ctx context.Context, cfg Config, priv crypto.PrivKey, psOpts []pubsub.Option, extraOpts ...libp2p.Option) (hostlibp2p.Host, *pubsub.PubSub, error) {
+	stackOpts, err := securityMuxerOptions(cfg)
+	if err != nil {
+		return nil, nil, fatalStartup("invalid security/muxer config", err)
+	}
+	connMgr, err := connmgr.NewConnManager(cfg.ConnLowWater, cfg.ConnHighWater, connmgr.WithGracePeriod(cfg.ConnGracePeriod))
+	if err != nil {
+		return nil, nil, fatalStartup("invalid connection manager config", err)
+	}
+	resourceMgr, err := NewResourceManager(cfg)
+	if err != nil {
+		return nil, nil, fatalStartup("invalid resource manager config", err)
+	}
+	psk, err := loadSwarmKey(cfg)
+	if err != nil {
+		return nil, nil, fatalStartup("loading swarm key", err)
+	}
+	opts := []libp2p.Option{
+		stackOpts,
+		libp2p.ListenAddrStrings(listenAddrs(cfg)...),
+		libp2p.Identity(priv),
+		libp2p.UserAgent(userAgent(cfg)),
+		libp2p.ConnectionManager(connMgr),
+		libp2p.ResourceManager(resourceMgr),
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	announceOpt, err := addrsFactory(cfg)
+	if err != nil {
+		return nil, nil, fatalStartup("invalid announce config", err)
+	}
+	if announceOpt != nil {
+		opts = append(opts, announceOpt)
+	}
+	if listensWSS(cfg) || psk != nil {
+		transportOpt, err := transportOptions(cfg, psk)
+		if err != nil {
+			return nil, nil, fatalStartup("invalid transport config", err)
+		}
+		opts = append(opts, transportOpt)
+	}
+	opts = append(opts, extraOpts...)
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, nil, startupFailure("creating libp2p host", err)
+	}
+	log.Printf("Libp2p Host created with peer ID: %s", h.ID())
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			st := c.ConnState()
+			debugf("Connection to %s via %s: transport=%s security=%s muxer=%s",
+				c.RemotePeer(), c.RemoteMultiaddr(), st.Transport, st.Security, st.StreamMultiplexer)
+		},
+	})
+
+	pubsubService, err := newGossipSub(ctx, cfg, h, psOpts)
+	if err != nil {
+		h.Close()
+		return nil, nil, err
+	}
+
+	return h, pubsubService, nil
+}
+
+// NewGossipSub creates the gossipsub router of a node on h with the
+// configured mesh parameters, for HostFactory implementations. psOpts are
+// the options the factory received.
+func NewGossipSub(ctx context.Context, cfg Config, h hostlibp2p.Host, psOpts []pubsub.Option) (PubSub, error) {
+	ps, err := newGossipSub(ctx, cfg, h, psOpts)
+	if err != nil {
+		return nil, err
+	}
+	return WrapPubSub(ps), nil
+}
+
+// newGossipSub starts the gossipsub router of h with the configured mesh
+// parameters. Errors are *StartupError.
+func newGossipSub(ctx context.Context, cfg Config, h hostlibp2p.Host, psOpts []pubsub.Option) (*pubsub.PubSub, error) {
+	params, err := gossipSubParams(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid gossipsub parameters", err)
+	}
+	psOpts = append([]pubsub.Option{
+		pubsub.WithMessageSignaturePolicy(pubsub.StrictSign),
+		pubsub.WithGossipSubParams(params),
+	}, psOpts...)
+	ps, err := pubsub.NewGossipSub(ctx, h, psOpts...)
+	if err != nil {
+		return nil, startupFailure("creating pubsub service", err)
+	}
+	return ps, nil
+}
+
+// gossipSubParams applies the configured mesh parameters on top of the libp2p
+// defaults. Dout and Dscore are lowered when a small mesh would violate their
+// bounds.
+func gossipSubParams(cfg Config) (pubsub.GossipSubParams, error) {
+	params := pubsub.DefaultGossipSubParams()
+	params.D = cfg.GossipSubD
+	params.Dlo = cfg.GossipSubDlo
+	params.Dhi = cfg.GossipSubDhi
+	params.HeartbeatInterval = cfg.GossipSubHeartbeat
+	params.FanoutTTL = cfg.GossipSubFanoutTTL
+	params.HistoryLength = cfg.GossipSubHistoryLength
+	params.HistoryGossip = cfg.GossipSubHistoryGossip
+
+	if params.D <= 0 || params.Dlo > params.D || params.D > params.Dhi {
+		return params, fmt.Errorf("mesh degree must satisfy 0 < GOSSIPSUB_DLO <= GOSSIPSUB_D <= GOSSIPSUB_DHI (got %d/%d/%d)",
+			params.Dlo, params.D, params.Dhi)
+	}
+	if params.HeartbeatInterval <= 0 || params.FanoutTTL <= 0 {
+		return params, fmt.Errorf("GOSSIPSUB_HEARTBEAT and GOSSIPSUB_FANOUT_TTL must be positive")
+	}
+	if params.HistoryGossip <= 0 || params.HistoryGossip > params.HistoryLength {
+		return params, fmt.Errorf("GOSSIPSUB_HISTORY_GOSSIP must be between 1 and GOSSIPSUB_HISTORY_LENGTH")
+	}
+	if params.Dout >= params.Dlo || params.Dout > params.D/2 {
+		params.Dout = min(params.Dlo-1, params.D/2)
+	}
+	if params.Dscore > params.Dhi {
+		params.Dscore = params.Dhi
+	}
+	return params, nil
+}
+
+// securityMuxerOptions builds the security transport and stream muxer options
+// from the configured names, keeping their order as the negotiation preference
+func securityMuxerOptions(cfg Config) (libp2p.Option, error) {
+	var opts []libp2p.Option
+	for _, name := range cfg.SecurityTransports {
+		switch strings.ToLower(name) {
+		case "noise":
+			opts = append(opts, libp2p.Security(noise.ID, noise.New))
+		case "tls":
+			opts = append(opts, libp2p.Security(libp2ptls.ID, libp2ptls.New))
+		default:
+			return nil, fmt.Errorf("unknown security transport %q", name)
+		}
+	}
+	for _, name := range cfg.Muxers {
+		switch strings.ToLower(name) {
+		case "yamux":
+			opts = append(opts, libp2p.Muxer(yamux.ID, yamux.DefaultTransport))
+		case "mplex":
+			opts = append(opts, libp2p.Muxer(mplexID, mplexTransport{}))
+		default:
+			return nil, fmt.Errorf("unknown muxer %q", name)
+		}
+	}
+	return libp2p.ChainOptions(opts...), nil
+}
+
+// listenAddrs returns LISTEN_ADDRS when set, otherwise the TCP listen address
+// plus any enabled WebSocket ones
+func listenAddrs(cfg Config) []string {
+	if len(cfg.ListenAddrs) > 0 {
+		return cfg.ListenAddrs
+	}
+	addrs := []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.NodePort)}
+	if cfg.WSPort != 0 {
+		addrs = append(addrs, fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", cfg.WSPort))
+	}
+	if cfg.WSSPort != 0 {
+		addrs = append(addrs, fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/tls/ws", cfg.WSSPort))
+	}
+	return addrs
+}
+
+// listensWSS reports whether any listen address is a secure WebSocket
+func listensWSS(cfg Config) bool {
+	for _, addr := range listenAddrs(cfg) {
+		if strings.Contains(addr, "/tls/ws") || strings.HasSuffix(addr, "/wss") || strings.Contains(addr, "/wss/") {
+			return true
+		}
+	}
+	return false
+}
+
+// transportOptions replaces the default transports so the WebSocket transport
+// can terminate TLS for /wss listeners with the configured cert, and leaves
+// out QUIC in a private network since it cannot use a pre-shared key
+func transportOptions(cfg Config, psk pnet.PSK) (libp2p.Option, error) {
+	ws := libp2p.Transport(websocket.New)
+	if listensWSS(cfg) {
+		if cfg.WSSCertFile == "" || cfg.WSSKeyFile == "" {
+			return nil, fmt.Errorf("NODE_WSS_CERT_FILE and NODE_WSS_KEY_FILE are required for /wss listeners")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.WSSCertFile, cfg.WSSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+		ws = libp2p.Transport(websocket.New, websocket.WithTLSConfig(tlsConf))
+	}
+	opts := []libp2p.Option{libp2p.Transport(tcp.NewTCPTransport), ws}
+	if psk == nil {
+		opts = append(opts, libp2p.Transport(quic.NewTransport))
+	}
+	return libp2p.ChainOptions(opts...), nil
+}
+
+// ToSightDID generates a DID for the node from a raw Ed25519 public key
+func ToSightDID(publicKey []byte) string {
+	multicodec := append(append([]byte{}, ed25519Multicodec...), publicKey...)
+	return sightDIDPrefix + base58.Encode(multicodec)
+}
@@ -0,0 +1,22 @@
+package sightnode
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// debugLogging enables verbose logs (LOG_LEVEL=debug); it can change on reload
+var debugLogging atomic.Bool
+
+// SetLogLevel switches debug logging on or off by level name
+func SetLogLevel(level string) {
+	debugLogging.Store(strings.EqualFold(level, "debug"))
+}
+
+// debugf logs only when debug logging is enabled
+func debugf(format string, args ...interface{}) {
+	if debugLogging.Load() {
+		log.Printf("[debug] "+format, args...)
+	}
+}
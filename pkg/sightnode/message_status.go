@@ -0,0 +1,103 @@
+package sightnode
+
+import (
+	"sync"
+	"time"
+)
+
+// Delivery states of an outgoing message
+const (
+	stateQueued    = "queued"
+	statePublished = "published"
+	// stateAcked is set when the recipient confirms delivery to its upstream
+	stateAcked  = "acked"
+	stateFailed = "failed"
+)
+
+// MessageStatus is returned by GET /v1/libp2p/message/{id}
+type MessageStatus struct {
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// statusTracker remembers the state of outgoing messages for a TTL
+type statusTracker struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	statuses  map[string]*MessageStatus
+	expires   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newStatusTracker(ttl time.Duration) *statusTracker {
+	return &statusTracker{
+		ttl:       ttl,
+		statuses:  make(map[string]*MessageStatus),
+		expires:   make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// Set records a new state for a message, creating its entry when needed
+func (t *statusTracker) Set(id, to, state string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastPrune) > t.ttl {
+		for k, exp := range t.expires {
+			if now.After(exp) {
+				delete(t.expires, k)
+				delete(t.statuses, k)
+			}
+		}
+		t.lastPrune = now
+	}
+
+	st, ok := t.statuses[id]
+	if !ok {
+		st = &MessageStatus{ID: id, To: to, CreatedAt: now.Format(time.RFC3339)}
+		t.statuses[id] = st
+	}
+	// A late publish result never downgrades an acknowledged message
+	if st.State == stateAcked && state != stateAcked {
+		return
+	}
+	st.State = state
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+	}
+	st.UpdatedAt = now.Format(time.RFC3339)
+	t.expires[id] = now.Add(t.ttl)
+}
+
+// RemoveTo forgets the messages sent to did
+func (t *statusTracker) RemoveTo(did string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := 0
+	for id, st := range t.statuses {
+		if st.To == did {
+			delete(t.statuses, id)
+			delete(t.expires, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Get returns the status of a message
+func (t *statusTracker) Get(id string) (MessageStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.statuses[id]
+	if !ok || time.Now().After(t.expires[id]) {
+		return MessageStatus{}, false
+	}
+	return *st, true
+}
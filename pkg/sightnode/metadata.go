@@ -0,0 +1,186 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+)
+
+// Version is the node software version, set at build time with
+// -ldflags "-X sight-libp2p-node/pkg/sightnode.Version=v1.2.3"
+var Version = "dev"
+
+// metadataProtocol serves the node's signed PeerMetadata record
+const metadataProtocol protocol.ID = "/sight/meta/1.0.0"
+
+// metadataPeerstoreKey stores verified remote metadata in the peerstore
+const metadataPeerstoreKey = "sight/metadata"
+
+const maxMetadataSize = 4096
+
+// PeerMetadata is the role information a node attaches to identify. It is
+// sealed in a libp2p signed envelope with the host key, so the remote PeerID
+// authenticates the role it claims.
+type PeerMetadata struct {
+	DID      string `json:"did"`
+	Role     string `json:"role"`
+	Version  string `json:"version"`
+	IssuedAt int64  `json:"issuedAt"`
+}
+
+func init() {
+	// The persistent peerstore gob-encodes metadata values
+	gob.Register(PeerMetadata{})
+}
+
+// Domain and Codec make PeerMetadata a libp2p record.Record
+func (m *PeerMetadata) Domain() string { return "sight-peer-metadata" }
+
+func (m *PeerMetadata) Codec() []byte { return []byte("/sight/peer-metadata") }
+
+func (m *PeerMetadata) MarshalRecord() ([]byte, error) { return json.Marshal(m) }
+
+func (m *PeerMetadata) UnmarshalRecord(data []byte) error { return json.Unmarshal(data, m) }
+
+// nodeRole names the node's role: bootstrap, gateway or hoster
+func nodeRole(cfg Config) string {
+	switch {
+	case cfg.IsBootstrap:
+		return "bootstrap"
+	case cfg.IsGateway:
+		return "gateway"
+	default:
+		return "hoster"
+	}
+}
+
+// userAgent is the identify agent version, e.g. "sight-libp2p-node/dev (gateway)"
+func userAgent(cfg Config) string {
+	return fmt.Sprintf("sight-libp2p-node/%s (%s)", Version, nodeRole(cfg))
+}
+
+// handleMetadataStream answers with a freshly sealed metadata envelope
+func (s *Libp2pNodeService) handleMetadataStream(stream network.Stream) {
+	defer stream.Close()
+	s.mu.RLock()
+	did, node := s.did, s.node
+	s.mu.RUnlock()
+	meta := &PeerMetadata{
+		DID:      did,
+		Role:     nodeRole(s.cfg),
+		Version:  Version,
+		IssuedAt: time.Now().Unix(),
+	}
+	env, err := record.Seal(meta, node.Peerstore().PrivKey(node.ID()))
+	if err != nil {
+		log.Printf("Error sealing peer metadata: %v", err)
+		stream.Reset()
+		return
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	stream.Write(data)
+}
+
+// fetchMetadata requests a peer's metadata envelope and accepts it only when
+// it is signed by that peer's key
+func fetchMetadata(ctx context.Context, h hostlibp2p.Host, p peer.ID) (*PeerMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	stream, err := h.NewStream(ctx, p, metadataProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	data, err := io.ReadAll(io.LimitReader(stream, maxMetadataSize))
+	if err != nil {
+		return nil, err
+	}
+	meta := &PeerMetadata{}
+	env, err := record.ConsumeTypedEnvelope(data, meta)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if signer != p {
+		return nil, fmt.Errorf("metadata signed by %s, not %s", signer, p)
+	}
+	return meta, nil
+}
+
+// watchMetadata fetches the metadata of every peer whose identify lists the
+// metadata protocol and keeps it in the peerstore
+func (s *Libp2pNodeService) watchMetadata(ctx context.Context, h hostlibp2p.Host) error {
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				if !supportsProtocol(evt.Protocols, metadataProtocol) {
+					continue
+				}
+				go func(p peer.ID) {
+					meta, err := fetchMetadata(ctx, h, p)
+					if err != nil {
+						debugf("No metadata from %s: %v", p, err)
+						return
+					}
+					if err := h.Peerstore().Put(p, metadataPeerstoreKey, *meta); err != nil {
+						log.Printf("Error storing metadata of %s: %v", p, err)
+					}
+				}(evt.Peer)
+			}
+		}
+	}()
+	return nil
+}
+
+func supportsProtocol(protos []protocol.ID, want protocol.ID) bool {
+	for _, p := range protos {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+// peerMetadata returns the verified metadata of a peer, if it was fetched
+func peerMetadata(h hostlibp2p.Host, p peer.ID) *PeerMetadata {
+	v, err := h.Peerstore().Get(p, metadataPeerstoreKey)
+	if err != nil {
+		return nil
+	}
+	meta, ok := v.(PeerMetadata)
+	if !ok {
+		return nil
+	}
+	return &meta
+}
@@ -0,0 +1,45 @@
+package sightnode
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// The 32-byte Ed25519 seed is used directly as BIP39 entropy, which gives a
+// 24-word mnemonic that maps back to exactly the same key. (This is not the
+// BIP39 PBKDF2 wallet seed derivation, which cannot be reversed.)
+
+// Mnemonic returns the 24-word backup phrase of the keypair
+func (kp Keypair) Mnemonic() (string, error) {
+	if kp.Signer != "" {
+		return "", errExternalKey
+	}
+	if kp.KeyType != "" || len(kp.Seed) != ed25519.PrivateKeySize {
+		return "", errors.New("keypair has no Ed25519 seed")
+	}
+	return bip39.NewMnemonic(kp.Seed[:ed25519.SeedSize])
+}
+
+// KeypairFromMnemonic recovers a keypair from its 24-word backup phrase
+func KeypairFromMnemonic(mnemonic string) (Keypair, error) {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	entropy, err := bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return Keypair{}, err
+	}
+	if len(entropy) != ed25519.SeedSize {
+		return Keypair{}, errors.New("mnemonic must have 24 words")
+	}
+	priv := ed25519.NewKeyFromSeed(entropy)
+	now := time.Now().Format(time.RFC3339)
+	return Keypair{
+		Seed:      priv,
+		CreatedAt: now,
+		LastUsed:  now,
+		PublicKey: priv.Public().(ed25519.PublicKey),
+	}, nil
+}
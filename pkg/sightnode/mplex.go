@@ -0,0 +1,111 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mp "github.com/libp2p/go-mplex"
+)
+
+// mplexID is the protocol ID of the mplex stream muxer. go-libp2p dropped
+// its mplex transport, so older peers that only speak mplex are served by
+// this adapter over go-mplex.
+const mplexID = "/mplex/6.7.0"
+
+// mplexTransport is a network.Multiplexer over go-mplex
+type mplexTransport struct{}
+
+func (mplexTransport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	// The peer scope accounts for the stream buffers, like it does for yamux
+	var mm mp.MemoryManager
+	if scope != nil {
+		mm = scope
+	}
+	m, err := mp.NewMultiplex(nc, isServer, mm)
+	if err != nil {
+		return nil, err
+	}
+	return (*mplexConn)(m), nil
+}
+
+type mplexConn mp.Multiplex
+
+func (c *mplexConn) mplex() *mp.Multiplex {
+	return (*mp.Multiplex)(c)
+}
+
+func (c *mplexConn) Close() error {
+	return c.mplex().Close()
+}
+
+// CloseWithError closes the connection; mplex has no way to send the code
+func (c *mplexConn) CloseWithError(network.ConnErrorCode) error {
+	return c.Close()
+}
+
+func (c *mplexConn) IsClosed() bool {
+	return c.mplex().IsClosed()
+}
+
+func (c *mplexConn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	s, err := c.mplex().NewStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*mplexStream)(s), nil
+}
+
+func (c *mplexConn) AcceptStream() (network.MuxedStream, error) {
+	s, err := c.mplex().Accept()
+	if err != nil {
+		return nil, err
+	}
+	return (*mplexStream)(s), nil
+}
+
+type mplexStream mp.Stream
+
+func (s *mplexStream) mplex() *mp.Stream {
+	return (*mp.Stream)(s)
+}
+
+// resetErr reports mplex stream resets as network.ErrReset
+func resetErr(err error) error {
+	if errors.Is(err, mp.ErrStreamReset) {
+		return network.ErrReset
+	}
+	return err
+}
+
+func (s *mplexStream) Read(b []byte) (int, error) {
+	n, err := s.mplex().Read(b)
+	return n, resetErr(err)
+}
+
+func (s *mplexStream) Write(b []byte) (int, error) {
+	n, err := s.mplex().Write(b)
+	return n, resetErr(err)
+}
+
+func (s *mplexStream) Close() error      { return s.mplex().Close() }
+func (s *mplexStream) CloseWrite() error { return s.mplex().CloseWrite() }
+func (s *mplexStream) CloseRead() error  { return s.mplex().CloseRead() }
+func (s *mplexStream) Reset() error      { return s.mplex().Reset() }
+
+// ResetWithError resets the stream; mplex has no way to send the code
+func (s *mplexStream) ResetWithError(network.StreamErrorCode) error {
+	return s.Reset()
+}
+
+func (s *mplexStream) SetDeadline(t time.Time) error      { return s.mplex().SetDeadline(t) }
+func (s *mplexStream) SetReadDeadline(t time.Time) error  { return s.mplex().SetReadDeadline(t) }
+func (s *mplexStream) SetWriteDeadline(t time.Time) error { return s.mplex().SetWriteDeadline(t) }
+
+var (
+	_ network.Multiplexer = mplexTransport{}
+	_ network.MuxedConn   = (*mplexConn)(nil)
+	_ network.MuxedStream = (*mplexStream)(nil)
+)
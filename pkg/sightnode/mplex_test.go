@@ -0,0 +1,60 @@
+package sightnode
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestMplexOnlyHostsConnect(t *testing.T) {
+	cfg := Config{SecurityTransports: []string{"noise"}, Muxers: []string{"mplex"}}
+	newHost := func() hostlibp2p.Host {
+		stack, err := securityMuxerOptions(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := libp2p.New(stack, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	a, b := newHost(), newHost()
+	b.SetStreamHandler("/sight/echo", func(st network.Stream) {
+		defer st.Close()
+		io.Copy(st, st)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	conns := a.Network().ConnsToPeer(b.ID())
+	if len(conns) == 0 || conns[0].ConnState().StreamMultiplexer != mplexID {
+		t.Fatalf("connections %v, want one muxed by %s", conns, mplexID)
+	}
+	st, err := a.NewStream(ctx, b.ID(), "/sight/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := st.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	st.CloseWrite()
+	got, err := io.ReadAll(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("echoed %q, want ping", got)
+	}
+}
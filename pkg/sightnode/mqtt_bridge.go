@@ -0,0 +1,213 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Directions of bridged messages
+const (
+	mqttToBroker = "to_mqtt"
+	mqttToMesh   = "to_libp2p"
+)
+
+// mqttPublishTimeout bounds how long a forwarded message waits for the broker
+const mqttPublishTimeout = 10 * time.Second
+
+var mqttBridged = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sight_mqtt_bridged_total",
+	Help: "Messages bridged between pubsub and MQTT, per direction and outcome",
+}, []string{"direction", "outcome"})
+
+// mqttMapping ties a pubsub topic to an MQTT topic in one or both directions
+type mqttMapping struct {
+	libp2p   string
+	mqtt     string
+	toBroker bool
+	toMesh   bool
+}
+
+// parseMQTTMapping parses libp2p=mqtt, libp2p>mqtt or libp2p<mqtt
+func parseMQTTMapping(s string) (mqttMapping, error) {
+	i := strings.IndexAny(s, "=<>")
+	if i <= 0 || i == len(s)-1 {
+		return mqttMapping{}, fmt.Errorf("invalid MQTT topic mapping %q: want libp2p=mqtt, libp2p>mqtt or libp2p<mqtt", s)
+	}
+	m := mqttMapping{
+		libp2p:   strings.TrimSpace(s[:i]),
+		mqtt:     strings.TrimSpace(s[i+1:]),
+		toBroker: s[i] != '<',
+		toMesh:   s[i] != '>',
+	}
+	// Wildcards can be subscribed to but not published on
+	if m.toBroker && strings.ContainsAny(m.mqtt, "+#") {
+		return mqttMapping{}, fmt.Errorf("invalid MQTT topic mapping %q: wildcards are only allowed from MQTT (libp2p<mqtt)", s)
+	}
+	return m, nil
+}
+
+// mqttBridge relays messages between pubsub topics and the topics of an MQTT
+// broker, so clients that do not speak libp2p can take part. Payloads are
+// bridged verbatim. It is nil unless MQTT_BROKER is set.
+type mqttBridge struct {
+	cfg      Config
+	mappings []mqttMapping
+	// Payloads recently bridged per mapping, in either direction: the broker
+	// echoes our own publishes, and another bridge may relay them back
+	recent *dedupCache
+}
+
+func newMQTTBridge(cfg Config) (*mqttBridge, error) {
+	if cfg.MQTTBroker == "" {
+		return nil, nil
+	}
+	if cfg.MQTTQoS < 0 || cfg.MQTTQoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT_QOS %d: want 0, 1 or 2", cfg.MQTTQoS)
+	}
+	b := &mqttBridge{cfg: cfg, recent: newDedupCache(cfg.MQTTLoopWindow)}
+	for _, item := range cfg.MQTTTopics {
+		m, err := parseMQTTMapping(item)
+		if err != nil {
+			return nil, err
+		}
+		b.mappings = append(b.mappings, m)
+	}
+	if len(b.mappings) == 0 {
+		return nil, fmt.Errorf("MQTT_BROKER is set but MQTT_TOPICS maps no topics")
+	}
+	return b, nil
+}
+
+// seen records a payload bridged on a mapping and reports whether it already
+// was within the loop window
+func (b *mqttBridge) seen(m mqttMapping, payload []byte) bool {
+	sum := sha256.Sum256(payload)
+	return b.recent.Seen(m.libp2p + "\x00" + m.mqtt + "\x00" + hex.EncodeToString(sum[:]))
+}
+
+// startMQTTBridge connects to the broker and starts relaying the mapped
+// topics until ctx is done. The broker being unreachable does not fail
+// startup: the client keeps retrying in the background.
+func (s *Libp2pNodeService) startMQTTBridge(ctx context.Context) error {
+	b := s.mqtt
+	if b == nil {
+		return nil
+	}
+	clientID := b.cfg.MQTTClientID
+	if clientID == "" {
+		clientID = "sight-" + s.node.ID().String()
+	}
+	qos := byte(b.cfg.MQTTQoS)
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.cfg.MQTTBroker).
+		SetClientID(clientID).
+		SetUsername(b.cfg.MQTTUsername).
+		SetPassword(b.cfg.MQTTPassword).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Printf("MQTT bridge lost connection to %s: %v", b.cfg.MQTTBroker, err)
+	})
+	// Sessions are clean, so subscriptions are made again on every connect
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		log.Printf("MQTT bridge connected to %s", b.cfg.MQTTBroker)
+		for _, m := range b.mappings {
+			if !m.toMesh {
+				continue
+			}
+			m := m
+			c.Subscribe(m.mqtt, qos, func(_ mqtt.Client, msg mqtt.Message) {
+				s.bridgeToMesh(ctx, m, msg.Payload())
+			})
+		}
+	})
+	client := mqtt.NewClient(opts)
+
+	for _, m := range b.mappings {
+		if err := s.registerTopicValidator(m.libp2p, nil); err != nil {
+			return err
+		}
+		topic, err := s.joinTopic(m.libp2p)
+		if err != nil {
+			return err
+		}
+		if !m.toBroker {
+			continue
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.subscriptions = append(s.subscriptions, sub)
+		s.mu.Unlock()
+		m := m
+		go s.supervise(ctx, sub, func() (Subscription, error) {
+			return s.resubscribeJoined(m.libp2p)
+		}, func(msg *pubsub.Message) {
+			// Our own publishes are what the bridge received from MQTT
+			if msg.Local {
+				return
+			}
+			s.bridgeToBroker(client, qos, m, messageData(msg))
+		})
+	}
+
+	log.Printf("MQTT bridge connecting to %s as %s, %d topic mappings", b.cfg.MQTTBroker, clientID, len(b.mappings))
+	client.Connect()
+	go func() {
+		<-ctx.Done()
+		client.Disconnect(250)
+	}()
+	return nil
+}
+
+// bridgeToBroker publishes a pubsub message on the mapped MQTT topic
+func (s *Libp2pNodeService) bridgeToBroker(client mqtt.Client, qos byte, m mqttMapping, payload []byte) {
+	if s.mqtt.seen(m, payload) {
+		mqttBridged.WithLabelValues(mqttToBroker, "loop").Inc()
+		return
+	}
+	token := client.Publish(m.mqtt, qos, false, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		mqttBridged.WithLabelValues(mqttToBroker, "failed").Inc()
+		debugf("MQTT bridge: publishing to %s timed out", m.mqtt)
+		return
+	}
+	if err := token.Error(); err != nil {
+		mqttBridged.WithLabelValues(mqttToBroker, "failed").Inc()
+		debugf("MQTT bridge: publishing to %s failed: %v", m.mqtt, err)
+		return
+	}
+	mqttBridged.WithLabelValues(mqttToBroker, "ok").Inc()
+}
+
+// bridgeToMesh publishes an MQTT message on the mapped pubsub topic
+func (s *Libp2pNodeService) bridgeToMesh(ctx context.Context, m mqttMapping, payload []byte) {
+	if s.mqtt.seen(m, payload) {
+		mqttBridged.WithLabelValues(mqttToMesh, "loop").Inc()
+		return
+	}
+	topic, err := s.joinTopic(m.libp2p)
+	if err == nil {
+		err = s.publishTopic(ctx, topic, payload)
+	}
+	if err != nil {
+		mqttBridged.WithLabelValues(mqttToMesh, "failed").Inc()
+		debugf("MQTT bridge: publishing to %s failed: %v", m.libp2p, err)
+		return
+	}
+	mqttBridged.WithLabelValues(mqttToMesh, "ok").Inc()
+}
@@ -0,0 +1,142 @@
+package sightnode
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Node is a sight libp2p node that other Go services can embed. The HTTP API
+// is optional: serve Handler() to expose it.
+type Node struct {
+	service *Libp2pNodeService
+}
+
+// New creates a node with the given identity and configuration; call Start
+// to join the network. Invalid configuration is reported as a fatal
+// StartupError.
+func New(kp Keypair, cfg Config, opts ...Option) (*Node, error) {
+	service, err := NewLibp2pNodeService(kp, cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{service: service}, nil
+}
+
+// Start creates the libp2p host, connects to the bootstrap peers and
+// subscribes to the inbox topics. Cancelling ctx cancels the node's in-flight
+// publishes, dials and webhook forwards. A failed start can be retried unless
+// IsFatalStartupError reports the error as fatal.
+func (n *Node) Start(ctx context.Context) error {
+	return n.service.InitNode(ctx)
+}
+
+// Drain stops accepting sends and flushes the outbound and tunnel queues,
+// persisting what is left when ctx is done
+func (n *Node) Drain(ctx context.Context) error {
+	return n.service.Drain(ctx)
+}
+
+// Stop closes the host and its subscriptions
+func (n *Node) Stop() {
+	n.service.Stop()
+}
+
+// DID returns the node's current DID
+func (n *Node) DID() string {
+	n.service.mu.RLock()
+	defer n.service.mu.RUnlock()
+	return n.service.did
+}
+
+// PeerID returns the peer ID of the running host, empty before Start
+func (n *Node) PeerID() peer.ID {
+	n.service.mu.RLock()
+	defer n.service.mu.RUnlock()
+	if n.service.node == nil {
+		return ""
+	}
+	return n.service.node.ID()
+}
+
+// Addrs returns the /p2p multiaddresses of the running host, e.g. to
+// bootstrap other nodes from it
+func (n *Node) Addrs() []string {
+	n.service.mu.RLock()
+	defer n.service.mu.RUnlock()
+	if n.service.node == nil {
+		return nil
+	}
+	return p2pAddrs(n.service.node.ID(), n.service.node.Addrs())
+}
+
+// InboxPeers returns the peers known to subscribe to the inbox topic of a
+// DID, which a message to it is published to
+func (n *Node) InboxPeers(did string) []peer.ID {
+	n.service.mu.RLock()
+	defer n.service.mu.RUnlock()
+	if n.service.pubsub == nil {
+		return nil
+	}
+	return n.service.pubsub.ListPeers(inboxTopic(did))
+}
+
+// Send publishes a payload to the node owning the DID and returns the message ID
+func (n *Node) Send(ctx context.Context, to string, payload map[string]interface{}) (string, error) {
+	return n.service.HandleOutgoingMessage(ctx, map[string]interface{}{
+		"to":      to,
+		"payload": payload,
+	})
+}
+
+// Subscribe delivers incoming messages (and network events on the "events"
+// topic) matching the topic and type filters; empty filters match all. The
+// channel is closed by the returned cancel function. Messages are dropped
+// while the channel is full.
+func (n *Node) Subscribe(topics, types []string) (<-chan StreamMessage, func()) {
+	client := &streamClient{
+		filter: streamFilter{Topics: topics, Types: types},
+		send:   make(chan StreamMessage, 256),
+		local:  true,
+	}
+	hub := n.service.streams
+	hub.add(client)
+	return client.send, func() {
+		hub.remove(client)
+		close(client.send)
+	}
+}
+
+// Reload re-reads the configuration from the source given with
+// WithConfigSource, see Libp2pNodeService.ReloadConfig
+func (n *Node) Reload() ([]string, error) {
+	return n.service.ReloadConfig()
+}
+
+// Service returns the underlying service, for the gRPC server and advanced use
+func (n *Node) Service() *Libp2pNodeService {
+	return n.service
+}
+
+// Handler returns the HTTP API of the node
+func (n *Node) Handler() http.Handler {
+	return NewRouter(NewLibp2pNodeController(n.service))
+}
+
+// NewRouter registers the HTTP API routes of the controller: the libp2p
+// endpoints under /v1 (see apiRoutes), the probes, UI and metrics
+func NewRouter(controller *Libp2pNodeController) *mux.Router {
+	router := mux.NewRouter()
+	registerAPIRoutes(router, controller)
+	router.HandleFunc("/v1/openapi.json", controller.OpenAPIHandler).Methods("GET")
+	router.HandleFunc("/healthz", controller.HealthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", controller.ReadyzHandler).Methods("GET")
+	router.Handle("/ui", requireAdminBrowser(controller.service.cfg, http.HandlerFunc(controller.UIHandler))).Methods("GET")
+	router.Handle("/ui/status", requireAdminBrowser(controller.service.cfg, http.HandlerFunc(controller.UIStatusHandler))).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	registerDebugRoutes(router, controller.service.cfg)
+	return router
+}
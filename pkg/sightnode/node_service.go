@@ -0,0 +1,750 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// messageTopic is the legacy gossipsub topic shared by all nodes, used before
+// per-DID inbox topics (see LEGACY_TOPIC)
+const messageTopic = "sight-message"
+
+type Libp2pNodeService struct {
+	mu sync.RWMutex
+	// rotateMu serialises key rotations and imports, which restart the host
+	// without holding mu
+	rotateMu sync.Mutex
+
+	did          string
+	keypair      Keypair
+	tunnelAPI    string
+	webhooks     []WebhookTarget
+	forwarder    *tunnelForwarder
+	files        *fileTransfers
+	outbox       *outbox
+	statuses     *statusTracker
+	sequence     *sequencer
+	encodings    *encodingCache
+	gaps         *gapDetector
+	idempotency  *idempotencyCache
+	draining     atomic.Bool
+	records      *recordStore
+	recordsTopic Topic
+	rendezvous   *rendezvousPoint
+	archive      *messageArchive
+	auditLog     *auditLog
+	isGateway    bool
+	node         hostlibp2p.Host
+	pubsub       PubSub
+	cfg          Config
+	dedup        *dedupCache
+	gater        *PeerGater
+	streams      *streamHub
+	bootstrap    *bootstrapManager
+	registry     *didRegistry
+	bandwidth    *metrics.BandwidthCounter
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	// Health of GATEWAY_ADDRS on hosters, and the gateways federated through
+	// FEDERATION_PEERS or clustered through CLUSTER_PEERS, each nil when not
+	// configured
+	gateways   *gatewayMonitor
+	federation *federation
+	cluster    *cluster
+
+	// Receiving subscriptions and the topics joined for them or for publishing
+	subscriptions []Subscription
+	topicsMu      sync.Mutex
+	topics        map[string]Topic
+
+	// Current bootstrap list, editable at runtime
+	bootstrapAddrs []string
+
+	// Key rotation state: our own previous DIDs and rotations announced by peers
+	previousDIDs  []string
+	rotationTopic Topic
+	rotatedDIDs   map[string]string
+
+	// Revoked DIDs, whose messages are neither accepted nor forwarded
+	revocations     *revocationList
+	revocationTopic Topic
+
+	// Groups sharing a symmetric key, see group.go
+	groups *groupStore
+
+	// Protected topics and the membership tokens held for them
+	topicTokens *topicTokens
+
+	// Gateway admission decisions about hosters
+	admissions *admissionList
+
+	// Per-sender pubsub rate limit, nil when disabled
+	rateLimiter *peerRateLimiter
+
+	// Minimum-peer connectivity watchdog
+	watchdog *connectivityWatchdog
+
+	// Health of the supervised pubsub subscriptions
+	subHealth *subscriptionHealthSet
+
+	// Context passed to Start; the host context of every restart derives
+	// from it, so cancelling it stops in-flight work
+	parentCtx context.Context
+
+	// Creates the host and pubsub router, see WithHostFactory
+	newHost HostFactory
+
+	// Reads the configuration ReloadConfig applies, see WithConfigSource
+	loadConfig func() (Config, error)
+
+	// Fault injection for resilience tests, nil unless CHAOS is set
+	chaos *chaosInjector
+
+	// Message counters behind /v1/libp2p/stats, and the latest messages shown
+	// by the status UI
+	counters  messageCounters
+	startedAt time.Time
+	recent    *messageLog
+
+	// Latest gossipsub peer scores, when scoring is enabled
+	peerScores peerScores
+
+	// Retries of acknowledged outgoing messages, and the acknowledged
+	// incoming ones already delivered, whose duplicates are acked again
+	qos          *qosTracker
+	qosDelivered *dedupCache
+
+	// Relay between pubsub and an MQTT broker, nil unless MQTT_BROKER is set
+	mqtt *mqttBridge
+
+	// Where incoming messages go by type, see TypeRoute
+	typeRoutes *typeRouter
+}
+
+func NewLibp2pNodeService(kp Keypair, cfg Config, opts ...Option) (*Libp2pNodeService, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	did := "gateway"
+	if !cfg.IsGateway {
+		var err error
+		if did, err = kp.DID(); err != nil {
+			return nil, fatalStartup("invalid node keypair", err)
+		}
+	}
+	if o.tunnel == nil {
+		client, err := newTunnelClient(cfg)
+		if err != nil {
+			return nil, fatalStartup("invalid tunnel client config", err)
+		}
+		o.tunnel = client
+	}
+	o.tunnel = timedTunnelClient{next: o.tunnel}
+	webhooks, err := loadWebhookTargets(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid webhook config", err)
+	}
+	sink, err := newMessageSink(cfg, o.tunnel)
+	if err != nil {
+		return nil, fatalStartup("invalid message sink config", err)
+	}
+	spool, err := newTunnelSpool(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid tunnel overflow config", err)
+	}
+	if err := checkGatewayFailover(cfg); err != nil {
+		return nil, fatalStartup("invalid gateway failover config", err)
+	}
+	if err := checkFederation(cfg); err != nil {
+		return nil, fatalStartup("invalid federation config", err)
+	}
+	if err := checkCluster(cfg); err != nil {
+		return nil, fatalStartup("invalid cluster config", err)
+	}
+	if err := checkBatch(cfg); err != nil {
+		return nil, fatalStartup("invalid batch send config", err)
+	}
+	bridge, err := newMQTTBridge(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid MQTT bridge config", err)
+	}
+	typeRoutes, err := newTypeRouter(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid type routes", err)
+	}
+	records, err := newRecordStore(cfg)
+	if err != nil {
+		return nil, fatalStartup("invalid record store config", err)
+	}
+	s := &Libp2pNodeService{
+		keypair:     kp,
+		did:         did,
+		tunnelAPI:   cfg.TunnelAPI,
+		webhooks:    webhooks,
+		forwarder:   newTunnelForwarder(cfg, o.tunnel, sink, spool),
+		files:       newFileTransfers(),
+		statuses:    newStatusTracker(cfg.MessageStatusTTL),
+		bandwidth:   newBandwidthCounter(cfg),
+		isGateway:   cfg.IsGateway,
+		cfg:         cfg,
+		topics:      make(map[string]Topic),
+		dedup:       newDedupCache(cfg.DedupTTL),
+		sequence:    newSequencer(uuid.NewString()),
+		encodings:   newEncodingCache(),
+		gaps:        newGapDetector(cfg.SeqGapTimeout),
+		idempotency: newIdempotencyCache(cfg.IdempotencyTTL),
+		records:     records,
+		revocations: newRevocationList(cfg),
+		rendezvous:  newRendezvousPoint(cfg),
+		gater:       NewPeerGater(cfg),
+		streams:     newStreamHub(cfg.StreamReplaySize),
+
+		bootstrapAddrs: loadBootstrapAddrs(cfg),
+		previousDIDs:   loadPreviousDIDs(cfg),
+		rotatedDIDs:    make(map[string]string),
+
+		groups:      newGroupStore(cfg),
+		topicTokens: newTopicTokens(cfg),
+		admissions:  newAdmissionList(cfg),
+		rateLimiter: newPeerRateLimiter(cfg),
+		watchdog:    &connectivityWatchdog{},
+		subHealth:   newSubscriptionHealthSet(),
+
+		newHost:    o.hosts,
+		loadConfig: o.config,
+		chaos:      newChaosInjector(cfg),
+
+		startedAt: time.Now(),
+		recent:    newMessageLog(recentMessagesSize),
+
+		qos:          newQoSTracker(),
+		qosDelivered: newDedupCache(cfg.DedupTTL),
+
+		mqtt:       bridge,
+		typeRoutes: typeRoutes,
+	}
+	s.outbox = newOutbox(cfg, s.publishOutbound)
+	if cfg.ArchiveEnabled {
+		archive, err := openMessageArchive(cfg)
+		if err != nil {
+			return nil, startupFailure("opening message archive", err)
+		}
+		s.archive = archive
+	}
+	if cfg.AuditLog {
+		auditLog, err := openAuditLog(cfg.AuditLogPath)
+		if err != nil {
+			return nil, startupFailure("opening audit log", err)
+		}
+		s.auditLog = auditLog
+	}
+	return s, nil
+}
+
+// InitNode creates the host, joins the topics and connects to the network.
+// Everything the host runs stops when parent is cancelled. On failure
+// everything started so far is torn down and a *StartupError is returned, so
+// the caller may retry.
+func (s *Libp2pNodeService) InitNode(parent context.Context) (err error) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	s.parentCtx, s.ctx, s.cancel = parent, ctx, cancel
+	s.mu.Unlock()
+	s.forwarder.bind(parent)
+	var pstore peerstore.Peerstore
+	defer func() {
+		if err != nil {
+			cancel()
+			s.subHealth.clear()
+			s.mu.Lock()
+			node := s.node
+			s.node = nil
+			s.mu.Unlock()
+			if node != nil {
+				node.Close()
+			}
+			if pstore != nil {
+				pstore.Close()
+			}
+		}
+	}()
+
+	priv, err := s.keypair.PrivKey()
+	if err != nil {
+		return fatalStartup("invalid node keypair", err)
+	}
+
+	hostOpts := []libp2p.Option{
+		libp2p.ConnectionGater(s.gater),
+		libp2p.BandwidthReporter(s.bandwidth),
+	}
+	if s.cfg.PeerstorePersist {
+		pstore, err = NewPersistentPeerstore(ctx, s.cfg.DataDir+"/peerstore")
+		if err != nil {
+			return startupFailure("opening peerstore", err)
+		}
+		hostOpts = append(hostOpts, libp2p.Peerstore(pstore))
+	}
+
+	// Bootstrap nodes relay for peers behind NAT; they are infrastructure and
+	// assumed to be publicly reachable
+	if s.cfg.IsBootstrap {
+		hostOpts = append(hostOpts, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	}
+
+	// Create node and pubsub
+	psOpts := s.rateLimiter.pubsubOptions()
+	if psOpts != nil {
+		psOpts = append(psOpts, pubsub.WithPeerScoreInspect(pubsub.PeerScoreInspectFn(s.peerScores.update), time.Second))
+	}
+	h, ps, err := s.newHost(ctx, s.cfg, priv, psOpts, hostOpts...)
+	if err != nil {
+		return err
+	}
+	// Restarts under a new identity run alongside API calls
+	s.mu.Lock()
+	s.node = h
+	s.pubsub = ps
+	s.mu.Unlock()
+	// Topic handles belong to the router, which is new on every start
+	s.topicsMu.Lock()
+	s.topics = make(map[string]Topic)
+	s.topicsMu.Unlock()
+	advertiseEncodings(h)
+	advertiseWireFormats(h)
+	h.SetStreamHandler(rpcProtocol, s.handleRPCStream)
+	h.SetStreamHandler(fileProtocol, s.handleFileStream)
+	h.SetStreamHandler(directProtocol, s.handleDirectStream)
+	h.SetStreamHandler(recordsProtocol, s.handleRecordsStream)
+	h.SetStreamHandler(metadataProtocol, s.handleMetadataStream)
+
+	// Gateways and bootstrap nodes act as rendezvous points
+	if s.isGateway || s.cfg.IsBootstrap {
+		h.SetStreamHandler(rendezvousProtocol, s.handleRendezvousStream)
+	}
+
+	// Gateways learn DID -> peer routes and deliver unicast messages directly
+	if s.isGateway {
+		s.registry = newDIDRegistry(h)
+		s.registry.admit = s.admitted
+		s.registry.join = s.joinTopic
+		if err := s.registry.Start(ctx); err != nil {
+			return startupFailure("starting DID registry", err)
+		}
+		h.SetStreamHandler(resolveProtocol, s.handleResolveStream)
+		if s.admissionRequired() {
+			h.SetStreamHandler(admissionProtocol, s.handleAdmissionStream)
+		}
+		if len(s.cfg.FederationPeers) > 0 {
+			s.federation = newFederation(h, s.cfg, s.gater, s.registry, s.joinTopic)
+			s.federation.Start(ctx)
+		}
+		if len(s.cfg.ClusterPeers) > 0 {
+			s.cluster = newCluster(h, s.cfg, s.gater, s.registry, s.joinTopic)
+			s.cluster.Start(ctx)
+		}
+	}
+
+	go s.watchGaps(ctx, h.ID())
+	go s.retentionLoop(ctx)
+	go s.forwarder.probeLoop(ctx)
+	go trimBandwidth(ctx, s.bandwidth)
+	if err := s.watchNetworkEvents(ctx, h); err != nil {
+		return startupFailure("watching network events", err)
+	}
+	if err := s.watchMetadata(ctx, h); err != nil {
+		return startupFailure("watching peer identification", err)
+	}
+	if err := s.watchAdmission(ctx, h); err != nil {
+		return startupFailure("watching gateways for admission", err)
+	}
+
+	s.applyPeerTags(Config{}, s.cfg)
+
+	// Connect to bootstrap peers and keep reconnecting on loss
+	s.bootstrap = newBootstrapManager(h, s.cfg, s.gater, s.bootstrapAddrs)
+	s.bootstrap.Start(ctx)
+	if len(s.cfg.Gateways) > 0 && !s.isGateway && !s.cfg.IsBootstrap {
+		s.gateways = newGatewayMonitor(h, s.cfg, s.gater)
+		s.gateways.Start(ctx)
+	}
+	reconnectKnownPeers(ctx, h, s.cfg.PeerstoreReconnect, s.cfg.DialTimeout)
+	go s.runWatchdog(ctx, h)
+	go s.chaos.run(ctx, h)
+	if s.cfg.RendezvousNamespace != "" {
+		go s.runRendezvous(ctx, h)
+	}
+
+	// Bootstrap nodes carry no application traffic: no inbox, no tunnel forwarding
+	if s.cfg.IsBootstrap {
+		for _, addr := range p2pAddrs(h.ID(), h.Addrs()) {
+			log.Printf("Bootstrap address: %s", addr)
+		}
+	} else if err := s.subscribeInboxes(ctx); err != nil {
+		// Subscribe to our inbox topics, each handled in its own goroutine
+		return startupFailure("subscribing to inbox topics", err)
+	} else if err := s.subscribeGroups(ctx); err != nil {
+		return startupFailure("subscribing to group topics", err)
+	}
+
+	if err := s.joinRotationTopic(ctx); err != nil {
+		return startupFailure("joining key rotation topic", err)
+	}
+
+	if err := s.joinRevocationTopic(ctx, h.ID()); err != nil {
+		return startupFailure("joining revocation topic", err)
+	}
+
+	if err := s.joinRecordsTopic(ctx); err != nil {
+		return startupFailure("joining records topic", err)
+	}
+	if err := s.publishDIDDocuments(ctx, h); err != nil {
+		return startupFailure("publishing DID document", err)
+	}
+	if err := s.startMQTTBridge(ctx); err != nil {
+		return startupFailure("starting MQTT bridge", err)
+	}
+
+	s.restoreOutbox(ctx)
+	return nil
+}
+
+// handleEnvelope filters a received envelope, from pubsub or a direct stream,
+// and delivers it when it is addressed to this node
+func (s *Libp2pNodeService) handleEnvelope(ctx context.Context, topic string, from peer.ID, data []byte) {
+	payload, err := decodeEnvelope(data)
+	if err != nil {
+		log.Printf("Invalid message format: %v", err)
+		return
+	}
+
+	// Only process messages intended for this node
+	if to, _ := payload["to"].(string); !s.isOwnDID(to) {
+		return
+	}
+
+	fromDID, _ := s.peerDID(from)
+	if s.senderRevoked(from) {
+		debugf("Dropping message %s from revoked sender %s", envelopeRef(payload), from)
+		s.auditEnvelope(directionIn, fromDID, payload, len(data), auditRevoked, nil)
+		return
+	}
+
+	// Track sequence numbers before anything is dropped, so expired messages don't look lost
+	if did, err := s.peerDID(from); err == nil {
+		s.mu.RLock()
+		self := s.node.ID()
+		s.mu.RUnlock()
+		s.reportGaps(self, s.gaps.Observe(did, payload))
+	}
+
+	// Drop stale messages, e.g. commands queued while this node was offline
+	if messageExpired(payload) {
+		debugf("Dropping expired message %s", envelopeRef(payload))
+		s.auditEnvelope(directionIn, fromDID, payload, len(data), auditExpired, nil)
+		return
+	}
+
+	// Drop duplicates delivered more than once by gossipsub or republished
+	// by a sender that missed our receipt, which is then sent again
+	if id, ok := payload["id"].(string); ok && s.dedup.Seen(id) {
+		debugf("Dropping duplicate message %s", envelopeRef(payload))
+		if acknowledged(payload) && fromDID != "" && s.qosDelivered.Contains(id) {
+			go func() {
+				if err := s.sendReceipt(id, fromDID); err != nil {
+					log.Printf("Error sending receipt for message %s: %v", id, err)
+				}
+			}()
+		}
+		return
+	}
+
+	if err := decompressEnvelope(payload); err != nil {
+		log.Printf("Invalid compressed payload: %v", err)
+		return
+	}
+
+	s.counters.received.Add(1)
+	s.recordMessage(directionIn, fromDID, topic, payload, len(data), "", nil)
+	s.archiveMessage(directionIn, topic, fromDID, payload)
+	s.auditEnvelope(directionIn, fromDID, payload, len(data), auditReceived, nil)
+
+	if isReceipt(payload) && !s.handleReceipt(payload) {
+		return
+	}
+	if isGroupInvite(payload) {
+		s.handleGroupInvite(payload)
+		return
+	}
+
+	id, _ := payload["id"].(string)
+	ctx, span := tracer.Start(extractTraceContext(ctx, payload), "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer), messageAttrs(id, s.did))
+	defer span.End()
+	s.deliverIncoming(ctx, topic, from, payload)
+}
+
+// deliverIncoming hands an accepted envelope to the upstream service, via the
+// matching webhooks, the event-streaming sink and/or the WebSocket stream
+// depending on DELIVERY_MODE and SINK_ONLY, unless the route of its type
+// drops it or sends it elsewhere
+func (s *Libp2pNodeService) deliverIncoming(ctx context.Context, topic string, from peer.ID, envelope map[string]interface{}) {
+	fromDID, _ := s.peerDID(from)
+	msg := newStreamMessage(topic, from, fromDID, envelope)
+	route := s.typeRoutes.Route(msg.Type)
+	if route.Action == routeDrop {
+		debugf("Dropping message %s of type %q by route", messageRef(msg.ID, msg.RequestID), msg.Type)
+		return
+	}
+	// Receipts are only sent for direct messages, never for other receipts or
+	// group messages, whose senders do not track them
+	var onDelivered func()
+	if _, group := envelope["group"]; !isReceipt(envelope) && !group {
+		onDelivered = s.receiptCallback(msg)
+	}
+
+	s.streams.Publish(msg, s.cfg.DeliveryMode == deliveryWebhook)
+	if s.cfg.DeliveryMode == deliveryStream || s.cfg.IsBootstrap {
+		// Streams have no acknowledgements: handing the message over is
+		// what acknowledged messages get
+		if msg.QoS != "" && onDelivered != nil && !s.cfg.IsBootstrap {
+			onDelivered()
+		}
+		return
+	}
+
+	buf, err := payloadJSON(envelope)
+	if err != nil {
+		log.Printf("Error marshalling payload: %v", err)
+		return
+	}
+
+	if route.Action == routeTopic {
+		s.publishRouted(ctx, route, msg, buf, onDelivered)
+		return
+	}
+
+	// The sink gets the message with its metadata, as on the stream
+	if s.cfg.SinkType != "" {
+		event, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Error marshalling message: %v", err)
+			return
+		}
+		s.forwarder.Enqueue(forwardJob{MessageID: msg.ID, RequestID: msg.RequestID, FromDID: msg.FromDID, URL: sinkTarget(s.cfg, msg), Body: event, Trace: traceCarrier(ctx), OnDelivered: onDelivered})
+		if s.cfg.SinkOnly {
+			return
+		}
+	}
+
+	// Queue the message for every matching webhook, or the route's
+	urls := s.webhookTargetsFor(msg)
+	if route.Action == routeWebhook {
+		urls = []string{route.URL}
+	}
+	for _, url := range urls {
+		s.forwarder.Enqueue(forwardJob{MessageID: msg.ID, RequestID: msg.RequestID, FromDID: msg.FromDID, URL: url, Body: buf, Trace: traceCarrier(ctx), OnDelivered: onDelivered})
+	}
+}
+
+// HandleOutgoingMessage queues an outgoing message in its priority lane, waits
+// until it is published and returns the message ID
+func (s *Libp2pNodeService) HandleOutgoingMessage(ctx context.Context, msg map[string]interface{}) (string, error) {
+	if s.draining.Load() {
+		return "", errDraining
+	}
+	id, done, err := s.enqueueOutgoing(ctx, msg)
+	if err != nil {
+		return id, err
+	}
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return id, err
+}
+
+// SendAsync queues an outgoing message and returns its ID without waiting;
+// GET /v1/libp2p/message/{id} reports how delivery progresses
+func (s *Libp2pNodeService) SendAsync(ctx context.Context, msg map[string]interface{}) (string, error) {
+	if s.draining.Load() {
+		return "", errDraining
+	}
+	id, _, err := s.enqueueOutgoing(context.WithoutCancel(ctx), msg)
+	return id, err
+}
+
+// MessageStatus returns the delivery state of an outgoing message
+func (s *Libp2pNodeService) MessageStatus(id string) (MessageStatus, bool) {
+	return s.statuses.Get(id)
+}
+
+// enqueueOutgoing completes the envelope, encodes it and adds it to the outbox.
+// The returned channel receives the publish result.
+func (s *Libp2pNodeService) enqueueOutgoing(ctx context.Context, msg map[string]interface{}) (string, <-chan error, error) {
+	if _, ok := msg["id"]; !ok {
+		msg["id"] = uuid.NewString()
+	}
+	if _, ok := msg["expiresAt"]; !ok && s.cfg.MessageTTL > 0 {
+		msg["expiresAt"] = time.Now().Add(s.cfg.MessageTTL).Format(time.RFC3339)
+	}
+	if to, ok := msg["to"].(string); ok {
+		msg["to"] = s.resolveRotatedDID(to)
+	}
+	id, _ := msg["id"].(string)
+	to, _ := msg["to"].(string)
+	priority, err := parsePriority(msg["priority"])
+	if err != nil {
+		return id, nil, err
+	}
+	if priority != priorityNormal {
+		msg["priority"] = priority
+	} else {
+		delete(msg, "priority")
+	}
+	qos, err := parseQoS(msg["qos"])
+	if err != nil {
+		return id, nil, err
+	}
+	if qos != qosAtMostOnce {
+		msg["qos"] = qos
+	} else {
+		delete(msg, "qos")
+	}
+	s.sequence.Stamp(to, msg)
+	s.mu.RLock()
+	self := s.did
+	s.mu.RUnlock()
+	s.archiveMessage(directionOut, inboxTopic(to), self, msg)
+	if err := s.compressEnvelope(msg); err != nil {
+		log.Printf("Error compressing payload, sending uncompressed: %v", err)
+	}
+
+	// The span ends in publishOutbound once the outbox has sent the message
+	ctx, span := tracer.Start(ctx, "publish", trace.WithSpanKind(trace.SpanKindProducer), messageAttrs(id, to))
+	injectTraceContext(ctx, msg)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshalling outgoing message: %v", err)
+		span.End()
+		return id, nil, err
+	}
+
+	done := make(chan error, 1)
+	job := outboundJob{ctx: ctx, id: id, to: to, priority: priority, qos: qos, envelope: msg, data: data, done: done}
+	s.statuses.Set(id, to, stateQueued, nil)
+	if qos == qosOrdered {
+		// Held back until the ordered messages before it are acknowledged
+		if now, err := s.qos.holdOrdered(job, s.cfg.OutboxSize); err != nil || !now {
+			if err != nil {
+				s.statuses.Set(id, to, stateFailed, err)
+				span.End()
+			}
+			return id, done, err
+		}
+	}
+	if err := s.outbox.Enqueue(priority, job); err != nil {
+		s.releaseOrdered(job)
+		s.statuses.Set(id, to, stateFailed, err)
+		s.auditEnvelope(directionOut, self, msg, len(data), auditFailed, err)
+		span.RecordError(err)
+		span.End()
+		return id, nil, err
+	}
+	return id, done, nil
+}
+
+// publishOutbound sends a dequeued envelope, directly when the gateway
+// registry or, on hosters with GATEWAY_ADDRS, the active gateway knows the
+// recipient and over its inbox topic otherwise
+func (s *Libp2pNodeService) publishOutbound(job outboundJob) error {
+	span := trace.SpanFromContext(job.ctx)
+	defer span.End()
+
+	ctx, cancel := s.operationContext(job.ctx, s.cfg.PublishTimeout)
+	defer cancel()
+	var err error
+	switch {
+	case messageExpired(job.envelope):
+		err = errors.New("message expired while queued")
+	case s.routeDirect(ctx, job.to, job.envelope, job.data):
+	case s.routeViaGateway(ctx, job.to, job.data):
+	default:
+		err = s.publishEnvelope(ctx, job.to, job.data)
+	}
+	s.mu.RLock()
+	self := s.did
+	s.mu.RUnlock()
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("Error publishing message %s: %v", envelopeRef(job.envelope), err)
+		s.counters.failed.Add(1)
+		s.recordMessage(directionOut, job.to, inboxTopic(job.to), job.envelope, len(job.data), stateFailed, err)
+		s.statuses.Set(job.id, job.to, stateFailed, err)
+		s.auditEnvelope(directionOut, self, job.envelope, len(job.data), auditFailed, err)
+		if job.qos != qosAtMostOnce {
+			s.awaitReceipt(job)
+		}
+		return err
+	}
+	if job.qos != qosAtMostOnce {
+		s.awaitReceipt(job)
+	}
+	s.counters.published.Add(1)
+	s.recordMessage(directionOut, job.to, inboxTopic(job.to), job.envelope, len(job.data), statePublished, nil)
+	s.statuses.Set(job.id, job.to, statePublished, nil)
+	s.auditEnvelope(directionOut, self, job.envelope, len(job.data), auditPublished, nil)
+	return nil
+}
+
+// operationContext bounds one operation started for parent, e.g. a send
+// request, by timeout. It is also cancelled when the host stops, since
+// parent may outlive it.
+func (s *Libp2pNodeService) operationContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	s.mu.RLock()
+	hostCtx := s.ctx
+	s.mu.RUnlock()
+	if hostCtx == nil {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(hostCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Stop gracefully stops the libp2p node
+func (s *Libp2pNodeService) Stop() {
+	s.mu.RLock()
+	cancel, subs, node := s.cancel, s.subscriptions, s.node
+	s.mu.RUnlock()
+	// Cancel the context first so the supervisors do not resubscribe
+	cancel()
+	s.subHealth.clear()
+	for _, sub := range subs {
+		sub.Cancel()
+	}
+	if err := node.Close(); err != nil {
+		log.Printf("Error stopping node: %v", err)
+	}
+}
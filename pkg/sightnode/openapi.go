@@ -0,0 +1,246 @@
+package sightnode
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// OpenAPI builds the OpenAPI 3 document of the versioned API from the route
+// table, deriving the body schemas from the Go types by reflection
+func OpenAPI() map[string]interface{} {
+	g := &schemaGenerator{schemas: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+	addOperation := func(path, method string, op map[string]interface{}) {
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = op
+	}
+
+	// Handler method values are only inspected for their names
+	for _, route := range apiRoutes(&Libp2pNodeController{}) {
+		id := route.id
+		if id == "" {
+			id = operationID(route.handler)
+		}
+		op := map[string]interface{}{
+			"operationId": id,
+			"summary":     route.summary,
+			"responses":   g.responses(route.response),
+		}
+		var params []interface{}
+		for _, v := range pathVariables(route.path) {
+			schema := map[string]interface{}{"type": "string"}
+			if v.enum != nil {
+				schema["enum"] = v.enum
+			}
+			params = append(params, map[string]interface{}{
+				"name": v.name, "in": "path", "required": true, "schema": schema,
+			})
+		}
+		for _, name := range route.query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]string{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if route.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(route.request))}},
+			}
+		}
+		if route.admin {
+			op["security"] = []map[string][]string{{"adminToken": {}}}
+		}
+		addOperation(openAPIPath(apiPrefix+route.path), route.method, op)
+	}
+
+	// Probes stay unversioned
+	addOperation("/healthz", "GET", map[string]interface{}{
+		"operationId": "healthz", "summary": "Liveness probe", "responses": g.responses(statusResponse{}),
+	})
+	addOperation("/readyz", "GET", map[string]interface{}{
+		"operationId": "readyz", "summary": "Readiness probe, 503 when not ready", "responses": g.responses(ReadinessReport{}),
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "sight libp2p node",
+			"version": Version,
+			"description": "HTTP API of a sight libp2p node. The " + apiPrefix + " endpoints are also served under " +
+				legacyPrefix + " as deprecated aliases.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]string{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+			},
+		},
+	}
+}
+
+// operationID derives an operation ID from the handler name: SendHandler
+// becomes send
+func operationID(handler interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndexByte(name, '.')+1:], "-fm")
+	name = strings.TrimSuffix(name, "Handler")
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// pathVariable is a mux variable of a path template. Variables restricted
+// to alternatives, like {decision:approve|reject}, have an enum.
+type pathVariable struct {
+	name string
+	enum []string
+}
+
+// pathVariables returns the mux variables of a path template
+func pathVariables(path string) []pathVariable {
+	var vars []pathVariable
+	for _, part := range strings.Split(path, "/") {
+		if !strings.HasPrefix(part, "{") {
+			continue
+		}
+		name, pattern, _ := strings.Cut(strings.Trim(part, "{}"), ":")
+		v := pathVariable{name: name}
+		if pattern != "" && strings.Trim(pattern, "abcdefghijklmnopqrstuvwxyz|") == "" {
+			v.enum = strings.Split(pattern, "|")
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// schemaGenerator turns Go types into JSON schemas, collecting named structs
+// under components/schemas
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) responses(response interface{}) map[string]interface{} {
+	ok := map[string]interface{}{"description": "OK"}
+	if response != nil {
+		ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(response))}}
+	}
+	return map[string]interface{}{
+		"200": ok,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}},
+		},
+	}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		// Unexported names are the route table's own response shapes
+		if t.Name() == "" || !unicode.IsUpper([]rune(t.Name())[0]) {
+			return g.object(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Placeholder first, for recursive types
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// object describes the JSON object encoding/json produces for a struct
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			// Embedded struct fields are promoted
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := g.object(ft)
+				for k, v := range embedded["properties"].(map[string]interface{}) {
+					props[k] = v
+				}
+				if req, ok := embedded["required"].([]string); ok {
+					required = append(required, req...)
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if required != nil {
+		obj["required"] = required
+	}
+	return obj
+}
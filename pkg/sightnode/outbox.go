@@ -0,0 +1,179 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Message priorities (envelope "priority" field)
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// priorities lists the lanes in dispatch order
+var priorities = []string{priorityHigh, priorityNormal, priorityLow}
+
+var errOutboxFull = errors.New("outbound queue is full")
+
+// outboundJob is an encoded envelope waiting to be published
+type outboundJob struct {
+	ctx      context.Context
+	id       string
+	to       string
+	priority string
+	qos      string
+	envelope map[string]interface{}
+	data     []byte
+	done     chan error
+}
+
+// outbox holds one bounded queue per priority. Workers drain them with
+// weighted round robin so control messages overtake bulk traffic without
+// starving it.
+type outbox struct {
+	lanes   map[string]chan outboundJob
+	weights map[string]int
+	publish func(outboundJob) error
+	// pending counts queued and in-flight jobs
+	pending atomic.Int64
+}
+
+func newOutbox(cfg Config, publish func(outboundJob) error) *outbox {
+	o := &outbox{
+		lanes:   make(map[string]chan outboundJob),
+		weights: make(map[string]int),
+		publish: publish,
+	}
+	for i, p := range priorities {
+		o.lanes[p] = make(chan outboundJob, cfg.OutboxSize)
+		o.weights[p] = 1
+		if i < len(cfg.OutboxWeights) && cfg.OutboxWeights[i] > 0 {
+			o.weights[p] = cfg.OutboxWeights[i]
+		}
+	}
+	for i := 0; i < cfg.OutboxWorkers; i++ {
+		go o.worker()
+	}
+	return o
+}
+
+// parsePriority normalizes an envelope priority, defaulting to normal
+func parsePriority(value interface{}) (string, error) {
+	p, _ := value.(string)
+	if p == "" {
+		return priorityNormal, nil
+	}
+	p = strings.ToLower(p)
+	for _, known := range priorities {
+		if p == known {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown priority %q", p)
+}
+
+// Enqueue adds a job to its priority lane without blocking
+func (o *outbox) Enqueue(priority string, job outboundJob) error {
+	job.priority = priority
+	o.pending.Add(1)
+	select {
+	case o.lanes[priority] <- job:
+		return nil
+	default:
+		o.pending.Add(-1)
+		return errOutboxFull
+	}
+}
+
+func (o *outbox) worker() {
+	high, normal, low := o.lanes[priorityHigh], o.lanes[priorityNormal], o.lanes[priorityLow]
+	for {
+		// Weighted pass: up to weight jobs from each lane, highest first
+		dispatched := false
+		for _, p := range priorities {
+			for n := 0; n < o.weights[p]; n++ {
+				select {
+				case job := <-o.lanes[p]:
+					o.run(job)
+					dispatched = true
+					continue
+				default:
+				}
+				break
+			}
+		}
+		if dispatched {
+			continue
+		}
+		// Nothing queued: wait for the next job on any lane
+		select {
+		case job := <-high:
+			o.run(job)
+		case job := <-normal:
+			o.run(job)
+		case job := <-low:
+			o.run(job)
+		}
+	}
+}
+
+func (o *outbox) run(job outboundJob) {
+	err := o.publish(job)
+	o.pending.Add(-1)
+	if job.done != nil {
+		job.done <- err
+	}
+}
+
+// Pending returns the number of queued and in-flight jobs
+func (o *outbox) Pending() int {
+	return int(o.pending.Load())
+}
+
+// Take removes and returns the jobs still queued, highest priority first
+func (o *outbox) Take() []outboundJob {
+	var jobs []outboundJob
+	for _, p := range priorities {
+		for {
+			select {
+			case job := <-o.lanes[p]:
+				o.pending.Add(-1)
+				jobs = append(jobs, job)
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return jobs
+}
+
+// Purge removes the queued jobs matching match and returns them; the other
+// jobs keep their place in their lane
+func (o *outbox) Purge(match func(outboundJob) bool) []outboundJob {
+	var purged []outboundJob
+	for _, job := range o.Take() {
+		if match(job) {
+			purged = append(purged, job)
+			continue
+		}
+		if err := o.Enqueue(job.priority, job); err != nil && job.done != nil {
+			job.done <- err
+		}
+	}
+	return purged
+}
+
+// Len returns the number of queued jobs per priority
+func (o *outbox) Len() map[string]int {
+	lens := make(map[string]int)
+	for p, lane := range o.lanes {
+		lens[p] = len(lane)
+	}
+	return lens
+}
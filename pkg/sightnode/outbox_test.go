@@ -0,0 +1,86 @@
+package sightnode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{nil, priorityNormal, false},
+		{"High", priorityHigh, false},
+		{"low", priorityLow, false},
+		{"urgent", "", true},
+	} {
+		got, err := parsePriority(tc.value)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("parsePriority(%v) = %q, %v; want %q, error %v", tc.value, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestOutboxDispatchesLanesByWeight(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		weights []int
+		want    string
+	}{
+		{"equal weights", []int{1, 1, 1}, "HNLHNLHNL"},
+		{"weighted", []int{4, 2, 1}, "HHHNNLNLL"},
+		{"high first", []int{2, 1, 1}, "HHNLHNLNL"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// No workers yet, so three jobs wait in every lane when the
+			// worker starts
+			cfg := Config{OutboxSize: 10, OutboxWeights: tc.weights}
+			order := make(chan string, 10)
+			o := newOutbox(cfg, func(job outboundJob) error {
+				order <- job.id
+				return nil
+			})
+			for _, lane := range []struct{ id, priority string }{{"L", priorityLow}, {"N", priorityNormal}, {"H", priorityHigh}} {
+				for i := 0; i < 3; i++ {
+					if err := o.Enqueue(lane.priority, outboundJob{id: lane.id}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			go o.worker()
+			var got strings.Builder
+			for got.Len() < len(tc.want) {
+				select {
+				case id := <-order:
+					got.WriteString(id)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("dispatched only %s", got.String())
+				}
+			}
+			if got.String() != tc.want {
+				t.Errorf("dispatched %s, want %s", got.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestOutboxLanesAreBounded(t *testing.T) {
+	o := newOutbox(Config{OutboxSize: 1}, func(outboundJob) error { return nil })
+	if err := o.Enqueue(priorityLow, outboundJob{id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Enqueue(priorityLow, outboundJob{id: "2"}); err != errOutboxFull {
+		t.Errorf("enqueueing on a full lane returned %v, want %v", err, errOutboxFull)
+	}
+	// A full lane does not hold up the others
+	if err := o.Enqueue(priorityHigh, outboundJob{id: "3"}); err != nil {
+		t.Error(err)
+	}
+	if n := o.Pending(); n != 2 {
+		t.Errorf("%d jobs pending, want 2", n)
+	}
+}
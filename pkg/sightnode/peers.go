@@ -0,0 +1,250 @@
+package sightnode
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// protectedTag protects the peers listed in PROTECTED_PEERS
+const protectedTag = "protected"
+
+// PeerInfo describes a connected peer and how the connection manager weighs it
+type PeerInfo struct {
+	PeerID       string         `json:"peerId"`
+	DID          string         `json:"did,omitempty"`
+	Addrs        []string       `json:"addrs"`
+	Direction    string         `json:"direction"`
+	Protected    []string       `json:"protected"`
+	Tags         map[string]int `json:"tags"`
+	Value        int            `json:"value"`
+	FirstSeen    time.Time      `json:"firstSeen,omitempty"`
+	AgentVersion string         `json:"agentVersion,omitempty"`
+	// Metadata is the peer's signed role record, when it serves one
+	Metadata *PeerMetadata `json:"metadata,omitempty"`
+	// RatePenalty is the decaying count of messages rejected by PUBSUB_RATE_LIMIT
+	RatePenalty float64 `json:"ratePenalty,omitempty"`
+}
+
+// peerInfo describes one connected peer
+func (s *Libp2pNodeService) peerInfo(node hostlibp2p.Host, id peer.ID) PeerInfo {
+	cm := node.ConnManager()
+	info := PeerInfo{
+		PeerID:    id.String(),
+		Addrs:     []string{},
+		Protected: []string{},
+		Tags:      map[string]int{},
+	}
+	if did, err := peerDID(node.Peerstore(), id); err == nil {
+		info.DID = did
+	}
+	if agent, err := node.Peerstore().Get(id, "AgentVersion"); err == nil {
+		info.AgentVersion, _ = agent.(string)
+	}
+	info.Metadata = peerMetadata(node, id)
+	info.RatePenalty = -s.rateLimiter.Score(id)
+	for _, conn := range node.Network().ConnsToPeer(id) {
+		info.Addrs = append(info.Addrs, conn.RemoteMultiaddr().String())
+		if conn.Stat().Direction == network.DirOutbound {
+			info.Direction = "outbound"
+		} else if info.Direction == "" {
+			info.Direction = "inbound"
+		}
+	}
+	for _, tag := range []string{bootstrapTag, protectedTag} {
+		if cm.IsProtected(id, tag) {
+			info.Protected = append(info.Protected, tag)
+		}
+	}
+	if tags := cm.GetTagInfo(id); tags != nil {
+		for tag, weight := range tags.Tags {
+			info.Tags[tag] = weight
+		}
+		info.Value = tags.Value
+		info.FirstSeen = tags.FirstSeen
+	}
+	return info
+}
+
+// ConnectionDetails describes one connection to a peer
+type ConnectionDetails struct {
+	Addr      string `json:"addr"`
+	Direction string `json:"direction"`
+	// Transport, Security and Muxer are the negotiated protocols, e.g. tcp,
+	// /noise and /yamux/1.0.0
+	Transport string    `json:"transport,omitempty"`
+	Security  string    `json:"security,omitempty"`
+	Muxer     string    `json:"muxer,omitempty"`
+	Opened    time.Time `json:"opened"`
+	Age       string    `json:"age"`
+	// Limited connections go through a relay with a data or time limit
+	Limited bool `json:"limited,omitempty"`
+}
+
+// PeerDetails is returned by GET /v1/libp2p/peers/{id}
+type PeerDetails struct {
+	PeerInfo
+	Protocols   []string            `json:"protocols"`
+	Connections []ConnectionDetails `json:"connections"`
+	// Latency is the moving average of the ping and identify round trips
+	Latency string `json:"latency,omitempty"`
+	// GossipScore is the gossipsub peer score, present when scoring is
+	// enabled by PUBSUB_RATE_LIMIT
+	GossipScore *float64 `json:"gossipScore,omitempty"`
+}
+
+var errPeerNotConnected = errors.New("peer is not connected")
+
+// PeerDetails describes a connected PeerID or DID: its protocols, the
+// transport and security of each connection, latency and gossipsub score
+func (s *Libp2pNodeService) PeerDetails(target string) (PeerDetails, error) {
+	id, err := parsePeerOrDID(target)
+	if err != nil {
+		return PeerDetails{}, fmt.Errorf("invalid peer: %w", err)
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	conns := node.Network().ConnsToPeer(id)
+	if len(conns) == 0 {
+		return PeerDetails{}, errPeerNotConnected
+	}
+
+	details := PeerDetails{
+		PeerInfo:    s.peerInfo(node, id),
+		Protocols:   []string{},
+		Connections: []ConnectionDetails{},
+	}
+	if protos, err := node.Peerstore().GetProtocols(id); err == nil {
+		for _, p := range protos {
+			details.Protocols = append(details.Protocols, string(p))
+		}
+		sort.Strings(details.Protocols)
+	}
+	for _, conn := range conns {
+		stat, state := conn.Stat(), conn.ConnState()
+		c := ConnectionDetails{
+			Addr:      conn.RemoteMultiaddr().String(),
+			Direction: strings.ToLower(stat.Direction.String()),
+			Transport: state.Transport,
+			Security:  string(state.Security),
+			Muxer:     string(state.StreamMultiplexer),
+			Opened:    stat.Opened,
+			Age:       time.Since(stat.Opened).Round(time.Second).String(),
+			Limited:   stat.Limited,
+		}
+		details.Connections = append(details.Connections, c)
+	}
+	if latency := node.Peerstore().LatencyEWMA(id); latency > 0 {
+		details.Latency = latency.String()
+	}
+	if score, ok := s.peerScores.Get(id); ok {
+		details.GossipScore = &score
+	}
+	return details, nil
+}
+
+// peerScores keeps the latest gossipsub scores, reported by the router's
+// score inspector
+type peerScores struct {
+	mu     sync.Mutex
+	scores map[peer.ID]float64
+}
+
+func (p *peerScores) update(scores map[peer.ID]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scores = scores
+}
+
+func (p *peerScores) Get(id peer.ID) (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	score, ok := p.scores[id]
+	return score, ok
+}
+
+// peerTag is one PEER_TAGS entry: peer=tag:weight
+type peerTag struct {
+	peer   peer.ID
+	tag    string
+	weight int
+}
+
+// parsePeerTag parses "<peer or DID>=<tag>:<weight>"
+func parsePeerTag(entry string) (peerTag, error) {
+	target, tagWeight, ok := strings.Cut(entry, "=")
+	if !ok {
+		return peerTag{}, fmt.Errorf("expected peer=tag:weight")
+	}
+	id, err := parsePeerOrDID(target)
+	if err != nil {
+		return peerTag{}, err
+	}
+	tag, weight, ok := strings.Cut(tagWeight, ":")
+	if !ok || tag == "" {
+		return peerTag{}, fmt.Errorf("expected peer=tag:weight")
+	}
+	w, err := strconv.Atoi(weight)
+	if err != nil {
+		return peerTag{}, fmt.Errorf("invalid weight %q", weight)
+	}
+	return peerTag{peer: id, tag: tag, weight: w}, nil
+}
+
+// applyPeerTags releases the protections and tags of the old config and
+// applies PROTECTED_PEERS and PEER_TAGS from the new one
+func (s *Libp2pNodeService) applyPeerTags(old, cfg Config) {
+	cm := s.node.ConnManager()
+	for _, entry := range old.ProtectedPeers {
+		if id, err := parsePeerOrDID(entry); err == nil {
+			cm.Unprotect(id, protectedTag)
+		}
+	}
+	for _, entry := range old.PeerTags {
+		if t, err := parsePeerTag(entry); err == nil {
+			cm.UntagPeer(t.peer, t.tag)
+		}
+	}
+
+	for _, entry := range cfg.ProtectedPeers {
+		id, err := parsePeerOrDID(entry)
+		if err != nil {
+			log.Printf("Ignoring PROTECTED_PEERS entry %q: %v", entry, err)
+			continue
+		}
+		cm.Protect(id, protectedTag)
+	}
+	for _, entry := range cfg.PeerTags {
+		t, err := parsePeerTag(entry)
+		if err != nil {
+			log.Printf("Ignoring PEER_TAGS entry %q: %v", entry, err)
+			continue
+		}
+		cm.TagPeer(t.peer, t.tag, t.weight)
+	}
+}
+
+// Peers lists the connected peers with their connection manager tags, so
+// pruning decisions can be explained
+func (s *Libp2pNodeService) Peers() []PeerInfo {
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+
+	peers := []PeerInfo{}
+	for _, id := range node.Network().Peers() {
+		peers = append(peers, s.peerInfo(node, id))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PeerID < peers[j].PeerID })
+	return peers
+}
@@ -0,0 +1,123 @@
+package sightnode
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+)
+
+type persistentAddrBook interface {
+	io.Closer
+	peerstore.AddrBook
+	peerstore.CertifiedAddrBook
+}
+
+// addrPersistentPeerstore keeps peer addresses in an on-disk datastore so they
+// survive restarts. Keys, protocols and metadata stay in memory on purpose: the
+// key book would otherwise write our private key to disk unencrypted.
+type addrPersistentPeerstore struct {
+	peerstore.Metrics
+	persistentAddrBook
+	peerstore.KeyBook
+	peerstore.ProtoBook
+	peerstore.PeerMetadata
+
+	store     *leveldb.Datastore
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewPersistentPeerstore opens (or creates) the peerstore datastore at path
+func NewPersistentPeerstore(ctx context.Context, path string) (peerstore.Peerstore, error) {
+	store, err := leveldb.NewDatastore(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	ab, err := pstoreds.NewAddrBook(ctx, store, pstoreds.DefaultOpts())
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	pb, err := pstoremem.NewProtoBook()
+	if err != nil {
+		ab.Close()
+		store.Close()
+		return nil, err
+	}
+	return &addrPersistentPeerstore{
+		Metrics:            pstore.NewMetrics(),
+		persistentAddrBook: ab,
+		KeyBook:            pstoremem.NewKeyBook(),
+		ProtoBook:          pb,
+		PeerMetadata:       pstoremem.NewPeerMetadata(),
+		store:              store,
+	}, nil
+}
+
+// Close is idempotent: a failed libp2p.New may or may not have closed the
+// peerstore already, and InitNode closes it again to release the lock
+func (ps *addrPersistentPeerstore) Close() error {
+	ps.closeOnce.Do(func() {
+		ps.persistentAddrBook.Close()
+		ps.closeErr = ps.store.Close()
+	})
+	return ps.closeErr
+}
+
+func (ps *addrPersistentPeerstore) Peers() peer.IDSlice {
+	set := map[peer.ID]struct{}{}
+	for _, p := range ps.PeersWithKeys() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.PeersWithAddrs() {
+		set[p] = struct{}{}
+	}
+	ids := make(peer.IDSlice, 0, len(set))
+	for p := range set {
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+func (ps *addrPersistentPeerstore) PeerInfo(p peer.ID) peer.AddrInfo {
+	return peer.AddrInfo{ID: p, Addrs: ps.Addrs(p)}
+}
+
+// RemovePeer removes everything but the addresses, like the stock peerstores
+func (ps *addrPersistentPeerstore) RemovePeer(p peer.ID) {
+	ps.KeyBook.RemovePeer(p)
+	ps.ProtoBook.RemovePeer(p)
+	ps.PeerMetadata.RemovePeer(p)
+	ps.Metrics.RemovePeer(p)
+}
+
+// reconnectKnownPeers dials up to limit peers remembered from a previous run,
+// giving each dial up to timeout
+func reconnectKnownPeers(ctx context.Context, h hostlibp2p.Host, limit int, timeout time.Duration) {
+	dialed := 0
+	for _, id := range h.Peerstore().PeersWithAddrs() {
+		if dialed >= limit {
+			return
+		}
+		if id == h.ID() {
+			continue
+		}
+		dialed++
+		go func(info peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := h.Connect(ctx, info); err == nil {
+				debugf("Reconnected to known peer %s", info.ID)
+			}
+		}(h.Peerstore().PeerInfo(id))
+	}
+}
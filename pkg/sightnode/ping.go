@@ -0,0 +1,63 @@
+package sightnode
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// PingResult summarizes RTT samples to a peer
+type PingResult struct {
+	Peer      string   `json:"peer"`
+	Samples   []string `json:"samples"`
+	Min       string   `json:"min,omitempty"`
+	Avg       string   `json:"avg,omitempty"`
+	Max       string   `json:"max,omitempty"`
+	Failed    int      `json:"failed"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+// Ping runs the libp2p ping protocol count times against a PeerID or DID,
+// connecting first if needed
+func (s *Libp2pNodeService) Ping(ctx context.Context, target string, count int) (PingResult, error) {
+	id, err := parsePeerOrDID(target)
+	if err != nil {
+		return PingResult{}, err
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+
+	result := PingResult{Peer: id.String(), Samples: []string{}}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var total, min, max time.Duration
+	results := ping.Ping(ctx, node, id)
+	for len(result.Samples)+result.Failed < count {
+		res, ok := <-results
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			result.Failed++
+			result.LastError = res.Error.Error()
+			// The ping stream is gone after an error
+			break
+		}
+		result.Samples = append(result.Samples, res.RTT.String())
+		total += res.RTT
+		if min == 0 || res.RTT < min {
+			min = res.RTT
+		}
+		if res.RTT > max {
+			max = res.RTT
+		}
+	}
+	if n := len(result.Samples); n > 0 {
+		result.Min = min.String()
+		result.Avg = (total / time.Duration(n)).String()
+		result.Max = max.String()
+	}
+	return result, nil
+}
@@ -0,0 +1,38 @@
+package sightnode
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// swarmKeyFile returns the path of the pre-shared swarm key (SWARM_KEY_FILE,
+// or swarm.key in the data dir)
+func swarmKeyFile(cfg Config) string {
+	if cfg.SwarmKeyFile != "" {
+		return cfg.SwarmKeyFile
+	}
+	return cfg.DataDir + "/swarm.key"
+}
+
+// loadSwarmKey reads the swarm key in the standard /key/swarm/psk/1.0.0/
+// format. Without a key file the node joins the public network (nil PSK).
+func loadSwarmKey(cfg Config) (pnet.PSK, error) {
+	path := swarmKeyFile(cfg)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && cfg.SwarmKeyFile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Private network enabled with swarm key %s", path)
+	return psk, nil
+}
@@ -0,0 +1,50 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// providerRecordPrefix names the records announcing that a DID provides a CID
+const providerRecordPrefix = "provide/"
+
+// ProviderInfo is the value of a provider record
+type ProviderInfo struct {
+	PeerID string   `json:"peerId"`
+	Addrs  []string `json:"addrs"`
+}
+
+// Provider is a DID holding a CID, as returned by FindProviders
+type Provider struct {
+	DID string `json:"did"`
+	ProviderInfo
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// Provide announces that this node holds the content c, with the addresses
+// it can be reached at
+func (s *Libp2pNodeService) Provide(ctx context.Context, c cid.Cid) (Record, error) {
+	addrs := s.Addresses()
+	value, err := json.Marshal(ProviderInfo{PeerID: addrs.PeerID, Addrs: addrs.Advertised})
+	if err != nil {
+		return Record{}, err
+	}
+	return s.PutRecord(ctx, providerRecordPrefix+c.String(), value)
+}
+
+// FindProviders returns the DIDs that announced the content c
+func (s *Libp2pNodeService) FindProviders(ctx context.Context, c cid.Cid) []Provider {
+	providers := []Provider{}
+	for _, r := range s.FindRecords(ctx, providerRecordPrefix+c.String()) {
+		p := Provider{ExpiresAt: r.ExpiresAt}
+		if err := json.Unmarshal(r.Value, &p.ProviderInfo); err != nil {
+			continue
+		}
+		p.DID = strings.TrimSuffix(r.Key, "/"+providerRecordPrefix+c.String())
+		providers = append(providers, p)
+	}
+	return providers
+}
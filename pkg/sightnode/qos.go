@@ -0,0 +1,196 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery guarantees of a message (envelope "qos" field)
+const (
+	// qosAtMostOnce publishes once and forgets
+	qosAtMostOnce = "at-most-once"
+	// qosAtLeastOnce republishes until the recipient's receipt arrives
+	qosAtLeastOnce = "at-least-once"
+	// qosOrdered is at-least-once, and a message to a recipient is only
+	// published once the previous ordered one to it was acknowledged
+	qosOrdered = "ordered"
+)
+
+// parseQoS normalizes an envelope QoS, defaulting to at-most-once
+func parseQoS(value interface{}) (string, error) {
+	q, _ := value.(string)
+	if q == "" {
+		return qosAtMostOnce, nil
+	}
+	q = strings.ToLower(q)
+	switch q {
+	case qosAtMostOnce, qosAtLeastOnce, qosOrdered:
+		return q, nil
+	}
+	return "", fmt.Errorf("unknown qos %q", q)
+}
+
+// acknowledged reports whether the recipient must acknowledge a message
+func acknowledged(envelope map[string]interface{}) bool {
+	q, _ := parseQoS(envelope["qos"])
+	return q != qosAtMostOnce
+}
+
+// qosTracker retries the acknowledged messages a node sends and holds back
+// ordered messages while an earlier one to the same recipient is unacked
+type qosTracker struct {
+	mu sync.Mutex
+	// unacked are the published messages waiting for their receipt
+	unacked map[string]*unackedMessage
+	// ordered are the ordered messages per recipient, the first one in
+	// flight and the others waiting for it
+	ordered map[string][]outboundJob
+}
+
+type unackedMessage struct {
+	job      outboundJob
+	attempts int
+	timer    *time.Timer
+}
+
+func newQoSTracker() *qosTracker {
+	return &qosTracker{
+		unacked: make(map[string]*unackedMessage),
+		ordered: make(map[string][]outboundJob),
+	}
+}
+
+// holdOrdered queues an ordered message behind the ones in flight to its
+// recipient and reports whether it can be published now
+func (q *qosTracker) holdOrdered(job outboundJob, limit int) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiting := q.ordered[job.to]
+	if len(waiting) >= limit {
+		return false, errOutboxFull
+	}
+	q.ordered[job.to] = append(waiting, job)
+	return len(waiting) == 0, nil
+}
+
+// nextOrdered drops the ordered message in flight to a recipient and returns
+// the one to publish next, if any
+func (q *qosTracker) nextOrdered(to, id string) (outboundJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiting := q.ordered[to]
+	if len(waiting) == 0 || waiting[0].id != id {
+		return outboundJob{}, false
+	}
+	waiting = waiting[1:]
+	if len(waiting) == 0 {
+		delete(q.ordered, to)
+		return outboundJob{}, false
+	}
+	q.ordered[to] = waiting
+	return waiting[0], true
+}
+
+// awaitReceipt arms the retry timer of an acknowledged message after a
+// publish attempt
+func (s *Libp2pNodeService) awaitReceipt(job outboundJob) {
+	q := s.qos
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, ok := q.unacked[job.id]
+	if !ok {
+		u = &unackedMessage{job: job}
+		q.unacked[job.id] = u
+	}
+	u.attempts++
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.timer = time.AfterFunc(s.cfg.QoSAckTimeout, func() { s.retryUnacked(job.id) })
+}
+
+// retryUnacked publishes a message again when its receipt is overdue, or
+// gives up after QoSMaxAttempts
+func (s *Libp2pNodeService) retryUnacked(id string) {
+	q := s.qos
+	q.mu.Lock()
+	u, ok := q.unacked[id]
+	if !ok {
+		q.mu.Unlock()
+		return
+	}
+	// A receipt may have beaten the timer being armed
+	if st, _ := s.statuses.Get(id); st.State == stateAcked {
+		delete(q.unacked, id)
+		q.mu.Unlock()
+		s.releaseOrdered(u.job)
+		return
+	}
+	if u.attempts >= s.cfg.QoSMaxAttempts || messageExpired(u.job.envelope) {
+		delete(q.unacked, id)
+		q.mu.Unlock()
+		err := fmt.Errorf("not acknowledged after %d attempts", u.attempts)
+		if messageExpired(u.job.envelope) {
+			err = errors.New("expired before it was acknowledged")
+		}
+		log.Printf("Giving up on message %s: %v", envelopeRef(u.job.envelope), err)
+		s.statuses.Set(id, u.job.to, stateFailed, err)
+		s.releaseOrdered(u.job)
+		return
+	}
+	job := u.job
+	q.mu.Unlock()
+
+	// The original caller has stopped waiting: retries run detached
+	job.ctx, job.done = context.WithoutCancel(job.ctx), nil
+	debugf("Republishing unacknowledged message %s", envelopeRef(job.envelope))
+	s.statuses.Set(id, job.to, stateQueued, nil)
+	if err := s.outbox.Enqueue(job.priority, job); err != nil {
+		// Try again at the next timeout
+		s.awaitReceipt(job)
+	}
+}
+
+// receiptReceived stops retrying an acknowledged message and releases the
+// next ordered message to its recipient
+func (s *Libp2pNodeService) receiptReceived(id string) {
+	q := s.qos
+	q.mu.Lock()
+	u, ok := q.unacked[id]
+	if ok {
+		u.timer.Stop()
+		delete(q.unacked, id)
+	}
+	q.mu.Unlock()
+	if ok {
+		s.releaseOrdered(u.job)
+	}
+}
+
+// releaseOrdered publishes the ordered message waiting behind job, if any
+func (s *Libp2pNodeService) releaseOrdered(job outboundJob) {
+	if job.qos != qosOrdered {
+		return
+	}
+	for {
+		next, ok := s.qos.nextOrdered(job.to, job.id)
+		if !ok {
+			return
+		}
+		err := s.outbox.Enqueue(next.priority, next)
+		if err == nil {
+			return
+		}
+		log.Printf("Error queueing ordered message %s: %v", envelopeRef(next.envelope), err)
+		s.statuses.Set(next.id, next.to, stateFailed, err)
+		if next.done != nil {
+			next.done <- err
+		}
+		job = next
+	}
+}
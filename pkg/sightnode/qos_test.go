@@ -0,0 +1,109 @@
+package sightnode
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseQoS(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{nil, qosAtMostOnce, false},
+		{"", qosAtMostOnce, false},
+		{"at-least-once", qosAtLeastOnce, false},
+		{"ORDERED", qosOrdered, false},
+		{"exactly-once", "", true},
+	} {
+		got, err := parseQoS(tc.value)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("parseQoS(%v) = %q, %v; want %q, error %v", tc.value, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestOrderedMessagesWaitForTheirPredecessor(t *testing.T) {
+	q := newQoSTracker()
+	job := func(id, to string) outboundJob { return outboundJob{id: id, to: to, qos: qosOrdered} }
+
+	for _, tc := range []struct {
+		job  outboundJob
+		now  bool
+		full bool
+	}{
+		{job("1", "a"), true, false},
+		{job("2", "a"), false, false},
+		// Recipients are ordered independently
+		{job("3", "b"), true, false},
+		{job("4", "a"), false, true},
+	} {
+		now, err := q.holdOrdered(tc.job, 2)
+		if now != tc.now || (err != nil) != tc.full {
+			t.Errorf("holdOrdered(%s to %s) = %v, %v; want %v, full %v", tc.job.id, tc.job.to, now, err, tc.now, tc.full)
+		}
+	}
+
+	if _, ok := q.nextOrdered("a", "2"); ok {
+		t.Error("a message that is not in flight released the next one")
+	}
+	if next, ok := q.nextOrdered("a", "1"); !ok || next.id != "2" {
+		t.Errorf("acknowledging 1 released %q, %v; want 2", next.id, ok)
+	}
+	if _, ok := q.nextOrdered("a", "2"); ok {
+		t.Error("the last message to a released another one")
+	}
+}
+
+// qosService is a node with only what the QoS retries use; published counts
+// the publish attempts, which arm the retry timer like publishOutbound does
+func qosService(published *atomic.Int32) *Libp2pNodeService {
+	cfg := Config{QoSAckTimeout: 20 * time.Millisecond, QoSMaxAttempts: 3, OutboxSize: 10, OutboxWorkers: 1}
+	s := &Libp2pNodeService{cfg: cfg, qos: newQoSTracker(), statuses: newStatusTracker(time.Minute)}
+	s.outbox = newOutbox(cfg, func(job outboundJob) error {
+		published.Add(1)
+		s.awaitReceipt(job)
+		return nil
+	})
+	return s
+}
+
+func TestUnacknowledgedMessagesAreRetried(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ack       bool
+		attempts  int32
+		wantState string
+	}{
+		{"gives up after QOS_MAX_ATTEMPTS", false, 3, stateFailed},
+		{"stops once acknowledged", true, 1, stateAcked},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var published atomic.Int32
+			s := qosService(&published)
+			job := outboundJob{ctx: context.Background(), id: "m1", to: "did:sight:a", qos: qosAtLeastOnce, envelope: map[string]interface{}{}}
+			s.statuses.Set(job.id, job.to, stateQueued, nil)
+			if err := s.outbox.Enqueue(priorityNormal, job); err != nil {
+				t.Fatal(err)
+			}
+			if tc.ack {
+				for published.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				s.statuses.Set(job.id, job.to, stateAcked, nil)
+				s.receiptReceived(job.id)
+			}
+
+			time.Sleep(10 * s.cfg.QoSAckTimeout)
+			if n := published.Load(); n != tc.attempts {
+				t.Errorf("published %d times, want %d", n, tc.attempts)
+			}
+			if st, _ := s.statuses.Get(job.id); st.State != tc.wantState {
+				t.Errorf("message is %q, want %q", st.State, tc.wantState)
+			}
+		})
+	}
+}
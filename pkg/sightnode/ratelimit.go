@@ -0,0 +1,125 @@
+package sightnode
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitPenaltyHalfLife is how fast a peer's rate-limit penalty fades
+const rateLimitPenaltyHalfLife = time.Minute
+
+// rateLimitIdle is how long an inactive sender is tracked
+const rateLimitIdle = 10 * time.Minute
+
+// peerRateLimiter enforces PUBSUB_RATE_LIMIT per sending PeerID and keeps a
+// decaying penalty for every rejected message, which lowers the peer's
+// gossipsub score
+type peerRateLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	peers     map[peer.ID]*peerRate
+	lastPrune time.Time
+}
+
+type peerRate struct {
+	limiter  *rate.Limiter
+	penalty  float64
+	lastSeen time.Time
+}
+
+// newPeerRateLimiter returns nil when rate limiting is disabled
+func newPeerRateLimiter(cfg Config) *peerRateLimiter {
+	if cfg.PubsubRateLimit <= 0 {
+		return nil
+	}
+	burst := cfg.PubsubRateBurst
+	if burst <= 0 {
+		burst = 2 * cfg.PubsubRateLimit
+	}
+	return &peerRateLimiter{
+		limit:     rate.Limit(cfg.PubsubRateLimit),
+		burst:     burst,
+		peers:     make(map[peer.ID]*peerRate),
+		lastPrune: time.Now(),
+	}
+}
+
+// decayed returns the penalty after the time elapsed since it was last updated
+func (r *peerRate) decayed(now time.Time) float64 {
+	return r.penalty * math.Pow(0.5, float64(now.Sub(r.lastSeen))/float64(rateLimitPenaltyHalfLife))
+}
+
+// Allow counts a message from id and reports whether it is within the limit
+func (l *peerRateLimiter) Allow(id peer.ID) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > rateLimitIdle {
+		for p, r := range l.peers {
+			if now.Sub(r.lastSeen) > rateLimitIdle {
+				delete(l.peers, p)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	r, ok := l.peers[id]
+	if !ok {
+		r = &peerRate{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.peers[id] = r
+	}
+	r.penalty = r.decayed(now)
+	r.lastSeen = now
+	if r.limiter.AllowN(now, 1) {
+		return true
+	}
+	r.penalty++
+	return false
+}
+
+// Score is the application-specific gossipsub score of a peer: minus its
+// current penalty
+func (l *peerRateLimiter) Score(id peer.ID) float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.peers[id]
+	if !ok {
+		return 0
+	}
+	return -r.decayed(time.Now())
+}
+
+// pubsubOptions enables peer scoring driven by the rate-limit penalties:
+// peers stop receiving gossip at -10, are not published to at -50 and are
+// graylisted at -80
+func (l *peerRateLimiter) pubsubOptions() []pubsub.Option {
+	if l == nil {
+		return nil
+	}
+	params := &pubsub.PeerScoreParams{
+		Topics:            make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:  l.Score,
+		AppSpecificWeight: 1,
+		DecayInterval:     time.Second,
+		DecayToZero:       0.01,
+	}
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:   -10,
+		PublishThreshold:  -50,
+		GraylistThreshold: -80,
+	}
+	return []pubsub.Option{pubsub.WithPeerScore(params, thresholds)}
+}
@@ -0,0 +1,142 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// receiptType is the payload type of delivery receipts, as seen by the tunnel API
+const receiptType = "sight.receipt"
+
+// DeliveryReceipt confirms that the recipient forwarded a message to its
+// tunnel API. It is signed by the recipient key so the sender can trust it.
+type DeliveryReceipt struct {
+	Type         string `json:"type"`
+	MessageID    string `json:"messageId"`
+	RecipientDID string `json:"recipientDid"`
+	DeliveredAt  string `json:"deliveredAt"`
+	Signature    []byte `json:"signature,omitempty"`
+}
+
+// signingBytes is the receipt encoding covered by the signature
+func (r DeliveryReceipt) signingBytes() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// Verify checks the receipt signature against the recipient DID key
+func (r DeliveryReceipt) Verify() error {
+	pub, err := DIDPublicKey(r.RecipientDID)
+	if err != nil {
+		return err
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, r.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid receipt signature")
+	}
+	return nil
+}
+
+// isReceipt reports whether an envelope carries a delivery receipt
+func isReceipt(envelope map[string]interface{}) bool {
+	receipt, _ := envelope["receipt"].(bool)
+	return receipt
+}
+
+// receiptCallback returns a function sending one receipt for the message to
+// its sender, however many webhooks the message is forwarded to. Messages
+// with a QoS are acknowledged even when DELIVERY_RECEIPTS is off.
+func (s *Libp2pNodeService) receiptCallback(msg StreamMessage) func() {
+	if (!s.cfg.DeliveryReceipts && msg.QoS == "") || msg.ID == "" || msg.FromDID == "" {
+		return nil
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if msg.QoS != "" {
+				s.qosDelivered.Seen(msg.ID)
+			}
+			if err := s.sendReceipt(msg.ID, msg.FromDID); err != nil {
+				log.Printf("Error sending receipt for message %s: %v", msg.ID, err)
+			}
+		})
+	}
+}
+
+// sendReceipt signs a receipt for messageID and queues it for the sender
+func (s *Libp2pNodeService) sendReceipt(messageID, senderDID string) error {
+	s.mu.RLock()
+	kp, did := s.keypair, s.did
+	s.mu.RUnlock()
+
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return err
+	}
+	receipt := DeliveryReceipt{
+		Type:         receiptType,
+		MessageID:    messageID,
+		RecipientDID: did,
+		DeliveredAt:  time.Now().Format(time.RFC3339),
+	}
+	data, err := receipt.signingBytes()
+	if err != nil {
+		return err
+	}
+	if receipt.Signature, err = priv.Sign(data); err != nil {
+		return err
+	}
+
+	// Round-trip through JSON so the payload looks the same as a decoded one
+	var payload map[string]interface{}
+	if data, err = json.Marshal(receipt); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+	_, _, err = s.enqueueOutgoing(context.Background(), map[string]interface{}{
+		"to":      senderDID,
+		"payload": payload,
+		"receipt": true,
+	})
+	return err
+}
+
+// handleReceipt verifies a received receipt and marks the message as acked.
+// It returns false for receipts that should not reach the tunnel API.
+func (s *Libp2pNodeService) handleReceipt(envelope map[string]interface{}) bool {
+	data, err := json.Marshal(envelope["payload"])
+	if err != nil {
+		return false
+	}
+	var receipt DeliveryReceipt
+	if err := json.Unmarshal(data, &receipt); err != nil || receipt.Type != receiptType {
+		debugf("Dropping malformed receipt %v", envelope["id"])
+		return false
+	}
+	if err := receipt.Verify(); err != nil {
+		log.Printf("Dropping receipt for %s: %v", receipt.MessageID, err)
+		return false
+	}
+	// Only the recipient of a message we sent can acknowledge it
+	status, ok := s.statuses.Get(receipt.MessageID)
+	if !ok || status.To != receipt.RecipientDID {
+		debugf("Dropping receipt for unknown message %s", receipt.MessageID)
+		return false
+	}
+	s.statuses.Set(receipt.MessageID, status.To, stateAcked, nil)
+	s.receiptReceived(receipt.MessageID)
+	return true
+}
@@ -0,0 +1,576 @@
+package sightnode
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// recordsTopic replicates signed records to every node
+const recordsTopic = "sight-records"
+
+// recordsProtocol fetches records from a connected peer
+const recordsProtocol protocol.ID = "/sight/records/1.0.0"
+
+// maxRecordQueryPeers bounds how many peers a lookup asks
+const maxRecordQueryPeers = 8
+
+// Record is a small signed value published by a DID. Its key is
+// "<did>/<name>"; only the holder of the DID key can write below its DID,
+// which is the namespace the validator enforces.
+type Record struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Seq       uint64          `json:"seq"`
+	ExpiresAt string          `json:"expiresAt"`
+	Signature []byte          `json:"signature,omitempty"`
+}
+
+// splitRecordKey returns the owner DID and the name of a record key
+func splitRecordKey(key string) (string, string, error) {
+	i := strings.Index(key, "/")
+	if i <= 0 || i == len(key)-1 {
+		return "", "", errors.New("record key must be <did>/<name>")
+	}
+	did, name := key[:i], key[i+1:]
+	if _, err := ParseSightDID(did); err != nil {
+		return "", "", err
+	}
+	return did, name, nil
+}
+
+// signingBytes is the record encoding covered by the signature
+func (r Record) signingBytes() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// Expired reports whether the record is past its expiry
+func (r Record) Expired() bool {
+	expiresAt, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// Verify checks the record signature against the key of the DID owning it
+func (r Record) Verify(maxSize int) error {
+	if len(r.Value) > maxSize {
+		return fmt.Errorf("record value exceeds %d bytes", maxSize)
+	}
+	did, _, err := splitRecordKey(r.Key)
+	if err != nil {
+		return err
+	}
+	pub, err := DIDPublicKey(did)
+	if err != nil {
+		return err
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, r.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid record signature")
+	}
+	return nil
+}
+
+// recordStore holds the latest valid version of every record seen, persisted
+// so a restarted node can answer lookups before the mesh has re-replicated.
+// Records come from any peer, so their lifetime and number are capped: in
+// total and per DID, which bounds what one identity can make others store.
+// Changes are appended to a log, rewritten only once it holds mostly
+// superseded entries.
+type recordStore struct {
+	mu        sync.RWMutex
+	maxSize   int
+	maxTTL    time.Duration
+	maxTotal  int
+	maxPerDID int
+	records   map[string]Record
+	perDID    map[string]int
+	path      string
+	log       *os.File
+	// logged counts the entries in the log, live or superseded
+	logged int
+}
+
+// recordLogEntry is one line of the record log: a stored record, or the
+// removal of its key
+type recordLogEntry struct {
+	Record
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func newRecordStore(cfg Config) (*recordStore, error) {
+	if cfg.RecordMaxTTL < cfg.RecordTTL {
+		return nil, fmt.Errorf("RECORD_MAX_TTL (%s) must not be shorter than RECORD_TTL (%s)", cfg.RecordMaxTTL, cfg.RecordTTL)
+	}
+	if cfg.RecordMaxRecords < 1 || cfg.RecordMaxPerDID < 1 {
+		return nil, errors.New("RECORD_MAX_RECORDS and RECORD_MAX_PER_DID must be at least 1")
+	}
+	rs := &recordStore{
+		maxSize:   cfg.RecordMaxSize,
+		maxTTL:    cfg.RecordMaxTTL,
+		maxTotal:  cfg.RecordMaxRecords,
+		maxPerDID: cfg.RecordMaxPerDID,
+		records:   make(map[string]Record),
+		perDID:    make(map[string]int),
+		path:      cfg.DataDir + "/records.log",
+	}
+	rs.load(cfg.DataDir + "/records.json")
+	return rs, nil
+}
+
+// Check verifies a record and that its lifetime is within RECORD_MAX_TTL
+func (rs *recordStore) Check(r Record) error {
+	if err := r.Verify(rs.maxSize); err != nil {
+		return err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("invalid record expiry: %w", err)
+	}
+	if time.Until(expiresAt) > rs.maxTTL+time.Minute {
+		return fmt.Errorf("record expires more than %s ahead", rs.maxTTL)
+	}
+	return nil
+}
+
+// Put validates a record and stores it when newer than the stored version.
+// It reports whether the record was stored.
+func (rs *recordStore) Put(r Record) (bool, error) {
+	if err := rs.Check(r); err != nil {
+		return false, err
+	}
+	if r.Expired() {
+		return false, errors.New("record expired")
+	}
+	did, _, _ := splitRecordKey(r.Key)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	old, exists := rs.records[r.Key]
+	if exists && old.Seq >= r.Seq && !old.Expired() {
+		return false, nil
+	}
+	if !exists {
+		if rs.perDID[did] >= rs.maxPerDID || len(rs.records) >= rs.maxTotal {
+			rs.pruneExpired()
+		}
+		if rs.perDID[did] >= rs.maxPerDID {
+			return false, fmt.Errorf("%s already has %d records", did, rs.maxPerDID)
+		}
+		if len(rs.records) >= rs.maxTotal {
+			return false, errors.New("record store is full")
+		}
+	}
+	rs.set(r)
+	rs.append(recordLogEntry{Record: r})
+	return true, nil
+}
+
+// set stores a record; callers hold rs.mu
+func (rs *recordStore) set(r Record) {
+	if _, ok := rs.records[r.Key]; !ok {
+		did, _, _ := splitRecordKey(r.Key)
+		rs.perDID[did]++
+	}
+	rs.records[r.Key] = r
+}
+
+// remove deletes a record; callers hold rs.mu
+func (rs *recordStore) remove(key string) {
+	if _, ok := rs.records[key]; !ok {
+		return
+	}
+	delete(rs.records, key)
+	did, _, _ := splitRecordKey(key)
+	if rs.perDID[did]--; rs.perDID[did] <= 0 {
+		delete(rs.perDID, did)
+	}
+}
+
+// pruneExpired drops expired records; callers hold rs.mu
+func (rs *recordStore) pruneExpired() {
+	for key, r := range rs.records {
+		if r.Expired() {
+			rs.remove(key)
+		}
+	}
+}
+
+// Get returns an unexpired record
+func (rs *recordStore) Get(key string) (Record, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	r, ok := rs.records[key]
+	if !ok || r.Expired() {
+		return Record{}, false
+	}
+	return r, true
+}
+
+// Find returns the unexpired records whose name is name, whatever their owner
+func (rs *recordStore) Find(name string) []Record {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	var found []Record
+	for key, r := range rs.records {
+		if strings.HasSuffix(key, "/"+name) && !r.Expired() {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Owned returns the records published by did, for republishing
+func (rs *recordStore) Owned(did string) []Record {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	var owned []Record
+	for key, r := range rs.records {
+		if strings.HasPrefix(key, did+"/") {
+			owned = append(owned, r)
+		}
+	}
+	return owned
+}
+
+// RemoveOwned deletes the records published by did
+func (rs *recordStore) RemoveOwned(did string) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	removed := 0
+	for key := range rs.records {
+		if strings.HasPrefix(key, did+"/") {
+			rs.remove(key)
+			rs.append(recordLogEntry{Record: Record{Key: key}, Deleted: true})
+			removed++
+		}
+	}
+	return removed
+}
+
+// load replays the record log, after importing the records.json snapshot
+// written by earlier versions
+func (rs *recordStore) load(legacyPath string) {
+	if data, err := os.ReadFile(legacyPath); err == nil {
+		legacy := make(map[string]Record)
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			log.Printf("Error reading record store: %v", err)
+		}
+		for _, r := range legacy {
+			rs.set(r)
+		}
+	}
+	if file, err := os.Open(rs.path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 4*rs.maxSize+64*1024)
+		for scanner.Scan() {
+			var entry recordLogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				log.Printf("Skipping invalid record log entry: %v", err)
+				continue
+			}
+			if entry.Deleted {
+				rs.remove(entry.Key)
+			} else {
+				rs.set(entry.Record)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("Error reading record log: %v", err)
+		}
+		file.Close()
+	}
+	rs.pruneExpired()
+	rs.compact()
+	if err := os.Remove(legacyPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing %s: %v", legacyPath, err)
+	}
+}
+
+// append logs one change, already applied to rs.records; once most of the
+// log is superseded it is rewritten instead. Callers hold rs.mu.
+func (rs *recordStore) append(entry recordLogEntry) {
+	if rs.logged > 2*len(rs.records)+100 {
+		rs.pruneExpired()
+		rs.compact()
+		return
+	}
+	if rs.log == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error marshalling record: %v", err)
+		return
+	}
+	if _, err := rs.log.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing record log: %v", err)
+		return
+	}
+	rs.logged++
+}
+
+// compact rewrites the log with only the live records and reopens it for
+// appending; callers hold rs.mu (or own the store while loading)
+func (rs *recordStore) compact() {
+	if rs.log != nil {
+		rs.log.Close()
+		rs.log = nil
+	}
+	tmp := rs.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Error writing record log: %v", err)
+		return
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, r := range rs.records {
+		if err := enc.Encode(recordLogEntry{Record: r}); err != nil {
+			log.Printf("Error marshalling record: %v", err)
+		}
+	}
+	err = w.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, rs.path)
+	}
+	if err != nil {
+		log.Printf("Error writing record log: %v", err)
+		return
+	}
+	rs.logged = len(rs.records)
+	if rs.log, err = os.OpenFile(rs.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		log.Printf("Error opening record log: %v", err)
+	}
+}
+
+// recordQuery asks a peer for one record by key, or all records with a name
+type recordQuery struct {
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// PutRecord signs value as the record name of this node and replicates it
+func (s *Libp2pNodeService) PutRecord(ctx context.Context, name string, value json.RawMessage) (Record, error) {
+	s.mu.RLock()
+	kp, did := s.keypair, s.did
+	s.mu.RUnlock()
+
+	key := did + "/" + name
+	record := Record{Key: key, Value: value, Seq: uint64(time.Now().UnixNano())}
+	if old, ok := s.records.Get(key); ok && old.Seq >= record.Seq {
+		record.Seq = old.Seq + 1
+	}
+	record.ExpiresAt = time.Now().Add(s.cfg.RecordTTL).Format(time.RFC3339)
+	priv, err := kp.PrivKey()
+	if err != nil {
+		return Record{}, err
+	}
+	data, err := record.signingBytes()
+	if err != nil {
+		return Record{}, err
+	}
+	if record.Signature, err = priv.Sign(data); err != nil {
+		return Record{}, err
+	}
+	if _, err := s.records.Put(record); err != nil {
+		return Record{}, err
+	}
+	return record, s.publishRecord(ctx, record)
+}
+
+// GetRecord returns a record from the local store, or asks connected peers
+func (s *Libp2pNodeService) GetRecord(ctx context.Context, key string) (Record, error) {
+	if _, _, err := splitRecordKey(key); err != nil {
+		return Record{}, err
+	}
+	if r, ok := s.records.Get(key); ok {
+		return r, nil
+	}
+	for _, r := range s.queryPeers(ctx, recordQuery{Key: key}) {
+		if r.Key == key {
+			if _, err := s.records.Put(r); err == nil {
+				return r, nil
+			}
+		}
+	}
+	return Record{}, errors.New("record not found")
+}
+
+// FindRecords returns the records named name of every DID, local and from peers
+func (s *Libp2pNodeService) FindRecords(ctx context.Context, name string) []Record {
+	for _, r := range s.queryPeers(ctx, recordQuery{Name: name}) {
+		if strings.HasSuffix(r.Key, "/"+name) {
+			s.records.Put(r)
+		}
+	}
+	return s.records.Find(name)
+}
+
+func (s *Libp2pNodeService) publishRecord(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	topic := s.recordsTopic
+	s.mu.RUnlock()
+	return s.publishTopic(ctx, topic, data)
+}
+
+// joinRecordsTopic stores replicated records and periodically republishes
+// our own so they survive peers joining later
+func (s *Libp2pNodeService) joinRecordsTopic(ctx context.Context) error {
+	// Invalid records are not forwarded to the rest of the mesh
+	err := s.registerTopicValidator(recordsTopic, func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		var record Record
+		if json.Unmarshal(messageData(msg), &record) != nil || s.records.Check(record) != nil {
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	})
+	if err != nil {
+		return err
+	}
+	topic, err := s.pubsub.Join(recordsTopic)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.recordsTopic = topic
+	go s.supervise(ctx, sub, func() (Subscription, error) { return topic.Subscribe() }, func(msg *pubsub.Message) {
+		var record Record
+		if err := json.Unmarshal(messageData(msg), &record); err != nil {
+			debugf("Invalid record from %s: %v", msg.GetFrom(), err)
+			return
+		}
+		if _, err := s.records.Put(record); err != nil {
+			debugf("Rejected record %s: %v", record.Key, err)
+		}
+	})
+	go s.republishRecords(ctx)
+	return nil
+}
+
+func (s *Libp2pNodeService) republishRecords(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.RecordTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.RLock()
+			did := s.did
+			s.mu.RUnlock()
+			for _, r := range s.records.Owned(did) {
+				name := strings.TrimPrefix(r.Key, did+"/")
+				if _, err := s.PutRecord(ctx, name, r.Value); err != nil {
+					log.Printf("Error republishing record %s: %v", r.Key, err)
+				}
+			}
+		}
+	}
+}
+
+// queryPeers asks up to maxRecordQueryPeers connected peers in parallel
+func (s *Libp2pNodeService) queryPeers(ctx context.Context, q recordQuery) []Record {
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+
+	peers := node.Network().Peers()
+	if len(peers) > maxRecordQueryPeers {
+		peers = peers[:maxRecordQueryPeers]
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var found []Record
+	for _, id := range peers {
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			records, err := queryRecords(ctx, node.NewStream, id, q)
+			if err != nil {
+				debugf("Record query to %s failed: %v", id, err)
+				return
+			}
+			mu.Lock()
+			found = append(found, records...)
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return found
+}
+
+func queryRecords(ctx context.Context, newStream func(context.Context, peer.ID, ...protocol.ID) (network.Stream, error), id peer.ID, q recordQuery) ([]Record, error) {
+	st, err := newStream(ctx, id, recordsProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(st).Encode(q); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	st.CloseWrite()
+	var records []Record
+	if err := json.NewDecoder(st).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// handleRecordsStream answers a record query from the local store
+func (s *Libp2pNodeService) handleRecordsStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	var q recordQuery
+	if err := json.NewDecoder(st).Decode(&q); err != nil {
+		st.Reset()
+		return
+	}
+	records := []Record{}
+	switch {
+	case q.Key != "":
+		if r, ok := s.records.Get(q.Key); ok {
+			records = append(records, r)
+		}
+	case q.Name != "":
+		records = append(records, s.records.Find(q.Name)...)
+	}
+	if err := json.NewEncoder(st).Encode(records); err != nil {
+		st.Reset()
+	}
+}
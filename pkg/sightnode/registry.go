@@ -0,0 +1,255 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// directProtocol delivers a single envelope straight to its recipient,
+// bypassing the gossipsub broadcast
+const directProtocol = protocol.ID("/sight/msg/1.0.0")
+
+// directTopic is reported as the topic of messages received over directProtocol
+const directTopic = "direct"
+
+// RegistryEntry maps a DID to the peer and addresses last seen through identify
+type RegistryEntry struct {
+	DID       string   `json:"did"`
+	PeerID    string   `json:"peerId"`
+	Addrs     []string `json:"addrs"`
+	Connected bool     `json:"connected"`
+	LastSeen  string   `json:"lastSeen"`
+	// Gateway is the federated gateway the DID is homed on, empty for the
+	// hosters of this gateway
+	Gateway string `json:"gateway,omitempty"`
+	// Encodings are the payload encodings the DID's node decodes, so
+	// senders that are not its peers can compress for it
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+// didRegistry is the gateway routing table, filled from identify events.
+// The gateway relays the inbox topic of every registered DID, so a hoster
+// publishing to another one's inbox always has the gateway as a mesh peer.
+type didRegistry struct {
+	mu      sync.RWMutex
+	host    hostlibp2p.Host
+	entries map[string]*RegistryEntry
+	relays  map[string]pubsub.RelayCancelFunc
+
+	// admit filters the peers that may be routed to, when set
+	admit func(peer.ID) bool
+	// join returns the handle of a topic to relay, when set
+	join func(string) (Topic, error)
+}
+
+func newDIDRegistry(h hostlibp2p.Host) *didRegistry {
+	return &didRegistry{
+		host:    h,
+		entries: make(map[string]*RegistryEntry),
+		relays:  make(map[string]pubsub.RelayCancelFunc),
+	}
+}
+
+// Start records every peer completing identify until ctx is done
+func (r *didRegistry) Start(ctx context.Context) error {
+	sub, err := r.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				r.record(evt.Peer, evt.ListenAddrs)
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *didRegistry) record(id peer.ID, listenAddrs []ma.Multiaddr) {
+	did, err := peerDID(r.host.Peerstore(), id)
+	if err != nil {
+		return
+	}
+	if r.admit != nil && !r.admit(id) {
+		debugf("Registry: %s is not admitted", did)
+		return
+	}
+	addrs := make([]string, 0, len(listenAddrs))
+	for _, addr := range listenAddrs {
+		addrs = append(addrs, addr.String())
+	}
+	r.mu.Lock()
+	r.entries[did] = &RegistryEntry{
+		DID:       did,
+		PeerID:    id.String(),
+		Addrs:     addrs,
+		LastSeen:  time.Now().Format(time.RFC3339),
+		Encodings: peerEncodings(r.host.Peerstore(), id),
+	}
+	_, relayed := r.relays[did]
+	r.mu.Unlock()
+	debugf("Registry: %s is %s", did, id)
+	if !relayed {
+		r.relay(did)
+	}
+}
+
+// relay starts relaying the inbox topic of a registered DID
+func (r *didRegistry) relay(did string) {
+	if r.join == nil {
+		return
+	}
+	topic, err := r.join(inboxTopic(did))
+	var cancel pubsub.RelayCancelFunc
+	if err == nil {
+		cancel, err = topic.Relay()
+	}
+	if err != nil {
+		log.Printf("Error relaying inbox of %s: %v", did, err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[did]; !ok || r.relays[did] != nil {
+		// Removed or relayed by a concurrent record in the meantime
+		cancel()
+		return
+	}
+	r.relays[did] = cancel
+}
+
+// Remove drops the entry of a DID and stops relaying its inbox
+func (r *didRegistry) Remove(did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, did)
+	if cancel, ok := r.relays[did]; ok {
+		cancel()
+		delete(r.relays, did)
+	}
+}
+
+// Lookup returns the PeerID registered for a DID
+func (r *didRegistry) Lookup(did string) (peer.ID, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[did]
+	if !ok {
+		return "", false
+	}
+	id, err := peer.Decode(entry.PeerID)
+	return id, err == nil
+}
+
+// List returns the registry sorted by DID, with the current connection state
+func (r *didRegistry) List() []RegistryEntry {
+	r.mu.RLock()
+	list := make([]RegistryEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		list = append(list, *entry)
+	}
+	r.mu.RUnlock()
+	for i := range list {
+		if id, err := peer.Decode(list[i].PeerID); err == nil {
+			list[i].Connected = r.host.Network().Connectedness(id) == network.Connected
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DID < list[j].DID })
+	return list
+}
+
+// routeDirect sends an envelope, JSON encoded as data or in the recipient's
+// preferred wire format, straight to a registered recipient that supports it.
+// A false result means the caller should broadcast instead.
+func (s *Libp2pNodeService) routeDirect(ctx context.Context, to string, envelope map[string]interface{}, data []byte) bool {
+	s.mu.RLock()
+	node, registry := s.node, s.registry
+	s.mu.RUnlock()
+	if registry == nil {
+		return false
+	}
+	id, ok := registry.Lookup(to)
+	if !ok {
+		return false
+	}
+	if supported, _ := node.Peerstore().SupportsProtocols(id, directProtocol); len(supported) == 0 {
+		return false
+	}
+
+	if err := sendDirect(ctx, node, id, s.directEnvelope(node, id, envelope, data)); err != nil {
+		debugf("Direct delivery to %s failed, broadcasting: %v", to, err)
+		return false
+	}
+	return true
+}
+
+func sendDirect(ctx context.Context, h hostlibp2p.Host, id peer.ID, data []byte) error {
+	st, err := h.NewStream(ctx, id, directProtocol)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+	if _, err := st.Write(data); err != nil {
+		st.Reset()
+		return err
+	}
+	return st.Close()
+}
+
+// handleDirectStream accepts an envelope sent over directProtocol. The secure
+// channel authenticates the sender, so no pubsub signature is required. The
+// read buffer is pooled: handleEnvelope copies what it keeps.
+func (s *Libp2pNodeService) handleDirectStream(st network.Stream) {
+	defer st.Close()
+	from := st.Conn().RemotePeer()
+	// The pubsub rate limit applies to direct messages too, checked before
+	// reading the message; refusals add to the sender's penalty
+	if !s.rateLimiter.Allow(from) {
+		debugf("Rejecting direct message from %s over the rate limit", from)
+		st.Reset()
+		return
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	_, err := buf.ReadFrom(io.LimitReader(st, int64(s.cfg.MaxMessageSize)+1))
+	data := buf.Bytes()
+	if err == nil && len(data) > s.cfg.MaxMessageSize {
+		err = errors.New("message too large")
+	}
+	if err == nil {
+		err = checkEnvelope(data)
+	}
+	if err == nil && !s.admitted(from) {
+		err = errors.New("sender is not admitted")
+	}
+	if err != nil {
+		log.Printf("Rejecting direct message from %s: %v", from, err)
+		st.Reset()
+		return
+	}
+	s.handleEnvelope(context.Background(), directTopic, from, data)
+}
@@ -0,0 +1,79 @@
+package sightnode
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// errNoConfigSource is returned by ReloadConfig for nodes created without
+// WithConfigSource: the environment of a running process does not change, and
+// an embedder's Config must not be replaced by it
+var errNoConfigSource = errors.New("no configuration source to reload from, see CONFIG_FILE")
+
+// ReloadConfig reads the configuration from the node's source, see
+// WithConfigSource, and applies the settings that can change without
+// restarting the host: log level, webhook targets, allow/block lists, peer
+// protections and tags, and the legacy topic subscription. It returns the
+// sections applied.
+func (s *Libp2pNodeService) ReloadConfig() ([]string, error) {
+	if s.loadConfig == nil {
+		return nil, errNoConfigSource
+	}
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading configuration: %w", err)
+	}
+	webhooks, err := loadWebhookTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	SetLogLevel(cfg.LogLevel)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = webhooks
+
+	s.gater.Reload(cfg)
+	s.bootstrap.allowPeers()
+	if s.gateways != nil {
+		s.gateways.allowPeers()
+	}
+	if s.federation != nil {
+		s.federation.allowPeers()
+	}
+	if s.cluster != nil {
+		s.cluster.allowPeers()
+	}
+	s.applyPeerTags(s.cfg, cfg)
+	s.cfg.ProtectedPeers, s.cfg.PeerTags = cfg.ProtectedPeers, cfg.PeerTags
+
+	if err := s.setLegacyTopic(cfg.LegacyTopic); err != nil {
+		return nil, err
+	}
+	log.Printf("Configuration reloaded")
+	return []string{"logLevel", "webhooks", "peerLists", "peerTags", "topics"}, nil
+}
+
+// setLegacyTopic subscribes to or leaves the shared legacy topic; callers hold s.mu
+func (s *Libp2pNodeService) setLegacyTopic(enabled bool) error {
+	if enabled == s.cfg.LegacyTopic {
+		return nil
+	}
+	s.cfg.LegacyTopic = enabled
+	if enabled {
+		return s.subscribeInbox(s.ctx, messageTopic)
+	}
+	s.subHealth.untrack(messageTopic)
+	subs := s.subscriptions[:0]
+	for _, sub := range s.subscriptions {
+		if sub.Topic() == messageTopic {
+			sub.Cancel()
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	s.subscriptions = subs
+	return s.pubsub.UnregisterTopicValidator(messageTopic)
+}
@@ -0,0 +1,230 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// rendezvousProtocol registers and discovers peers under a namespace at a
+// rendezvous point (a gateway), a lighter alternative to a DHT for small
+// deployments
+const rendezvousProtocol protocol.ID = "/sight/rendezvous/1.0.0"
+
+// Rendezvous operations
+const (
+	rendezvousRegister = "register"
+	rendezvousDiscover = "discover"
+)
+
+type rendezvousRequest struct {
+	Op        string   `json:"op"`
+	Namespace string   `json:"namespace"`
+	TTL       int      `json:"ttl,omitempty"`
+	Addrs     []string `json:"addrs,omitempty"`
+}
+
+type rendezvousResponse struct {
+	Error         string                   `json:"error,omitempty"`
+	Registrations []RendezvousRegistration `json:"registrations,omitempty"`
+}
+
+// RendezvousRegistration is a peer registered under a namespace
+type RendezvousRegistration struct {
+	PeerID    string   `json:"peerId"`
+	Addrs     []string `json:"addrs"`
+	ExpiresAt string   `json:"expiresAt"`
+}
+
+// rendezvousPoint keeps the registrations of a gateway
+type rendezvousPoint struct {
+	mu     sync.Mutex
+	max    int
+	maxTTL time.Duration
+	regs   map[string]map[peer.ID]RendezvousRegistration
+}
+
+func newRendezvousPoint(cfg Config) *rendezvousPoint {
+	return &rendezvousPoint{
+		max:    cfg.RendezvousMaxRegistrations,
+		maxTTL: cfg.RendezvousTTL,
+		regs:   make(map[string]map[peer.ID]RendezvousRegistration),
+	}
+}
+
+// register records a peer under a namespace. The peer is the authenticated
+// remote of the stream, so peers can only register themselves.
+func (p *rendezvousPoint) register(id peer.ID, req rendezvousRequest) error {
+	if req.Namespace == "" {
+		return errors.New("namespace is required")
+	}
+	for _, addr := range req.Addrs {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			return err
+		}
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl <= 0 || ttl > p.maxTTL {
+		ttl = p.maxTTL
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(req.Namespace)
+	regs := p.regs[req.Namespace]
+	if regs == nil {
+		regs = make(map[peer.ID]RendezvousRegistration)
+		p.regs[req.Namespace] = regs
+	}
+	if _, ok := regs[id]; !ok && len(regs) >= p.max {
+		return errors.New("namespace is full")
+	}
+	regs[id] = RendezvousRegistration{
+		PeerID:    id.String(),
+		Addrs:     req.Addrs,
+		ExpiresAt: time.Now().Add(ttl).Format(time.RFC3339),
+	}
+	return nil
+}
+
+// discover lists the live registrations of a namespace
+func (p *rendezvousPoint) discover(namespace string) []RendezvousRegistration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(namespace)
+	regs := make([]RendezvousRegistration, 0, len(p.regs[namespace]))
+	for _, reg := range p.regs[namespace] {
+		regs = append(regs, reg)
+	}
+	return regs
+}
+
+// prune drops expired registrations; callers hold p.mu
+func (p *rendezvousPoint) prune(namespace string) {
+	now := time.Now()
+	for id, reg := range p.regs[namespace] {
+		if expiresAt, err := time.Parse(time.RFC3339, reg.ExpiresAt); err != nil || now.After(expiresAt) {
+			delete(p.regs[namespace], id)
+		}
+	}
+}
+
+// handleRendezvousStream serves one register or discover request
+func (s *Libp2pNodeService) handleRendezvousStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	var req rendezvousRequest
+	if err := json.NewDecoder(st).Decode(&req); err != nil {
+		st.Reset()
+		return
+	}
+	var resp rendezvousResponse
+	switch req.Op {
+	case rendezvousRegister:
+		if err := s.rendezvous.register(st.Conn().RemotePeer(), req); err != nil {
+			resp.Error = err.Error()
+		}
+	case rendezvousDiscover:
+		resp.Registrations = s.rendezvous.discover(req.Namespace)
+	default:
+		resp.Error = "unknown operation"
+	}
+	if err := json.NewEncoder(st).Encode(resp); err != nil {
+		st.Reset()
+	}
+}
+
+// rendezvousCall sends one request to a rendezvous point
+func rendezvousCall(ctx context.Context, h hostlibp2p.Host, point peer.ID, req rendezvousRequest) (rendezvousResponse, error) {
+	var resp rendezvousResponse
+	st, err := h.NewStream(ctx, point, rendezvousProtocol)
+	if err != nil {
+		return resp, err
+	}
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewEncoder(st).Encode(req); err != nil {
+		st.Reset()
+		return resp, err
+	}
+	st.CloseWrite()
+	if err := json.NewDecoder(st).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// runRendezvous registers under the configured namespace at every connected
+// rendezvous point and connects to the peers registered there, refreshing
+// before the registration expires
+func (s *Libp2pNodeService) runRendezvous(ctx context.Context, h hostlibp2p.Host) {
+	ns := s.cfg.RendezvousNamespace
+	interval := s.cfg.RendezvousTTL / 2
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		addrs := make([]string, 0)
+		for _, addr := range h.Addrs() {
+			addrs = append(addrs, addr.String())
+		}
+		for _, point := range h.Network().Peers() {
+			if ok, _ := h.Peerstore().SupportsProtocols(point, rendezvousProtocol); len(ok) == 0 {
+				continue
+			}
+			_, err := rendezvousCall(ctx, h, point, rendezvousRequest{
+				Op: rendezvousRegister, Namespace: ns, TTL: int(s.cfg.RendezvousTTL.Seconds()), Addrs: addrs,
+			})
+			if err != nil {
+				log.Printf("Rendezvous registration at %s failed: %v", point, err)
+				continue
+			}
+			resp, err := rendezvousCall(ctx, h, point, rendezvousRequest{Op: rendezvousDiscover, Namespace: ns})
+			if err != nil {
+				log.Printf("Rendezvous discovery at %s failed: %v", point, err)
+				continue
+			}
+			s.connectRegistrations(ctx, h, resp.Registrations)
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (s *Libp2pNodeService) connectRegistrations(ctx context.Context, h hostlibp2p.Host, regs []RendezvousRegistration) {
+	for _, reg := range regs {
+		id, err := peer.Decode(reg.PeerID)
+		if err != nil || id == h.ID() || h.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		info := peer.AddrInfo{ID: id}
+		for _, a := range reg.Addrs {
+			if addr, err := ma.NewMultiaddr(a); err == nil {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+		go func() {
+			dialCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+			defer cancel()
+			if err := h.Connect(dialCtx, info); err != nil {
+				debugf("Connecting to rendezvous peer %s failed: %v", info.ID, err)
+			}
+		}()
+	}
+}
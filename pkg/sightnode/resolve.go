@@ -0,0 +1,136 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// resolveProtocol maps a DID to its current PeerID and addresses from a
+// gateway's registry
+const resolveProtocol protocol.ID = "/sight/resolve/1.0.0"
+
+type resolveRequest struct {
+	DID string `json:"did"`
+}
+
+type resolveResponse struct {
+	Entry *RegistryEntry `json:"entry,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// Get returns the registry entry of a DID
+func (r *didRegistry) Get(did string) (RegistryEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[did]
+	if !ok {
+		return RegistryEntry{}, false
+	}
+	return *entry, true
+}
+
+// handleResolveStream answers a resolution request from the registry
+func (s *Libp2pNodeService) handleResolveStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(10 * time.Second))
+	var req resolveRequest
+	if err := json.NewDecoder(st).Decode(&req); err != nil {
+		st.Reset()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var resp resolveResponse
+	if entry, ok := s.lookupRoute(ctx, req.DID); ok {
+		resp.Entry = &entry
+	} else {
+		resp.Error = "DID not registered"
+	}
+	json.NewEncoder(st).Encode(resp)
+}
+
+func queryResolve(ctx context.Context, h hostlibp2p.Host, id peer.ID, did string) (RegistryEntry, error) {
+	st, err := h.NewStream(ctx, id, resolveProtocol)
+	if err != nil {
+		return RegistryEntry{}, err
+	}
+	defer st.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(st).Encode(resolveRequest{DID: did}); err != nil {
+		st.Reset()
+		return RegistryEntry{}, err
+	}
+	st.CloseWrite()
+	var resp resolveResponse
+	if err := json.NewDecoder(st).Decode(&resp); err != nil {
+		return RegistryEntry{}, err
+	}
+	if resp.Entry == nil {
+		return RegistryEntry{}, errors.New(resp.Error)
+	}
+	return *resp.Entry, nil
+}
+
+// ResolvePeer returns the current PeerID and addresses of a DID. Gateways
+// answer from their registry; other nodes ask the connected gateways, the
+// active one of GATEWAY_ADDRS first, and add the addresses to the peerstore so
+// the DID can be dialled directly.
+func (s *Libp2pNodeService) ResolvePeer(ctx context.Context, did string) (RegistryEntry, error) {
+	if _, err := ParseSightDID(did); err != nil {
+		return RegistryEntry{}, err
+	}
+	s.mu.RLock()
+	node, registry, gateways := s.node, s.registry, s.gateways
+	s.mu.RUnlock()
+	if registry != nil {
+		if entry, ok := s.lookupRoute(ctx, did); ok {
+			return entry, nil
+		}
+		return RegistryEntry{}, errors.New("DID not registered")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	lastErr := errors.New("no connected peer resolves DIDs")
+	resolvers := node.Network().Peers()
+	if gateways != nil {
+		if active, ok := gateways.Active(); ok {
+			others := slices.DeleteFunc(resolvers, func(id peer.ID) bool { return id == active })
+			resolvers = append([]peer.ID{active}, others...)
+		}
+	}
+	for _, id := range resolvers {
+		if supported, _ := node.Peerstore().SupportsProtocols(id, resolveProtocol); len(supported) == 0 {
+			continue
+		}
+		entry, err := queryResolve(ctx, node, id, did)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", id, err)
+			continue
+		}
+		if pid, err := peer.Decode(entry.PeerID); err == nil {
+			var addrs []ma.Multiaddr
+			for _, a := range entry.Addrs {
+				if addr, err := ma.NewMultiaddr(a); err == nil {
+					addrs = append(addrs, addr)
+				}
+			}
+			node.Peerstore().AddAddrs(pid, addrs, peerstore.AddressTTL)
+		}
+		return entry, nil
+	}
+	return RegistryEntry{}, lastErr
+}
@@ -0,0 +1,64 @@
+package sightnode
+
+import (
+	"os"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewResourceManager builds the libp2p resource manager from the default
+// auto-scaled limits, overridden by RCMGR_LIMITS_FILE and the RCMGR_* variables.
+// Blocked resources are reported as libp2p_rcmgr_blocked_resources on /metrics.
+func NewResourceManager(cfg Config) (network.ResourceManager, error) {
+	defaults := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&defaults)
+	concrete := defaults.AutoScale()
+
+	// A JSON limits file (go-libp2p PartialLimitConfig format) overrides the defaults
+	if cfg.RcmgrLimitsFile != "" {
+		f, err := os.Open(cfg.RcmgrLimitsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		limiter, err := rcmgr.NewLimiterFromJSON(f, concrete)
+		if err != nil {
+			return nil, err
+		}
+		return newResourceManager(cfg, limiter)
+	}
+
+	partial := rcmgr.PartialLimitConfig{
+		System: rcmgr.ResourceLimits{
+			Conns:   rcmgr.LimitVal(cfg.RcmgrMaxConns),
+			Streams: rcmgr.LimitVal(cfg.RcmgrMaxStreams),
+			FD:      rcmgr.LimitVal(cfg.RcmgrMaxFD),
+			Memory:  rcmgr.LimitVal64(int64(cfg.RcmgrMaxMemoryMB) << 20),
+		},
+		PeerDefault: rcmgr.ResourceLimits{
+			Conns:   rcmgr.LimitVal(cfg.RcmgrPeerMaxConns),
+			Streams: rcmgr.LimitVal(cfg.RcmgrPeerMaxStreams),
+			Memory:  rcmgr.LimitVal64(int64(cfg.RcmgrPeerMaxMemoryMB) << 20),
+		},
+	}
+	return newResourceManager(cfg, rcmgr.NewFixedLimiter(partial.Build(concrete)))
+}
+
+func newResourceManager(cfg Config, limiter rcmgr.Limiter) (network.ResourceManager, error) {
+	rcmgr.MustRegisterWith(prometheus.DefaultRegisterer)
+	reporter, err := rcmgr.NewStatsTraceReporter()
+	if err != nil {
+		return nil, err
+	}
+	opts := []rcmgr.Option{rcmgr.WithTraceReporter(reporter)}
+	if cfg.RcmgrConnsPerIP > 0 {
+		opts = append(opts, rcmgr.WithLimitPerSubnet(
+			[]rcmgr.ConnLimitPerSubnet{{PrefixLength: 32, ConnCount: cfg.RcmgrConnsPerIP}},
+			[]rcmgr.ConnLimitPerSubnet{{PrefixLength: 56, ConnCount: cfg.RcmgrConnsPerIP}},
+		))
+	}
+	return rcmgr.NewResourceManager(limiter, opts...)
+}
@@ -0,0 +1,90 @@
+package sightnode
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Labels of the eviction counter: the store and the limit that applied
+const (
+	storeArchive     = "archive"
+	storeDeadLetters = "dead_letters"
+
+	evictAge        = "age"
+	evictDIDBytes   = "did_bytes"
+	evictTotalBytes = "total_bytes"
+)
+
+var retentionEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sight_retention_evictions_total",
+	Help: "Stored messages deleted by the retention policy, per store and reason",
+}, []string{"store", "reason"})
+
+// retentionLoop is the janitor enforcing the retention policy on the
+// archive and the dead-letter queue until ctx is done. The audit log is
+// append-only and never pruned.
+func (s *Libp2pNodeService) retentionLoop(ctx context.Context) {
+	if s.cfg.RetentionInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.RetentionInterval)
+	defer ticker.Stop()
+	for {
+		s.enforceRetention()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enforceRetention applies the age limit first, then the per-DID byte limit,
+// then the per-store byte limit, always evicting the oldest messages
+func (s *Libp2pNodeService) enforceRetention() {
+	var cutoff time.Time
+	if s.cfg.RetentionMaxAge > 0 {
+		cutoff = time.Now().Add(-s.cfg.RetentionMaxAge)
+	}
+
+	if s.archive != nil {
+		evict := func(reason string, n int64, err error) {
+			if err != nil {
+				log.Printf("Error pruning message archive (%s): %v", reason, err)
+				return
+			}
+			if n > 0 {
+				retentionEvictions.WithLabelValues(storeArchive, reason).Add(float64(n))
+				debugf("Retention evicted %d archived messages (%s)", n, reason)
+			}
+		}
+		if !cutoff.IsZero() {
+			n, err := s.archive.evictOlderThan(cutoff)
+			evict(evictAge, n, err)
+		}
+		if s.cfg.RetentionMaxBytesPerDID > 0 {
+			n, err := s.archive.evictOverBytes(s.cfg.RetentionMaxBytesPerDID, true)
+			evict(evictDIDBytes, n, err)
+		}
+		if s.cfg.RetentionMaxBytes > 0 {
+			n, err := s.archive.evictOverBytes(s.cfg.RetentionMaxBytes, false)
+			evict(evictTotalBytes, n, err)
+		}
+	}
+
+	// Dead letters carry no DID, so only the age and store limits apply
+	aged, over := s.forwarder.evictDeadLetters(cutoff, s.cfg.RetentionMaxBytes)
+	if aged > 0 {
+		retentionEvictions.WithLabelValues(storeDeadLetters, evictAge).Add(float64(aged))
+	}
+	if over > 0 {
+		retentionEvictions.WithLabelValues(storeDeadLetters, evictTotalBytes).Add(float64(over))
+	}
+	if aged+over > 0 {
+		debugf("Retention evicted %d dead letters", aged+over)
+	}
+}
@@ -0,0 +1,302 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// revocationTopic carries revocations signed by the revocation authorities
+const revocationTopic = "sight-revocations"
+
+// revocationRepublishInterval is how often authorities re-announce the list
+// so nodes that joined later catch up
+const revocationRepublishInterval = 10 * time.Minute
+
+// errNotAuthority is returned when this node may not issue revocations
+var errNotAuthority = errors.New("this node is not a revocation authority")
+
+// Revocation withdraws a DID: messages from it are neither accepted nor
+// forwarded. It is signed by the host key of a gateway operator listed in
+// REVOCATION_AUTHORITIES.
+type Revocation struct {
+	DID       string `json:"did"`
+	Reason    string `json:"reason,omitempty"`
+	RevokedAt string `json:"revokedAt"`
+	Issuer    string `json:"issuer"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// signingBytes is the revocation encoding covered by the signature
+func (r Revocation) signingBytes() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// Verify checks the signature against the issuer's PeerID key
+func (r Revocation) Verify() error {
+	if _, err := ParseSightDID(r.DID); err != nil {
+		return err
+	}
+	issuer, err := peer.Decode(r.Issuer)
+	if err != nil {
+		return fmt.Errorf("invalid issuer: %w", err)
+	}
+	pub, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return err
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, r.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid revocation signature")
+	}
+	return nil
+}
+
+// revocationList holds the accepted revocations, persisted across restarts
+type revocationList struct {
+	mu          sync.RWMutex
+	authorities map[peer.ID]struct{}
+	revoked     map[string]Revocation
+	path        string
+}
+
+// newRevocationList trusts REVOCATION_AUTHORITIES, or the configured
+// bootstrap gateways when none are set
+func newRevocationList(cfg Config) *revocationList {
+	l := &revocationList{
+		authorities: make(map[peer.ID]struct{}),
+		revoked:     make(map[string]Revocation),
+		path:        cfg.DataDir + "/revocations.json",
+	}
+	for _, entry := range cfg.RevocationAuthorities {
+		id, err := parsePeerOrDID(entry)
+		if err != nil {
+			log.Printf("Ignoring REVOCATION_AUTHORITIES entry %q: %v", entry, err)
+			continue
+		}
+		l.authorities[id] = struct{}{}
+	}
+	if len(cfg.RevocationAuthorities) == 0 {
+		infos, _ := parseBootstrapAddrs(cfg.Bootstrap)
+		for _, info := range infos {
+			l.authorities[info.ID] = struct{}{}
+		}
+	}
+	l.load()
+	return l
+}
+
+// IsAuthority reports whether id may issue revocations
+func (l *revocationList) IsAuthority(id peer.ID) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.authorities[id]
+	return ok
+}
+
+// check verifies a revocation and that its issuer is an authority
+func (l *revocationList) check(r Revocation) error {
+	if err := r.Verify(); err != nil {
+		return err
+	}
+	issuer, _ := peer.Decode(r.Issuer)
+	if !l.IsAuthority(issuer) {
+		return fmt.Errorf("issuer %s is not a revocation authority", r.Issuer)
+	}
+	return nil
+}
+
+// Add stores a valid revocation, reporting whether it was new
+func (l *revocationList) Add(r Revocation) (bool, error) {
+	if err := l.check(r); err != nil {
+		return false, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.revoked[r.DID]; ok {
+		return false, nil
+	}
+	l.revoked[r.DID] = r
+	l.save()
+	return true, nil
+}
+
+// Revoked reports whether a DID has been revoked
+func (l *revocationList) Revoked(did string) bool {
+	if did == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.revoked[did]
+	return ok
+}
+
+// List returns the revocations sorted by DID
+func (l *revocationList) List() []Revocation {
+	l.mu.RLock()
+	list := make([]Revocation, 0, len(l.revoked))
+	for _, r := range l.revoked {
+		list = append(list, r)
+	}
+	l.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].DID < list[j].DID })
+	return list
+}
+
+func (l *revocationList) load() {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return
+	}
+	var list []Revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Error reading revocation list: %v", err)
+		return
+	}
+	for _, r := range list {
+		if err := l.check(r); err != nil {
+			log.Printf("Dropping stored revocation of %s: %v", r.DID, err)
+			continue
+		}
+		l.revoked[r.DID] = r
+	}
+}
+
+// save persists the list; callers hold l.mu
+func (l *revocationList) save() {
+	list := make([]Revocation, 0, len(l.revoked))
+	for _, r := range l.revoked {
+		list = append(list, r)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Error marshalling revocation list: %v", err)
+		return
+	}
+	if err := os.WriteFile(l.path, data, 0600); err != nil {
+		log.Printf("Error writing revocation list: %v", err)
+	}
+}
+
+// Revoke signs a revocation of did with the host key and distributes it.
+// Only revocation authorities may call it.
+func (s *Libp2pNodeService) Revoke(ctx context.Context, did, reason string) (Revocation, error) {
+	if _, err := ParseSightDID(did); err != nil {
+		return Revocation{}, err
+	}
+	s.mu.RLock()
+	node, topic := s.node, s.revocationTopic
+	s.mu.RUnlock()
+	if !s.revocations.IsAuthority(node.ID()) {
+		return Revocation{}, errNotAuthority
+	}
+	r := Revocation{
+		DID:       did,
+		Reason:    reason,
+		RevokedAt: time.Now().Format(time.RFC3339),
+		Issuer:    node.ID().String(),
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return Revocation{}, err
+	}
+	if r.Signature, err = node.Peerstore().PrivKey(node.ID()).Sign(data); err != nil {
+		return Revocation{}, err
+	}
+	if _, err := s.revocations.Add(r); err != nil {
+		return Revocation{}, err
+	}
+	if data, err = json.Marshal(r); err != nil {
+		return Revocation{}, err
+	}
+	return r, s.publishTopic(ctx, topic, data)
+}
+
+// Revocations returns the accepted revocations
+func (s *Libp2pNodeService) Revocations() []Revocation {
+	return s.revocations.List()
+}
+
+// joinRevocationTopic accepts revocations from the authorities; authorities
+// also re-announce the whole list periodically
+func (s *Libp2pNodeService) joinRevocationTopic(ctx context.Context, self peer.ID) error {
+	err := s.registerTopicValidator(revocationTopic, func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		var r Revocation
+		if json.Unmarshal(messageData(msg), &r) != nil || s.revocations.check(r) != nil {
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	})
+	if err != nil {
+		return err
+	}
+	topic, err := s.pubsub.Join(revocationTopic)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.revocationTopic = topic
+	go s.supervise(ctx, sub, func() (Subscription, error) { return topic.Subscribe() }, func(msg *pubsub.Message) {
+		var r Revocation
+		if err := json.Unmarshal(messageData(msg), &r); err != nil {
+			return
+		}
+		if added, err := s.revocations.Add(r); err != nil {
+			debugf("Rejected revocation of %s: %v", r.DID, err)
+		} else if added {
+			log.Printf("DID revoked by %s: %s (%s)", r.Issuer, r.DID, r.Reason)
+		}
+	})
+	if s.revocations.IsAuthority(self) {
+		go s.republishRevocations(ctx, topic)
+	}
+	return nil
+}
+
+func (s *Libp2pNodeService) republishRevocations(ctx context.Context, topic Topic) {
+	ticker := time.NewTicker(revocationRepublishInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, r := range s.revocations.List() {
+				data, err := json.Marshal(r)
+				if err != nil {
+					continue
+				}
+				if err := s.publishTopic(ctx, topic, data); err != nil {
+					log.Printf("Error republishing revocation of %s: %v", r.DID, err)
+				}
+			}
+		}
+	}
+}
+
+// senderRevoked reports whether the DID behind a PeerID has been revoked
+func (s *Libp2pNodeService) senderRevoked(from peer.ID) bool {
+	did, err := s.peerDID(from)
+	return err == nil && s.revocations.Revoked(did)
+}
@@ -0,0 +1,268 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiPrefix is where the versioned libp2p endpoints are served. The same
+// routes answer under legacyPrefix as deprecated aliases.
+const (
+	apiPrefix    = "/v1/libp2p"
+	legacyPrefix = "/libp2p"
+)
+
+// apiRoute is one endpoint of the versioned API. The table drives both the
+// router and the generated OpenAPI document.
+type apiRoute struct {
+	method string
+	// path is relative to apiPrefix, with mux variables
+	path    string
+	handler http.HandlerFunc
+	// admin routes require the ADMIN_TOKEN bearer token
+	admin   bool
+	summary string
+	// id is the OpenAPI operation ID, derived from the handler name when
+	// empty; set it for handlers serving several methods
+	id string
+	// query lists the accepted query parameters
+	query []string
+	// request and response are values of the JSON body types, for the
+	// OpenAPI schemas; nil when the body is not JSON or not described
+	request  interface{}
+	response interface{}
+}
+
+type (
+	statusResponse struct {
+		Status string `json:"status"`
+	}
+	sendResponse struct {
+		Status string `json:"status"`
+		ID     string `json:"id"`
+	}
+	importKeyResponse struct {
+		Status string `json:"status"`
+		DID    string `json:"did"`
+	}
+	mnemonicResponse struct {
+		Mnemonic string `json:"mnemonic"`
+	}
+	disconnectResponse struct {
+		Status      string `json:"status"`
+		Peer        string `json:"peer"`
+		Connections int    `json:"connections"`
+		Blocked     bool   `json:"blocked"`
+	}
+	provideResponse struct {
+		Status    string `json:"status"`
+		CID       string `json:"cid"`
+		ExpiresAt string `json:"expiresAt"`
+	}
+	bootstrapResponse struct {
+		Bootstrap []string `json:"bootstrap"`
+	}
+	typeRoutesResponse struct {
+		Routes []TypeRoute `json:"routes"`
+	}
+	reloadResponse struct {
+		Status  string   `json:"status"`
+		Applied []string `json:"applied"`
+	}
+	// sendRequest is the message posted by the upstream service
+	sendRequest struct {
+		// Envelope 1 marks a structured envelope: Payload is delivered byte
+		// for byte. Without it the whole request, these fields included, is
+		// the payload, as from upstreams that predate envelopes.
+		Envelope  int    `json:"envelope,omitempty"`
+		To        string `json:"to"`
+		Type      string `json:"type,omitempty"`
+		ExpiresAt string `json:"expiresAt,omitempty"`
+		Priority  string `json:"priority,omitempty"`
+		// QoS is at-most-once (the default), at-least-once or ordered
+		QoS string `json:"qos,omitempty"`
+		// Payload is the payload of a structured envelope
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+)
+
+// apiRoutes lists the libp2p endpoints in registration order: literal paths
+// come before the variables that would match them
+func apiRoutes(c *Libp2pNodeController) []apiRoute {
+	return []apiRoute{
+		{method: "POST", path: "/send", handler: c.SendHandler, summary: "Queue a message for a DID",
+			request: sendRequest{}, response: sendResponse{}},
+		{method: "POST", path: "/send/batch", handler: c.SendBatchHandler, summary: "Queue several messages",
+			request: []sendRequest{}, response: []BatchSendResult{}},
+		{method: "GET", path: "/message/{id}", handler: c.MessageStatusHandler, summary: "Delivery state of an outgoing message",
+			response: MessageStatus{}},
+		{method: "GET", path: "/messages", handler: c.MessagesHandler, summary: "Query the message archive",
+			query: []string{"did", "topic", "direction", "since", "until", "limit"}, response: []ArchivedMessage{}},
+		{method: "DELETE", path: "/data/{did}", handler: c.DeleteDataHandler, admin: true, summary: "Erase the data held about a DID",
+			response: DataDeletion{}},
+		{method: "GET", path: "/audit", handler: c.AuditExportHandler, admin: true, summary: "Export the audit log as JSON lines"},
+		{method: "GET", path: "/audit/verify", handler: c.AuditVerifyHandler, admin: true, summary: "Verify the audit log hash chain",
+			response: AuditVerification{}},
+		{method: "POST", path: "/key/rotate", handler: c.RotateKeyHandler, admin: true, summary: "Rotate the node keypair",
+			response: KeyRotationRecord{}},
+		{method: "POST", path: "/key/export", handler: c.ExportKeyHandler, admin: true, summary: "Export the encrypted keypair",
+			request: ExportKeyRequest{}, response: EncryptedKeystore{}},
+		{method: "POST", path: "/key/mnemonic", handler: c.MnemonicHandler, admin: true, summary: "Show the keypair backup phrase",
+			response: mnemonicResponse{}},
+		{method: "POST", path: "/key/import", handler: c.ImportKeyHandler, admin: true, summary: "Import a keypair",
+			request: ImportKeyRequest{}, response: importKeyResponse{}},
+		{method: "GET", path: "/did/{did}", handler: c.ResolveDIDHandler, summary: "Decode a DID",
+			response: DIDInfo{}},
+		{method: "GET", path: "/did/{did}/document", handler: c.DIDDocumentHandler, summary: "Resolve the DID document of a DID",
+			response: DIDDocument{}},
+		{method: "GET", path: "/resolve/{did}", handler: c.ResolvePeerHandler, summary: "Resolve the current PeerID and addresses of a DID",
+			response: RegistryEntry{}},
+		{method: "POST", path: "/revocations", handler: c.RevokeHandler, admin: true, summary: "Revoke a DID",
+			request: RevokeRequest{}, response: Revocation{}},
+		{method: "GET", path: "/revocations", handler: c.RevocationsHandler, summary: "List revoked DIDs",
+			response: struct {
+				Revocations []Revocation `json:"revocations"`
+			}{}},
+		{method: "GET", path: "/admissions", handler: c.AdmissionsHandler, summary: "List admission decisions",
+			response: struct {
+				Admissions []Admission `json:"admissions"`
+			}{}},
+		{method: "POST", path: "/admissions/{did}/{decision:approve|reject}", handler: c.DecideAdmissionHandler, admin: true,
+			summary: "Approve or reject a hoster", response: Admission{}},
+		{method: "POST", path: "/topics/tokens/issue", handler: c.IssueTopicTokenHandler, admin: true, summary: "Issue a topic membership token",
+			request: IssueTopicTokenRequest{}, response: TopicToken{}},
+		{method: "POST", path: "/topics/tokens", handler: c.AddTopicTokenHandler, admin: true, summary: "Install a topic membership token",
+			request: TopicToken{}, response: statusResponse{}},
+		{method: "GET", path: "/topics/tokens", handler: c.TopicTokensHandler, summary: "List the topic membership tokens held",
+			response: struct {
+				Tokens []TopicToken `json:"tokens"`
+			}{}},
+		{method: "POST", path: "/groups", handler: c.CreateGroupHandler, summary: "Create a group",
+			request: CreateGroupRequest{}, response: Group{}},
+		{method: "GET", path: "/groups", handler: c.GroupsHandler, summary: "List groups",
+			response: struct {
+				Groups []Group `json:"groups"`
+			}{}},
+		{method: "POST", path: "/groups/{id}/join", handler: c.JoinGroupHandler, summary: "Join a group",
+			response: Group{}},
+		{method: "POST", path: "/groups/{id}/send", handler: c.SendGroupHandler, summary: "Send a message to a group",
+			request: map[string]interface{}{}, response: sendResponse{}},
+		{method: "GET", path: "/subscriptions", handler: c.SubscriptionsHandler, summary: "Health of the pubsub subscriptions",
+			response: struct {
+				Subscriptions []SubscriptionHealth `json:"subscriptions"`
+			}{}},
+		{method: "GET", path: "/peers", handler: c.PeersHandler, summary: "List connected peers",
+			response: struct {
+				Peers []PeerInfo `json:"peers"`
+			}{}},
+		{method: "GET", path: "/peers/{id}/did", handler: c.PeerDIDHandler, summary: "Look up the DID of a PeerID",
+			response: DIDInfo{}},
+		{method: "POST", path: "/peers/block", handler: c.BlockHandler, id: "block", admin: true, summary: "Block a PeerID, DID or CIDR",
+			request: BlockRequest{}, response: statusResponse{}},
+		{method: "DELETE", path: "/peers/block", handler: c.BlockHandler, id: "unblock", admin: true, summary: "Unblock a PeerID, DID or CIDR",
+			request: BlockRequest{}, response: statusResponse{}},
+		{method: "GET", path: "/peers/block", handler: c.BlocklistHandler, summary: "List the blocklist",
+			response: Blocklist{}},
+		{method: "GET", path: "/peers/{id}", handler: c.PeerDetailsHandler, summary: "Details of a connected peer",
+			response: PeerDetails{}},
+		{method: "DELETE", path: "/peers/{id}", handler: c.DisconnectPeerHandler, admin: true, summary: "Disconnect a peer",
+			query: []string{"block"}, response: disconnectResponse{}},
+		{method: "POST", path: "/bootstrap", handler: c.BootstrapHandler, id: "addBootstrap", admin: true, summary: "Add a bootstrap peer",
+			request: BootstrapRequest{}, response: bootstrapResponse{}},
+		{method: "DELETE", path: "/bootstrap", handler: c.BootstrapHandler, id: "removeBootstrap", admin: true, summary: "Remove a bootstrap peer",
+			request: BootstrapRequest{}, response: bootstrapResponse{}},
+		{method: "GET", path: "/bootstrap", handler: c.BootstrapListHandler, summary: "List the bootstrap peers",
+			response: bootstrapResponse{}},
+		{method: "GET", path: "/type-routes", handler: c.TypeRoutesHandler, summary: "List the routes of incoming message types",
+			response: typeRoutesResponse{}},
+		{method: "PUT", path: "/type-routes/{type}", handler: c.SetTypeRouteHandler, admin: true, summary: "Set the route of a message type",
+			request: TypeRouteRequest{}, response: TypeRoute{}},
+		{method: "DELETE", path: "/type-routes/{type}", handler: c.DeleteTypeRouteHandler, admin: true, summary: "Remove the route of a message type",
+			response: statusResponse{}},
+		{method: "GET", path: "/stream", handler: c.StreamHandler, summary: "Stream incoming messages over a WebSocket",
+			query: []string{"topic", "type"}},
+		{method: "GET", path: "/events", handler: c.EventsHandler, summary: "Stream incoming messages and network events as Server-Sent Events",
+			query: []string{"topic", "type", "lastEventId"}},
+		{method: "GET", path: "/dlq", handler: c.DLQHandler, summary: "List the dead-letter queue",
+			response: []DeadLetter{}},
+		{method: "POST", path: "/dlq/{id}/replay", handler: c.DLQReplayHandler, summary: "Replay a dead letter",
+			response: statusResponse{}},
+		{method: "POST", path: "/rpc", handler: c.RPCHandler, summary: "Call the node owning a DID and wait for its response",
+			request: RPCRequest{}, response: RPCResponse{}},
+		{method: "POST", path: "/files/send", handler: c.SendFileHandler, summary: "Send a local file to a DID",
+			request: SendFileRequest{}, response: FileTransfer{}},
+		{method: "GET", path: "/files", handler: c.FileTransfersHandler, summary: "List file transfers",
+			response: []FileTransfer{}},
+		{method: "GET", path: "/files/{id}", handler: c.FileTransferHandler, summary: "Progress of a file transfer",
+			response: FileTransfer{}},
+		{method: "POST", path: "/ping", handler: c.PingHandler, summary: "Measure the round trip time to a peer",
+			request: PingRequest{}, response: PingResult{}},
+		{method: "POST", path: "/connect", handler: c.ConnectHandler, summary: "Connect to a multiaddr, PeerID or DID",
+			request: ConnectRequest{}, response: ConnectResult{}},
+		{method: "GET", path: "/addresses", handler: c.AddressesHandler, summary: "Addresses of this node",
+			response: AddressReport{}},
+		{method: "PUT", path: "/dht/{key:.+}", handler: c.PutRecordHandler, summary: "Publish a signed record under <did>/<name>",
+			request: map[string]interface{}{}, response: Record{}},
+		{method: "GET", path: "/dht/{key:.+}", handler: c.GetRecordHandler, summary: "Fetch a signed record",
+			response: Record{}},
+		{method: "POST", path: "/providers", handler: c.ProvideHandler, summary: "Announce that this node holds a CID",
+			request: ProvideRequest{}, response: provideResponse{}},
+		{method: "GET", path: "/providers/{cid}", handler: c.FindProvidersHandler, summary: "Find the providers of a CID",
+			response: []Provider{}},
+		{method: "POST", path: "/config/reload", handler: c.ConfigReloadHandler, admin: true, summary: "Re-read the configuration",
+			response: reloadResponse{}},
+		{method: "GET", path: "/bandwidth", handler: c.BandwidthHandler, summary: "Bandwidth per peer and protocol",
+			response: BandwidthReport{}},
+		{method: "GET", path: "/stats", handler: c.StatsHandler, summary: "Message counters, queue depths and topic mesh sizes",
+			response: NodeStats{}},
+		{method: "GET", path: "/registry", handler: c.RegistryHandler, summary: "List the DID registry",
+			response: []RegistryEntry{}},
+		{method: "GET", path: "/schema/{name}", handler: c.SchemaHandler, summary: "Protobuf schema of the API or wire envelope"},
+	}
+}
+
+// registerAPIRoutes serves the routes under apiPrefix and, marked deprecated,
+// under legacyPrefix
+func registerAPIRoutes(router *mux.Router, controller *Libp2pNodeController) {
+	v1 := router.PathPrefix(apiPrefix).Subrouter()
+	legacy := router.PathPrefix(legacyPrefix).Subrouter()
+	legacy.Use(deprecatedAlias)
+	for _, route := range apiRoutes(controller) {
+		var h http.Handler = route.handler
+		if route.admin {
+			h = requireAdmin(controller.service.cfg, h)
+		}
+		v1.Handle(route.path, h).Methods(route.method)
+		legacy.Handle(route.path, h).Methods(route.method)
+	}
+}
+
+// deprecatedAlias marks responses to the unversioned paths as deprecated and
+// points at their successor (RFC 8594 / draft-ietf-httpapi-deprecation-header)
+func deprecatedAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := "/v1" + r.URL.Path
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openAPIPath converts a mux path template to OpenAPI: {key:.+} becomes {key}
+func openAPIPath(path string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(path, '{')
+		if start < 0 {
+			b.WriteString(path)
+			return b.String()
+		}
+		end := strings.IndexByte(path[start:], '}') + start
+		name, _, _ := strings.Cut(path[start+1:end], ":")
+		b.WriteString(path[:start] + "{" + name + "}")
+		path = path[end+1:]
+	}
+}
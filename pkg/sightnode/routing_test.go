@@ -0,0 +1,323 @@
+package sightnode_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+
+	"sight-libp2p-node/pkg/sightnode"
+	"sight-libp2p-node/pkg/sightnode/sightnodetest"
+)
+
+// testNode is a node on an in-memory network forwarding to a MockTunnel
+type testNode struct {
+	*sightnode.Node
+	tunnel *sightnodetest.MockTunnel
+}
+
+// startNode starts a node on net, configured by configure when not nil and
+// opts; it is stopped when the test ends
+func startNode(t *testing.T, net *sightnodetest.Network, configure func(*sightnode.Config), opts ...sightnode.Option) *testNode {
+	t.Helper()
+	kp, err := sightnode.GenerateKeypairOfType("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := sightnode.LoadConfig()
+	cfg.DataDir, cfg.IdentityDir = dir, dir
+	cfg.FileReceiveDir = dir + "/files"
+	cfg.ArchivePath = dir + "/archive.db"
+	cfg.AuditLogPath = dir + "/audit.log"
+	cfg.PeerstorePersist = false
+	cfg.GRPCPort = 0
+	cfg.TunnelAPI = "http://tunnel.test/messages"
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	tunnel := sightnodetest.NewMockTunnel()
+	opts = append([]sightnode.Option{sightnode.WithHostFactory(net.HostFactory()), sightnode.WithTunnelClient(tunnel)}, opts...)
+	node, err := sightnode.New(kp, cfg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := node.Start(ctx); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		node.Stop()
+	})
+	return &testNode{Node: node, tunnel: tunnel}
+}
+
+// forwards returns the payloads the node forwarded to its tunnel, skipping
+// readiness probes and delivery receipts
+func (n *testNode) forwards() []map[string]interface{} {
+	var forwards []map[string]interface{}
+	for _, req := range n.tunnel.Requests() {
+		var payload map[string]interface{}
+		if req.Method != "POST" || json.Unmarshal(req.Body, &payload) != nil || payload["type"] == "sight.receipt" {
+			continue
+		}
+		forwards = append(forwards, payload)
+	}
+	return forwards
+}
+
+// waitForward blocks until the node forwarded a payload of the given type
+func (n *testNode) waitForward(t *testing.T, typ string) map[string]interface{} {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 1; ; i++ {
+		for _, payload := range n.forwards() {
+			if payload["type"] == typ {
+				return payload
+			}
+		}
+		if _, err := n.tunnel.Wait(ctx, i); err != nil {
+			t.Fatalf("no %s message was forwarded: %v", typ, err)
+		}
+	}
+}
+
+// withLegacyTopic also publishes and subscribes to the shared legacy topic
+func withLegacyTopic(cfg *sightnode.Config) {
+	cfg.LegacyTopic = true
+}
+
+// settle gives messages that should not arrive the time to do so
+func settle() {
+	time.Sleep(300 * time.Millisecond)
+}
+
+func TestSendIsForwardedToRecipientTunnel(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	sender := startNode(t, net, withLegacyTopic)
+	recipient := startNode(t, net, withLegacyTopic)
+
+	if _, err := sender.Send(context.Background(), recipient.DID(), map[string]interface{}{"type": "telemetry", "value": 42.0}); err != nil {
+		t.Fatal(err)
+	}
+	if payload := recipient.waitForward(t, "telemetry"); payload["value"] != 42.0 {
+		t.Errorf("forwarded payload %v, want value 42", payload)
+	}
+
+	settle()
+	// The legacy topic carries a second copy, which must be deduplicated
+	if n := len(recipient.forwards()); n != 1 {
+		t.Errorf("recipient forwarded %d messages, want 1", n)
+	}
+	if forwards := sender.forwards(); len(forwards) != 0 {
+		t.Errorf("sender forwarded its own messages: %v", forwards)
+	}
+}
+
+func TestLegacyTopicMessagesForOtherDIDsAreNotForwarded(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	sender := startNode(t, net, withLegacyTopic)
+	recipient := startNode(t, net, withLegacyTopic)
+	bystander := startNode(t, net, withLegacyTopic)
+
+	if _, err := sender.Send(context.Background(), recipient.DID(), map[string]interface{}{"type": "telemetry"}); err != nil {
+		t.Fatal(err)
+	}
+	recipient.waitForward(t, "telemetry")
+	settle()
+	if forwards := bystander.forwards(); len(forwards) != 0 {
+		t.Errorf("bystander forwarded a message addressed to another DID: %v", forwards)
+	}
+}
+
+func TestOversizedMessagesAreRejected(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	sender := startNode(t, net, nil)
+	recipient := startNode(t, net, func(cfg *sightnode.Config) { cfg.MaxMessageSize = 1024 })
+
+	big := map[string]interface{}{"type": "blob", "data": strings.Repeat("x", 4096)}
+	if _, err := sender.Send(context.Background(), recipient.DID(), big); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.Send(context.Background(), recipient.DID(), map[string]interface{}{"type": "ping"}); err != nil {
+		t.Fatal(err)
+	}
+	recipient.waitForward(t, "ping")
+	settle()
+	if n := len(recipient.forwards()); n != 1 {
+		t.Errorf("recipient forwarded %d messages, want only the small one", n)
+	}
+}
+
+func TestSendFailsWhenOwnValidatorRejects(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	sender := startNode(t, net, func(cfg *sightnode.Config) { cfg.MaxMessageSize = 1024 })
+	recipient := startNode(t, net, nil)
+
+	big := map[string]interface{}{"type": "blob", "data": strings.Repeat("x", 4096)}
+	if _, err := sender.Send(context.Background(), recipient.DID(), big); err == nil {
+		t.Fatal("message larger than MAX_MESSAGE_SIZE was sent")
+	}
+}
+
+func TestRotatedNodeReceivesOnOldAndNewDID(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	sender := startNode(t, net, nil)
+	recipient := startNode(t, net, nil)
+	oldDID := recipient.DID()
+
+	record, err := recipient.Service().RotateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.OldDID != oldDID || recipient.DID() != record.NewDID {
+		t.Fatalf("rotated %s -> %s, node now %s", record.OldDID, record.NewDID, recipient.DID())
+	}
+	if _, err := sender.Send(context.Background(), oldDID, map[string]interface{}{"type": "to-old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.Send(context.Background(), record.NewDID, map[string]interface{}{"type": "to-new"}); err != nil {
+		t.Fatal(err)
+	}
+	recipient.waitForward(t, "to-old")
+	recipient.waitForward(t, "to-new")
+}
+
+func TestFailedRotationRestoresPreviousIdentity(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	// The hosts after the first fail to start, until the rotation gives up
+	var starts atomic.Int32
+	hosts := net.HostFactory()
+	failing := func(ctx context.Context, cfg sightnode.Config, priv crypto.PrivKey, psOpts []pubsub.Option, opts ...libp2p.Option) (hostlibp2p.Host, sightnode.PubSub, error) {
+		if n := starts.Add(1); n > 1 && n <= 4 {
+			return nil, nil, &sightnode.StartupError{Op: "creating host", Err: errors.New("port in use")}
+		}
+		return hosts(ctx, cfg, priv, psOpts, opts...)
+	}
+	sender := startNode(t, net, nil)
+	recipient := startNode(t, net, nil, sightnode.WithHostFactory(failing))
+	oldDID := recipient.DID()
+
+	if _, err := recipient.Service().RotateKey(); err == nil {
+		t.Fatal("rotation succeeded although the node did not restart")
+	}
+	if recipient.DID() != oldDID {
+		t.Fatalf("node is %s after the failed rotation, want %s", recipient.DID(), oldDID)
+	}
+	if recipient.PeerID() == "" {
+		t.Fatal("node was not restarted with its previous identity")
+	}
+	// Peers follow the announced rotation, which the node keeps serving
+	if _, err := sender.Send(context.Background(), oldDID, map[string]interface{}{"type": "after-rollback"}); err != nil {
+		t.Fatal(err)
+	}
+	recipient.waitForward(t, "after-rollback")
+}
+
+func TestRotationRunsAlongsideTraffic(t *testing.T) {
+	net := sightnodetest.NewBusNetwork()
+	defer net.Close()
+	peer := startNode(t, net, nil)
+	rotating := startNode(t, net, nil)
+	peerDID, rotatingDID := peer.DID(), rotating.DID()
+
+	// Sends both ways hit the validators and inbox handlers of the rotating
+	// node while its host is replaced; failures during the restart are expected
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, send := range []func(){
+		func() { rotating.Send(context.Background(), peerDID, map[string]interface{}{"type": "out"}) },
+		func() { peer.Send(context.Background(), rotatingDID, map[string]interface{}{"type": "in"}) },
+	} {
+		wg.Add(1)
+		go func(send func()) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					send()
+				}
+			}
+		}(send)
+	}
+	_, err := rotating.Service().RotateKey()
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Send(context.Background(), rotatingDID, map[string]interface{}{"type": "after-rotation"}); err != nil {
+		t.Fatal(err)
+	}
+	rotating.waitForward(t, "after-rotation")
+}
+
+func TestQoSMessagesAreAcknowledged(t *testing.T) {
+	for _, qos := range []string{"at-least-once", "ordered"} {
+		t.Run(qos, func(t *testing.T) {
+			net := sightnodetest.NewBusNetwork()
+			defer net.Close()
+			sender := startNode(t, net, nil)
+			recipient := startNode(t, net, nil)
+
+			var ids []string
+			for i := 1; i <= 3; i++ {
+				id, err := sender.Service().HandleOutgoingMessage(context.Background(), map[string]interface{}{
+					"to":      recipient.DID(),
+					"qos":     qos,
+					"payload": map[string]interface{}{"type": fmt.Sprintf("m%d", i)},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			recipient.waitForward(t, "m3")
+			if qos == "ordered" {
+				var types []interface{}
+				for _, payload := range recipient.forwards() {
+					types = append(types, payload["type"])
+				}
+				if fmt.Sprint(types) != "[m1 m2 m3]" {
+					t.Errorf("ordered messages were forwarded as %v", types)
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for _, id := range ids {
+				for {
+					st, _ := sender.Service().MessageStatus(id)
+					if st.State == "acked" {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("message %s is %q, want acked", id, st.State)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
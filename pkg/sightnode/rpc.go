@@ -0,0 +1,149 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// rpcProtocol carries one request/response exchange per stream
+const rpcProtocol = protocol.ID("/sight/rpc/1.0.0")
+
+type rpcRequest struct {
+	ID      string          `json:"id"`
+	From    string          `json:"from"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// RPCResponse is the peer's answer, carrying the status and body returned by
+// its upstream service
+type RPCResponse struct {
+	ID      string          `json:"id"`
+	Status  int             `json:"status"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// CallRPC opens a stream to the node owning the DID, sends the payload and
+// waits for the response until ctx expires
+func (s *Libp2pNodeService) CallRPC(ctx context.Context, to string, payload json.RawMessage) (*RPCResponse, error) {
+	id, err := DIDToPeerID(s.resolveRotatedDID(to))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	node, did := s.node, s.did
+	s.mu.RUnlock()
+
+	st, err := node.NewStream(ctx, id, rpcProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		st.SetDeadline(deadline)
+	}
+
+	req := rpcRequest{ID: uuid.NewString(), From: did, Payload: payload}
+	if err := json.NewEncoder(st).Encode(req); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	if err := st.CloseWrite(); err != nil {
+		st.Reset()
+		return nil, err
+	}
+
+	var resp RPCResponse
+	if err := json.NewDecoder(io.LimitReader(st, int64(s.cfg.MaxMessageSize))).Decode(&resp); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// handleRPCStream serves an incoming RPC by proxying it to the tunnel API
+func (s *Libp2pNodeService) handleRPCStream(st network.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(s.cfg.RPCTimeout))
+
+	var req rpcRequest
+	if err := json.NewDecoder(io.LimitReader(st, int64(s.cfg.MaxMessageSize))).Decode(&req); err != nil {
+		debugf("Invalid RPC request from %s: %v", st.Conn().RemotePeer(), err)
+		st.Reset()
+		return
+	}
+
+	// The sender DID is derived from the authenticated peer, not trusted from the request
+	from, err := s.peerDID(st.Conn().RemotePeer())
+	if err != nil {
+		from = req.From
+	}
+	resp := s.proxyRPC(req, from)
+	if err := json.NewEncoder(st).Encode(resp); err != nil {
+		log.Printf("Error writing RPC response: %v", err)
+		st.Reset()
+	}
+}
+
+func (s *Libp2pNodeService) proxyRPC(req rpcRequest, from string) RPCResponse {
+	resp := RPCResponse{ID: req.ID}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RPCTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.RPCAPI, bytes.NewReader(req.Payload))
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Sight-RPC-ID", req.ID)
+	httpReq.Header.Set("X-Sight-From-DID", from)
+	signTunnelRequest(s.cfg, httpReq, req.Payload)
+
+	httpResp, err := s.forwarder.client.Do(httpReq)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, int64(s.cfg.MaxMessageSize)))
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Status = httpResp.StatusCode
+	if json.Valid(body) {
+		resp.Payload = body
+	} else if len(body) > 0 {
+		resp.Payload, _ = json.Marshal(string(body))
+	}
+	return resp
+}
+
+// rpcTimeout parses an optional per-request timeout, falling back to RPC_TIMEOUT
+func (s *Libp2pNodeService) rpcTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return s.cfg.RPCTimeout, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return d, nil
+}
+
+// isTimeout reports whether an RPC failed because its deadline passed
+func isTimeout(err error) bool {
+	var netErr interface{ Timeout() bool }
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,158 @@
+package sightnode
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxTrackedGap caps how many missing sequence numbers are remembered per
+// sender; larger jumps are reported at once
+const maxTrackedGap = 1000
+
+var messageGaps = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sight_message_gaps_total",
+	Help: "Messages from other nodes that never arrived, detected by sequence gaps",
+})
+
+// sequencer stamps outgoing envelopes with a sequence number per recipient.
+// The session changes on every start so receivers don't mistake a restart
+// for lost messages.
+type sequencer struct {
+	mu      sync.Mutex
+	session string
+	next    map[string]uint64
+}
+
+func newSequencer(session string) *sequencer {
+	return &sequencer{session: session, next: make(map[string]uint64)}
+}
+
+// Stamp sets "seq" and "seqSession" on an envelope addressed to to
+func (q *sequencer) Stamp(to string, envelope map[string]interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.next[to]++
+	envelope["seq"] = q.next[to]
+	envelope["seqSession"] = q.session
+}
+
+// senderWindow is what a receiver knows about the sequence of one sender
+type senderWindow struct {
+	session string
+	highest uint64
+	missing map[uint64]time.Time
+}
+
+// gapDetector tracks received sequence numbers per sender. Numbers skipped
+// over are given a grace period to arrive out of order before they count as lost.
+type gapDetector struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	senders map[string]*senderWindow
+}
+
+// messageGap is a run of lost messages from one sender
+type messageGap struct {
+	FromDID  string
+	FirstSeq uint64
+	LastSeq  uint64
+	Missing  int
+}
+
+func newGapDetector(timeout time.Duration) *gapDetector {
+	return &gapDetector{timeout: timeout, senders: make(map[string]*senderWindow)}
+}
+
+// Observe records a received envelope; envelopes without a sequence are ignored
+func (d *gapDetector) Observe(fromDID string, envelope map[string]interface{}) []messageGap {
+	session, _ := envelope["seqSession"].(string)
+	seqValue, ok := envelope["seq"].(float64)
+	if fromDID == "" || session == "" || !ok || seqValue < 1 {
+		return nil
+	}
+	seq := uint64(seqValue)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.senders[fromDID]
+	if !ok || w.session != session {
+		d.senders[fromDID] = &senderWindow{session: session, highest: seq, missing: make(map[uint64]time.Time)}
+		return nil
+	}
+	if seq <= w.highest {
+		delete(w.missing, seq)
+		return nil
+	}
+
+	var gaps []messageGap
+	if seq-w.highest-1 > maxTrackedGap {
+		gaps = append(gaps, messageGap{FromDID: fromDID, FirstSeq: w.highest + 1, LastSeq: seq - 1, Missing: int(seq - w.highest - 1)})
+	} else {
+		now := time.Now()
+		for i := w.highest + 1; i < seq; i++ {
+			w.missing[i] = now
+		}
+	}
+	w.highest = seq
+	return gaps
+}
+
+// Expired returns and forgets the missing sequence numbers past their grace period
+func (d *gapDetector) Expired() []messageGap {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var gaps []messageGap
+	deadline := time.Now().Add(-d.timeout)
+	for did, w := range d.senders {
+		var gap *messageGap
+		for seq, since := range w.missing {
+			if since.After(deadline) {
+				continue
+			}
+			delete(w.missing, seq)
+			if gap == nil {
+				gap = &messageGap{FromDID: did, FirstSeq: seq, LastSeq: seq}
+			}
+			gap.FirstSeq = min(gap.FirstSeq, seq)
+			gap.LastSeq = max(gap.LastSeq, seq)
+			gap.Missing++
+		}
+		if gap != nil {
+			gaps = append(gaps, *gap)
+		}
+	}
+	return gaps
+}
+
+// watchGaps periodically reports messages that never arrived
+func (s *Libp2pNodeService) watchGaps(ctx context.Context, self peer.ID) {
+	ticker := time.NewTicker(s.cfg.SeqGapTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reportGaps(self, s.gaps.Expired())
+		}
+	}
+}
+
+func (s *Libp2pNodeService) reportGaps(self peer.ID, gaps []messageGap) {
+	for _, gap := range gaps {
+		messageGaps.Add(float64(gap.Missing))
+		s.emitNetworkEvent(self, NetworkEvent{
+			Type:     eventMessageGap,
+			DID:      gap.FromDID,
+			FirstSeq: gap.FirstSeq,
+			LastSeq:  gap.LastSeq,
+			Missing:  gap.Missing,
+			Time:     time.Now().Format(time.RFC3339),
+		})
+	}
+}
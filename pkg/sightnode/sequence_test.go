@@ -0,0 +1,74 @@
+package sightnode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSequencerCountsPerRecipient(t *testing.T) {
+	q := newSequencer("s1")
+	for _, tc := range []struct {
+		to   string
+		want uint64
+	}{
+		{"a", 1}, {"a", 2}, {"b", 1}, {"a", 3},
+	} {
+		envelope := map[string]interface{}{}
+		q.Stamp(tc.to, envelope)
+		if envelope["seq"] != tc.want || envelope["seqSession"] != "s1" {
+			t.Errorf("message to %s stamped %v/%v, want %d/s1", tc.to, envelope["seq"], envelope["seqSession"], tc.want)
+		}
+	}
+}
+
+func TestGapDetector(t *testing.T) {
+	// seq is what a received envelope carries, a JSON number
+	type received struct {
+		session string
+		seq     float64
+	}
+	for _, tc := range []struct {
+		name     string
+		received []received
+		// immediate are the gaps reported by Observe, expired those left
+		// once the grace period is over
+		immediate []messageGap
+		expired   []messageGap
+	}{
+		{"in order", []received{{"s1", 1}, {"s1", 2}, {"s1", 3}}, nil, nil},
+		{"out of order within the grace period", []received{{"s1", 1}, {"s1", 3}, {"s1", 2}}, nil, nil},
+		{"lost", []received{{"s1", 1}, {"s1", 4}}, nil, []messageGap{{FromDID: "a", FirstSeq: 2, LastSeq: 3, Missing: 2}}},
+		{"restart", []received{{"s1", 1}, {"s1", 2}, {"s2", 1}}, nil, nil},
+		{"first message of a session", []received{{"s1", 5}}, nil, nil},
+		{"unsequenced", []received{{"", 1}, {"s1", 0}}, nil, nil},
+		{
+			"jump past the tracked window",
+			[]received{{"s1", 1}, {"s1", maxTrackedGap + 3}},
+			[]messageGap{{FromDID: "a", FirstSeq: 2, LastSeq: maxTrackedGap + 2, Missing: maxTrackedGap + 1}},
+			nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newGapDetector(time.Millisecond)
+			var immediate []messageGap
+			for _, r := range tc.received {
+				envelope := map[string]interface{}{"seq": r.seq}
+				if r.session != "" {
+					envelope["seqSession"] = r.session
+				}
+				immediate = append(immediate, d.Observe("a", envelope)...)
+			}
+			if !reflect.DeepEqual(immediate, tc.immediate) {
+				t.Errorf("reported %+v while receiving, want %+v", immediate, tc.immediate)
+			}
+			time.Sleep(5 * time.Millisecond)
+			if expired := d.Expired(); !reflect.DeepEqual(expired, tc.expired) {
+				t.Errorf("reported %+v after the grace period, want %+v", expired, tc.expired)
+			}
+			if expired := d.Expired(); expired != nil {
+				t.Errorf("reported %+v again", expired)
+			}
+		})
+	}
+}
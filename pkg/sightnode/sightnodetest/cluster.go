@@ -0,0 +1,327 @@
+// Package sightnodetest runs clusters of sight nodes inside one process for
+// integration tests: a gateway and N hosters, connected in memory or over
+// localhost, each forwarding to its own test tunnel server.
+package sightnodetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"sight-libp2p-node/pkg/sightnode"
+)
+
+// pollInterval is how often the Wait helpers re-check node state
+const pollInterval = 50 * time.Millisecond
+
+// Transport selects how the nodes of a cluster reach each other
+type Transport int
+
+const (
+	// InMemory links the hosts through a Network running gossipsub
+	InMemory Transport = iota
+	// Localhost runs real libp2p hosts listening on 127.0.0.1
+	Localhost
+)
+
+// Options configure a cluster
+type Options struct {
+	// Hosters is the number of hoster nodes started besides the gateway
+	Hosters   int
+	Transport Transport
+	// Configure adjusts the config of node i (0 is the gateway) before it
+	// starts; the base config is LoadConfig with the cluster's directories,
+	// addresses and tunnel URL filled in
+	Configure func(i int, cfg *sightnode.Config)
+	// BasePort, when set, makes node i serve its HTTP API on BasePort+2i and,
+	// over Localhost, listen for libp2p on BasePort+2i+1. Random ports are
+	// used otherwise.
+	BasePort int
+	// Dir keeps the nodes' state in Dir/node<i> across runs instead of a
+	// temporary directory removed by Close
+	Dir string
+}
+
+// Cluster is a gateway and its hosters, all bootstrapped from the gateway
+type Cluster struct {
+	// Nodes[0] is the gateway
+	Nodes []*Node
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	net     *Network
+	dir     string
+	tempDir bool
+}
+
+// Node is one node of a cluster
+type Node struct {
+	*sightnode.Node
+	Index  int
+	Config sightnode.Config
+	// Tunnel receives the messages the node forwards
+	Tunnel *Tunnel
+	// API serves the node's HTTP API
+	API *httptest.Server
+
+	cluster *Cluster
+}
+
+// New starts a cluster for a test, failing it when the cluster does not
+// start; the cluster is closed when the test ends
+func New(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	c, err := Start(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("starting cluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Start starts the gateway, then the hosters with the gateway as their only
+// bootstrap peer. Cancelling ctx stops the nodes' in-flight work.
+func Start(ctx context.Context, opts Options) (*Cluster, error) {
+	c := &Cluster{dir: opts.Dir}
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "sightnodetest")
+		if err != nil {
+			return nil, err
+		}
+		c.dir, c.tempDir = dir, true
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	if opts.Transport == InMemory {
+		c.net = NewNetwork()
+	}
+
+	for i := 0; i <= opts.Hosters; i++ {
+		n, err := c.newNode(i, opts)
+		if err == nil {
+			if err = n.Start(c.ctx); err != nil {
+				n.API.Close()
+				n.Tunnel.Close()
+			}
+		}
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+		c.Nodes = append(c.Nodes, n)
+	}
+	return c, nil
+}
+
+func (c *Cluster) newNode(i int, opts Options) (*Node, error) {
+	nodeDir := filepath.Join(c.dir, fmt.Sprintf("node%d", i))
+	if err := os.MkdirAll(nodeDir, 0700); err != nil {
+		return nil, err
+	}
+	kp, err := loadKeypair(nodeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := sightnode.LoadConfig()
+	cfg.IsGateway = i == 0
+	cfg.DataDir, cfg.IdentityDir = nodeDir, nodeDir
+	cfg.FileReceiveDir = filepath.Join(nodeDir, "files")
+	cfg.ArchivePath = filepath.Join(nodeDir, "archive.db")
+	cfg.AuditLogPath = filepath.Join(nodeDir, "audit.log")
+	cfg.PeerstorePersist = false
+	cfg.GRPCPort = 0
+	if i > 0 {
+		cfg.Bootstrap = c.Nodes[0].Addrs()
+	}
+
+	var hostOpts []sightnode.Option
+	if c.net != nil {
+		hostOpts = append(hostOpts, sightnode.WithHostFactory(c.net.HostFactory()))
+	} else {
+		// A fixed port keeps the bootstrap address valid across restarts
+		port := opts.BasePort + 2*i + 1
+		if opts.BasePort == 0 {
+			if port, err = freePort(); err != nil {
+				return nil, err
+			}
+		}
+		cfg.ListenAddrs = []string{fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)}
+	}
+
+	tunnel := NewTunnel()
+	cfg.TunnelAPI = tunnel.URL
+	if opts.Configure != nil {
+		opts.Configure(i, &cfg)
+	}
+
+	node, err := sightnode.New(kp, cfg, hostOpts...)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Node:    node,
+		Index:   i,
+		Config:  cfg,
+		Tunnel:  tunnel,
+		cluster: c,
+	}
+	n.API = httptest.NewUnstartedServer(n.Handler())
+	if opts.BasePort != 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.BasePort+2*i))
+		if err != nil {
+			tunnel.Close()
+			return nil, err
+		}
+		n.API.Listener.Close()
+		n.API.Listener = l
+	}
+	n.API.Start()
+	return n, nil
+}
+
+// loadKeypair reads the node's keypair from dir, generating and saving one
+// on first use so a kept cluster directory keeps its DIDs
+func loadKeypair(dir string) (sightnode.Keypair, error) {
+	path := filepath.Join(dir, "device-keypair.json")
+	var kp sightnode.Keypair
+	if data, err := os.ReadFile(path); err == nil {
+		return kp, json.Unmarshal(data, &kp)
+	}
+	kp, err := sightnode.GenerateKeypairOfType("")
+	if err != nil {
+		return kp, err
+	}
+	data, err := json.Marshal(kp)
+	if err != nil {
+		return kp, err
+	}
+	return kp, os.WriteFile(path, data, 0600)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Gateway returns the gateway node
+func (c *Cluster) Gateway() *Node {
+	return c.Nodes[0]
+}
+
+// Hosters returns the hoster nodes
+func (c *Cluster) Hosters() []*Node {
+	return c.Nodes[1:]
+}
+
+// WaitReady blocks until every hoster knows the gateway subscribes to its
+// inbox and the gateway knows every hoster subscribes to theirs, then for the
+// gateway to graft them into its mesh, so messages between them are routed
+func (c *Cluster) WaitReady(ctx context.Context) error {
+	gw := c.Gateway()
+	for _, h := range c.Hosters() {
+		if err := h.WaitInboxPeer(ctx, gw.DID(), gw); err != nil {
+			return err
+		}
+		if err := gw.WaitInboxPeer(ctx, h.DID(), h); err != nil {
+			return err
+		}
+	}
+	// Peers join the mesh of a topic at the gossipsub heartbeat; until then
+	// the gateway does not forward the topic's messages to them
+	timer := time.NewTimer(2 * gw.Config.GossipSubHeartbeat)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the gateway mesh: %w", ctx.Err())
+	case <-timer.C:
+	}
+	return nil
+}
+
+// Connect dials node b from node a. Hosters only connect to the gateway on
+// their own; connect them to each other to exchange messages and receipts.
+func (c *Cluster) Connect(ctx context.Context, a, b int) error {
+	addrs := c.Nodes[b].Addrs()
+	if len(addrs) == 0 {
+		return fmt.Errorf("node %d is not running", b)
+	}
+	res, err := c.Nodes[a].Service().Connect(ctx, addrs[0])
+	if err != nil {
+		return err
+	}
+	if !res.Connected {
+		return fmt.Errorf("connecting node %d to node %d: %s", a, b, res.Error)
+	}
+	return nil
+}
+
+// Close stops every node and its servers and removes their state unless it
+// is kept in Options.Dir
+func (c *Cluster) Close() {
+	c.cancel()
+	for _, n := range c.Nodes {
+		if n.PeerID() != "" {
+			n.Stop()
+		}
+		n.API.Close()
+		n.Tunnel.Close()
+	}
+	if c.net != nil {
+		c.net.Close()
+	}
+	if c.tempDir {
+		os.RemoveAll(c.dir)
+	}
+}
+
+// Restart stops the node and starts it again with the same identity and
+// state, e.g. to test store-and-forward
+func (n *Node) Restart() error {
+	n.Stop()
+	return n.Start(n.cluster.ctx)
+}
+
+// WaitInboxPeer blocks until n knows that peer subscribes to the inbox of did
+func (n *Node) WaitInboxPeer(ctx context.Context, did string, peer *Node) error {
+	return poll(ctx, func() bool {
+		return slices.Contains(n.InboxPeers(did), peer.PeerID())
+	}, fmt.Sprintf("node %d did not see node %d on the inbox of %s", n.Index, peer.Index, did))
+}
+
+// WaitState blocks until the outgoing message id reaches state: queued,
+// published, acked or failed
+func (n *Node) WaitState(ctx context.Context, id, state string) (sightnode.MessageStatus, error) {
+	var st sightnode.MessageStatus
+	err := poll(ctx, func() bool {
+		var ok bool
+		st, ok = n.Service().MessageStatus(id)
+		return ok && st.State == state
+	}, fmt.Sprintf("message %s on node %d did not reach %s", id, n.Index, state))
+	return st, err
+}
+
+// poll checks done every pollInterval until it holds or ctx is done
+func poll(ctx context.Context, done func() bool, failure string) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return errors.New(failure + ": " + ctx.Err().Error())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package sightnodetest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sight-libp2p-node/pkg/sightnode"
+)
+
+func TestClusterDeliversBetweenHosters(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		transport Transport
+	}{{"in-memory", InMemory}, {"localhost", Localhost}} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(t, Options{Hosters: 2, Transport: tc.transport})
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			if err := c.WaitReady(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			sender, recipient := c.Hosters()[0], c.Hosters()[1]
+			if _, err := sender.Send(ctx, recipient.DID(), map[string]interface{}{"type": "telemetry", "seq": 1}); err != nil {
+				t.Fatal(err)
+			}
+			d, err := recipient.Tunnel.WaitFor(ctx, HasField("seq", 1.0))
+			if err != nil {
+				t.Fatalf("message was not delivered through the gateway: %v", err)
+			}
+			if d.Payload["type"] != "telemetry" {
+				t.Errorf("delivered payload %v, want type telemetry", d.Payload)
+			}
+			for _, n := range []*Node{c.Gateway(), sender} {
+				for _, d := range n.Tunnel.Deliveries() {
+					if d.Payload["seq"] == 1.0 {
+						t.Errorf("node %d forwarded a message addressed to node %d", n.Index, recipient.Index)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClusterAcknowledgesDelivery(t *testing.T) {
+	c := New(t, Options{Hosters: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sender, recipient := c.Hosters()[0], c.Hosters()[1]
+	// Receipts travel back on the sender's inbox, which the hosters only
+	// share once connected to each other
+	if err := c.Connect(ctx, sender.Index, recipient.Index); err != nil {
+		t.Fatal(err)
+	}
+	if err := recipient.WaitInboxPeer(ctx, sender.DID(), sender); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := sender.Service().HandleOutgoingMessage(ctx, map[string]interface{}{
+		"to":      recipient.DID(),
+		"qos":     "at-least-once",
+		"payload": map[string]interface{}{"type": "command", "seq": 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := sender.WaitState(ctx, id, "acked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ID != id {
+		t.Errorf("status of message %s, want %s", st.ID, id)
+	}
+	if _, err := recipient.Tunnel.WaitFor(ctx, HasField("seq", 2.0)); err != nil {
+		t.Fatal(err)
+	}
+	// An acknowledged message is not republished, so it is forwarded once
+	time.Sleep(time.Second)
+	delivered := 0
+	for _, d := range recipient.Tunnel.Deliveries() {
+		if d.Payload["seq"] == 2.0 {
+			delivered++
+		}
+	}
+	if delivered != 1 {
+		t.Errorf("acknowledged message forwarded %d times, want 1", delivered)
+	}
+}
+
+func TestClusterProtoWireFormat(t *testing.T) {
+	c := New(t, Options{Hosters: 2, Configure: func(i int, cfg *sightnode.Config) {
+		cfg.WireFormat = "proto"
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The gateway delivers to its hosters over direct streams, in protobuf
+	gw, recipient := c.Gateway(), c.Hosters()[1]
+	if _, err := gw.Send(ctx, recipient.DID(), map[string]interface{}{"type": "direct", "seq": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recipient.Tunnel.WaitFor(ctx, HasField("seq", 3.0)); err != nil {
+		t.Fatalf("direct protobuf message was not delivered: %v", err)
+	}
+	// Hosters publish JSON, which the gateway relays whatever its format
+	if _, err := c.Hosters()[0].Send(ctx, recipient.DID(), map[string]interface{}{"type": "gossip", "seq": 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recipient.Tunnel.WaitFor(ctx, HasField("seq", 4.0)); err != nil {
+		t.Fatalf("relayed message was not delivered: %v", err)
+	}
+}
+
+func TestClusterCompressesBetweenHosters(t *testing.T) {
+	c := New(t, Options{Hosters: 2, Configure: func(i int, cfg *sightnode.Config) {
+		cfg.Compression = []string{"zstd"}
+		cfg.CompressMinSize = 64
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosters are not peers of each other; the gateway registry tells the
+	// encodings of the recipient
+	sender, recipient := c.Hosters()[0], c.Hosters()[1]
+	entry, err := sender.Service().ResolvePeer(ctx, recipient.DID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(entry.Encodings, "zstd") {
+		t.Fatalf("resolved encodings %v, want zstd", entry.Encodings)
+	}
+	readings := strings.Repeat("0123456789", 100)
+	for seq := 1; seq <= 2; seq++ {
+		if _, err := sender.Send(ctx, recipient.DID(), map[string]interface{}{"type": "telemetry", "seq": seq, "readings": readings}); err != nil {
+			t.Fatal(err)
+		}
+		d, err := recipient.Tunnel.WaitFor(ctx, HasField("seq", float64(seq)))
+		if err != nil {
+			t.Fatalf("message %d was not delivered: %v", seq, err)
+		}
+		if d.Payload["readings"] != readings {
+			t.Errorf("message %d delivered with readings %.20v..., want them restored", seq, d.Payload["readings"])
+		}
+		// The first send starts the lookup; the second is compressed
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,101 @@
+package sightnodetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MockTunnelRequest is a request received by a MockTunnel
+type MockTunnelRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// MockTunnel is an in-memory tunnel API for unit tests: it records every
+// request and answers with a configurable status. Unlike Tunnel it runs no
+// server; pass it to sightnode.WithTunnelClient.
+type MockTunnel struct {
+	mu       sync.Mutex
+	status   int
+	requests []MockTunnelRequest
+	// received is closed and replaced on every request, waking Wait
+	received chan struct{}
+}
+
+func NewMockTunnel() *MockTunnel {
+	return &MockTunnel{status: http.StatusOK, received: make(chan struct{})}
+}
+
+// SetStatus sets the status code of the following responses
+func (t *MockTunnel) SetStatus(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = code
+}
+
+// Do records the request and answers it
+func (t *MockTunnel) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	t.mu.Lock()
+	t.requests = append(t.requests, MockTunnelRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	status := t.status
+	close(t.received)
+	t.received = make(chan struct{})
+	t.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// Requests returns the requests received so far, oldest first
+func (t *MockTunnel) Requests() []MockTunnelRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]MockTunnelRequest{}, t.requests...)
+}
+
+// Wait blocks until at least n requests were received or ctx is done
+func (t *MockTunnel) Wait(ctx context.Context, n int) ([]MockTunnelRequest, error) {
+	for {
+		t.mu.Lock()
+		if len(t.requests) >= n {
+			requests := append([]MockTunnelRequest{}, t.requests...)
+			t.mu.Unlock()
+			return requests, nil
+		}
+		received := t.received
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return t.Requests(), ctx.Err()
+		case <-received:
+		}
+	}
+}
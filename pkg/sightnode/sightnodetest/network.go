@@ -0,0 +1,96 @@
+package sightnodetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"sight-libp2p-node/pkg/sightnode"
+)
+
+// Network is an in-memory network for unit tests. Nodes created with
+// sightnode.WithHostFactory(n.HostFactory()) get hosts linked to every other
+// host of the network without sockets. Their pubsub router is real gossipsub,
+// so routing and filtering run unchanged, or with NewBusNetwork a PubSubBus.
+// The libp2p options of the node (connection gater, peerstore, relay) are
+// not applied; disable PeerstorePersist.
+type Network struct {
+	mn  mocknet.Mocknet
+	bus *PubSubBus
+
+	mu    sync.Mutex
+	hosts int
+}
+
+// NewNetwork creates a network whose nodes route over gossipsub
+func NewNetwork() *Network {
+	return &Network{mn: mocknet.New()}
+}
+
+// NewBusNetwork creates a network whose nodes exchange pubsub messages over
+// one PubSubBus, which needs no mesh to form
+func NewBusNetwork() *Network {
+	return &Network{mn: mocknet.New(), bus: NewPubSubBus()}
+}
+
+// Bus returns the pubsub bus of a network created with NewBusNetwork
+func (n *Network) Bus() *PubSubBus {
+	return n.bus
+}
+
+// HostFactory returns the factory creating the nodes' hosts on this network
+func (n *Network) HostFactory() sightnode.HostFactory {
+	return func(ctx context.Context, cfg sightnode.Config, priv crypto.PrivKey, psOpts []pubsub.Option, _ ...libp2p.Option) (hostlibp2p.Host, sightnode.PubSub, error) {
+		n.mu.Lock()
+		n.hosts++
+		addr := ma.StringCast(fmt.Sprintf("/ip4/10.%d.%d.%d/tcp/4001", n.hosts>>16&0xff, n.hosts>>8&0xff, n.hosts&0xff))
+		n.mu.Unlock()
+
+		h, err := n.mn.AddPeer(priv, addr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating mock host: %w", err)
+		}
+		for _, other := range n.mn.Peers() {
+			if other == h.ID() || len(n.mn.LinksBetweenPeers(h.ID(), other)) > 0 {
+				continue
+			}
+			if _, err := n.mn.LinkPeers(h.ID(), other); err != nil {
+				h.Close()
+				return nil, nil, fmt.Errorf("linking mock host: %w", err)
+			}
+		}
+		if n.bus != nil {
+			return h, n.bus.Attach(ctx, priv), nil
+		}
+		ps, err := sightnode.NewGossipSub(ctx, cfg, h, psOpts)
+		if err != nil {
+			h.Close()
+			return nil, nil, err
+		}
+		return h, ps, nil
+	}
+}
+
+// Connect dials b from a, as a bootstrap connection would
+func (n *Network) Connect(a, b peer.ID) error {
+	_, err := n.mn.ConnectPeers(a, b)
+	return err
+}
+
+// Disconnect closes the connections between a and b
+func (n *Network) Disconnect(a, b peer.ID) error {
+	return n.mn.DisconnectPeers(a, b)
+}
+
+// Close shuts down every host of the network
+func (n *Network) Close() error {
+	return n.mn.Close()
+}
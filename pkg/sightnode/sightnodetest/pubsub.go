@@ -0,0 +1,363 @@
+package sightnodetest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"sight-libp2p-node/pkg/sightnode"
+)
+
+// subscriptionBuffer is how many messages a subscription holds before the
+// bus drops further ones, as gossipsub does for slow subscribers
+const subscriptionBuffer = 256
+
+// PubSubBus is an in-memory stand-in for gossipsub. A message published on a
+// topic reaches every node subscribed to it at once, once the validators the
+// receiving node registered accept it; nodes need no connections or mesh.
+// Each node attached gets its own sightnode.PubSub, detached when the
+// context it was attached with is done.
+type PubSubBus struct {
+	mu    sync.Mutex
+	nodes map[peer.ID]*busPubSub
+	seq   uint64
+}
+
+func NewPubSubBus() *PubSubBus {
+	return &PubSubBus{nodes: make(map[peer.ID]*busPubSub)}
+}
+
+// Attach returns the router of the node with key priv, replacing the one
+// of an earlier start
+func (b *PubSubBus) Attach(ctx context.Context, priv crypto.PrivKey) sightnode.PubSub {
+	id, _ := peer.IDFromPrivateKey(priv)
+	ps := &busPubSub{
+		bus:        b,
+		id:         id,
+		priv:       priv,
+		topics:     make(map[string]*busTopic),
+		validators: make(map[string]interface{}),
+	}
+	b.mu.Lock()
+	b.nodes[id] = ps
+	b.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		if b.nodes[id] == ps {
+			delete(b.nodes, id)
+		}
+		b.mu.Unlock()
+	})
+	return ps
+}
+
+// attached returns the routers of the nodes currently attached
+func (b *PubSubBus) attached() []*busPubSub {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	nodes := make([]*busPubSub, 0, len(b.nodes))
+	for _, ps := range b.nodes {
+		nodes = append(nodes, ps)
+	}
+	return nodes
+}
+
+// publish validates a message at its sender, then delivers it to every
+// attached node subscribed to its topic
+func (b *PubSubBus) publish(ctx context.Context, from *busPubSub, topic string, data []byte) error {
+	b.mu.Lock()
+	b.seq++
+	seqno := binary.BigEndian.AppendUint64(nil, b.seq)
+	b.mu.Unlock()
+
+	msg := &pb.Message{From: []byte(from.id), Data: data, Seqno: seqno, Topic: &topic}
+	if _, err := from.id.ExtractPublicKey(); err != nil {
+		// Keys that do not fit in the peer ID travel with the message
+		if msg.Key, err = crypto.MarshalPublicKey(from.priv.GetPublic()); err != nil {
+			return err
+		}
+	}
+	signed, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	if msg.Signature, err = from.priv.Sign(append([]byte("libp2p-pubsub:"), signed...)); err != nil {
+		return err
+	}
+
+	if !from.validate(ctx, topic, from.id, msg, true) {
+		return errors.New("validation failed")
+	}
+	for _, node := range b.attached() {
+		if node == from || node.subscribed(topic) {
+			node.deliver(ctx, topic, from.id, msg)
+		}
+	}
+	return nil
+}
+
+// busPubSub is the sightnode.PubSub of one node attached to a bus
+type busPubSub struct {
+	bus  *PubSubBus
+	id   peer.ID
+	priv crypto.PrivKey
+
+	mu         sync.Mutex
+	topics     map[string]*busTopic
+	validators map[string]interface{}
+}
+
+func (ps *busPubSub) Join(topic string, _ ...pubsub.TopicOpt) (sightnode.Topic, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.topics[topic]; ok {
+		return nil, fmt.Errorf("topic already exists")
+	}
+	t := &busTopic{ps: ps, name: topic}
+	ps.topics[topic] = t
+	return t, nil
+}
+
+// ListPeers returns the other attached nodes subscribed to or relaying topic
+func (ps *busPubSub) ListPeers(topic string) []peer.ID {
+	var peers []peer.ID
+	for _, node := range ps.bus.attached() {
+		if node != ps && node.subscribed(topic) {
+			peers = append(peers, node.id)
+		}
+	}
+	return peers
+}
+
+func (ps *busPubSub) RegisterTopicValidator(topic string, val interface{}, _ ...pubsub.ValidatorOpt) error {
+	switch val.(type) {
+	case func(context.Context, peer.ID, *pubsub.Message) pubsub.ValidationResult, pubsub.ValidatorEx,
+		func(context.Context, peer.ID, *pubsub.Message) bool, pubsub.Validator:
+	default:
+		return fmt.Errorf("unsupported validator type %T", val)
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.validators[topic]; ok {
+		return fmt.Errorf("duplicate validator for topic %s", topic)
+	}
+	ps.validators[topic] = val
+	return nil
+}
+
+func (ps *busPubSub) UnregisterTopicValidator(topic string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.validators[topic]; !ok {
+		return fmt.Errorf("no validator for topic %s", topic)
+	}
+	delete(ps.validators, topic)
+	return nil
+}
+
+// subscribed reports whether the node subscribes to or relays topic
+func (ps *busPubSub) subscribed(topic string) bool {
+	ps.mu.Lock()
+	t, ok := ps.topics[topic]
+	ps.mu.Unlock()
+	if !ok {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subs) > 0 || t.relays > 0
+}
+
+// validate runs the node's validator of topic, if any, on its own copy of
+// the message
+func (ps *busPubSub) validate(ctx context.Context, topic string, from peer.ID, m *pb.Message, local bool) bool {
+	_, ok := ps.accept(ctx, topic, from, m, local)
+	return ok
+}
+
+func (ps *busPubSub) accept(ctx context.Context, topic string, from peer.ID, m *pb.Message, local bool) (*pubsub.Message, bool) {
+	msg := &pubsub.Message{Message: m, ReceivedFrom: from, Local: local}
+	ps.mu.Lock()
+	val := ps.validators[topic]
+	ps.mu.Unlock()
+	switch v := val.(type) {
+	case func(context.Context, peer.ID, *pubsub.Message) pubsub.ValidationResult:
+		return msg, v(ctx, from, msg) == pubsub.ValidationAccept
+	case pubsub.ValidatorEx:
+		return msg, v(ctx, from, msg) == pubsub.ValidationAccept
+	case func(context.Context, peer.ID, *pubsub.Message) bool:
+		return msg, v(ctx, from, msg)
+	case pubsub.Validator:
+		return msg, v(ctx, from, msg)
+	}
+	return msg, true
+}
+
+// deliver hands a message the node's validator accepts to its subscriptions
+func (ps *busPubSub) deliver(ctx context.Context, topic string, from peer.ID, m *pb.Message) {
+	msg, ok := ps.accept(ctx, topic, from, m, ps.id == from)
+	if !ok {
+		return
+	}
+	ps.mu.Lock()
+	t, ok := ps.topics[topic]
+	ps.mu.Unlock()
+	if ok {
+		t.deliver(msg)
+	}
+}
+
+// busTopic is a topic joined on a bus
+type busTopic struct {
+	ps   *busPubSub
+	name string
+
+	mu     sync.Mutex
+	subs   []*busSubscription
+	relays int
+	closed bool
+}
+
+func (t *busTopic) String() string {
+	return t.name
+}
+
+func (t *busTopic) Publish(ctx context.Context, data []byte, _ ...pubsub.PubOpt) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return pubsub.ErrTopicClosed
+	}
+	return t.ps.bus.publish(ctx, t.ps, t.name, data)
+}
+
+func (t *busTopic) Subscribe(_ ...pubsub.SubOpt) (sightnode.Subscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, pubsub.ErrTopicClosed
+	}
+	sub := &busSubscription{
+		topic:    t,
+		messages: make(chan *pubsub.Message, subscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	t.subs = append(t.subs, sub)
+	return sub, nil
+}
+
+func (t *busTopic) Relay() (pubsub.RelayCancelFunc, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, pubsub.ErrTopicClosed
+	}
+	t.relays++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.relays--
+			t.mu.Unlock()
+		})
+	}, nil
+}
+
+// EventHandler returns a handler reporting no events: membership on a bus
+// does not change through peers joining a mesh
+func (t *busTopic) EventHandler(_ ...pubsub.TopicEventHandlerOpt) (sightnode.TopicEventHandler, error) {
+	return &busEventHandler{done: make(chan struct{})}, nil
+}
+
+func (t *busTopic) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) > 0 || t.relays > 0 {
+		return errors.New("cannot close topic: outstanding event handlers or subscriptions")
+	}
+	t.closed = true
+	t.ps.mu.Lock()
+	if t.ps.topics[t.name] == t {
+		delete(t.ps.topics, t.name)
+	}
+	t.ps.mu.Unlock()
+	return nil
+}
+
+func (t *busTopic) deliver(msg *pubsub.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sub := range t.subs {
+		select {
+		case sub.messages <- msg:
+		default:
+		}
+	}
+}
+
+func (t *busTopic) remove(sub *busSubscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, s := range t.subs {
+		if s == sub {
+			t.subs = append(t.subs[:i], t.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// busSubscription is a subscription to a topic joined on a bus
+type busSubscription struct {
+	topic    *busTopic
+	messages chan *pubsub.Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *busSubscription) Topic() string {
+	return s.topic.name
+}
+
+func (s *busSubscription) Next(ctx context.Context) (*pubsub.Message, error) {
+	select {
+	case msg := <-s.messages:
+		return msg, nil
+	case <-s.done:
+		return nil, pubsub.ErrSubscriptionCancelled
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *busSubscription) Cancel() {
+	s.once.Do(func() {
+		close(s.done)
+		s.topic.remove(s)
+	})
+}
+
+type busEventHandler struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func (h *busEventHandler) NextPeerEvent(ctx context.Context) (pubsub.PeerEvent, error) {
+	select {
+	case <-h.done:
+		return pubsub.PeerEvent{}, errors.New("event handler cancelled")
+	case <-ctx.Done():
+		return pubsub.PeerEvent{}, ctx.Err()
+	}
+}
+
+func (h *busEventHandler) Cancel() {
+	h.once.Do(func() { close(h.done) })
+}
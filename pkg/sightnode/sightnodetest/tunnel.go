@@ -0,0 +1,105 @@
+package sightnodetest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Delivery is a message forwarded by a node to its tunnel API
+type Delivery struct {
+	Header http.Header
+	Body   []byte
+	// Payload is the decoded body, nil when it is not a JSON object
+	Payload map[string]interface{}
+}
+
+// Tunnel is a test HTTP server standing in for a node's tunnel API. It
+// records every forward and answers with a configurable status.
+type Tunnel struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	deliveries []Delivery
+	// received is closed and replaced on every delivery, waking WaitFor
+	received chan struct{}
+}
+
+// NewTunnel starts a tunnel server answering 200 OK
+func NewTunnel() *Tunnel {
+	t := &Tunnel{status: http.StatusOK, received: make(chan struct{})}
+	t.Server = httptest.NewServer(http.HandlerFunc(t.serve))
+	return t
+}
+
+func (t *Tunnel) serve(w http.ResponseWriter, r *http.Request) {
+	// Readiness probes only check reachability
+	if r.Method == http.MethodHead {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	d := Delivery{Header: r.Header.Clone(), Body: body}
+	_ = json.Unmarshal(body, &d.Payload)
+
+	t.mu.Lock()
+	status := t.status
+	if status < 300 {
+		t.deliveries = append(t.deliveries, d)
+		close(t.received)
+		t.received = make(chan struct{})
+	}
+	t.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// SetStatus sets the status of the following responses. Forwards answered
+// with an error status are not recorded.
+func (t *Tunnel) SetStatus(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = code
+}
+
+// Deliveries returns the forwards accepted so far, oldest first
+func (t *Tunnel) Deliveries() []Delivery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Delivery{}, t.deliveries...)
+}
+
+// WaitFor blocks until a delivery matching match was accepted, or ctx is done
+func (t *Tunnel) WaitFor(ctx context.Context, match func(Delivery) bool) (Delivery, error) {
+	for seen := 0; ; {
+		t.mu.Lock()
+		for ; seen < len(t.deliveries); seen++ {
+			if d := t.deliveries[seen]; match(d) {
+				t.mu.Unlock()
+				return d, nil
+			}
+		}
+		received := t.received
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return Delivery{}, ctx.Err()
+		case <-received:
+		}
+	}
+}
+
+// HasField matches deliveries whose payload has key set to value, compared
+// after a JSON round trip (numbers are float64)
+func HasField(key string, value interface{}) func(Delivery) bool {
+	return func(d Delivery) bool {
+		v, ok := d.Payload[key]
+		return ok && v == value
+	}
+}
@@ -0,0 +1,143 @@
+package sightnode
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+)
+
+// signerTimeout bounds one request to the external signer
+const signerTimeout = 10 * time.Second
+
+// errExternalKey is returned for operations that need the private key itself
+var errExternalKey = errors.New("the node key is held by an external signer")
+
+// The external signer is a local daemon listening on a unix socket
+// (SIGNER_SOCKET). Each connection carries one JSON request and one JSON
+// response:
+//
+//	{"op":"publicKey"}           -> {"publicKey":"<base64 libp2p public key>"}
+//	{"op":"sign","data":"<b64>"} -> {"signature":"<base64>"}
+//
+// Failures are reported as {"error":"..."}. The daemon can front a PKCS#11
+// token or any other HSM, so the private key never reaches the node.
+type signerRequest struct {
+	Op   string `json:"op"`
+	Data []byte `json:"data,omitempty"`
+}
+
+type signerResponse struct {
+	PublicKey []byte `json:"publicKey,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// externalSigner is a crypto.PrivKey whose signatures are made by the signer
+// daemon
+type externalSigner struct {
+	socket string
+	pub    crypto.PubKey
+	secret []byte
+}
+
+// newExternalSigner connects to the signer daemon and fetches its public key
+func newExternalSigner(socket string) (*externalSigner, error) {
+	s := &externalSigner{socket: socket, secret: make([]byte, 32)}
+	if _, err := rand.Read(s.secret); err != nil {
+		return nil, err
+	}
+	resp, err := s.call(signerRequest{Op: "publicKey"})
+	if err != nil {
+		return nil, err
+	}
+	if s.pub, err = crypto.UnmarshalPublicKey(resp.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid signer public key: %w", err)
+	}
+	return s, nil
+}
+
+func (s *externalSigner) call(req signerRequest) (signerResponse, error) {
+	conn, err := net.DialTimeout("unix", s.socket, signerTimeout)
+	if err != nil {
+		return signerResponse{}, fmt.Errorf("external signer: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return signerResponse{}, fmt.Errorf("external signer: %w", err)
+	}
+	var resp signerResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return signerResponse{}, fmt.Errorf("external signer: %w", err)
+	}
+	if resp.Error != "" {
+		return signerResponse{}, fmt.Errorf("external signer: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// Sign asks the daemon to sign data
+func (s *externalSigner) Sign(data []byte) ([]byte, error) {
+	resp, err := s.call(signerRequest{Op: "sign", Data: data})
+	if err != nil {
+		return nil, err
+	}
+	// Catch a daemon signing with a different key before peers reject us
+	if ok, err := s.pub.Verify(data, resp.Signature); err != nil || !ok {
+		return nil, errors.New("external signer returned an invalid signature")
+	}
+	return resp.Signature, nil
+}
+
+// GetPublic returns the public key of the daemon's key
+func (s *externalSigner) GetPublic() crypto.PubKey {
+	return s.pub
+}
+
+// Type returns the type of the daemon's key
+func (s *externalSigner) Type() pb.KeyType {
+	return s.pub.Type()
+}
+
+// Raw cannot return the private key, which never leaves the signer. libp2p
+// only uses it as input key material for the QUIC stateless reset and token
+// keys, so a random per-process secret stands in; QUIC tokens issued before a
+// restart are simply no longer recognised.
+func (s *externalSigner) Raw() ([]byte, error) {
+	return s.secret, nil
+}
+
+// Equals compares keys by their public half
+func (s *externalSigner) Equals(k crypto.Key) bool {
+	other, ok := k.(crypto.PrivKey)
+	return ok && s.pub.Equals(other.GetPublic())
+}
+
+// ExternalKeypair returns a keypair whose private key operations are
+// delegated to the signer daemon on socket
+func ExternalKeypair(socket string) (Keypair, error) {
+	signer, err := newExternalSigner(socket)
+	if err != nil {
+		return Keypair{}, err
+	}
+	pubBytes, err := signer.pub.Raw()
+	if err != nil {
+		return Keypair{}, err
+	}
+	kp := Keypair{
+		Signer:    socket,
+		LastUsed:  time.Now().Format(time.RFC3339),
+		PublicKey: pubBytes,
+		signer:    signer,
+	}
+	if t, err := keyTypeOf(signer.pub); err == nil && t.keyType != crypto.Ed25519 {
+		kp.KeyType = t.name
+	}
+	return kp, nil
+}
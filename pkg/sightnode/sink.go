@@ -0,0 +1,205 @@
+package sightnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Kinds of event-streaming sinks
+const (
+	sinkNATS      = "nats"
+	sinkKafkaREST = "kafka-rest"
+)
+
+// kafkaRESTContentType is the embedded-JSON format of the Kafka REST proxy
+// v2 API, spoken by the Confluent REST Proxy and Redpanda
+const kafkaRESTContentType = "application/vnd.kafka.json.v2+json"
+
+// messageSink publishes incoming messages to an event-streaming system. Sink
+// deliveries go through the tunnel forwarder like webhook posts, so they are
+// retried, circuit-broken and dead-lettered the same way; their target is the
+// sink kind and subject, e.g. nats:sight.chat.
+type messageSink interface {
+	Publish(ctx context.Context, subject string, job forwardJob) error
+}
+
+// newMessageSink connects the sink configured by SINK_TYPE, nil when unset
+func newMessageSink(cfg Config, client TunnelClient) (messageSink, error) {
+	if cfg.SinkType == "" {
+		return nil, nil
+	}
+	if len(cfg.SinkURLs) == 0 {
+		return nil, fmt.Errorf("SINK_TYPE %s needs SINK_URLS", cfg.SinkType)
+	}
+	switch cfg.SinkType {
+	case sinkNATS:
+		return newNATSSink(cfg)
+	case sinkKafkaREST:
+		return &kafkaRESTSink{urls: cfg.SinkURLs, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown SINK_TYPE %q: want %s or %s", cfg.SinkType, sinkNATS, sinkKafkaREST)
+}
+
+// sinkTarget returns the forward target of a message: the sink kind and the
+// subject rendered from SINK_SUBJECT
+func sinkTarget(cfg Config, msg StreamMessage) string {
+	typ := msg.Type
+	if typ == "" {
+		typ = "none"
+	}
+	clean := natsToken
+	if cfg.SinkType == sinkKafkaREST {
+		clean = kafkaTopicName
+	}
+	subject := strings.NewReplacer(
+		"{did}", clean(msg.FromDID),
+		"{type}", clean(typ),
+		"{topic}", clean(msg.Topic),
+	).Replace(cfg.SinkSubject)
+	return cfg.SinkType + ":" + subject
+}
+
+// sinkSubject splits a forward target into the subject when it is one of the
+// sink's, rather than a webhook URL
+func (f *tunnelForwarder) sinkSubject(target string) (string, bool) {
+	if f.sink == nil {
+		return "", false
+	}
+	return strings.CutPrefix(target, f.cfg.SinkType+":")
+}
+
+// natsToken makes a value usable as a single subject token: no separators,
+// wildcards or whitespace
+func natsToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// kafkaTopicName keeps the characters Kafka allows in topic names
+func kafkaTopicName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// natsSink publishes on core NATS. The message ID goes in the Nats-Msg-Id
+// header, so a JetStream stream capturing the subjects drops the duplicates
+// of retried deliveries.
+type natsSink struct {
+	conn *nats.Conn
+}
+
+func newNATSSink(cfg Config) (*natsSink, error) {
+	// The connection is retried in the background, like the other upstreams
+	conn, err := nats.Connect(strings.Join(cfg.SinkURLs, ","),
+		nats.Name("sight-libp2p-node"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{conn: conn}, nil
+}
+
+func (n *natsSink) Publish(ctx context.Context, subject string, job forwardJob) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = job.Body
+	if job.MessageID != "" {
+		msg.Header.Set(nats.MsgIdHdr, job.MessageID)
+	}
+	if job.RequestID != "" {
+		msg.Header.Set(requestIDHeader, job.RequestID)
+	}
+	if err := n.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	// Publishing only buffers; the flush tells whether the server got it
+	return n.conn.FlushWithContext(ctx)
+}
+
+// kafkaRESTSink produces to Kafka through a REST proxy, keyed by sender DID
+// so the messages of a sender stay ordered in one partition. The URLs are
+// those of the proxy, not Kafka brokers, tried in turn.
+type kafkaRESTSink struct {
+	urls   []string
+	client TunnelClient
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (k *kafkaRESTSink) Publish(ctx context.Context, topic string, job forwardJob) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {{Key: job.FromDID, Value: job.Body}},
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, base := range k.urls {
+		err := k.produce(ctx, strings.TrimSuffix(base, "/")+"/topics/"+url.PathEscape(topic), body, job.RequestID)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (k *kafkaRESTSink) produce(ctx context.Context, endpoint string, body []byte, requestID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaRESTContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka REST proxy %s returned %s", endpoint, resp.Status)
+	}
+	var produced kafkaProduceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&produced); err != nil {
+		return fmt.Errorf("kafka REST proxy %s: %w", endpoint, err)
+	}
+	for _, o := range produced.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("kafka REST proxy %s: error %d: %s", endpoint, *o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseKeepAlive is how often an idle SSE stream gets a comment, so proxies
+// do not close it
+const sseKeepAlive = 15 * time.Second
+
+// eventID is the SSE event ID of a message
+func (h *streamHub) eventID(msg StreamMessage) string {
+	return h.epoch + "-" + strconv.FormatUint(msg.seq, 10)
+}
+
+// lastSeq returns the sequence number of a Last-Event-ID. IDs from another
+// run of the node, or unparseable ones, replay everything kept.
+func (h *streamHub) lastSeq(id string) uint64 {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0
+	}
+	n, _ := strconv.ParseUint(seq, 10, 64)
+	return n
+}
+
+// EventsHandler streams incoming messages, and network events when
+// EVENTS_STREAM is set, as Server-Sent Events. Like the WebSocket stream it
+// follows DELIVERY_MODE and takes ?topic=a,b&type=x,y filters. A client
+// reconnecting with Last-Event-ID (or ?lastEventId=) first gets the
+// messages it missed, as far as STREAM_REPLAY_SIZE reaches back.
+func (c *Libp2pNodeController) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	client := &streamClient{
+		filter: streamFilter{
+			Topics: splitQuery(r.URL.Query().Get("topic")),
+			Types:  splitQuery(r.URL.Query().Get("type")),
+		},
+		send: make(chan StreamMessage, 256),
+	}
+	hub := c.service.streams
+	var missed []StreamMessage
+	if lastID != "" {
+		missed = hub.resume(client, hub.lastSeq(lastID))
+	} else {
+		hub.add(client)
+	}
+	defer hub.remove(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	for _, msg := range missed {
+		if writeSSE(w, hub.eventID(msg), msg) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-client.send:
+			if writeSSE(w, hub.eventID(msg), msg) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes one event: "message" for incoming messages, which
+// EventSource.onmessage receives, and "node" for network events
+func writeSSE(w http.ResponseWriter, id string, msg StreamMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	event := "message"
+	if msg.Topic == eventsTopic {
+		event = "node"
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data)
+	return err
+}
@@ -0,0 +1,36 @@
+package sightnode
+
+import "errors"
+
+// StartupError is returned when the node cannot start. Fatal errors come from
+// invalid configuration or identity and will fail again on retry; the others
+// (listening, pubsub, topic joins) may be transient.
+type StartupError struct {
+	Op    string
+	Err   error
+	Fatal bool
+}
+
+func (e *StartupError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+// IsFatalStartupError reports whether retrying a failed start is pointless
+func IsFatalStartupError(err error) bool {
+	var se *StartupError
+	return errors.As(err, &se) && se.Fatal
+}
+
+// fatalStartup wraps a configuration error
+func fatalStartup(op string, err error) error {
+	return &StartupError{Op: op, Err: err, Fatal: true}
+}
+
+// startupFailure wraps an error that may go away on retry
+func startupFailure(op string, err error) error {
+	return &StartupError{Op: op, Err: err}
+}
@@ -0,0 +1,108 @@
+package sightnode
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// messageCounters count messages since the node was created. They are kept
+// per service rather than as Prometheus metrics, which are process-wide.
+type messageCounters struct {
+	received  atomic.Uint64
+	published atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// MessageStats are the message counters of a node; clients derive rates
+// from two snapshots
+type MessageStats struct {
+	// Received counts envelopes addressed to this node, after deduplication
+	Received uint64 `json:"received"`
+	// Published and Failed count outgoing messages by outcome
+	Published uint64 `json:"published"`
+	Failed    uint64 `json:"failed"`
+	// Forwarded counts successful posts to the tunnel API and webhooks
+	Forwarded uint64 `json:"forwarded"`
+}
+
+// QueueStats are the depths of the node's queues
+type QueueStats struct {
+	// Outbox is the number of queued outgoing messages per priority
+	Outbox map[string]int `json:"outbox"`
+	// OutboxPending also counts the messages being published
+	OutboxPending int `json:"outboxPending"`
+	// Forwarder is the number of queued, spooled and in-flight deliveries
+	Forwarder int `json:"forwarder"`
+	// ForwarderSpooled is the part of Forwarder waiting on disk
+	ForwarderSpooled int `json:"forwarderSpooled"`
+	// ForwarderDropped counts deliveries shed because the buffer was full
+	ForwarderDropped uint64 `json:"forwarderDropped"`
+	DeadLetters      int    `json:"deadLetters"`
+}
+
+// TopicStats is the number of mesh peers seen on a subscribed topic
+type TopicStats struct {
+	Topic   string `json:"topic"`
+	Peers   int    `json:"peers"`
+	Healthy bool   `json:"healthy"`
+}
+
+// NodeStats is returned by /v1/libp2p/stats
+type NodeStats struct {
+	DID      string       `json:"did"`
+	PeerID   string       `json:"peerId,omitempty"`
+	Uptime   string       `json:"uptime"`
+	Peers    int          `json:"peers"`
+	Messages MessageStats `json:"messages"`
+	Queues   QueueStats   `json:"queues"`
+	Topics   []TopicStats `json:"topics"`
+	// ActiveGateway is the gateway a hoster with GATEWAY_ADDRS routes
+	// through, empty when none is reachable
+	ActiveGateway string          `json:"activeGateway,omitempty"`
+	Gateways      []GatewayHealth `json:"gateways,omitempty"`
+}
+
+// Stats returns a snapshot of the node's counters, queues and topics
+func (s *Libp2pNodeService) Stats() NodeStats {
+	s.mu.RLock()
+	node, ps, did, gateways := s.node, s.pubsub, s.did, s.gateways
+	s.mu.RUnlock()
+
+	stats := NodeStats{
+		DID:    did,
+		Uptime: time.Since(s.startedAt).Round(time.Second).String(),
+		Messages: MessageStats{
+			Received:  s.counters.received.Load(),
+			Published: s.counters.published.Load(),
+			Failed:    s.counters.failed.Load(),
+			Forwarded: s.forwarder.delivered.Load(),
+		},
+		Queues: QueueStats{
+			Outbox:           s.outbox.Len(),
+			OutboxPending:    s.outbox.Pending(),
+			Forwarder:        s.forwarder.Pending(),
+			ForwarderSpooled: s.forwarder.Spooled(),
+			ForwarderDropped: s.forwarder.dropped.Load(),
+			DeadLetters:      len(s.forwarder.DeadLetters()),
+		},
+		Topics: []TopicStats{},
+	}
+	if node != nil {
+		stats.PeerID = node.ID().String()
+		stats.Peers = len(node.Network().Peers())
+	}
+	if gateways != nil {
+		stats.Gateways = gateways.List()
+		if id, ok := gateways.Active(); ok {
+			stats.ActiveGateway = id.String()
+		}
+	}
+	for _, sub := range s.Subscriptions() {
+		topic := TopicStats{Topic: sub.Topic, Healthy: sub.Healthy}
+		if ps != nil {
+			topic.Peers = len(ps.ListPeers(sub.Topic))
+		}
+		stats.Topics = append(stats.Topics, topic)
+	}
+	return stats
+}
@@ -0,0 +1,236 @@
+package sightnode
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Delivery modes for incoming messages (DELIVERY_MODE)
+const (
+	deliveryWebhook = "webhook"
+	deliveryStream  = "stream"
+	deliveryBoth    = "both"
+)
+
+// StreamMessage is pushed to WebSocket clients of /v1/libp2p/stream and SSE
+// clients of /v1/libp2p/events
+type StreamMessage struct {
+	ID      string `json:"id,omitempty"`
+	Topic   string `json:"topic"`
+	Type    string `json:"type,omitempty"`
+	From    string `json:"from"`
+	FromDID string `json:"fromDid,omitempty"`
+	// RequestID is the X-Request-ID the sender's upstream attached
+	RequestID string `json:"requestId,omitempty"`
+	// QoS is the delivery guarantee the sender asked for, when not at-most-once
+	QoS     string      `json:"qos,omitempty"`
+	Payload interface{} `json:"payload"`
+
+	// seq numbers the messages sent to remote clients, for SSE resumption
+	seq uint64
+}
+
+func newStreamMessage(topic string, from peer.ID, fromDID string, envelope map[string]interface{}) StreamMessage {
+	msg := StreamMessage{Topic: topic, From: from.String(), FromDID: fromDID, Payload: envelope["payload"]}
+	msg.ID, _ = envelope["id"].(string)
+	msg.RequestID, _ = envelope["requestId"].(string)
+	msg.QoS, _ = envelope["qos"].(string)
+	msg.Type = envelopeType(envelope)
+	return msg
+}
+
+// streamFilter selects which messages a client receives; empty lists match all
+type streamFilter struct {
+	Topics []string `json:"topics"`
+	Types  []string `json:"types"`
+}
+
+func (f streamFilter) matches(msg StreamMessage) bool {
+	return matchesAny(f.Topics, msg.Topic) && matchesAny(f.Types, msg.Type)
+}
+
+func matchesAny(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type streamClient struct {
+	mu     sync.Mutex
+	filter streamFilter
+	send   chan StreamMessage
+
+	// local clients are Go subscribers of an embedded Node and gRPC
+	// subscribers; they receive messages whatever the DELIVERY_MODE
+	local bool
+}
+
+// streamHub fans incoming messages out to the connected WebSocket and SSE
+// clients, keeping the latest ones for SSE clients that reconnect
+type streamHub struct {
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+	// SSE event IDs are epoch-seq, so IDs from before a restart are told apart
+	epoch string
+	seq   uint64
+	// replay is a ring of the latest messages sent to remote clients
+	replay     []StreamMessage
+	replayNext int
+}
+
+func newStreamHub(replaySize int) *streamHub {
+	return &streamHub{
+		clients: make(map[*streamClient]struct{}),
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay:  make([]StreamMessage, 0, max(replaySize, 0)),
+	}
+}
+
+// Publish pushes a message to every matching client, dropping it for clients
+// too slow to keep up rather than blocking the pubsub loop. With localOnly
+// set only local (embedded and gRPC) subscribers receive it.
+func (h *streamHub) Publish(msg StreamMessage, localOnly bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !localOnly {
+		h.seq++
+		msg.seq = h.seq
+		h.remember(msg)
+	}
+	for c := range h.clients {
+		if localOnly && !c.local {
+			continue
+		}
+		c.mu.Lock()
+		match := c.filter.matches(msg)
+		c.mu.Unlock()
+		if !match {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			log.Printf("Stream client too slow, dropping message %s", msg.ID)
+		}
+	}
+}
+
+// remember adds a message to the replay ring; callers hold h.mu
+func (h *streamHub) remember(msg StreamMessage) {
+	switch {
+	case cap(h.replay) == 0:
+	case len(h.replay) < cap(h.replay):
+		h.replay = append(h.replay, msg)
+	default:
+		h.replay[h.replayNext] = msg
+		h.replayNext = (h.replayNext + 1) % len(h.replay)
+	}
+}
+
+func (h *streamHub) add(c *streamClient) {
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+}
+
+// resume adds a client and returns the kept messages numbered after lastSeq
+// that match its filter, oldest first. Both happen under the lock, so the
+// client misses nothing between the replay and the live messages.
+func (h *streamHub) resume(c *streamClient, lastSeq uint64) []StreamMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	var missed []StreamMessage
+	for i := range h.replay {
+		msg := h.replay[(h.replayNext+i)%len(h.replay)]
+		if msg.seq > lastSeq && c.filter.matches(msg) {
+			missed = append(missed, msg)
+		}
+	}
+	return missed
+}
+
+func (h *streamHub) remove(c *streamClient) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+var streamUpgrader = websocket.Upgrader{
+	// The API is consumed by the local upstream service, not browsers: a
+	// request carrying an Origin comes from a web page, which must not read
+	// the node's messages through the user's browser
+	CheckOrigin: func(r *http.Request) bool { return r.Header.Get("Origin") == "" },
+}
+
+// StreamHandler upgrades to a WebSocket and pushes incoming messages. Filters
+// come from ?topic=a,b&type=x,y and can be replaced by sending a JSON filter
+// {"topics": [...], "types": [...]} over the socket.
+func (c *Libp2pNodeController) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &streamClient{
+		filter: streamFilter{
+			Topics: splitQuery(r.URL.Query().Get("topic")),
+			Types:  splitQuery(r.URL.Query().Get("type")),
+		},
+		send: make(chan StreamMessage, 256),
+	}
+	hub := c.service.streams
+	hub.add(client)
+	done := make(chan struct{})
+
+	// Reader: filter updates from the client, and close detection
+	go func() {
+		defer close(done)
+		for {
+			var filter streamFilter
+			if err := conn.ReadJSON(&filter); err != nil {
+				return
+			}
+			client.mu.Lock()
+			client.filter = filter
+			client.mu.Unlock()
+		}
+	}()
+
+	defer func() {
+		hub.remove(client)
+		conn.Close()
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-client.send:
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func splitQuery(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
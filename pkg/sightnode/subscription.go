@@ -0,0 +1,210 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Backoff bounds between resubscription attempts
+const (
+	resubscribeBackoffMin = time.Second
+	resubscribeBackoffMax = time.Minute
+)
+
+var (
+	subscriptionHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sight_subscription_healthy",
+		Help: "1 while the subscription to a topic receives messages, 0 while it is being restored",
+	}, []string{"topic"})
+	subscriptionRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_subscription_restarts_total",
+		Help: "Subscriptions recreated after a pubsub error, per topic",
+	}, []string{"topic"})
+)
+
+// SubscriptionHealth is the state of one supervised subscription
+type SubscriptionHealth struct {
+	Topic       string `json:"topic"`
+	Healthy     bool   `json:"healthy"`
+	Restarts    int    `json:"restarts"`
+	LastError   string `json:"lastError,omitempty"`
+	LastRestart string `json:"lastRestart,omitempty"`
+}
+
+// subscriptionHealthSet tracks the supervised subscriptions by topic
+type subscriptionHealthSet struct {
+	mu     sync.Mutex
+	topics map[string]*SubscriptionHealth
+}
+
+func newSubscriptionHealthSet() *subscriptionHealthSet {
+	return &subscriptionHealthSet{topics: make(map[string]*SubscriptionHealth)}
+}
+
+func (h *subscriptionHealthSet) track(topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.topics[topic]; !ok {
+		h.topics[topic] = &SubscriptionHealth{Topic: topic}
+	}
+	h.topics[topic].Healthy = true
+	subscriptionHealthy.WithLabelValues(topic).Set(1)
+}
+
+// untrack marks a subscription as deliberately cancelled, so its supervisor
+// stops instead of resubscribing
+func (h *subscriptionHealthSet) untrack(topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.topics, topic)
+	subscriptionHealthy.DeleteLabelValues(topic)
+}
+
+// clear untracks every subscription when the host stops
+func (h *subscriptionHealthSet) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for topic := range h.topics {
+		subscriptionHealthy.DeleteLabelValues(topic)
+	}
+	h.topics = make(map[string]*SubscriptionHealth)
+}
+
+func (h *subscriptionHealthSet) tracked(topic string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.topics[topic]
+	return ok
+}
+
+func (h *subscriptionHealthSet) failed(topic string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if st, ok := h.topics[topic]; ok {
+		st.Healthy = false
+		st.LastError = err.Error()
+		subscriptionHealthy.WithLabelValues(topic).Set(0)
+	}
+}
+
+func (h *subscriptionHealthSet) restored(topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if st, ok := h.topics[topic]; ok {
+		st.Healthy = true
+		st.Restarts++
+		st.LastRestart = time.Now().Format(time.RFC3339)
+		subscriptionHealthy.WithLabelValues(topic).Set(1)
+		subscriptionRestarts.WithLabelValues(topic).Inc()
+	}
+}
+
+// List returns the subscriptions sorted by topic
+func (h *subscriptionHealthSet) List() []SubscriptionHealth {
+	h.mu.Lock()
+	list := make([]SubscriptionHealth, 0, len(h.topics))
+	for _, st := range h.topics {
+		list = append(list, *st)
+	}
+	h.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Topic < list[j].Topic })
+	return list
+}
+
+// Healthy reports whether every supervised subscription is receiving
+func (h *subscriptionHealthSet) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, st := range h.topics {
+		if !st.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// supervise hands every message of sub to handle until ctx is done or the
+// topic is deliberately unsubscribed. Any other Next error no longer ends
+// message receipt: the subscription is recreated with resubscribe, retrying
+// with exponential backoff.
+func (s *Libp2pNodeService) supervise(ctx context.Context, sub Subscription, resubscribe func() (Subscription, error), handle func(*pubsub.Message)) {
+	name := sub.Topic()
+	s.subHealth.track(name)
+	for {
+		msg, err := sub.Next(ctx)
+		if err == nil {
+			if s.chaos.admit(msg) {
+				handle(msg)
+			}
+			continue
+		}
+		if ctx.Err() != nil || !s.subHealth.tracked(name) {
+			return
+		}
+		log.Printf("Subscription to %s failed: %v", name, err)
+		s.subHealth.failed(name, err)
+		for failures := 1; ; failures++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(expBackoff(failures, resubscribeBackoffMin, resubscribeBackoffMax)):
+			}
+			if !s.subHealth.tracked(name) {
+				return
+			}
+			if sub, err = resubscribe(); err == nil {
+				break
+			}
+			log.Printf("Resubscribing to %s failed: %v", name, err)
+			s.subHealth.failed(name, err)
+		}
+		s.subHealth.restored(name)
+		log.Printf("Resubscribed to %s", name)
+	}
+}
+
+// resubscribeJoined subscribes again to a topic joined through joinTopic,
+// rejoining it when its handle was closed, and replaces the old subscription
+// in s.subscriptions
+func (s *Libp2pNodeService) resubscribeJoined(name string) (Subscription, error) {
+	topic, err := s.joinTopic(name)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := topic.Subscribe()
+	if errors.Is(err, pubsub.ErrTopicClosed) {
+		s.topicsMu.Lock()
+		delete(s.topics, name)
+		s.topicsMu.Unlock()
+		if topic, err = s.joinTopic(name); err != nil {
+			return nil, err
+		}
+		sub, err = topic.Subscribe()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, old := range s.subscriptions {
+		if old.Topic() == name {
+			s.subscriptions[i] = sub
+			return sub, nil
+		}
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+	return sub, nil
+}
+
+// Subscriptions returns the health of the supervised subscriptions
+func (s *Libp2pNodeService) Subscriptions() []SubscriptionHealth {
+	return s.subHealth.List()
+}
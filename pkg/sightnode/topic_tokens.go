@@ -0,0 +1,332 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// errNotTopicOwner is returned when this node may not issue tokens for a topic
+var errNotTopicOwner = errors.New("this node does not own the topic")
+
+// TopicToken lets Holder publish on a protected topic. It is signed by the
+// host key of one of the topic owners declared in PROTECTED_TOPICS.
+type TopicToken struct {
+	Topic     string `json:"topic"`
+	Holder    string `json:"holder"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Issuer    string `json:"issuer"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// signingBytes is the token encoding covered by the signature
+func (t TopicToken) signingBytes() ([]byte, error) {
+	t.Signature = nil
+	return json.Marshal(t)
+}
+
+// Verify checks the signature against the issuer's PeerID key and the expiry
+func (t TopicToken) Verify() error {
+	issuer, err := peer.Decode(t.Issuer)
+	if err != nil {
+		return fmt.Errorf("invalid issuer: %w", err)
+	}
+	pub, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return err
+	}
+	data, err := t.signingBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, t.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid topic token signature")
+	}
+	if t.expired() {
+		return errors.New("topic token expired")
+	}
+	return nil
+}
+
+func (t TopicToken) expired() bool {
+	if t.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, t.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// tokenedMessage is the wire format of protected topics: the publisher's
+// token next to the original message
+type tokenedMessage struct {
+	Token TopicToken `json:"token"`
+	Data  []byte     `json:"data"`
+}
+
+// topicTokens tracks the protected topics, their owners and the tokens this
+// node holds for publishing on them
+type topicTokens struct {
+	mu     sync.RWMutex
+	owners map[string]map[peer.ID]struct{}
+	held   map[string]TopicToken
+	path   string
+}
+
+// newTopicTokens parses PROTECTED_TOPICS entries, "topic" or "topic=owner".
+// Topics without an owner are owned by the configured bootstrap gateways.
+func newTopicTokens(cfg Config) *topicTokens {
+	t := &topicTokens{
+		owners: make(map[string]map[peer.ID]struct{}),
+		held:   make(map[string]TopicToken),
+		path:   cfg.DataDir + "/topic-tokens.json",
+	}
+	infos, _ := parseBootstrapAddrs(cfg.Bootstrap)
+	for _, entry := range cfg.ProtectedTopics {
+		topic, owner, hasOwner := strings.Cut(entry, "=")
+		if t.owners[topic] == nil {
+			t.owners[topic] = make(map[peer.ID]struct{})
+		}
+		if !hasOwner {
+			for _, info := range infos {
+				t.owners[topic][info.ID] = struct{}{}
+			}
+			continue
+		}
+		id, err := parsePeerOrDID(owner)
+		if err != nil {
+			log.Printf("Ignoring PROTECTED_TOPICS owner %q: %v", entry, err)
+			continue
+		}
+		t.owners[topic][id] = struct{}{}
+	}
+	t.load()
+	return t
+}
+
+// Protected reports whether publishing on topic requires a token
+func (t *topicTokens) Protected(topic string) bool {
+	_, ok := t.owners[topic]
+	return ok
+}
+
+// IsOwner reports whether id may issue tokens for topic
+func (t *topicTokens) IsOwner(topic string, id peer.ID) bool {
+	_, ok := t.owners[topic][id]
+	return ok
+}
+
+// check verifies that token lets from publish on topic
+func (t *topicTokens) check(topic string, from peer.ID, token TopicToken) error {
+	if token.Topic != topic {
+		return fmt.Errorf("token is for topic %q", token.Topic)
+	}
+	if token.Holder != from.String() {
+		return fmt.Errorf("token is held by %s", token.Holder)
+	}
+	if err := token.Verify(); err != nil {
+		return err
+	}
+	issuer, _ := peer.Decode(token.Issuer)
+	if !t.IsOwner(topic, issuer) {
+		return fmt.Errorf("issuer %s does not own the topic", token.Issuer)
+	}
+	return nil
+}
+
+// Add stores a token issued to self for publishing
+func (t *topicTokens) Add(self peer.ID, token TopicToken) error {
+	if !t.Protected(token.Topic) {
+		return fmt.Errorf("topic %q is not protected", token.Topic)
+	}
+	if err := t.check(token.Topic, self, token); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held[token.Topic] = token
+	t.save()
+	return nil
+}
+
+// Held returns the unexpired token held for topic
+func (t *topicTokens) Held(topic string) (TopicToken, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	token, ok := t.held[topic]
+	if !ok || token.expired() {
+		return TopicToken{}, false
+	}
+	return token, true
+}
+
+// List returns the held tokens
+func (t *topicTokens) List() []TopicToken {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	list := make([]TopicToken, 0, len(t.held))
+	for _, token := range t.held {
+		list = append(list, token)
+	}
+	return list
+}
+
+func (t *topicTokens) load() {
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return
+	}
+	var list []TopicToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Error reading topic tokens: %v", err)
+		return
+	}
+	for _, token := range list {
+		t.held[token.Topic] = token
+	}
+}
+
+// save persists the held tokens; callers hold t.mu
+func (t *topicTokens) save() {
+	list := make([]TopicToken, 0, len(t.held))
+	for _, token := range t.held {
+		list = append(list, token)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Error marshalling topic tokens: %v", err)
+		return
+	}
+	if err := os.WriteFile(t.path, data, 0600); err != nil {
+		log.Printf("Error writing topic tokens: %v", err)
+	}
+}
+
+// IssueTopicToken signs a token letting holder (a PeerID or DID) publish on a
+// protected topic for ttl. Only the topic owners may call it.
+func (s *Libp2pNodeService) IssueTopicToken(topic, holder string, ttl time.Duration) (TopicToken, error) {
+	if !s.topicTokens.Protected(topic) {
+		return TopicToken{}, fmt.Errorf("topic %q is not protected", topic)
+	}
+	id, err := parsePeerOrDID(holder)
+	if err != nil {
+		return TopicToken{}, fmt.Errorf("invalid holder: %w", err)
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	if !s.topicTokens.IsOwner(topic, node.ID()) {
+		return TopicToken{}, errNotTopicOwner
+	}
+	return signTopicToken(node, topic, id, ttl)
+}
+
+// signTopicToken signs a token for holder with the host key
+func signTopicToken(node hostlibp2p.Host, topic string, holder peer.ID, ttl time.Duration) (TopicToken, error) {
+	token := TopicToken{Topic: topic, Holder: holder.String(), Issuer: node.ID().String()}
+	if ttl > 0 {
+		token.ExpiresAt = time.Now().Add(ttl).Format(time.RFC3339)
+	}
+	data, err := token.signingBytes()
+	if err != nil {
+		return TopicToken{}, err
+	}
+	if token.Signature, err = node.Peerstore().PrivKey(node.ID()).Sign(data); err != nil {
+		return TopicToken{}, err
+	}
+	return token, nil
+}
+
+// AddTopicToken stores a token issued to this node
+func (s *Libp2pNodeService) AddTopicToken(token TopicToken) error {
+	s.mu.RLock()
+	self := s.node.ID()
+	s.mu.RUnlock()
+	return s.topicTokens.Add(self, token)
+}
+
+// TopicTokens returns the tokens this node holds
+func (s *Libp2pNodeService) TopicTokens() []TopicToken {
+	return s.topicTokens.List()
+}
+
+// publishTopic publishes data on topic, attaching our token when the topic
+// is protected. Owners issue themselves a token on demand.
+func (s *Libp2pNodeService) publishTopic(ctx context.Context, topic Topic, data []byte) error {
+	name := topic.String()
+	if !s.topicTokens.Protected(name) {
+		return topic.Publish(ctx, data)
+	}
+	token, ok := s.topicTokens.Held(name)
+	if !ok {
+		s.mu.RLock()
+		node := s.node
+		s.mu.RUnlock()
+		self := node.ID()
+		if !s.topicTokens.IsOwner(name, self) {
+			return fmt.Errorf("no membership token for protected topic %s", name)
+		}
+		var err error
+		if token, err = signTopicToken(node, name, self, s.cfg.TopicTokenTTL); err != nil {
+			return err
+		}
+		if err := s.topicTokens.Add(self, token); err != nil {
+			return err
+		}
+	}
+	wrapped, err := json.Marshal(tokenedMessage{Token: token, Data: data})
+	if err != nil {
+		return err
+	}
+	return topic.Publish(ctx, wrapped)
+}
+
+// registerTopicValidator registers next for topic; on protected topics the
+// publisher's token is checked first and the unwrapped message is handed on
+// as the validator data. next may be nil.
+func (s *Libp2pNodeService) registerTopicValidator(topic string, next pubsub.ValidatorEx) error {
+	if !s.topicTokens.Protected(topic) {
+		if next == nil {
+			return nil
+		}
+		return s.pubsub.RegisterTopicValidator(topic, next)
+	}
+	return s.pubsub.RegisterTopicValidator(topic, func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		var tm tokenedMessage
+		if err := json.Unmarshal(msg.Data, &tm); err != nil {
+			debugf("Rejecting message without topic token from %s", msg.GetFrom())
+			return pubsub.ValidationReject
+		}
+		if err := s.topicTokens.check(topic, msg.GetFrom(), tm.Token); err != nil {
+			debugf("Rejecting message from %s on %s: %v", msg.GetFrom(), topic, err)
+			return pubsub.ValidationReject
+		}
+		msg.ValidatorData = tm.Data
+		if next == nil {
+			return pubsub.ValidationAccept
+		}
+		return next(ctx, from, msg)
+	})
+}
+
+// messageData returns the application data of a validated message, without
+// the token of protected topics
+func messageData(msg *pubsub.Message) []byte {
+	if data, ok := msg.ValidatorData.([]byte); ok {
+		return data
+	}
+	return msg.Data
+}
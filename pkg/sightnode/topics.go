@@ -0,0 +1,102 @@
+package sightnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// inboxTopicPrefix starts the per-recipient topics; the rest is a hash of the
+// DID so topic names do not reveal who is being addressed
+const inboxTopicPrefix = "sight/msg/"
+
+// inboxTopic returns the topic a DID receives its messages on
+func inboxTopic(did string) string {
+	sum := sha256.Sum256([]byte(did))
+	return inboxTopicPrefix + hex.EncodeToString(sum[:16])
+}
+
+// joinTopic returns the joined topic handle, joining it on first use
+func (s *Libp2pNodeService) joinTopic(name string) (Topic, error) {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	if topic, ok := s.topics[name]; ok {
+		return topic, nil
+	}
+	topic, err := s.pubsub.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	s.topics[name] = topic
+	return topic, nil
+}
+
+// subscribeInbox validates and consumes messages of one receiving topic
+func (s *Libp2pNodeService) subscribeInbox(ctx context.Context, name string) error {
+	if err := s.registerTopicValidator(name, s.validateMessage); err != nil {
+		return err
+	}
+	topic, err := s.joinTopic(name)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+	go s.supervise(ctx, sub, func() (Subscription, error) {
+		return s.resubscribeJoined(name)
+	}, func(msg *pubsub.Message) {
+		s.handleEnvelope(ctx, msg.GetTopic(), msg.GetFrom(), messageData(msg))
+	})
+	return nil
+}
+
+// subscribeInboxes subscribes to the inbox of the current DID, those of our
+// previous DIDs so senders with stale keys still reach us, and the legacy
+// shared topic when enabled
+func (s *Libp2pNodeService) subscribeInboxes(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions = nil
+	for _, did := range append([]string{s.did}, s.previousDIDs...) {
+		if err := s.subscribeInbox(ctx, inboxTopic(did)); err != nil {
+			return err
+		}
+	}
+	if s.cfg.LegacyTopic {
+		return s.subscribeInbox(ctx, messageTopic)
+	}
+	return nil
+}
+
+// publishEnvelope publishes to the recipient's inbox topic, and to the legacy
+// shared topic while older nodes are still being migrated
+func (s *Libp2pNodeService) publishEnvelope(ctx context.Context, to string, data []byte) error {
+	if to == "" {
+		return errors.New("message has no recipient")
+	}
+	// s.mu is released before publishing: our own validators run on local
+	// publishes and read the host under it
+	s.mu.RLock()
+	topic, err := s.joinTopic(inboxTopic(to))
+	var legacy Topic
+	if err == nil && s.cfg.LegacyTopic {
+		legacy, err = s.joinTopic(messageTopic)
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := s.publishTopic(ctx, topic, data); err != nil {
+		return err
+	}
+	if legacy != nil {
+		return s.publishTopic(ctx, legacy, data)
+	}
+	return nil
+}
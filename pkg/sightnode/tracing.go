@@ -0,0 +1,101 @@
+package sightnode
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceContextField carries the W3C trace context inside the message envelope
+const traceContextField = "traceContext"
+
+var tracer = otel.Tracer("sight-libp2p-node")
+
+// InitTracing installs an OTLP/HTTP trace exporter when
+// OTEL_EXPORTER_OTLP_ENDPOINT (or the traces-specific variant) is set; the
+// exporter reads the standard OTEL_EXPORTER_OTLP_* variables itself. The
+// returned function flushes pending spans on shutdown.
+func InitTracing(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewSchemaless(semconv.ServiceName(cfg.OTelServiceName))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// injectTraceContext stores the span context of ctx in the envelope
+func injectTraceContext(ctx context.Context, envelope map[string]interface{}) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		envelope[traceContextField] = map[string]string(carrier)
+	}
+}
+
+// extractTraceContext returns a context continuing the trace of an envelope
+func extractTraceContext(ctx context.Context, envelope map[string]interface{}) context.Context {
+	carrier := propagation.MapCarrier{}
+	switch tc := envelope[traceContextField].(type) {
+	case map[string]interface{}:
+		for k, v := range tc {
+			if s, ok := v.(string); ok {
+				carrier[k] = s
+			}
+		}
+	case map[string]string:
+		for k, v := range tc {
+			carrier[k] = v
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// traceCarrier captures the span context of ctx for work done asynchronously
+func traceCarrier(ctx context.Context) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// httpTraceContext continues a trace started by the HTTP caller (traceparent header)
+func httpTraceContext(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+func messageAttrs(id, to string) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("sight.message.id", id), attribute.String("sight.message.to", to))
+}
+
+// requestIDHeader correlates one message across the sender's API call, the
+// envelope and the recipient's tunnel API call
+const requestIDHeader = "X-Request-ID"
+
+// messageRef names a message in log lines, with its request ID when known
+func messageRef(id, requestID string) string {
+	if requestID == "" {
+		return id
+	}
+	return id + " [request " + requestID + "]"
+}
+
+// envelopeRef is messageRef for an envelope
+func envelopeRef(envelope map[string]interface{}) string {
+	id, _ := envelope["id"].(string)
+	requestID, _ := envelope["requestId"].(string)
+	return messageRef(id, requestID)
+}
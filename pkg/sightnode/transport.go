@@ -0,0 +1,136 @@
+package sightnode
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PubSub is the part of the pubsub router the service uses. WrapPubSub
+// adapts a go-libp2p-pubsub router to it; tests may substitute a fake.
+type PubSub interface {
+	Join(topic string, opts ...pubsub.TopicOpt) (Topic, error)
+	ListPeers(topic string) []peer.ID
+	RegisterTopicValidator(topic string, val interface{}, opts ...pubsub.ValidatorOpt) error
+	UnregisterTopicValidator(topic string) error
+}
+
+// Topic is a joined pubsub topic, as returned by PubSub.Join
+type Topic interface {
+	String() string
+	Publish(ctx context.Context, data []byte, opts ...pubsub.PubOpt) error
+	Subscribe(opts ...pubsub.SubOpt) (Subscription, error)
+	Relay() (pubsub.RelayCancelFunc, error)
+	EventHandler(opts ...pubsub.TopicEventHandlerOpt) (TopicEventHandler, error)
+	Close() error
+}
+
+// Subscription delivers the messages of a topic; *pubsub.Subscription
+// implements it
+type Subscription interface {
+	Topic() string
+	Next(ctx context.Context) (*pubsub.Message, error)
+	Cancel()
+}
+
+// TopicEventHandler reports peers joining and leaving a topic;
+// *pubsub.TopicEventHandler implements it
+type TopicEventHandler interface {
+	NextPeerEvent(ctx context.Context) (pubsub.PeerEvent, error)
+	Cancel()
+}
+
+// WrapPubSub adapts a go-libp2p-pubsub router to PubSub
+func WrapPubSub(ps *pubsub.PubSub) PubSub {
+	return libp2pPubSub{ps}
+}
+
+type libp2pPubSub struct {
+	*pubsub.PubSub
+}
+
+func (ps libp2pPubSub) Join(topic string, opts ...pubsub.TopicOpt) (Topic, error) {
+	t, err := ps.PubSub.Join(topic, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return libp2pTopic{t}, nil
+}
+
+type libp2pTopic struct {
+	*pubsub.Topic
+}
+
+func (t libp2pTopic) Subscribe(opts ...pubsub.SubOpt) (Subscription, error) {
+	sub, err := t.Topic.Subscribe(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (t libp2pTopic) EventHandler(opts ...pubsub.TopicEventHandlerOpt) (TopicEventHandler, error) {
+	h, err := t.Topic.EventHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// HostFactory creates the host and pubsub router of a node on every start.
+// psOpts must be passed on to the router; extraOpts configure a libp2p host
+// and may be ignored by factories that do not build one.
+type HostFactory func(ctx context.Context, cfg Config, priv crypto.PrivKey, psOpts []pubsub.Option, extraOpts ...libp2p.Option) (hostlibp2p.Host, PubSub, error)
+
+// TunnelClient sends the HTTP requests to the tunnel API: webhook forwards
+// and readiness probes. *http.Client implements it.
+type TunnelClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Option customises how a node reaches the network and its tunnel API, e.g.
+// to run it against the in-memory fakes of package sightnodetest, and where
+// it reloads its configuration from
+type Option func(*options)
+
+type options struct {
+	hosts  HostFactory
+	tunnel TunnelClient
+	config func() (Config, error)
+}
+
+func defaultOptions() options {
+	// The tunnel client is built from the config unless one is given
+	return options{hosts: createLibp2pHost}
+}
+
+// WithHostFactory replaces CreateLibp2pNode as the way hosts are created
+func WithHostFactory(f HostFactory) Option {
+	return func(o *options) { o.hosts = f }
+}
+
+// WithTunnelClient replaces the HTTP client used to reach the tunnel API
+func WithTunnelClient(c TunnelClient) Option {
+	return func(o *options) { o.tunnel = c }
+}
+
+// WithConfigSource sets where ReloadConfig reads the new configuration from,
+// e.g. LoadConfigFile of the file the node was started with. Without it a
+// node cannot be reloaded.
+func WithConfigSource(load func() (Config, error)) Option {
+	return func(o *options) { o.config = load }
+}
+
+// createLibp2pHost is the default HostFactory
+func createLibp2pHost(ctx context.Context, cfg Config, priv crypto.PrivKey, psOpts []pubsub.Option, extraOpts ...libp2p.Option) (hostlibp2p.Host, PubSub, error) {
+	h, ps, err := CreateLibp2pNode(ctx, cfg, priv, psOpts, extraOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, WrapPubSub(ps), nil
+}
@@ -0,0 +1,67 @@
+package sightnode
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var tunnelRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "sight_tunnel_request_duration_seconds",
+	Help:    "Duration of requests to the tunnel API, webhooks and RPC API, per method and status class",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "status"})
+
+// newTunnelClient builds the HTTP client of the tunnel API from the TUNNEL_*
+// connection settings. Without TUNNEL_PROXY the usual HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY variables apply.
+func newTunnelClient(cfg Config) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if cfg.TunnelProxy != "" {
+		u, err := url.Parse(cfg.TunnelProxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid TUNNEL_PROXY %q", cfg.TunnelProxy)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	dialer := &net.Dialer{
+		Timeout:   cfg.TunnelConnectTimeout,
+		KeepAlive: cfg.TunnelKeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.TunnelConnectTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          cfg.TunnelMaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.TunnelMaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.TunnelMaxConnsPerHost,
+		IdleConnTimeout:       cfg.TunnelIdleConnTimeout,
+		// A negative keep-alive turns connection reuse off altogether
+		DisableKeepAlives: cfg.TunnelKeepAlive < 0,
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// timedTunnelClient records the duration of every tunnel request
+type timedTunnelClient struct {
+	next TunnelClient
+}
+
+func (c timedTunnelClient) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.next.Do(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	tunnelRequestDuration.WithLabelValues(req.Method, status).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,232 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// What happens to a delivery when the forward buffer is full
+const (
+	// overflowDeadLetter parks it in the DLQ for a manual replay
+	overflowDeadLetter = "deadletter"
+	// overflowDrop sheds it
+	overflowDrop = "drop"
+	// overflowSpool queues it on disk until the workers catch up
+	overflowSpool = "spool"
+)
+
+var errSpoolFull = errors.New("tunnel spool is full")
+
+var (
+	tunnelQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sight_tunnel_queue_depth",
+		Help: "Deliveries waiting for a tunnel forward worker, in the memory buffer or spooled to disk",
+	}, []string{"queue"})
+	tunnelOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_tunnel_overflow_total",
+		Help: "Deliveries that found the forward buffer full, per outcome: spooled, dead_lettered or dropped",
+	}, []string{"outcome"})
+)
+
+// spooledJob is a forwardJob as written to the spool. OnDelivered cannot be
+// persisted: it is kept in memory and lost on restart.
+type spooledJob struct {
+	MessageID string                 `json:"messageId,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	FromDID   string                 `json:"fromDid,omitempty"`
+	URL       string                 `json:"url"`
+	Body      []byte                 `json:"body"`
+	Trace     propagation.MapCarrier `json:"trace,omitempty"`
+}
+
+// tunnelSpool is the disk queue behind the forward buffer with
+// TUNNEL_OVERFLOW=spool: one file per delivery, named so they sort oldest
+// first. It survives restarts.
+type tunnelSpool struct {
+	dir string
+	max int
+
+	mu    sync.Mutex
+	files []string
+	// callbacks are the OnDelivered of spooled jobs, by file name
+	callbacks map[string]func()
+	// ready is signalled when a job is spooled
+	ready chan struct{}
+}
+
+func tunnelSpoolDir(cfg Config) string {
+	return cfg.IdentityDir + "/tunnel-spool"
+}
+
+// newTunnelSpool checks TUNNEL_OVERFLOW and opens the spool when it is
+// selected, nil otherwise
+func newTunnelSpool(cfg Config) (*tunnelSpool, error) {
+	switch cfg.TunnelOverflow {
+	case overflowDeadLetter, overflowDrop:
+		return nil, nil
+	case overflowSpool:
+	default:
+		return nil, fmt.Errorf("unknown TUNNEL_OVERFLOW %q: want %s, %s or %s", cfg.TunnelOverflow, overflowDeadLetter, overflowDrop, overflowSpool)
+	}
+	sp := &tunnelSpool{
+		dir:       tunnelSpoolDir(cfg),
+		max:       cfg.TunnelSpoolMax,
+		callbacks: make(map[string]func()),
+		ready:     make(chan struct{}, 1),
+	}
+	if err := os.MkdirAll(sp.dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			sp.files = append(sp.files, e.Name())
+		}
+	}
+	if len(sp.files) > 0 {
+		log.Printf("Resuming %d spooled tunnel deliveries", len(sp.files))
+	}
+	tunnelQueueDepth.WithLabelValues("spool").Set(float64(len(sp.files)))
+	return sp, nil
+}
+
+// Len returns the number of spooled jobs
+func (sp *tunnelSpool) Len() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.files)
+}
+
+// Push writes a job at the end of the spool
+func (sp *tunnelSpool) Push(job forwardJob) error {
+	data, err := json.Marshal(spooledJob{
+		MessageID: job.MessageID,
+		RequestID: job.RequestID,
+		FromDID:   job.FromDID,
+		URL:       job.URL,
+		Body:      job.Body,
+		Trace:     job.Trace,
+	})
+	if err != nil {
+		return err
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.files) >= sp.max {
+		return errSpoolFull
+	}
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), uuid.NewString())
+	if err := os.WriteFile(filepath.Join(sp.dir, name), data, 0600); err != nil {
+		return err
+	}
+	sp.files = append(sp.files, name)
+	if job.OnDelivered != nil {
+		sp.callbacks[name] = job.OnDelivered
+	}
+	tunnelQueueDepth.WithLabelValues("spool").Set(float64(len(sp.files)))
+	select {
+	case sp.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the oldest spooled job, waiting for one. Unreadable files are
+// discarded.
+func (sp *tunnelSpool) next() (forwardJob, string) {
+	for {
+		sp.mu.Lock()
+		if len(sp.files) == 0 {
+			sp.mu.Unlock()
+			<-sp.ready
+			continue
+		}
+		name, onDelivered := sp.files[0], sp.callbacks[sp.files[0]]
+		sp.mu.Unlock()
+
+		var job spooledJob
+		data, err := os.ReadFile(filepath.Join(sp.dir, name))
+		if err == nil {
+			err = json.Unmarshal(data, &job)
+		}
+		if err != nil {
+			log.Printf("Discarding unreadable spooled delivery %s: %v", name, err)
+			sp.remove(name)
+			continue
+		}
+		return forwardJob{
+			MessageID:   job.MessageID,
+			RequestID:   job.RequestID,
+			FromDID:     job.FromDID,
+			URL:         job.URL,
+			Body:        job.Body,
+			Trace:       job.Trace,
+			OnDelivered: onDelivered,
+		}, name
+	}
+}
+
+// remove deletes the oldest spooled job, once it is back in the buffer
+func (sp *tunnelSpool) remove(name string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.files) > 0 && sp.files[0] == name {
+		sp.files = sp.files[1:]
+	}
+	delete(sp.callbacks, name)
+	if err := os.Remove(filepath.Join(sp.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing spooled delivery %s: %v", name, err)
+	}
+	tunnelQueueDepth.WithLabelValues("spool").Set(float64(len(sp.files)))
+}
+
+// unspool moves spooled jobs back into the buffer, oldest first, as the
+// workers free room. A job is removed from disk only once it is buffered, so
+// a crash in between delivers it twice rather than never.
+func (f *tunnelForwarder) unspool() {
+	for {
+		job, name := f.spool.next()
+		f.pending.Add(1)
+		f.queue <- job
+		f.spool.remove(name)
+		tunnelQueueDepth.WithLabelValues("memory").Set(float64(len(f.queue)))
+	}
+}
+
+// overflow applies TUNNEL_OVERFLOW to a delivery that found the buffer full
+func (f *tunnelForwarder) overflow(job forwardJob) {
+	ref := messageRef(job.MessageID, job.RequestID)
+	switch f.cfg.TunnelOverflow {
+	case overflowSpool:
+		err := f.spool.Push(job)
+		if err == nil {
+			tunnelOverflows.WithLabelValues("spooled").Inc()
+			return
+		}
+		log.Printf("Tunnel buffer full and spool unavailable, dropping message %s: %v", ref, err)
+	case overflowDrop:
+		log.Printf("Tunnel buffer full, dropping message %s", ref)
+	default:
+		log.Printf("Tunnel buffer full, dead-lettering message %s", ref)
+		tunnelOverflows.WithLabelValues("dead_lettered").Inc()
+		f.deadLetter(job, 0, errors.New("forward buffer full"))
+		return
+	}
+	f.dropped.Add(1)
+	tunnelOverflows.WithLabelValues("dropped").Inc()
+}
@@ -0,0 +1,135 @@
+package sightnode
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewTunnelSpool(t *testing.T) {
+	for _, tc := range []struct {
+		overflow string
+		spool    bool
+		err      bool
+	}{
+		{overflowDeadLetter, false, false},
+		{overflowDrop, false, false},
+		{overflowSpool, true, false},
+		{"block", false, true},
+	} {
+		sp, err := newTunnelSpool(Config{IdentityDir: t.TempDir(), TunnelOverflow: tc.overflow, TunnelSpoolMax: 10})
+		if (err != nil) != tc.err || (sp != nil) != tc.spool {
+			t.Errorf("TUNNEL_OVERFLOW=%s opened spool %v, error %v; want spool %v, error %v", tc.overflow, sp != nil, err, tc.spool, tc.err)
+		}
+	}
+}
+
+func TestTunnelSpoolSurvivesRestarts(t *testing.T) {
+	cfg := Config{IdentityDir: t.TempDir(), TunnelOverflow: overflowSpool, TunnelSpoolMax: 3}
+	sp, err := newTunnelSpool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := sp.Push(forwardJob{MessageID: id, URL: "http://tunnel.test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.Push(forwardJob{MessageID: "4"}); err != errSpoolFull {
+		t.Errorf("pushing past TUNNEL_SPOOL_MAX returned %v, want %v", err, errSpoolFull)
+	}
+
+	// A corrupted entry is discarded rather than blocking the spool
+	sp.mu.Lock()
+	corrupted := sp.files[1]
+	sp.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(tunnelSpoolDir(cfg), corrupted), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newTunnelSpool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for reopened.Len() > 0 {
+		job, name := reopened.next()
+		ids = append(ids, job.MessageID)
+		reopened.remove(name)
+	}
+	if strings.Join(ids, ",") != "1,3" {
+		t.Errorf("resumed deliveries %v, want 1 and 3", ids)
+	}
+}
+
+// slowTunnel records the bodies of the deliveries it receives and blocks them
+// until released
+type slowTunnel struct {
+	release chan struct{}
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func (c *slowTunnel) Do(req *http.Request) (*http.Response, error) {
+	<-c.release
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.ids = append(c.ids, string(body))
+	c.mu.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (c *slowTunnel) delivered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.ids...)
+}
+
+func TestForwarderSpoolsOverflowInOrder(t *testing.T) {
+	cfg := Config{
+		IdentityDir:     t.TempDir(),
+		TunnelQueueSize: 1,
+		TunnelWorkers:   1,
+		TunnelOverflow:  overflowSpool,
+		TunnelSpoolMax:  10,
+		TunnelTimeout:   5 * time.Second,
+	}
+	sp, err := newTunnelSpool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := &slowTunnel{release: make(chan struct{})}
+	f := newTunnelForwarder(cfg, tunnel, nil, sp)
+
+	ids := []string{"1", "2", "3", "4", "5"}
+	for _, id := range ids {
+		f.Enqueue(forwardJob{MessageID: id, URL: "http://tunnel.test", Body: []byte(id)})
+	}
+	if f.Spooled() == 0 {
+		t.Fatal("nothing was spooled while the tunnel was stalled")
+	}
+	close(tunnel.release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tunnel.delivered()) < len(ids) {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered only %v", tunnel.delivered())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := strings.Join(tunnel.delivered(), ","); got != strings.Join(ids, ",") {
+		t.Errorf("delivered %s, want %s", got, strings.Join(ids, ","))
+	}
+	if n := f.Spooled(); n != 0 {
+		t.Errorf("%d deliveries left in the spool", n)
+	}
+}
@@ -0,0 +1,205 @@
+package sightnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Actions of a type route
+const (
+	// routeTunnel delivers to TUNNEL_API or the matching WEBHOOKS_FILE
+	// targets, as messages without a route are
+	routeTunnel = "tunnel"
+	// routeWebhook posts to the route's URL instead
+	routeWebhook = "webhook"
+	// routeTopic publishes the payload on the route's pubsub topic
+	routeTopic = "topic"
+	// routeDrop discards the message
+	routeDrop = "drop"
+)
+
+// TypeRoute maps a message type to where its incoming messages go. Type is
+// exact, or a prefix ending in * such as job.*.
+type TypeRoute struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	URL    string `json:"url,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+}
+
+func (r TypeRoute) validate() error {
+	if r.Type == "" {
+		return errors.New("type is required")
+	}
+	switch r.Action {
+	case routeTunnel, routeDrop:
+	case routeWebhook:
+		if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("route %s: webhook needs an http(s) url", r.Type)
+		}
+	case routeTopic:
+		if r.Topic == "" {
+			return fmt.Errorf("route %s: topic action needs a topic", r.Type)
+		}
+	default:
+		return fmt.Errorf("route %s: unknown action %q: want %s, %s, %s or %s", r.Type, r.Action, routeTunnel, routeWebhook, routeTopic, routeDrop)
+	}
+	return nil
+}
+
+func (r TypeRoute) matches(typ string) bool {
+	if prefix, ok := strings.CutSuffix(r.Type, "*"); ok {
+		return strings.HasPrefix(typ, prefix)
+	}
+	return r.Type == typ
+}
+
+// parseTypeRoute parses a TYPE_ROUTES entry: type=tunnel, type=drop,
+// type=webhook:URL or type=topic:NAME
+func parseTypeRoute(s string) (TypeRoute, error) {
+	typ, target, ok := strings.Cut(s, "=")
+	if !ok {
+		return TypeRoute{}, fmt.Errorf("invalid type route %q: want type=action[:target]", s)
+	}
+	r := TypeRoute{Type: strings.TrimSpace(typ)}
+	var arg string
+	r.Action, arg, _ = strings.Cut(strings.TrimSpace(target), ":")
+	switch r.Action {
+	case routeWebhook:
+		r.URL = arg
+	case routeTopic:
+		r.Topic = arg
+	}
+	return r, r.validate()
+}
+
+// typeRoutesFile persists the routes edited through the API. Once it exists
+// it takes precedence over TYPE_ROUTES.
+func typeRoutesFile(cfg Config) string {
+	return cfg.DataDir + "/type-routes.json"
+}
+
+// typeRouter holds the routing table. Exact types win over prefixes, and
+// longer prefixes over shorter ones.
+type typeRouter struct {
+	mu     sync.RWMutex
+	cfg    Config
+	routes []TypeRoute
+}
+
+func newTypeRouter(cfg Config) (*typeRouter, error) {
+	t := &typeRouter{cfg: cfg}
+	data, err := os.ReadFile(typeRoutesFile(cfg))
+	if err == nil {
+		if err := json.Unmarshal(data, &t.routes); err != nil {
+			log.Printf("Error reading persisted type routes, using TYPE_ROUTES: %v", err)
+		} else {
+			return t, nil
+		}
+	}
+	t.routes = nil
+	for _, item := range cfg.TypeRoutes {
+		r, err := parseTypeRoute(item)
+		if err != nil {
+			return nil, err
+		}
+		t.routes = append(t.routes, r)
+	}
+	return t, nil
+}
+
+// Route returns the route of a message type, the tunnel when none matches
+func (t *typeRouter) Route(typ string) TypeRoute {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	best := TypeRoute{Action: routeTunnel}
+	bestLen := -1
+	for _, r := range t.routes {
+		if !r.matches(typ) {
+			continue
+		}
+		n := len(r.Type)
+		if !strings.HasSuffix(r.Type, "*") {
+			// Exact matches beat any prefix
+			n += len(typ) + 1
+		}
+		if n > bestLen {
+			best, bestLen = r, n
+		}
+	}
+	return best
+}
+
+// List returns the routing table
+func (t *typeRouter) List() []TypeRoute {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]TypeRoute{}, t.routes...)
+}
+
+// Set adds or replaces the route of a type
+func (t *typeRouter) Set(r TypeRoute) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := make([]TypeRoute, 0, len(t.routes)+1)
+	for _, existing := range t.routes {
+		if existing.Type != r.Type {
+			routes = append(routes, existing)
+		}
+	}
+	return t.save(append(routes, r))
+}
+
+// Remove deletes the route of a type and reports whether there was one
+func (t *typeRouter) Remove(typ string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := make([]TypeRoute, 0, len(t.routes))
+	for _, existing := range t.routes {
+		if existing.Type != typ {
+			routes = append(routes, existing)
+		}
+	}
+	if len(routes) == len(t.routes) {
+		return false, nil
+	}
+	return true, t.save(routes)
+}
+
+// save persists and installs a routing table; callers hold t.mu
+func (t *typeRouter) save(routes []TypeRoute) error {
+	data, err := json.Marshal(routes)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(typeRoutesFile(t.cfg), data, 0600); err != nil {
+		return err
+	}
+	t.routes = routes
+	return nil
+}
+
+// publishRouted publishes the payload of a message routed to a topic
+func (s *Libp2pNodeService) publishRouted(ctx context.Context, route TypeRoute, msg StreamMessage, payload []byte, onDelivered func()) {
+	topic, err := s.joinTopic(route.Topic)
+	if err == nil {
+		err = s.publishTopic(ctx, topic, payload)
+	}
+	if err != nil {
+		log.Printf("Error routing message %s to topic %s: %v", messageRef(msg.ID, msg.RequestID), route.Topic, err)
+		return
+	}
+	if onDelivered != nil {
+		onDelivered()
+	}
+}
@@ -0,0 +1,85 @@
+package sightnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// validateMessage rejects malformed envelopes before gossipsub forwards them
+// to the rest of the mesh
+func (s *Libp2pNodeService) validateMessage(_ context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if len(msg.Data) > s.cfg.MaxMessageSize {
+		debugf("Rejecting oversized message from %s (%d bytes)", from, len(msg.Data))
+		return pubsub.ValidationReject
+	}
+
+	// Signatures are verified by pubsub (StrictSign); an unsigned message never passes
+	if len(msg.Signature) == 0 {
+		debugf("Rejecting unsigned message from %s", from)
+		return pubsub.ValidationReject
+	}
+	s.rememberAuthorKey(msg)
+
+	// Revoked DIDs are not forwarded through the mesh
+	if s.senderRevoked(msg.GetFrom()) {
+		debugf("Rejecting message from revoked sender %s", msg.GetFrom())
+		return pubsub.ValidationReject
+	}
+
+	// Gateways requiring admission only route admitted hosters
+	if !s.admitted(msg.GetFrom()) {
+		debugf("Rejecting message from unadmitted peer %s", msg.GetFrom())
+		return pubsub.ValidationReject
+	}
+
+	// A sender over PUBSUB_RATE_LIMIT is rejected and loses peer score
+	if !msg.Local && !s.rateLimiter.Allow(msg.GetFrom()) {
+		debugf("Rejecting message from %s over the rate limit", msg.GetFrom())
+		return pubsub.ValidationReject
+	}
+
+	if err := checkEnvelope(messageData(msg)); err != nil {
+		debugf("Rejecting message from %s: %v", from, err)
+		return pubsub.ValidationReject
+	}
+	return pubsub.ValidationAccept
+}
+
+// rememberAuthorKey stores the public key a signed message carries when its
+// author's PeerID does not embed it (RSA), so the author's DID can be derived
+// even without a direct connection
+func (s *Libp2pNodeService) rememberAuthorKey(msg *pubsub.Message) {
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	if len(msg.Key) == 0 || node == nil {
+		return
+	}
+	key, err := crypto.UnmarshalPublicKey(msg.Key)
+	if err != nil {
+		return
+	}
+	if id, err := peer.IDFromPublicKey(key); err == nil && id == msg.GetFrom() {
+		node.Peerstore().AddPubKey(id, key)
+	}
+}
+
+// checkEnvelope verifies that data decodes to an envelope with "to" and "payload"
+func checkEnvelope(data []byte) error {
+	envelope, err := decodeEnvelope(data)
+	if err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if _, ok := envelope["to"]; !ok {
+		return errors.New("message without \"to\"")
+	}
+	if _, ok := envelope["payload"]; !ok {
+		return errors.New("message without \"payload\"")
+	}
+	return nil
+}
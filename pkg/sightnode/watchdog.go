@@ -0,0 +1,109 @@
+package sightnode
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+)
+
+// Connectivity event types
+const (
+	eventConnectivityDegraded = "connectivity.degraded"
+	eventConnectivityRestored = "connectivity.restored"
+)
+
+// connectivityWatchdog tracks whether the node stays above its minimum peer
+// and topic mesh counts
+type connectivityWatchdog struct {
+	mu sync.Mutex
+	// belowSince is when the counts last dropped below the thresholds, zero
+	// while they are met
+	belowSince time.Time
+	// degraded is set once they stayed below for the grace period
+	degraded bool
+	detail   string
+	lastKick time.Time
+}
+
+// status reports whether the node is degraded and why
+func (w *connectivityWatchdog) status() (bool, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.degraded, w.detail
+}
+
+// connectivityShortfall describes the thresholds not met, or "" when all are
+func (s *Libp2pNodeService) connectivityShortfall(h hostlibp2p.Host) (string, int) {
+	var short []string
+	peers := len(h.Network().Peers())
+	if peers < s.cfg.WatchdogMinPeers {
+		short = append(short, fmt.Sprintf("%d peers, %d required", peers, s.cfg.WatchdogMinPeers))
+	}
+	if s.cfg.WatchdogMinTopicPeers > 0 {
+		for _, topic := range s.cfg.WatchdogTopics {
+			if n := len(s.pubsub.ListPeers(topic)); n < s.cfg.WatchdogMinTopicPeers {
+				short = append(short, fmt.Sprintf("%d peers on %s, %d required", n, topic, s.cfg.WatchdogMinTopicPeers))
+			}
+		}
+	}
+	return strings.Join(short, "; "), peers
+}
+
+// runWatchdog checks connectivity every WatchdogInterval. Once the node has
+// stayed below its thresholds for WatchdogGrace it logs an alert, emits a
+// connectivity.degraded event, reports not ready and re-bootstraps, again
+// every grace period until connectivity is back.
+func (s *Libp2pNodeService) runWatchdog(ctx context.Context, h hostlibp2p.Host) {
+	if s.cfg.WatchdogInterval <= 0 {
+		return
+	}
+	w := s.watchdog
+	ticker := time.NewTicker(s.cfg.WatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		shortfall, peers := s.connectivityShortfall(h)
+		now := time.Now()
+
+		w.mu.Lock()
+		if shortfall == "" {
+			restored := w.degraded
+			w.belowSince, w.degraded, w.detail = time.Time{}, false, ""
+			w.mu.Unlock()
+			if restored {
+				log.Printf("Watchdog: connectivity restored peers=%d", peers)
+				s.emitNetworkEvent(h.ID(), NetworkEvent{Type: eventConnectivityRestored, Peers: peers, Time: now.Format(time.RFC3339)})
+			}
+			continue
+		}
+		if w.belowSince.IsZero() {
+			w.belowSince = now
+		}
+		w.detail = shortfall
+		if now.Sub(w.belowSince) < s.cfg.WatchdogGrace || now.Sub(w.lastKick) < s.cfg.WatchdogGrace {
+			w.mu.Unlock()
+			continue
+		}
+		first := !w.degraded
+		w.degraded, w.lastKick = true, now
+		since := w.belowSince
+		w.mu.Unlock()
+
+		log.Printf("Watchdog alert: connectivity degraded since=%s peers=%d detail=%q action=rebootstrap",
+			since.Format(time.RFC3339), peers, shortfall)
+		if first {
+			s.emitNetworkEvent(h.ID(), NetworkEvent{Type: eventConnectivityDegraded, Peers: peers, Detail: shortfall, Time: now.Format(time.RFC3339)})
+		}
+		s.bootstrap.Rebootstrap()
+		reconnectKnownPeers(ctx, h, s.cfg.PeerstoreReconnect, s.cfg.DialTimeout)
+	}
+}
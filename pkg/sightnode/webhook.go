@@ -0,0 +1,55 @@
+package sightnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// WebhookTarget is an upstream URL receiving incoming messages that match its
+// rules. Empty rule lists match everything.
+type WebhookTarget struct {
+	URL      string   `json:"url"`
+	Types    []string `json:"types,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	FromDIDs []string `json:"fromDids,omitempty"`
+}
+
+func (t WebhookTarget) matches(msg StreamMessage) bool {
+	return matchesAny(t.Topics, msg.Topic) && matchesAny(t.Types, msg.Type) && matchesAny(t.FromDIDs, msg.FromDID)
+}
+
+// loadWebhookTargets reads the targets from WEBHOOKS_FILE, a JSON array of
+// WebhookTarget. Without it every message goes to TUNNEL_API.
+func loadWebhookTargets(cfg Config) ([]WebhookTarget, error) {
+	if cfg.WebhooksFile == "" {
+		return []WebhookTarget{{URL: cfg.TunnelAPI}}, nil
+	}
+	data, err := os.ReadFile(cfg.WebhooksFile)
+	if err != nil {
+		return nil, err
+	}
+	var targets []WebhookTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, err
+	}
+	for i, t := range targets {
+		if t.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no url", i)
+		}
+	}
+	return targets, nil
+}
+
+// webhookTargetsFor returns the URLs of the targets matching a message
+func (s *Libp2pNodeService) webhookTargetsFor(msg StreamMessage) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var urls []string
+	for _, t := range s.webhooks {
+		if t.matches(msg) {
+			urls = append(urls, t.URL)
+		}
+	}
+	return urls
+}
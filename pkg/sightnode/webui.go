@@ -0,0 +1,98 @@
+package sightnode
+
+import (
+	_ "embed"
+	"sync"
+	"time"
+)
+
+// recentMessagesSize is the number of messages listed by the status UI
+const recentMessagesSize = 50
+
+//go:embed webui/index.html
+var webUIPage []byte
+
+// MessageSummary is the metadata of a recent message, without its payload
+type MessageSummary struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+	// Peer is the sender of an incoming message or the recipient of an
+	// outgoing one
+	Peer  string `json:"peer"`
+	Topic string `json:"topic,omitempty"`
+	Size  int    `json:"size"`
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+	At    string `json:"at"`
+}
+
+// messageLog keeps the metadata of the latest messages in a ring
+type messageLog struct {
+	mu      sync.Mutex
+	entries []MessageSummary
+	next    int
+}
+
+func newMessageLog(size int) *messageLog {
+	return &messageLog{entries: make([]MessageSummary, 0, size)}
+}
+
+func (l *messageLog) Add(m MessageSummary) {
+	m.At = time.Now().UTC().Format(time.RFC3339)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, m)
+		return
+	}
+	l.entries[l.next] = m
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// List returns the logged messages, newest first
+func (l *messageLog) List() []MessageSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]MessageSummary, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		list = append(list, l.entries[(l.next+i)%len(l.entries)])
+	}
+	return list
+}
+
+// recordMessage logs the metadata of a message for the status UI
+func (s *Libp2pNodeService) recordMessage(direction, peerDID, topic string, envelope map[string]interface{}, size int, state string, err error) {
+	id, _ := envelope["id"].(string)
+	m := MessageSummary{ID: id, Direction: direction, Peer: peerDID, Topic: topic, Size: size, State: state}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	s.recent.Add(m)
+}
+
+// UIStatus is the snapshot rendered by the /ui status page
+type UIStatus struct {
+	NodeStats
+	Addrs          []string         `json:"addrs"`
+	PeerList       []PeerInfo       `json:"peerList"`
+	RecentMessages []MessageSummary `json:"recentMessages"`
+}
+
+// UIStatus returns the node's identity, addresses, peers, queues and latest
+// messages
+func (s *Libp2pNodeService) UIStatus() UIStatus {
+	status := UIStatus{
+		NodeStats:      s.Stats(),
+		Addrs:          []string{},
+		PeerList:       []PeerInfo{},
+		RecentMessages: s.recent.List(),
+	}
+	s.mu.RLock()
+	node := s.node
+	s.mu.RUnlock()
+	if node != nil {
+		status.Addrs = p2pAddrs(node.ID(), node.Addrs())
+		status.PeerList = s.Peers()
+	}
+	return status
+}
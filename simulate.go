@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"sight-libp2p-node/pkg/sightnode"
+	"sight-libp2p-node/pkg/sightnode/sightnodetest"
+)
+
+// simulate runs a gateway and N hosters in this process over localhost, for
+// developing against the bridge without several machines
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	hosters := fs.Int("hosters", 3, "number of hoster nodes besides the gateway")
+	basePort := fs.Int("base-port", 19000, "node i serves its HTTP API on base-port+2i and listens for libp2p on base-port+2i+1")
+	dataDir := fs.String("data-dir", "", "keep node state (and DIDs) in this directory across runs; a temporary one by default")
+	tunnel := fs.String("tunnel", "", "tunnel API every node forwards to; by default each node gets its own recording server")
+	traffic := fs.Duration("traffic", 0, "send a synthetic message from a random node to another every interval (0 disables)")
+	mesh := fs.Bool("mesh", true, "connect the hosters to each other, not only to the gateway")
+	fs.Parse(args)
+
+	opts := sightnodetest.Options{
+		Hosters:   *hosters,
+		Transport: sightnodetest.Localhost,
+		BasePort:  *basePort,
+		Dir:       *dataDir,
+	}
+	if *tunnel != "" {
+		opts.Configure = func(_ int, cfg *sightnode.Config) { cfg.TunnelAPI = *tunnel }
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cluster, err := sightnodetest.Start(ctx, opts)
+	if err != nil {
+		log.Fatal("Failed to start simulation: ", err)
+	}
+	defer cluster.Close()
+
+	readyCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if *mesh {
+		for a := 1; a < len(cluster.Nodes); a++ {
+			for b := a + 1; b < len(cluster.Nodes); b++ {
+				if err := cluster.Connect(readyCtx, a, b); err != nil {
+					log.Printf("Simulation: %v", err)
+				}
+			}
+		}
+	}
+	if err := cluster.WaitReady(readyCtx); err != nil {
+		log.Printf("Simulation: not every node is ready: %v", err)
+	}
+	printNodes(cluster, *tunnel)
+
+	if *traffic > 0 {
+		go generateTraffic(ctx, cluster, *traffic, *mesh)
+	}
+	if *tunnel == "" {
+		go reportDeliveries(ctx, cluster)
+	}
+	<-ctx.Done()
+	log.Println("Stopping simulation...")
+}
+
+func printNodes(cluster *sightnodetest.Cluster, tunnel string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tROLE\tDID\tAPI\tTUNNEL\tLIBP2P")
+	for _, n := range cluster.Nodes {
+		role := "hoster"
+		if n.Config.IsGateway {
+			role = "gateway"
+		}
+		tunnelURL := n.Tunnel.URL
+		if tunnel != "" {
+			tunnelURL = tunnel
+		}
+		addr := ""
+		if addrs := n.Addrs(); len(addrs) > 0 {
+			addr = addrs[0]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", n.Index, role, n.DID(), n.API.URL, tunnelURL, addr)
+	}
+	w.Flush()
+}
+
+// reportDeliveries logs the messages the nodes forward to their recording
+// tunnel servers
+func reportDeliveries(ctx context.Context, cluster *sightnodetest.Cluster) {
+	seen := make([]int, len(cluster.Nodes))
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for i, n := range cluster.Nodes {
+			deliveries := n.Tunnel.Deliveries()
+			for _, d := range deliveries[seen[i]:] {
+				log.Printf("Simulation: node %d received %s", n.Index, d.Body)
+			}
+			seen[i] = len(deliveries)
+		}
+	}
+}
+
+// generateTraffic sends a numbered message every interval: from a random
+// hoster to the gateway, or to another hoster when they are meshed
+func generateTraffic(ctx context.Context, cluster *sightnodetest.Cluster, interval time.Duration, mesh bool) {
+	hosters := cluster.Hosters()
+	if len(hosters) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for seq := 1; ; seq++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		from := hosters[rand.Intn(len(hosters))]
+		to := "gateway"
+		if mesh && len(hosters) > 1 && rand.Intn(2) == 0 {
+			for to == "gateway" || to == from.DID() {
+				to = hosters[rand.Intn(len(hosters))].DID()
+			}
+		}
+		id, err := from.Send(ctx, to, map[string]interface{}{
+			"simulated": true,
+			"seq":       seq,
+			"sentAt":    time.Now().Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			log.Printf("Simulation: node %d failed to send #%d: %v", from.Index, seq, err)
+			continue
+		}
+		log.Printf("Simulation: node %d sent #%d (%s) to %s", from.Index, seq, id, to)
+	}
+}